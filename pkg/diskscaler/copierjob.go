@@ -0,0 +1,264 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	kubecostDataMoverJobName = "kubecost-data-mover"
+	copierContainerName      = "data-mover"
+	// copierJobTTL is how long a finished copier job is kept when the service could not delete it
+	copierJobTTL = time.Hour
+	// copierJobPollInterval is how often the status and the progress of the copier job are checked
+	copierJobPollInterval = 10 * time.Second
+)
+
+// copierJob returns the Job copying the data of originalPVC to newPVC. The job is owned by the
+// new claim, so that it is garbage collected along with a new claim which is given up.
+func (ds *DiskScaler) copierJob(namespace string, jobName string, dm dataMover, workloadSpec *v1.PodSpec, originalPVC string, newPVC *v1.PersistentVolumeClaim) *batchv1.Job {
+	backoffLimit := dm.backoffLimit
+	activeDeadlineSeconds := int64(dm.timeout.Seconds())
+	ttlSecondsAfterFinished := int32(copierJobTTL.Seconds())
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: namespace,
+			Labels:    map[string]string{PVCAnnotationCreatedBy: DiskAutoScaler},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "PersistentVolumeClaim",
+				Name:       newPVC.GetName(),
+				UID:        newPVC.GetUID(),
+			}},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   &activeDeadlineSeconds,
+			TTLSecondsAfterFinished: &ttlSecondsAfterFinished,
+			Template:                ds.copierPodTemplate(dm, workloadSpec, originalPVC, newPVC.GetName()),
+		},
+	}
+}
+
+// runCopierJob runs a Job moving data with dm between original PV claim volume source to new PV Claim volume source,
+// scheduled like the pods of the workload described by workloadSpec, and waits for it to finish, logging the progress of the copy and recording it on the new claim.
+// The copied data is then verified, and a verification failure is returned as an error so that the claim is never swapped.
+func (ds *DiskScaler) runCopierJob(ctx context.Context, namespace string, jobName string, dm dataMover, workloadSpec *v1.PodSpec, originalPVC string, newPVC *v1.PersistentVolumeClaim) (err error) {
+	start := time.Now()
+	// The size of the copied data is known from its verification, or else from the last progress of the copy
+	copiedBytes := int64(-1)
+	defer func() {
+		recordCopy(ctx, time.Since(start), copiedBytes, err)
+		if err != nil {
+			ds.recordEvent(ctx, nil, claimReference(newPVC), v1.EventTypeWarning, EventReasonCopyFailed, "copy of the data of pvc %s by job %s failed: %v", originalPVC, jobName, err)
+			return
+		}
+		ds.recordEvent(ctx, nil, claimReference(newPVC), v1.EventTypeNormal, EventReasonCopyCompleted, "copied the data of pvc %s by job %s in %s", originalPVC, jobName, time.Since(start).Round(time.Second))
+	}()
+
+	_, err = ds.basicK8sClient.BatchV1().Jobs(namespace).Create(ctx, ds.copierJob(namespace, jobName, dm, workloadSpec, originalPVC, newPVC), metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create copier job %s in namespace %s with err: %w", jobName, namespace, err)
+	}
+	log.Debug().Msgf("ctx: %s, successfully created copier job: %s in namespace: %s", ctx.Value(diskScalerRunContextKey), jobName, namespace)
+	ds.recordEvent(ctx, nil, claimReference(newPVC), v1.EventTypeNormal, EventReasonCopyStarted, "copying the data of pvc %s with %s by job %s", originalPVC, dm.mode, jobName)
+
+	var job *batchv1.Job
+	lastProgress := ""
+	// The active deadline of the job bounds the copy, the margin leaves time to observe it
+	err = wait.PollUntilContextTimeout(ctx, copierJobPollInterval, dm.timeout+diskScalingOperationTimeout, true, func(ctx context.Context) (bool, error) {
+		var getErr error
+		job, getErr = ds.basicK8sClient.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{})
+		if getErr != nil {
+			log.Debug().Msgf("ctx: %s, unable to get copier job %s: %v", ctx.Value(diskScalerRunContextKey), jobName, getErr)
+			return false, nil
+		}
+		if jobCondition(job, batchv1.JobComplete) != nil || jobCondition(job, batchv1.JobFailed) != nil {
+			return true, nil
+		}
+		lastProgress = ds.reportCopyProgress(ctx, namespace, jobName, originalPVC, newPVC.GetName(), lastProgress)
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("timeout to wait for copier job %s in namespace %s to finish: %w", jobName, namespace, err)
+	}
+
+	if failed := jobCondition(job, batchv1.JobFailed); failed != nil {
+		message := ds.copierTerminationMessage(ctx, namespace, jobName, v1.PodFailed)
+		return fmt.Errorf("failed to perform %s copy operation on namespace:%s job:%s: %s: %s, output: %s", dm.mode, namespace, jobName, failed.Reason, failed.Message, message)
+	}
+	log.Debug().Msgf("ctx: %s, copier job %s completed", ctx.Value(diskScalerRunContextKey), jobName)
+
+	if dm.verify == verifyNone {
+		if progress, parseErr := parseCopyProgress(lastProgress); parseErr == nil && progress.totalBytes > 0 {
+			copiedBytes = progress.totalBytes
+		}
+		return nil
+	}
+	copied, err := verifyCopy(ds.copierTerminationMessage(ctx, namespace, jobName, v1.PodSucceeded))
+	if err != nil {
+		return err
+	}
+	copiedBytes = copied.bytes
+	log.Info().Msgf("ctx: %s, verified the %s of the data copied from pvc %s to pvc %s", ctx.Value(diskScalerRunContextKey), dm.verify, originalPVC, newPVC.GetName())
+	return nil
+}
+
+// jobCondition returns the condition of the given type of the job when it is true.
+func jobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		condition := &job.Status.Conditions[i]
+		if condition.Type == conditionType && condition.Status == v1.ConditionTrue {
+			return condition
+		}
+	}
+	return nil
+}
+
+// copierPods lists the pods created by the copier job.
+func (ds *DiskScaler) copierPods(ctx context.Context, namespace string, jobName string) ([]v1.Pod, error) {
+	pods, err := ds.basicK8sClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", batchv1.JobNameLabel, jobName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pods of copier job %s: %w", jobName, err)
+	}
+	return pods.Items, nil
+}
+
+// copierTerminationMessage returns the termination message of the latest pod of the copier job in the given phase.
+func (ds *DiskScaler) copierTerminationMessage(ctx context.Context, namespace string, jobName string, phase v1.PodPhase) string {
+	pods, err := ds.copierPods(ctx, namespace, jobName)
+	if err != nil {
+		log.Error().Msgf("ctx: %s, %v", ctx.Value(diskScalerRunContextKey), err)
+		return ""
+	}
+	var latest *v1.ContainerStateTerminated
+	for _, pod := range pods {
+		if pod.Status.Phase != phase {
+			continue
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			terminated := status.State.Terminated
+			if status.Name != copierContainerName || terminated == nil {
+				continue
+			}
+			if latest == nil || terminated.FinishedAt.After(latest.FinishedAt.Time) {
+				latest = terminated
+			}
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.Message
+}
+
+// reportCopyProgress reads the latest progress line printed by the running pod of the copier job,
+// logs it with the estimated time left and records it on the new claim. The last reported line is
+// returned so that the same progress is not reported twice.
+func (ds *DiskScaler) reportCopyProgress(ctx context.Context, namespace string, jobName string, originalPVC string, newPVC string, lastProgress string) string {
+	pods, err := ds.copierPods(ctx, namespace, jobName)
+	if err != nil {
+		log.Debug().Msgf("ctx: %s, %v", ctx.Value(diskScalerRunContextKey), err)
+		return lastProgress
+	}
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+		tailLines := int64(1)
+		stream, err := ds.basicK8sClient.CoreV1().Pods(namespace).GetLogs(pod.GetName(), &v1.PodLogOptions{
+			Container: copierContainerName,
+			TailLines: &tailLines,
+		}).Stream(ctx)
+		if err != nil {
+			log.Debug().Msgf("ctx: %s, unable to read logs of copier pod %s: %v", ctx.Value(diskScalerRunContextKey), pod.GetName(), err)
+			return lastProgress
+		}
+		output, err := io.ReadAll(stream)
+		stream.Close()
+		if err != nil {
+			log.Debug().Msgf("ctx: %s, unable to read logs of copier pod %s: %v", ctx.Value(diskScalerRunContextKey), pod.GetName(), err)
+			return lastProgress
+		}
+		line := strings.TrimSpace(string(output))
+		if line == lastProgress {
+			return lastProgress
+		}
+		progress, err := parseCopyProgress(line)
+		if err != nil {
+			// The job prints other lines before the first progress line
+			return lastProgress
+		}
+
+		eta := "unknown"
+		if pod.Status.StartTime != nil {
+			if remaining, ok := progress.eta(time.Since(pod.Status.StartTime.Time)); ok {
+				eta = remaining.String()
+			}
+		}
+		log.Info().
+			Str("job", jobName).
+			Str("sourcePVC", originalPVC).
+			Str("targetPVC", newPVC).
+			Int64("bytesCopied", progress.bytes).
+			Int64("totalBytes", progress.totalBytes).
+			Int64("filesCopied", progress.files).
+			Int64("totalFiles", progress.totalFiles).
+			Str("eta", eta).
+			Msgf("ctx: %s, copied %s from pvc %s to pvc %s, eta %s", ctx.Value(diskScalerRunContextKey), progress, originalPVC, newPVC, eta)
+		err = ds.patchPVCMetadata(ctx, namespace, newPVC, map[string]interface{}{}, map[string]interface{}{
+			PVCAnnotationCopyProgress: fmt.Sprintf("%s, eta %s", progress, eta),
+		})
+		if err != nil {
+			log.Debug().Msgf("ctx: %s, unable to record copy progress on pvc %s: %v", ctx.Value(diskScalerRunContextKey), newPVC, err)
+		}
+		return line
+	}
+	return lastProgress
+}
+
+// retryDeleteCopierJob attempts to delete the copier job whenever there are any intermittent failure
+func (ds *DiskScaler) retryDeleteCopierJob(ctx context.Context, namespace string, jobName string) error {
+	return withRetries(ctx, fmt.Sprintf("delete copier job %s", jobName), func() error {
+		return ds.deleteCopierJob(ctx, namespace, jobName)
+	})
+}
+
+// deleteCopierJob deletes the copier job and waits for it and its pods to be gone, so that the
+// volumes it mounted are released before the workload is restored.
+func (ds *DiskScaler) deleteCopierJob(ctx context.Context, namespace string, jobName string) error {
+	deletePolicy := metav1.DeletePropagationForeground
+	err := ds.basicK8sClient.BatchV1().Jobs(namespace).Delete(ctx, jobName, metav1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	})
+	if k8serrors.IsNotFound(err) {
+		log.Debug().Msgf("ctx: %s, copier job %s doesn't exist in namespace: %s", ctx.Value(diskScalerRunContextKey), jobName, namespace)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete copier job: %s: %w", jobName, err)
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, time.Second, diskScalingOperationTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := ds.basicK8sClient.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{})
+		return k8serrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		return fmt.Errorf("timeout to wait for copier job %s deletion in namespace %s: %w", jobName, namespace, err)
+	}
+	log.Debug().Msgf("ctx: %s, successfully deleted copier job: %s in namespace: %s", ctx.Value(diskScalerRunContextKey), jobName, namespace)
+	return nil
+}
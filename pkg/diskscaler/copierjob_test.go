@@ -0,0 +1,118 @@
+package diskscaler
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func newCopierPod(jobName string, phase v1.PodPhase, message string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName + "-abcde",
+			Namespace: "test",
+			Labels:    map[string]string{batchv1.JobNameLabel: jobName},
+		},
+		Status: v1.PodStatus{
+			Phase: phase,
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:  copierContainerName,
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Message: message}},
+			}},
+		},
+	}
+}
+
+func Test_runCopierJob(t *testing.T) {
+	type testCase struct {
+		name      string
+		condition batchv1.JobConditionType
+		phase     v1.PodPhase
+		message   string
+		expectErr bool
+	}
+
+	testCases := []testCase{
+		{
+			name:      "when the copied data is verified",
+			condition: batchv1.JobComplete,
+			phase:     v1.PodSucceeded,
+			message:   "files=4 bytes=13\nfiles=4 bytes=13\n",
+		},
+		{
+			name:      "when the verification of the copied data fails",
+			condition: batchv1.JobComplete,
+			phase:     v1.PodSucceeded,
+			message:   "files=4 bytes=13\nfiles=3 bytes=10\n",
+			expectErr: true,
+		},
+		{
+			name:      "when the copier job fails",
+			condition: batchv1.JobFailed,
+			phase:     v1.PodFailed,
+			message:   "tar: write error: No space left on device",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		newPVC := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-abcde", Namespace: "test", UID: "new-uid"}}
+		client := fake.NewSimpleClientset(newPVC, newCopierPod("copier", tc.phase, tc.message))
+		client.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+			job.Status.Conditions = []batchv1.JobCondition{{Type: tc.condition, Status: v1.ConditionTrue}}
+			return false, nil, nil
+		})
+		ds, err := NewDiskScaler(nil, client, newFakeDynamicClient(), "test", stubRecommender{}, false)
+		if err != nil {
+			t.Fatalf("test '%s': unable to create disk scaler: %s", tc.name, err)
+		}
+
+		recorder := record.NewFakeRecorder(10)
+		ds.recorder = recorder
+
+		err = ds.runCopierJob(context.Background(), "test", "copier", ds.dataMover, &v1.PodSpec{}, "data", newPVC)
+		if tc.expectErr && err == nil {
+			t.Fatalf("test '%s': expected err but received none", tc.name)
+		}
+		if !tc.expectErr && err != nil {
+			t.Fatalf("test '%s': received unexpected err: %s", tc.name, err)
+		}
+		if !receivedEvent(recorder, EventReasonCopyStarted) {
+			t.Fatalf("test '%s': expected a %s event", tc.name, EventReasonCopyStarted)
+		}
+		finished := EventReasonCopyCompleted
+		if tc.expectErr {
+			finished = EventReasonCopyFailed
+		}
+		if !receivedEvent(recorder, finished) {
+			t.Fatalf("test '%s': expected a %s event", tc.name, finished)
+		}
+
+		job, err := client.BatchV1().Jobs("test").Get(context.Background(), "copier", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("test '%s': expected copier job to be created: %s", tc.name, err)
+		}
+		if owners := job.GetOwnerReferences(); len(owners) != 1 || owners[0].Kind != "PersistentVolumeClaim" || owners[0].UID != newPVC.GetUID() {
+			t.Fatalf("test '%s': expected copier job to be owned by the new pvc but received %+v", tc.name, owners)
+		}
+		if job.Spec.BackoffLimit == nil || job.Spec.ActiveDeadlineSeconds == nil || job.Spec.TTLSecondsAfterFinished == nil {
+			t.Fatalf("test '%s': expected copier job to set backoffLimit, activeDeadlineSeconds and ttlSecondsAfterFinished", tc.name)
+		}
+
+		err = ds.retryDeleteCopierJob(context.Background(), "test", "copier")
+		if err != nil {
+			t.Fatalf("test '%s': received unexpected err deleting copier job: %s", tc.name, err)
+		}
+		if _, err := client.BatchV1().Jobs("test").Get(context.Background(), "copier", metav1.GetOptions{}); err == nil {
+			t.Fatalf("test '%s': expected copier job to be deleted", tc.name)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package diskscaler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Modes of copying the data between the volumes mounted by the copier job
+const (
+	// dataMoverCp copies with cp -a, which preserves ownership and permissions but not sparse files
+	dataMoverCp = "cp"
+	// dataMoverTar pipes GNU tar, preserving ownership, permissions, xattrs, ACLs, hard links and sparse files
+	dataMoverTar = "tar"
+	// dataMoverRsync runs rsync, which must be installed in the copier image
+	dataMoverRsync = "rsync"
+)
+
+// Verifications of the copied data run after the copy
+const (
+	verifyNone = "none"
+	// verifyCount compares the number of entries and the total bytes of the files
+	verifyCount = "count"
+	// verifyChecksum also compares a checksum of the content of every file
+	verifyChecksum = "checksum"
+)
+
+const (
+	defaultDataMoverImage        = "ubuntu"
+	defaultDataMoverTimeout      = 6 * time.Hour
+	defaultDataMoverBackoffLimit = 2
+	oldDataMountPath             = "/oldData"
+	newDataMountPath             = "/newData"
+	// copyProgressInterval is how often the copier job prints the progress of the copy
+	copyProgressInterval = 30 * time.Second
+)
+
+// rsyncCheckCommand fails when rsync is not installed in the image of the copier job
+const rsyncCheckCommand = "command -v rsync >/dev/null || { echo \"rsync is not installed in the data mover image\" >&2; exit 1; }; "
+
+// Shell commands printing the number of entries and the total bytes of the files of the
+// current directory, without the lost+found directory created by the file system
+const (
+	countEntriesCommand = `find . -path ./lost+found -prune -o ! -type d -print | wc -l`
+	countBytesCommand   = `find . -path ./lost+found -prune -o -type f -exec stat -c %s {} + | awk '{s+=$1} END {print s+0}'`
+)
+
+// dataMover is how the copier job copies and verifies the data of a volume.
+type dataMover struct {
+	mode   string
+	verify string
+	image  string
+	// timeout is the active deadline of the copier job, covering all of its attempts
+	timeout time.Duration
+	// backoffLimit is the number of times a failed copy is retried
+	backoffLimit int32
+	// podTemplate is the configured pod template of the copier job, if any
+	podTemplate *v1.PodTemplateSpec
+	// deleteExtraneous removes the files of the new volume missing from the old volume, which is
+	// only set when syncing a new volume pre-copied from a snapshot
+	deleteExtraneous bool
+	// requireRsync fails the copy when the image has no rsync, which is set when pre-copying a
+	// volume from a snapshot as only rsync can sync it once the workload is stopped
+	requireRsync bool
+}
+
+type dataMoverOptions struct {
+	Mode         string
+	Verify       string
+	Image        string
+	Timeout      time.Duration
+	BackoffLimit int32
+	// PodTemplate is the path of a file holding the pod template of the copier job
+	PodTemplate string
+}
+
+func newDataMover(opts dataMoverOptions) (dataMover, error) {
+	mode := strings.ToLower(opts.Mode)
+	switch mode {
+	case "":
+		mode = dataMoverTar
+	case dataMoverCp, dataMoverTar, dataMoverRsync:
+	default:
+		return dataMover{}, fmt.Errorf("unsupported data mover %q, supported data movers are %s, %s and %s", mode, dataMoverCp, dataMoverTar, dataMoverRsync)
+	}
+	verify := strings.ToLower(opts.Verify)
+	switch verify {
+	case "":
+		verify = verifyCount
+	case verifyNone, verifyCount, verifyChecksum:
+	default:
+		return dataMover{}, fmt.Errorf("unsupported data mover verification %q, supported verifications are %s, %s and %s", verify, verifyNone, verifyCount, verifyChecksum)
+	}
+	if opts.BackoffLimit < 0 {
+		return dataMover{}, fmt.Errorf("data mover backoff limit must not be negative, received %d", opts.BackoffLimit)
+	}
+	dm := dataMover{mode: mode, verify: verify, image: opts.Image, timeout: opts.Timeout, backoffLimit: opts.BackoffLimit}
+	if dm.image == "" {
+		dm.image = defaultDataMoverImage
+	}
+	if dm.timeout <= 0 {
+		dm.timeout = defaultDataMoverTimeout
+	}
+	if opts.PodTemplate != "" {
+		var err error
+		dm.podTemplate, err = loadDataMoverPodTemplate(opts.PodTemplate)
+		if err != nil {
+			return dataMover{}, err
+		}
+	}
+	return dm, nil
+}
+
+// copyCommand returns the shell command copying the data of the old volume to the new volume.
+// The lost+found directory created by the file system of each volume is not copied, except by cp
+// which has no way to exclude it.
+func (dm dataMover) copyCommand() string {
+	check := ""
+	if dm.requireRsync {
+		check = rsyncCheckCommand
+	}
+	switch dm.mode {
+	case dataMoverCp:
+		// Copying the directory itself rather than a glob includes the dotfiles at the root
+		return check + "cp -a /oldData/. /newData/"
+	case dataMoverRsync:
+		deleteExtraneous := ""
+		if dm.deleteExtraneous {
+			deleteExtraneous = " --delete"
+		}
+		return rsyncCheckCommand + "rsync -aHAXS --numeric-ids" + deleteExtraneous + " --exclude=/lost+found /oldData/ /newData/"
+	default:
+		// sh has no pipefail, so a failure of the archiving side is flagged through a file
+		return check + "{ tar -C /oldData --exclude=./lost+found --sparse --xattrs --acls --numeric-owner -cpf - . || touch /tmp/archive-failed; } | " +
+			"tar -C /newData --xattrs --acls --numeric-owner -xpf - && [ ! -e /tmp/archive-failed ]"
+	}
+}
+
+// finalSync returns the data mover syncing the changes made to the old volume since the new volume
+// was pre-copied from its snapshot. Only rsync copies just the changed files and removes the
+// deleted ones, so the image of the data mover must have rsync installed.
+func (dm dataMover) finalSync() dataMover {
+	dm.mode = dataMoverRsync
+	dm.deleteExtraneous = true
+	return dm
+}
+
+// precopy returns the data mover pre-copying a new volume from a snapshot, which fails when the
+// image has no rsync so that the pre-copy is discarded before the workload is stopped rather than
+// failing the final sync while it is stopped.
+func (dm dataMover) precopy() dataMover {
+	dm.requireRsync = true
+	return dm
+}
+
+// verifyCommand returns the shell command printing a summary of the data of each volume on
+// its own line, or an empty command when the copy is not verified.
+func (dm dataMover) verifyCommand() string {
+	if dm.verify == verifyNone {
+		return ""
+	}
+	checksum := ""
+	if dm.verify == verifyChecksum {
+		checksum = " checksum=%s"
+	}
+	summary := `summary() { cd "$1" && printf 'files=%s bytes=%s` + checksum + `\n' ` +
+		`"$(` + countEntriesCommand + `)" "$(` + countBytesCommand + `)"`
+	if dm.verify == verifyChecksum {
+		summary += ` "$(find . -path ./lost+found -prune -o -type f -print0 | sort -z | xargs -0 -r sha256sum | sha256sum | cut -d' ' -f1)"`
+	}
+	return summary + "; }; summary /oldData && summary /newData"
+}
+
+// jobScript returns the script run by the copier job. While the data is copied, the number of
+// entries and bytes already on the new volume is printed every copyProgressInterval against
+// the totals of the old volume. The output of the verify command is written to the
+// termination message of the container so that the service can verify the copy.
+func (dm dataMover) jobScript() string {
+	script := fmt.Sprintf(`cd %s && totalFiles=$(%s) && totalBytes=$(%s) || exit 1
+echo "total files=$totalFiles bytes=$totalBytes"
+while sleep %d; do
+  cd %s && echo "progress files=$(%s) bytes=$(%s) totalFiles=$totalFiles totalBytes=$totalBytes"
+done &
+progress=$!
+(%s)
+status=$?
+kill $progress
+[ $status -eq 0 ] || exit $status
+echo "copied data from %s to %s"
+`, oldDataMountPath, countEntriesCommand, countBytesCommand, int(copyProgressInterval.Seconds()),
+		newDataMountPath, countEntriesCommand, countBytesCommand, dm.copyCommand(), oldDataMountPath, newDataMountPath)
+	if verify := dm.verifyCommand(); verify != "" {
+		script += "(" + verify + ") > /dev/termination-log\n"
+	}
+	return script
+}
+
+// copyProgress is a progress line printed by the copier job.
+type copyProgress struct {
+	files      int64
+	bytes      int64
+	totalFiles int64
+	totalBytes int64
+}
+
+// parseCopyProgress parses a progress line printed by the job script.
+func parseCopyProgress(line string) (copyProgress, error) {
+	var progress copyProgress
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "progress" {
+		return progress, fmt.Errorf("not a progress line %q", line)
+	}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return progress, fmt.Errorf("unexpected field %q", field)
+		}
+		var err error
+		switch key {
+		case "files":
+			progress.files, err = strconv.ParseInt(value, 10, 64)
+		case "bytes":
+			progress.bytes, err = strconv.ParseInt(value, 10, 64)
+		case "totalFiles":
+			progress.totalFiles, err = strconv.ParseInt(value, 10, 64)
+		case "totalBytes":
+			progress.totalBytes, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return progress, fmt.Errorf("unable to parse %s: %w", key, err)
+		}
+	}
+	return progress, nil
+}
+
+// percent returns the share of the bytes already copied.
+func (p copyProgress) percent() int {
+	if p.totalBytes <= 0 || p.bytes >= p.totalBytes {
+		return 100
+	}
+	return int(p.bytes * 100 / p.totalBytes)
+}
+
+// eta estimates the remaining time of the copy from the rate of the bytes copied in elapsed.
+// False is returned when nothing was copied yet.
+func (p copyProgress) eta(elapsed time.Duration) (time.Duration, bool) {
+	if p.bytes <= 0 || elapsed <= 0 {
+		return 0, false
+	}
+	if p.bytes >= p.totalBytes {
+		return 0, true
+	}
+	remaining := float64(elapsed) * float64(p.totalBytes-p.bytes) / float64(p.bytes)
+	return time.Duration(remaining).Round(time.Second), true
+}
+
+func (p copyProgress) String() string {
+	return fmt.Sprintf("%d%% (%d of %d bytes, %d of %d files)", p.percent(), p.bytes, p.totalBytes, p.files, p.totalFiles)
+}
+
+// volumeSummary describes the data of a volume for the verification of a copy.
+type volumeSummary struct {
+	files    int64
+	bytes    int64
+	checksum string
+}
+
+func (vs volumeSummary) String() string {
+	if vs.checksum == "" {
+		return fmt.Sprintf("%d entries of %d bytes", vs.files, vs.bytes)
+	}
+	return fmt.Sprintf("%d entries of %d bytes with checksum %s", vs.files, vs.bytes, vs.checksum)
+}
+
+// parseVolumeSummary parses a line printed by the verify command.
+func parseVolumeSummary(line string) (volumeSummary, error) {
+	var summary volumeSummary
+	for _, field := range strings.Fields(line) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return summary, fmt.Errorf("unexpected field %q", field)
+		}
+		var err error
+		switch key {
+		case "files":
+			summary.files, err = strconv.ParseInt(value, 10, 64)
+		case "bytes":
+			summary.bytes, err = strconv.ParseInt(value, 10, 64)
+		case "checksum":
+			summary.checksum = value
+		}
+		if err != nil {
+			return summary, fmt.Errorf("unable to parse %s: %w", key, err)
+		}
+	}
+	return summary, nil
+}
+
+// verifyCopy compares the summaries of the old and the new volume printed by the verify command,
+// and returns the summary of the copied data.
+func verifyCopy(output string) (volumeSummary, error) {
+	lines := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 2 {
+		return volumeSummary{}, fmt.Errorf("unable to verify copied data, unexpected output %q", output)
+	}
+	oldData, err := parseVolumeSummary(lines[0])
+	if err != nil {
+		return volumeSummary{}, fmt.Errorf("unable to verify copied data of %s: %w", oldDataMountPath, err)
+	}
+	newData, err := parseVolumeSummary(lines[1])
+	if err != nil {
+		return volumeSummary{}, fmt.Errorf("unable to verify copied data of %s: %w", newDataMountPath, err)
+	}
+	if oldData != newData {
+		return volumeSummary{}, fmt.Errorf("verification of copied data failed, %s has %s but %s has %s", oldDataMountPath, oldData, newDataMountPath, newData)
+	}
+	return newData, nil
+}
@@ -0,0 +1,145 @@
+package diskscaler
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_newDataMover(t *testing.T) {
+	testCases := map[string]struct {
+		opts      dataMoverOptions
+		expected  dataMover
+		expectErr bool
+	}{
+		"when nothing is configured tar is verified by count": {
+			expected: dataMover{mode: dataMoverTar, verify: verifyCount, image: defaultDataMoverImage, timeout: defaultDataMoverTimeout},
+		},
+		"when rsync is verified by checksum": {
+			opts:     dataMoverOptions{Mode: "Rsync", Verify: "checksum", Timeout: time.Hour, BackoffLimit: 1},
+			expected: dataMover{mode: dataMoverRsync, verify: verifyChecksum, image: defaultDataMoverImage, timeout: time.Hour, backoffLimit: 1},
+		},
+		"when the data mover is unsupported": {
+			opts:      dataMoverOptions{Mode: "dd"},
+			expectErr: true,
+		},
+		"when the verification is unsupported": {
+			opts:      dataMoverOptions{Verify: "md5"},
+			expectErr: true,
+		},
+		"when the backoff limit is negative": {
+			opts:      dataMoverOptions{BackoffLimit: -1},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		actual, err := newDataMover(tc.opts)
+		if tc.expectErr {
+			if err == nil {
+				t.Fatalf("for test case: `%s`, expected err but received none", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("for test case: `%s`, received unexpected err: %s", name, err)
+		}
+		if actual != tc.expected {
+			t.Fatalf("for test case: `%s`, expected %+v but received %+v", name, tc.expected, actual)
+		}
+	}
+}
+
+func Test_verifyCopy(t *testing.T) {
+	testCases := map[string]struct {
+		output    string
+		expectErr bool
+	}{
+		"when the counts match": {
+			output: "files=4 bytes=13\nfiles=4 bytes=13\n",
+		},
+		"when the checksums match": {
+			output: "files=4 bytes=13 checksum=abc\r\nfiles=4 bytes=13 checksum=abc\r\n",
+		},
+		"when a file was not copied": {
+			output:    "files=4 bytes=13\nfiles=3 bytes=10\n",
+			expectErr: true,
+		},
+		"when the content differs": {
+			output:    "files=4 bytes=13 checksum=abc\nfiles=4 bytes=13 checksum=def\n",
+			expectErr: true,
+		},
+		"when the output of the new volume is missing": {
+			output:    "files=4 bytes=13\n",
+			expectErr: true,
+		},
+		"when the output cannot be parsed": {
+			output:    "files=4 bytes=13\nfind: permission denied\n",
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		_, err := verifyCopy(tc.output)
+		if tc.expectErr && err == nil {
+			t.Fatalf("for test case: `%s`, expected err but received none", name)
+		}
+		if !tc.expectErr && err != nil {
+			t.Fatalf("for test case: `%s`, received unexpected err: %s", name, err)
+		}
+	}
+}
+
+func Test_parseCopyProgress(t *testing.T) {
+	testCases := map[string]struct {
+		line            string
+		elapsed         time.Duration
+		expected        copyProgress
+		expectedPercent int
+		expectedETA     time.Duration
+		expectETA       bool
+		expectErr       bool
+	}{
+		"when a quarter of the data is copied": {
+			line:            "progress files=10 bytes=256 totalFiles=40 totalBytes=1024",
+			elapsed:         time.Minute,
+			expected:        copyProgress{files: 10, bytes: 256, totalFiles: 40, totalBytes: 1024},
+			expectedPercent: 25,
+			expectedETA:     3 * time.Minute,
+			expectETA:       true,
+		},
+		"when nothing is copied yet": {
+			line:            "progress files=0 bytes=0 totalFiles=40 totalBytes=1024",
+			elapsed:         time.Minute,
+			expected:        copyProgress{totalFiles: 40, totalBytes: 1024},
+			expectedPercent: 0,
+		},
+		"when the line is not a progress line": {
+			line:      "total files=40 bytes=1024",
+			expectErr: true,
+		},
+		"when a field cannot be parsed": {
+			line:      "progress files=10 bytes=many",
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		actual, err := parseCopyProgress(tc.line)
+		if tc.expectErr {
+			if err == nil {
+				t.Fatalf("for test case: `%s`, expected err but received none", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("for test case: `%s`, received unexpected err: %s", name, err)
+		}
+		if actual != tc.expected {
+			t.Fatalf("for test case: `%s`, expected %+v but received %+v", name, tc.expected, actual)
+		}
+		if percent := actual.percent(); percent != tc.expectedPercent {
+			t.Fatalf("for test case: `%s`, expected %d%% but received %d%%", name, tc.expectedPercent, percent)
+		}
+		eta, ok := actual.eta(tc.elapsed)
+		if ok != tc.expectETA || eta != tc.expectedETA {
+			t.Fatalf("for test case: `%s`, expected eta %s (%t) but received %s (%t)", name, tc.expectedETA, tc.expectETA, eta, ok)
+		}
+	}
+}
@@ -1,17 +1,18 @@
 package diskscaler
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"math/rand"
-	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/kubecost/disk-autoscaler/pkg/provisioner"
 	"github.com/kubecost/disk-autoscaler/pkg/pvsizingrecommendation"
 	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
 	v1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -21,18 +22,13 @@ import (
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/remotecommand"
-	"k8s.io/client-go/util/retry"
-	"k8s.io/kubectl/pkg/scheme"
+	"k8s.io/client-go/tools/record"
 )
 
-var supportedSCProvisioner = []string{"ebs.csi.aws.com"}
-
 const (
-	kubecostDataMoverTransientPodName = "kubecost-data-mover-pod"
-	volumeBindingWaitForFirstConsumer = "WaitForFirstConsumer"
-	maxRetries                        = 3
-	inactivityDuringDelay             = 10 * time.Second
+	serviceAccountTokenVolumePrefix = "kube-api-access-"
+	maxRetries                      = 3
+	inactivityDuringDelay           = 10 * time.Second
 	// Setting a timeout of 4 minutes on any creation or delete operation of disk scaler
 	diskScalingOperationTimeout = 4 * time.Minute
 )
@@ -42,10 +38,31 @@ var allowedCharactersForPVCName = []rune("abcdefghijklmnopqrstuvwxyz")
 type DiskScaler struct {
 	clientConfig     *rest.Config
 	basicK8sClient   kubernetes.Interface
-	dynamicK8sClient *dynamic.DynamicClient
+	dynamicK8sClient dynamic.Interface
 	clusterID        string
-	kubecostsvc      *pvsizingrecommendation.KubecostService
+	recommender      pvsizingrecommendation.Recommender
 	auditMode        bool
+	workloads        []workload
+	journal          *journal
+	snapshotter      *snapshotter
+	// recorder records the decisions of the disk auto scaler as events on workloads and claims
+	recorder record.EventRecorder
+	// history holds the last resizes of the claims of every workload reported by the workload endpoint
+	history *resizeHistory
+	// report holds the recommendations found by the last audit run
+	report *auditReport
+	// rollbackWindow is how long a claim replaced by a resize is retained, it is deleted right away when zero
+	rollbackWindow time.Duration
+	// shrinkStrategy is the default strategy of copying data to a new volume
+	shrinkStrategy string
+	// shrinkFromSnapshot pre-copies data from a snapshot while the workload is running
+	shrinkFromSnapshot bool
+	dataMover          dataMover
+	// policies are the disk scaling policies listed at the start of the last run
+	policyMu sync.RWMutex
+	policies *policySet
+	// scaling holds the workloads being scaled, so that a workload is never scaled twice at once
+	scaling sync.Map
 }
 
 type pvcDetails struct {
@@ -59,13 +76,41 @@ type pvcDetails struct {
 	pvName               string
 	resizedPVCName       string
 	isSkippedForDeletion bool
+	driver               provisioner.Driver
+	lastResized          time.Time
+	// keepName is true when the new volume is rebound behind the claim name instead of swapping the claim
+	keepName bool
+	// workloadSpec is the pod spec of the workload mounting the claim, which the copier job inherits from
+	workloadSpec *v1.PodSpec
+	// precopiedTo is the new claim the data was pre-copied to from a snapshot while the workload was running
+	precopiedTo *v1.PersistentVolumeClaim
+	// blockMode is true for claims with volumeMode Block, whose data cannot be copied file by file
+	blockMode bool
+	// claimRef is the claim events about its resize are recorded on
+	claimRef *v1.ObjectReference
+	// savings is the projected monthly savings of resizing the claim to its recommended size
+	savings float64
+	// blocker is the reason the claim would not be resized, only set in audit mode
+	blocker error
+	// blockedBy is the precondition of the resize the blocker failed
+	blockedBy string
+	// dataEstimate is the number of bytes expected to be copied, derived from the recommendation at the target utilization
+	dataEstimate int64
+	// selectedNode pins the new volume to the topology of the original one when its storage class binds immediately
+	selectedNode string
+	// claimTemplate, ordinal, ordinalStart and allowStop are only set for claims created from a StatefulSet volumeClaimTemplate
+	claimTemplate string
+	ordinal       int
+	ordinalStart  int
+	// allowStop is true when the StatefulSet may be scaled to zero replicas to release its lowest ordinal
+	allowStop bool
 }
 
 func NewDiskScaler(clientConfig *rest.Config,
 	basicK8sClient kubernetes.Interface,
-	dynamicK8sClient *dynamic.DynamicClient,
+	dynamicK8sClient dynamic.Interface,
 	clusterID string,
-	kubecostsvc *pvsizingrecommendation.KubecostService,
+	recommender pvsizingrecommendation.Recommender,
 	auditMode bool) (*DiskScaler, error) {
 	if basicK8sClient == nil {
 		return nil, fmt.Errorf("must have a Kubernetes client")
@@ -75,95 +120,272 @@ func NewDiskScaler(clientConfig *rest.Config,
 		return nil, fmt.Errorf("disk scaler must have a dynamic client to modify custom resource")
 	}
 
-	return &DiskScaler{
+	ds := &DiskScaler{
 		clientConfig:     clientConfig,
 		basicK8sClient:   basicK8sClient,
 		dynamicK8sClient: dynamicK8sClient,
 		clusterID:        clusterID,
-		kubecostsvc:      kubecostsvc,
+		recommender:      recommender,
 		auditMode:        auditMode,
-	}, nil
+		recorder:         newEventRecorder(basicK8sClient),
+		history:          newResizeHistory(),
+		report:           newAuditReport(),
+	}
+	ds.shrinkStrategy = strings.ToLower(viper.GetString("shrink-strategy"))
+	switch ds.shrinkStrategy {
+	case "":
+		ds.shrinkStrategy = shrinkStrategySwap
+	case shrinkStrategySwap, shrinkStrategyRebind:
+	default:
+		return nil, fmt.Errorf("unsupported shrink strategy %q, supported strategies are %s and %s", ds.shrinkStrategy, shrinkStrategySwap, shrinkStrategyRebind)
+	}
+	backoffLimit := int32(defaultDataMoverBackoffLimit)
+	if viper.IsSet("data-mover-backoff-limit") {
+		backoffLimit = viper.GetInt32("data-mover-backoff-limit")
+	}
+	var err error
+	ds.dataMover, err = newDataMover(dataMoverOptions{
+		Mode:         viper.GetString("data-mover"),
+		Verify:       viper.GetString("data-mover-verify"),
+		Image:        viper.GetString("data-mover-image"),
+		Timeout:      viper.GetDuration("data-mover-timeout"),
+		BackoffLimit: backoffLimit,
+		PodTemplate:  viper.GetString("data-mover-pod-template"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ds.shrinkFromSnapshot = viper.GetBool("shrink-from-snapshot")
+	if ds.shrinkFromSnapshot && viper.GetBool("disable-snapshots") {
+		return nil, fmt.Errorf("shrinking from snapshots requires snapshots to be enabled")
+	}
+	ds.rollbackWindow = defaultRollbackWindow
+	if viper.IsSet("rollback-window") {
+		ds.rollbackWindow = viper.GetDuration("rollback-window")
+	}
+	ds.workloads = newWorkloads(ds)
+	ds.journal = newJournal(basicK8sClient, diskScalerNamespace())
+	ds.snapshotter = newSnapshotter(dynamicK8sClient, snapshotterOptions{
+		Disabled:     viper.GetBool("disable-snapshots"),
+		ClassName:    viper.GetString("snapshot-class"),
+		Retention:    viper.GetDuration("snapshot-retention"),
+		ReadyTimeout: viper.GetDuration("snapshot-ready-timeout"),
+	})
+	return ds, nil
+}
+
+// runWorkloadDiskScalingWorkflow initiates the disk scaling workflow suited to the kind of the workload.
+func (ds *DiskScaler) runWorkloadDiskScalingWorkflow(ctx context.Context, namespace, kind, name string) error {
+	wl, err := ds.workloadFor(kind)
+	if err != nil {
+		return fmt.Errorf("disk scaling failed: %w", err)
+	}
+	release, err := ds.startScaling(namespace, wl.Kind(), name)
+	if err != nil {
+		return fmt.Errorf("disk scaling failed: %w", err)
+	}
+	defer release()
+	if sts, ok := wl.(*statefulSetWorkload); ok {
+		return ds.runStatefulSetDiskScalingWorkflow(ctx, sts, namespace, name)
+	}
+	return ds.runDiskScalingWorkflow(ctx, wl, namespace, name)
+}
+
+// startScaling marks the workload as being scaled and returns the function which releases it.
+// An error is returned when the workload is already being scaled.
+func (ds *DiskScaler) startScaling(namespace, kind, name string) (func(), error) {
+	key := workloadKey(namespace, kind, name)
+	if _, busy := ds.scaling.LoadOrStore(key, struct{}{}); busy {
+		return nil, fmt.Errorf("%s %s in namespace %s is already being scaled", strings.ToLower(kind), name, namespace)
+	}
+	return func() { ds.scaling.Delete(key) }, nil
 }
 
-// runDiskScalingWorkflow initiates a disk scaling workflow for a specific deployment in the given namespace.
-func (ds *DiskScaler) runDiskScalingWorkflow(ctx context.Context, namespace, deployment string) error {
-	volMap, err := ds.getPVCMap(ctx, namespace, deployment)
+// runDiskScalingWorkflow initiates a disk scaling workflow for a specific workload in the given namespace.
+func (ds *DiskScaler) runDiskScalingWorkflow(ctx context.Context, wl workload, namespace, name string) error {
+	ref, volMap, err := ds.getPVCMap(ctx, wl, namespace, name)
 	if err != nil {
 		return fmt.Errorf("disk scaling failed : %w", err)
 	}
 
 	// No further action is needed if audit mode is enabled
-	if ds.auditMode {
+	if ds.auditing(ctx) {
+		ds.recordAudit(ctx, ref, volMap, time.Now())
 		return nil
 	}
 
-	originalScale, err := ds.retryscaleDeployment(ctx, deployment, namespace, 0)
-	if err != nil {
-		return fmt.Errorf("disk scaling failed: %w", err)
+	// Volumes which can all be expanded online are resized without stopping the workload
+	quiesce := needsQuiesce(volMap)
+	var originalScale int32
+	// Operations which stop the workload are journaled so that they can be recovered after a restart
+	var op *operation
+	if quiesce {
+		op = newOperation(namespace, wl.Kind(), name)
+		// The replicas are journaled before scaling down so that they are restored even if the scale down is not journaled
+		op.OriginalScale, err = wl.Replicas(ctx, namespace, name)
+		if err != nil {
+			return fmt.Errorf("disk scaling failed: %w", err)
+		}
+		err = ds.journal.record(ctx, op)
+		if err != nil {
+			return fmt.Errorf("disk scaling failed: %w", err)
+		}
+		// The bulk of the data is copied while the workload is running, only the changes are synced once it is stopped
+		if ds.shrinkFromSnapshot {
+			ds.precopyFromSnapshots(ctx, namespace, volMap, op)
+		}
+		err = withRetries(ctx, fmt.Sprintf("quiesce %s", strings.ToLower(wl.Kind())), func() error {
+			var quiesceErr error
+			originalScale, quiesceErr = wl.Quiesce(ctx, namespace, name)
+			return quiesceErr
+		})
+		if err != nil {
+			for pvcName := range op.Claims {
+				ds.discardPrecopy(ctx, namespace, pvcName, op)
+			}
+			ds.completeOperation(ctx, op)
+			ds.recordEvent(ctx, ref, nil, v1.EventTypeWarning, EventReasonResizeFailed, "unable to scale down to resize its volumes: %v", err)
+			return fmt.Errorf("disk scaling failed: %w", err)
+		}
+		ds.recordEvent(ctx, ref, nil, v1.EventTypeNormal, EventReasonScaledDown, "scaled down from %d replicas to resize its volumes", originalScale)
+		op.Phase = operationQuiesced
+		op.OriginalScale = originalScale
+		ds.checkpoint(ctx, op)
 	}
 
 	var didCopyFail bool
-	// During the resize operation with multiple PVC attached to same deployment
+	// During the resize operation with multiple PVC attached to same workload
 	// we dont error out rather perform the partial operation and scale back up
 	// notifying the user that errors occured.
-	for name, pvcDetails := range volMap {
+	for pvcName, pvcDetails := range volMap {
 		if isEqualQuantity(pvcDetails.currentSize, pvcDetails.resizeTo) {
-			log.Info().Msgf("ctx: %s, PVC has %s optimal storage at this time, so no action taken from disk auto scaler", ctx.Value(diskScalerRunContextKey), name)
+			log.Info().Msgf("ctx: %s, PVC has %s optimal storage at this time, so no action taken from disk auto scaler", ctx.Value(diskScalerRunContextKey), pvcName)
 			pvcDetails.isSkippedForDeletion = true
 			continue
 		}
-		if pvcDetails.allowVolumeExpansion && isGreaterQuantity(pvcDetails.currentSize, pvcDetails.resizeTo) {
-			log.Info().Msgf("ctx: %s, disk auto scaler is performing action to increase the volume size for pvc %s from %s to %s", ctx.Value(diskScalerRunContextKey), name, pvcDetails.currentSize.String(), pvcDetails.resizeTo.String())
-			err := ds.patchPVCWithResize(ctx, namespace, name, pvcDetails.resizeTo)
+		if pvcDetails.canExpand() {
+			if remaining := pvcDetails.driver.CooldownRemaining(pvcDetails.lastResized, time.Now()); remaining > 0 {
+				log.Info().Msgf("ctx: %s, PVC %s was resized at %s and provisioner %s allows another resize in %s, so no action taken from disk auto scaler", ctx.Value(diskScalerRunContextKey), pvcName, pvcDetails.lastResized.Format(timeFormat), pvcDetails.provisioner, remaining.Round(time.Minute))
+				ds.recordEvent(ctx, nil, pvcDetails.claimRef, v1.EventTypeNormal, EventReasonResizeSkipped, "pvc was resized at %s and provisioner %s allows another resize in %s", pvcDetails.lastResized.Format(timeFormat), pvcDetails.provisioner, remaining.Round(time.Minute))
+				pvcDetails.resizeTo = pvcDetails.currentSize
+				pvcDetails.isSkippedForDeletion = true
+				continue
+			}
+			log.Info().Msgf("ctx: %s, disk auto scaler is performing action to increase the volume size for pvc %s from %s to %s", ctx.Value(diskScalerRunContextKey), pvcName, pvcDetails.currentSize.String(), pvcDetails.resizeTo.String())
+			err := ds.patchPVCWithResize(ctx, namespace, pvcName, pvcDetails.resizeTo)
 			if err != nil {
 				pvcDetails.err = err
 			}
+			ds.recordExpansion(ctx, ref, pvcName, pvcDetails)
 			pvcDetails.isSkippedForDeletion = true
 		} else {
-			log.Info().Msgf("ctx: %s, disk auto scaler is performing action to decrease the volume size for pvc %s from %s to %s", ctx.Value(diskScalerRunContextKey), name, pvcDetails.currentSize.String(), pvcDetails.resizeTo.String())
+			log.Info().Msgf("ctx: %s, disk auto scaler is performing action to decrease the volume size for pvc %s from %s to %s", ctx.Value(diskScalerRunContextKey), pvcName, pvcDetails.currentSize.String(), pvcDetails.resizeTo.String())
+			if pvcDetails.keepName {
+				pvcDetails.err = ds.copyResizeKeepingName(ctx, namespace, pvcName, pvcDetails, op)
+				ds.recordRebind(ctx, ref, pvcName, pvcDetails)
+				// The workload still uses the same claim, so there is no replaced claim to retain
+				pvcDetails.isSkippedForDeletion = pvcDetails.err == nil
+				continue
+			}
 			// PVC name created with smaller pv is different from original pvc name
 			didCopyFail = false
-			newPVC, err := ds.createPVCFromASpec(ctx, namespace, name, pvcDetails.spec, pvcDetails.resizeTo, pvcDetails.resizedPVCName)
-			if err != nil {
-				pvcDetails.err = err
-				continue
+			copierJobName := fmt.Sprintf("%s-%s", kubecostDataMoverJobName, randStringRunes(5))
+			mover := ds.dataMover
+			newPVC := pvcDetails.precopiedTo
+			var claimOp *claimOperation
+			if newPVC != nil {
+				// The new PVC already holds the data of the snapshot, only the changes made since are synced
+				claimOp = op.Claims[pvcName]
+				claimOp.CopierJob = copierJobName
+				ds.checkpoint(ctx, op)
+				mover = ds.dataMover.finalSync()
+			} else {
+				// The original PVC is deleted once its data is copied, the snapshot is the only way back
+				snapshotName, err := ds.snapshotter.snapshot(ctx, namespace, pvcName, time.Now())
+				if err != nil {
+					pvcDetails.err = err
+					continue
+				}
+				claimOp = &claimOperation{Phase: claimCreated, NewClaim: pvcDetails.resizedPVCName, CopierJob: copierJobName, Snapshot: snapshotName}
+				op.Claims[pvcName] = claimOp
+				ds.checkpoint(ctx, op)
+				newPVC, err = ds.createPVCFromASpec(ctx, namespace, pvcName, pvcDetails.spec, pvcDetails.resizeTo, pvcDetails.resizedPVCName, snapshotName, pvcDetails.selectedNode)
+				if err != nil {
+					pvcDetails.err = err
+					claimOp.Phase = claimFailed
+					ds.checkpoint(ctx, op)
+					continue
+				}
+				log.Debug().Msgf("ctx: %s, created pvc of name %s of size: %s", ctx.Value(diskScalerRunContextKey), newPVC.GetName(), pvcDetails.resizeTo.String())
 			}
-			log.Debug().Msgf("ctx: %s, created pvc of name %s of size: %s", ctx.Value(diskScalerRunContextKey), newPVC.GetName(), pvcDetails.resizeTo.String())
 
-			copierPodName := fmt.Sprintf("%s-%s", kubecostDataMoverTransientPodName, randStringRunes(5))
-			err = ds.dataMoverTransientPod(ctx, namespace, copierPodName, name, pvcDetails.resizedPVCName)
+			err = ds.runCopierJob(ctx, namespace, copierJobName, mover, pvcDetails.workloadSpec, pvcName, newPVC)
 			if err != nil {
 				pvcDetails.err = err
 				didCopyFail = true
 			}
 
-			// Always delete the transient copier pod before exiting  when copy operation failed we need to forcefully delete the copier
-			err = ds.retryDeleteTransientPod(ctx, namespace, copierPodName, didCopyFail)
+			// Always delete the copier job before exiting so that its pods release the volumes
+			err = ds.retryDeleteCopierJob(ctx, namespace, copierJobName)
 			if err != nil {
-				pvcDetails.err = fmt.Errorf("ctx: %s, failed to delete transient pod after %d attempts, manual deletion needed err: %w", ctx.Value(diskScalerRunContextKey), maxRetries, err)
+				pvcDetails.err = fmt.Errorf("ctx: %s, failed to delete copier job after %d attempts, manual deletion needed err: %w", ctx.Value(diskScalerRunContextKey), maxRetries, err)
+				claimOp.Phase = claimFailed
+				ds.checkpoint(ctx, op)
+				continue
+			}
+			claimOp.CopierJob = ""
+			if didCopyFail {
+				claimOp.Phase = claimFailed
+				ds.checkpoint(ctx, op)
 				continue
 			}
 
-			log.Debug().Msgf("ctx: %s, successfully moved data between PVC: %s to PVC: %s", ctx.Value(diskScalerRunContextKey), name, newPVC.GetName())
+			log.Debug().Msgf("ctx: %s, successfully moved data between PVC: %s to PVC: %s", ctx.Value(diskScalerRunContextKey), pvcName, newPVC.GetName())
+			claimOp.Phase = claimCopied
+			ds.checkpoint(ctx, op)
 
-			// Only if copy is successful update the deployment with smaller PVC
-			if !didCopyFail {
-				err = ds.updateDeploymentWithSmallerSizePV(ctx, deployment, namespace, name, pvcDetails.resizedPVCName)
-				if err != nil {
-					pvcDetails.err = err
-				}
+			// Only if copy is successful update the workload with smaller PVC
+			err = wl.SwapClaim(ctx, namespace, name, pvcName, pvcDetails.resizedPVCName)
+			if err != nil {
+				pvcDetails.err = fmt.Errorf("update failed to the %s: %s err: %w", strings.ToLower(wl.Kind()), name, err)
+				claimOp.Phase = claimFailed
+			} else {
+				log.Info().Msgf("ctx: %s, successfully updated %s with new pvc: %s", ctx.Value(diskScalerRunContextKey), strings.ToLower(wl.Kind()), pvcDetails.resizedPVCName)
+				ds.recordEvent(ctx, ref, pvcDetails.claimRef, v1.EventTypeNormal, EventReasonClaimSwapped, "replaced pvc %s of %s with pvc %s of %s", pvcName, pvcDetails.currentSize.String(), pvcDetails.resizedPVCName, pvcDetails.resizeTo.String())
+				claimOp.Phase = claimSwapped
 			}
+			ds.checkpoint(ctx, op)
 		}
 	}
 
-	err = ds.retryAnnotateDeployment(ctx, deployment, namespace)
+	err = withRetries(ctx, fmt.Sprintf("annotate %s with disk auto scaler annotations", strings.ToLower(wl.Kind())), func() error {
+		return wl.Annotate(ctx, namespace, name, map[string]string{AnnotationLastScaled: time.Now().Format(timeFormat)})
+	})
 	if err != nil {
-		return fmt.Errorf("ctx: %s, disk scaling annotating deployment failed: %w", ctx.Value(diskScalerRunContextKey), err)
+		return fmt.Errorf("ctx: %s, disk scaling annotating %s failed: %w", ctx.Value(diskScalerRunContextKey), strings.ToLower(wl.Kind()), err)
 	}
 
-	_, err = ds.retryscaleDeployment(ctx, deployment, namespace, originalScale)
-	if err != nil {
-		return fmt.Errorf("disk scaling failed: %w", err)
+	// The workload must not start without a claim whose rebind is incomplete, the operation stays in the journal
+	if err := incompleteRebind(volMap); quiesce && err != nil {
+		ds.recordEvent(ctx, ref, nil, v1.EventTypeWarning, EventReasonResizeFailed, "not scaled back up to %d replicas because a volume is not bound after its rebind failed: %v", originalScale, err)
+		recordResizes(ctx, namespace, volMap)
+		ds.recordHistory(ref, volMap, time.Now())
+		return fmt.Errorf("disk scaling failed, %s %s is left scaled down: %w", strings.ToLower(wl.Kind()), name, err)
+	}
+
+	if quiesce {
+		err = withRetries(ctx, fmt.Sprintf("restore %s", strings.ToLower(wl.Kind())), func() error {
+			return wl.Restore(ctx, namespace, name, originalScale)
+		})
+		if err != nil {
+			ds.recordEvent(ctx, ref, nil, v1.EventTypeWarning, EventReasonResizeFailed, "unable to scale back up to %d replicas after resizing its volumes: %v", originalScale, err)
+			recordResizes(ctx, namespace, volMap)
+			ds.recordHistory(ref, volMap, time.Now())
+			return fmt.Errorf("disk scaling failed: %w", err)
+		}
+		ds.recordEvent(ctx, ref, nil, v1.EventTypeNormal, EventReasonScaledUp, "scaled back up to %d replicas after resizing its volumes", originalScale)
+		op.Phase = operationRestored
+		ds.checkpoint(ctx, op)
 	}
 
 	noOfErrors := 0
@@ -177,64 +399,36 @@ func (ds *DiskScaler) runDiskScalingWorkflow(ctx context.Context, namespace, dep
 			noOfErrors += 1
 			failedPVCS = append(failedPVCS, pvcName)
 			log.Error().Msgf("ctx: %s, disk scaling of pvc with name: %s failed with err: %v", ctx.Value(diskScalerRunContextKey), pvcName, pvcDetails.err)
+			ds.recordResizeFailure(ctx, ref, pvcName, pvcDetails)
+			// A claim left between its volumes is settled from the journal, its volumes are retained meanwhile
+			if claimOp, ok := op.Claims[pvcName]; ok && claimOp.Phase == claimRebinding {
+				continue
+			}
 			err = ds.deletePVC(ctx, namespace, pvcDetails.resizedPVCName)
 			if err != nil {
 				log.Error().Msgf("ctx: %s, unable to delete PVC created in disk scaling operation: %s", ctx.Value(diskScalerRunContextKey), pvcDetails.resizedPVCName)
 			}
 			continue
 		}
-		err = ds.deletePVC(ctx, namespace, pvcName)
+		err = ds.retainReplacedPVC(ctx, namespace, pvcName, pvcDetails.resizedPVCName)
 		if err != nil {
-			log.Error().Msgf("ctx: %s, unable to delete PVC after the disk scaling operation: %s", ctx.Value(diskScalerRunContextKey), pvcName)
+			log.Error().Msgf("ctx: %s, unable to retain or delete PVC after the disk scaling operation: %s err: %v", ctx.Value(diskScalerRunContextKey), pvcName, err)
 		}
 	}
+	if !op.rebinding() {
+		ds.completeOperation(ctx, op)
+	}
+	recordResizes(ctx, namespace, volMap)
+	ds.recordHistory(ref, volMap, time.Now())
 
 	if noOfErrors == 0 {
 		return nil
 	}
 
 	if noOfErrors == len(volMap) {
-		return &DiskScalingAllFailedError{namespace: namespace, deployment: deployment}
+		return &DiskScalingAllFailedError{namespace: namespace, kind: wl.Kind(), workload: name}
 	}
-	return &DiskScalingPartialFailedError{namespace: namespace, deployment: deployment, pvc: failedPVCS}
-}
-
-// retryAnnotateDeployment attempts to retry annotating the deployment in case of any infrastructure delay
-func (ds *DiskScaler) retryAnnotateDeployment(ctx context.Context, deploymentName string, namespace string) error {
-	var retryErr error
-	for i := 0; i < maxRetries; i++ {
-		retryErr = ds.annotateDeployment(ctx, deploymentName, namespace)
-		if retryErr == nil {
-			break
-		}
-		log.Debug().Msgf("ctx: %s, failed to annotate deployment with disk auto scaler annotations in %d attempt(s) with err %v", ctx.Value(diskScalerRunContextKey), i, retryErr)
-		if i < maxRetries-1 {
-			time.Sleep(inactivityDuringDelay)
-		}
-	}
-	return retryErr
-}
-
-// annotateDeployment annotates the given deployment with specific annotations required for the disk autoscaler.
-func (ds *DiskScaler) annotateDeployment(ctx context.Context, deploymentName string, namespace string) error {
-	deployment := ds.basicK8sClient.AppsV1().
-		Deployments(namespace)
-
-	dep, err := deployment.
-		Get(ctx, deploymentName, metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to get deployment with err: %w", err)
-	}
-	lastScalingTime := time.Now().Format(timeFormat)
-	currAnnotation := dep.GetAnnotations()
-	currAnnotation[AnnotationLastScaled] = lastScalingTime
-	dep.SetAnnotations(currAnnotation)
-	dep, err = deployment.Update(ctx, dep, metav1.UpdateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to annotate the deployment with err: %w", err)
-	}
-	log.Debug().Msgf("ctx: %s, successfully updated deployment %s with last scaling timestamp: %s", ctx.Value(diskScalerRunContextKey), dep.Name, lastScalingTime)
-	return nil
+	return &DiskScalingPartialFailedError{namespace: namespace, kind: wl.Kind(), workload: name, pvc: failedPVCS}
 }
 
 // patchPVCWithResize resizes the PersistentVolumeClaim (PVC) associated
@@ -244,58 +438,33 @@ func (ds *DiskScaler) annotateDeployment(ctx context.Context, deploymentName str
 func (ds *DiskScaler) patchPVCWithResize(ctx context.Context, namespace, pvc string, resizeTo resource.Quantity) error {
 	persVolC := ds.basicK8sClient.CoreV1().PersistentVolumeClaims(namespace)
 
-	data := fmt.Sprintf(`{ "spec": { "resources": { "requests": { "storage": "%s" }}}}`, resizeTo.String())
+	// The resize time is recorded so that the resize cooldown of the provisioner can be respected
+	data := fmt.Sprintf(`{ "metadata": { "annotations": { "%s": "%s", "%s": "%s" }}, "spec": { "resources": { "requests": { "storage": "%s" }}}}`,
+		PVCAnnotationExtendBy, DiskAutoScaler, PVCAnnotationLastResized, time.Now().Format(timeFormat), resizeTo.String())
 	updatePVC, err := persVolC.Patch(ctx, pvc, types.MergePatchType, []byte(data), metav1.PatchOptions{})
 	if err != nil {
 		return fmt.Errorf("unable to patch pvc: %s err: %w", pvc, err)
 	}
-	// To-Do: add annotation extendBy kubecot_disk_auto_scaler for the pvc in the same patch
 	log.Info().Msgf("ctx: %s, updated PVC %s with size: %s", ctx.Value(diskScalerRunContextKey), updatePVC.GetName(), resizeTo.String())
 	return nil
 }
 
-// updateDeploymentWithSmallerSizePV updates the Deployment's spec to use a new PVC name
-// for a smaller-sized PersistentVolumeClaim (PVC).
-func (ds *DiskScaler) updateDeploymentWithSmallerSizePV(ctx context.Context, deploymentName string, namespace string, oldClaimName string, newClaim string) error {
-	deployment := ds.basicK8sClient.AppsV1().
-		Deployments(namespace)
-
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		// Retrieve the latest version of Deployment before attempting update
-		// RetryOnConflict uses exponential backoff to avoid exhausting the apiserver
-		result, getErr := deployment.Get(ctx, deploymentName, metav1.GetOptions{})
-		if getErr != nil {
-			return fmt.Errorf("unable to get deployment: %s err: %w", deploymentName, getErr)
-		}
-
-		volumes := result.Spec.Template.Spec.Volumes
-		// Get the volume with old claim name
-		var volumeToReplaceClaimName *v1.Volume
-		for _, volume := range volumes {
-			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == oldClaimName {
-				volumeToReplaceClaimName = &volume
-			}
-		}
-		volumeToReplaceClaimName.PersistentVolumeClaim.ClaimName = newClaim
-		_, updateErr := deployment.Update(ctx, result, metav1.UpdateOptions{})
-		return updateErr
-	})
-	if retryErr != nil {
-		return fmt.Errorf("update failed to the deployment: %s err: %w", deploymentName, retryErr)
+// getRecommendationForPV is used to get the recommendation from
+// the configured recommender for a particular pvName.
+func (ds *DiskScaler) getRecommendationForPV(ctx context.Context, pvName string, targetUtilization int, interval string) (pvsizingrecommendation.RecommendationSizeWithSavings, error) {
+	recommendedStorageQuantity, err := ds.recommender.GetRecommendation(ctx, pvName, targetUtilization, interval)
+	if err != nil {
+		return recommendedStorageQuantity, fmt.Errorf("failed to get recommendation err: %w", err)
 	}
-
-	log.Info().Msgf("ctx: %s,successfully updated deployment with new pvc: %s", ctx.Value(diskScalerRunContextKey), newClaim)
-	return nil
+	return recommendedStorageQuantity, nil
 }
 
-// getKubecostRecommendationForPV is used to get the recommendation from
-// kubecost service for a particular pvName.
-func (ds *DiskScaler) getKubecostRecommendationForPV(ctx context.Context, pvName string, targetUtilization int, interval string) (pvsizingrecommendation.RecommendationSizeWithSavings, error) {
-	recommendedStorageQuantity, err := ds.kubecostsvc.GetRecommendation(ctx, pvName, targetUtilization, interval)
-	if err != nil {
-		return recommendedStorageQuantity, fmt.Errorf("failed to get recommendation from kubecost err: %w", err)
+// projectedFullAt formats when the volume is forecast to be full for logging.
+func projectedFullAt(recommendation pvsizingrecommendation.RecommendationSizeWithSavings) string {
+	if recommendation.ProjectedFullAt.IsZero() {
+		return "unknown"
 	}
-	return recommendedStorageQuantity, nil
+	return recommendation.ProjectedFullAt.Format(timeFormat)
 }
 
 // newPVCName generates a new unique name for a PersistentVolumeClaim (PVC) to ensure that
@@ -331,188 +500,245 @@ func (ds *DiskScaler) isPVValidForDiskScaling(k8spv *v1.PersistentVolume) bool {
 }
 
 // getPVCMap retrieves and maps the PersistentVolumeClaim (PVC) and its associated information
-// before scaling the deployment, in order to perform the PersistentVolume (PV) scaling.
-func (ds *DiskScaler) getPVCMap(ctx context.Context, namespace string, deploymentName string) (map[string]*pvcDetails, error) {
-	deployment := ds.basicK8sClient.AppsV1().
-		Deployments(namespace)
-
+// before scaling the workload, in order to perform the PersistentVolume (PV) scaling.
+// The reference of the workload is returned along so that events can be recorded on it.
+func (ds *DiskScaler) getPVCMap(ctx context.Context, wl workload, namespace string, name string) (*v1.ObjectReference, map[string]*pvcDetails, error) {
 	volumeMap := map[string]*pvcDetails{}
-	v1Dep, err := deployment.Get(ctx, deploymentName, metav1.GetOptions{})
+	meta, template, err := wl.Get(ctx, namespace, name)
 	if err != nil {
-		log.Error().Msgf("ctx: %s, unable to get deployment for the name %s err: %v", ctx.Value(diskScalerRunContextKey), deploymentName, err)
-		return volumeMap, fmt.Errorf("unable to get deployment for the name %s err: %w", deploymentName, err)
+		log.Error().Msgf("ctx: %s, unable to get %s for the name %s err: %v", ctx.Value(diskScalerRunContextKey), strings.ToLower(wl.Kind()), name, err)
+		return nil, volumeMap, err
 	}
+	ref := workloadReference(wl.Kind(), meta)
 
-	currentAnnotation := v1Dep.GetAnnotations()
-	targetUtilization := currentAnnotation[AnnotationTargetUtilization]
-	if targetUtilization == "" {
-		targetUtilization = defaultTargetUtilization
-	}
-
-	var intTargetUtilization int
-	if intTargetUtilization, err = strconv.Atoi(targetUtilization); err != nil {
-		log.Warn().Msgf("targetUtilization is invalid for deployment name %s, defaulting to %s", deploymentName, defaultTargetUtilization)
-		intTargetUtilization, _ = strconv.Atoi(defaultTargetUtilization)
-	}
-
-	interval := currentAnnotation[AnnotationInterval]
-	if interval == "" {
-		interval = defaultInterval
-	}
-	if _, err = time.ParseDuration(interval); err != nil {
-		log.Warn().Msgf("interval is invalid for deployment name %s, defaulting to %s", deploymentName, defaultInterval)
-		interval = defaultInterval
-	}
+	policy := ds.policyFor(meta)
+	keepName := ds.shrinkStrategyFor(meta) == shrinkStrategyRebind
 
-	volumes := v1Dep.Spec.Template.Spec.Volumes
+	volumes := template.Spec.Volumes
 	for _, vol := range volumes {
 		if vol.PersistentVolumeClaim == nil {
+			// The service account token volume is injected by the API server into every pod
+			if vol.Projected != nil && strings.HasPrefix(vol.Name, serviceAccountTokenVolumePrefix) {
+				continue
+			}
 			// The PVC name is required even when in audit mode so we continue and don't provide any recommendation or err logs
 			// this condition is typically encountered when we have ephemeral storage, i.e storage tied to pod lifecyle.
-			if ds.auditMode {
+			if ds.auditing(ctx) {
 				continue
 			}
-			log.Error().Msgf("ctx: %s, deployment %s contains non PV claim volume source", ctx.Value(diskScalerRunContextKey), deploymentName)
-			return map[string]*pvcDetails{}, fmt.Errorf("deployment %s contains non PV claim volume source", deploymentName)
+			log.Error().Msgf("ctx: %s, %s %s contains non PV claim volume source", ctx.Value(diskScalerRunContextKey), strings.ToLower(wl.Kind()), name)
+			ds.recordEvent(ctx, ref, nil, v1.EventTypeWarning, EventReasonResizeSkipped, "volume %s is not a persistent volume claim, volumes of the %s are not resized", vol.Name, strings.ToLower(wl.Kind()))
+			return ref, map[string]*pvcDetails{}, fmt.Errorf("%s %s contains non PV claim volume source", strings.ToLower(wl.Kind()), name)
 		}
 		pvcName := vol.PersistentVolumeClaim.ClaimName
-		k8sPVCInfo, err := ds.getPVCInfo(ctx, namespace, pvcName)
+		details, err := ds.getPVCDetails(ctx, namespace, ref, pvcName, policy)
 		if err != nil {
-			// The PVC information is required even when in audit mode so we continue and don't provide any recommendations or err logs
-			if ds.auditMode {
-				continue
-			}
-			return map[string]*pvcDetails{}, fmt.Errorf("failed to get volume map for pvc: %s with err: %w", pvcName, err)
+			return ref, map[string]*pvcDetails{}, err
+		}
+		// nil details without an error means the claim is skipped in audit mode
+		if details == nil {
+			continue
 		}
+		details.keepName = keepName
+		details.workloadSpec = &template.Spec
+		volumeMap[pvcName] = details
+	}
 
-		pvName := k8sPVCInfo.Spec.VolumeName
+	return ref, volumeMap, nil
+}
 
-		// Check to see if PV is not hostPath mounted. Kubecost doesn't provide recommendations for hostPath mounts at this time.
-		// i.e volume mounted on node itself rather than a Physical volume.
-		pvInfo, err := ds.getPVInfo(ctx, pvName)
-		if err != nil {
-			// checking pvInfo before asking for recommendation from kubecost is important. In case of audit mode error log is ignore
-			// for this pv if there's any failure.
-			if ds.auditMode {
-				continue
-			}
-			return map[string]*pvcDetails{}, fmt.Errorf("unable to get kubernetes pv information for pv %s with err:%w", pvName, err)
-		}
+// scalingSettings reads the target utilization and interval annotations of a workload,
+// falling back to the defaults when they are missing or invalid.
+func scalingSettings(annotations map[string]string, workloadName string) (int, string) {
+	targetUtilization := annotations[AnnotationTargetUtilization]
+	if targetUtilization == "" {
+		targetUtilization = defaultTargetUtilization
+	}
 
-		spec := k8sPVCInfo.Spec
-		storageCapacity := k8sPVCInfo.Status.Capacity[v1.ResourceStorage]
-		storageClassName := k8sPVCInfo.Spec.StorageClassName
+	intTargetUtilization, err := strconv.Atoi(targetUtilization)
+	if err != nil {
+		log.Warn().Msgf("targetUtilization is invalid for workload name %s, defaulting to %s", workloadName, defaultTargetUtilization)
+		intTargetUtilization, _ = strconv.Atoi(defaultTargetUtilization)
+	}
 
-		isValid := ds.isPVValidForDiskScaling(pvInfo)
-		if !isValid {
-			if ds.auditMode {
-				// hostpath mounted pvs are ingnored by disk scaler at this time. In case of audit mode we dont provide any error
-				continue
-			}
-			return map[string]*pvcDetails{}, fmt.Errorf("pv %s is invalid for disk autoscaling", pvName)
-		}
+	interval := annotations[AnnotationInterval]
+	if interval == "" {
+		interval = defaultInterval
+	}
+	if _, err = pvsizingrecommendation.ParseInterval(interval); err != nil {
+		log.Warn().Msgf("interval is invalid for workload name %s, defaulting to %s", workloadName, defaultInterval)
+		interval = defaultInterval
+	}
+	return intTargetUtilization, interval
+}
 
-		log.Debug().Msgf("ctx: %s, backing volume name is: %s for pvc: %s", ctx.Value(diskScalerRunContextKey), pvName, pvcName)
-		recommendation, err := ds.getKubecostRecommendationForPV(ctx, pvName, intTargetUtilization, interval)
-		if err == nil {
-			log.Info().Msgf("Namespace: %s, Deployment: %s, PVC: %s, PV: %s, Target Utilization: %d%%, current size is: %s, recommended size is: %s, and expected monthly savings is: $%.2f", namespace, deploymentName, pvcName, pvName, intTargetUtilization, storageCapacity.String(), recommendation.RecommendedResourceSize.String(), recommendation.Savings)
+// getPVCDetails validates a single PersistentVolumeClaim mounted by a workload and computes
+// the size it should be scaled to. In audit mode a claim that cannot be found is skipped by
+// returning nil details and a nil error, and a claim that would not be resized is returned
+// along with the reason in its blocker instead of an error, so that it is still reported.
+// When a resize is attempted, that is when not auditing, the recommendation and the reasons a
+// claim is not resized are recorded as events on the workload, referenced by owner, and on the claim.
+func (ds *DiskScaler) getPVCDetails(ctx context.Context, namespace string, owner *v1.ObjectReference, pvcName string, policy scalingPolicy) (*pvcDetails, error) {
+	k8sPVCInfo, err := ds.getPVCInfo(ctx, namespace, pvcName)
+	if err != nil {
+		// The PVC information is required even when in audit mode so we continue and don't provide any recommendations or err logs
+		if ds.auditing(ctx) {
+			return nil, nil
 		}
-		if ds.auditMode {
-			continue
+		return nil, fmt.Errorf("failed to get volume map for pvc: %s with err: %w", pvcName, err)
+	}
+
+	pvName := k8sPVCInfo.Spec.VolumeName
+	claimRef := claimReference(k8sPVCInfo)
+
+	// Check to see if PV is not hostPath mounted. Kubecost doesn't provide recommendations for hostPath mounts at this time.
+	// i.e volume mounted on node itself rather than a Physical volume.
+	pvInfo, err := ds.getPVInfo(ctx, pvName)
+	if err != nil {
+		// checking pvInfo before asking for recommendation from kubecost is important. In case of audit mode error log is ignore
+		// for this pv if there's any failure.
+		if ds.auditing(ctx) {
+			return nil, nil
 		}
-		resizeTo := recommendation.RecommendedResourceSize
-		if err != nil {
-			return map[string]*pvcDetails{}, fmt.Errorf("unable to get recommendation from kubecost %w", err)
+		return nil, fmt.Errorf("unable to get kubernetes pv information for pv %s with err:%w", pvName, err)
+	}
+
+	spec := k8sPVCInfo.Spec
+	storageCapacity := k8sPVCInfo.Status.Capacity[v1.ResourceStorage]
+	storageClassName := k8sPVCInfo.Spec.StorageClassName
+
+	details := &pvcDetails{
+		currentSize: storageCapacity,
+		spec:        spec,
+		pvName:      pvName,
+		lastResized: lastResizedTime(k8sPVCInfo),
+		blockMode:   spec.VolumeMode != nil && *spec.VolumeMode == v1.PersistentVolumeBlock,
+		claimRef:    claimRef,
+	}
+	if storageClassName != nil {
+		details.storageClass = *storageClassName
+	}
+	// skip records why the claim is not resized, in audit mode the claim is returned along with the reason
+	skip := func(check string, err error, messageFmt string, args ...interface{}) (*pvcDetails, error) {
+		if ds.auditing(ctx) {
+			details.blocker = err
+			details.blockedBy = check
+			return details, nil
 		}
+		ds.recordEvent(ctx, owner, claimRef, v1.EventTypeWarning, EventReasonResizeSkipped, messageFmt, args...)
+		return nil, err
+	}
 
-		newPVCName, err := ds.newPVCName(ctx, namespace, pvcName)
-		if err != nil {
-			return map[string]*pvcDetails{}, fmt.Errorf("failed to create a new PVC Name: %w", err)
-		}
+	isValid := ds.isPVValidForDiskScaling(pvInfo)
+	if !isValid {
+		return skip(preconditionVolume, fmt.Errorf("pv %s is invalid for disk autoscaling", pvName), "pv %s of pvc %s is not backed by a persistent disk, such as a hostPath volume", pvName, pvcName)
+	}
 
-		scClass, err := ds.getStorageClassInfo(ctx, k8sPVCInfo.GetName(), *storageClassName)
-		if err != nil {
-			return map[string]*pvcDetails{}, fmt.Errorf("failed to get storage class info: %w", err)
-		}
+	log.Debug().Msgf("ctx: %s, backing volume name is: %s for pvc: %s", ctx.Value(diskScalerRunContextKey), pvName, pvcName)
+	recommendation, err := ds.getRecommendationForPV(ctx, pvName, policy.targetUtilization, policy.interval)
+	if err != nil {
+		return skip(preconditionRecommendation, fmt.Errorf("unable to get recommendation %w", err), "no recommendation is available for pvc %s: %v", pvcName, err)
+	}
+	recordRecommendation(ctx, namespace, pvcName, pvName, storageCapacity, recommendation)
+	if !ds.auditing(ctx) {
+		ds.recordEvent(ctx, nil, claimRef, v1.EventTypeNormal, EventReasonResizeRecommended, "recommended size is %s for a current size of %s at %d%% target utilization, expected monthly savings is $%.2f", recommendation.RecommendedResourceSize.String(), storageCapacity.String(), policy.targetUtilization, recommendation.Savings)
+	}
+	log.Info().Msgf("Namespace: %s, %s: %s, PVC: %s, PV: %s, Target Utilization: %d%%, current size is: %s, recommended size is: %s, projected to be full at: %s, and expected monthly savings is: $%.2f", namespace, owner.Kind, owner.Name, pvcName, pvName, policy.targetUtilization, storageCapacity.String(), recommendation.RecommendedResourceSize.String(), projectedFullAt(recommendation), recommendation.Savings)
+	resizeTo := recommendation.RecommendedResourceSize
+	details.resizeTo = policy.limit(storageCapacity, resizeTo)
+	details.savings = recommendation.Savings
+	details.dataEstimate = recommendation.RecommendedResourceSize.Value() * int64(policy.targetUtilization) / 100
 
-		provisioner := scClass.Provisioner
-		allowExpansion := *scClass.AllowVolumeExpansion
-		volumeBindingMode := *scClass.VolumeBindingMode
-		log.Debug().Msgf("ctx: %s, provisioner is: %s allowVolumeExpansion is: %t, volumeBindingMode is: %s", ctx.Value(diskScalerRunContextKey), provisioner, allowExpansion, volumeBindingMode)
+	details.resizedPVCName, err = ds.newPVCName(ctx, namespace, pvcName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a new PVC Name: %w", err)
+	}
 
-		// Currently only support storage class with provisioner "ebs.csi.aws.com"
-		if !slices.Contains(supportedSCProvisioner, provisioner) {
-			log.Error().Msgf("ctx: %s, unsupported provisioner %s for storage class %s", ctx.Value(diskScalerRunContextKey), provisioner, *storageClassName)
-			return map[string]*pvcDetails{}, fmt.Errorf("unsupported provisioner %s for storage class %s", provisioner, *storageClassName)
-		}
+	scClass, err := ds.getStorageClassInfo(ctx, k8sPVCInfo.GetName(), details.storageClass)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage class info: %w", err)
+	}
 
-		if volumeBindingMode != volumeBindingWaitForFirstConsumer {
-			log.Error().Msgf("ctx: %s, unsupported volumeBindingMode %s for storage class %s", ctx.Value(diskScalerRunContextKey), volumeBindingMode, *storageClassName)
-			return map[string]*pvcDetails{}, fmt.Errorf("cannot support volume binding mode %s for storage class %s", volumeBindingMode, *storageClassName)
-		}
+	provisionerName := scClass.Provisioner
+	allowExpansion := scClass.AllowVolumeExpansion != nil && *scClass.AllowVolumeExpansion
+	volumeBindingMode := storagev1.VolumeBindingImmediate
+	if scClass.VolumeBindingMode != nil {
+		volumeBindingMode = *scClass.VolumeBindingMode
+	}
+	log.Debug().Msgf("ctx: %s, provisioner is: %s allowVolumeExpansion is: %t, volumeBindingMode is: %s", ctx.Value(diskScalerRunContextKey), provisionerName, allowExpansion, volumeBindingMode)
+	details.provisioner = provisionerName
+	details.allowVolumeExpansion = allowExpansion
 
-		pvcDetails := &pvcDetails{
-			currentSize:          storageCapacity,
-			storageClass:         *storageClassName,
-			provisioner:          provisioner,
-			allowVolumeExpansion: allowExpansion,
-			spec:                 spec,
-			resizeTo:             resizeTo,
-			pvName:               pvName,
-			resizedPVCName:       newPVCName,
-		}
+	driver, ok := provisioner.Lookup(provisionerName)
+	if !ok {
+		log.Error().Msgf("ctx: %s, unsupported provisioner %s for storage class %s", ctx.Value(diskScalerRunContextKey), provisionerName, details.storageClass)
+		return skip(preconditionProvisioner, fmt.Errorf("unsupported provisioner %s for storage class %s", provisionerName, details.storageClass), "provisioner %s of storage class %s is not supported", provisionerName, details.storageClass)
+	}
+	details.driver = driver
+	details.resizeTo = driver.Normalize(details.resizeTo)
 
-		volumeMap[k8sPVCInfo.GetName()] = pvcDetails
+	if !isEqualQuantity(details.resizeTo, driver.Normalize(resizeTo)) {
+		log.Info().Msgf("ctx: %s, recommended size %s of pvc %s is limited to %s by %s", ctx.Value(diskScalerRunContextKey), resizeTo.String(), pvcName, details.resizeTo.String(), policy.sourceName())
 	}
 
-	return volumeMap, nil
-}
+	// A raw block volume carries no filesystem the data mover could copy, so it is only resized in place
+	if details.needsCopy() && details.blockMode {
+		log.Error().Msgf("ctx: %s, pvc %s with volume mode %s cannot be resized from %s to %s by copy", ctx.Value(diskScalerRunContextKey), pvcName, v1.PersistentVolumeBlock, storageCapacity.String(), details.resizeTo.String())
+		return skip(preconditionVolumeMode, fmt.Errorf("cannot resize pvc %s with volume mode %s by copy, only expansion in place is supported", pvcName, v1.PersistentVolumeBlock), "pvc %s with volume mode %s cannot be resized from %s to %s by copy", pvcName, v1.PersistentVolumeBlock, storageCapacity.String(), details.resizeTo.String())
+	}
 
-// retryAnnotateDeployment attempts to retry annotating the deployment in case of any infrastructure delay
-func (ds *DiskScaler) retryscaleDeployment(ctx context.Context, deploymentName string, namespace string, scaleTo int32) (int32, error) {
-	var retryErr error
-	var originalScale int32
-	for i := 0; i < maxRetries; i++ {
-		originalScale, retryErr = ds.scaleDeployment(ctx, deploymentName, namespace, scaleTo)
-		if retryErr == nil {
-			break
+	// The binding mode matters only when the data is copied to a newly provisioned volume
+	if details.needsCopy() && !driver.SupportsBindingMode(volumeBindingMode) {
+		if volumeBindingMode != storagev1.VolumeBindingImmediate {
+			log.Error().Msgf("ctx: %s, unsupported volumeBindingMode %s for storage class %s", ctx.Value(diskScalerRunContextKey), volumeBindingMode, details.storageClass)
+			return skip(preconditionBindingMode, fmt.Errorf("cannot support volume binding mode %s for storage class %s", volumeBindingMode, details.storageClass), "volume binding mode %s of storage class %s is not supported to resize pvc %s by copy", volumeBindingMode, details.storageClass, pvcName)
 		}
-		log.Debug().Msgf("ctx: %s, failed to scale deployment with disk auto scaler in %d attempt(s) with err %v", ctx.Value(diskScalerRunContextKey), i, retryErr)
-		if i < maxRetries-1 {
-			time.Sleep(inactivityDuringDelay)
+		// A volume bound immediately could be provisioned where the original volume cannot be mounted
+		// along with it, so the new volume is pinned to a node in the topology of the original volume
+		// and the copier pod is scheduled there by the node affinity of both volumes.
+		selectedNode, err := ds.nodeForPV(ctx, pvInfo)
+		if err != nil {
+			log.Error().Msgf("ctx: %s, unable to pin pvc %s to the topology of pv %s: %v", ctx.Value(diskScalerRunContextKey), pvcName, pvName, err)
+			return skip(preconditionBindingMode, fmt.Errorf("cannot support volume binding mode %s for storage class %s: %w", volumeBindingMode, details.storageClass, err), "unable to pin the new volume of pvc %s to the topology of pv %s for volume binding mode %s: %v", pvcName, pvName, volumeBindingMode, err)
 		}
+		log.Info().Msgf("ctx: %s, new volume of pvc %s is pinned to node %s in the topology of pv %s", ctx.Value(diskScalerRunContextKey), pvcName, selectedNode, pvName)
+		details.selectedNode = selectedNode
 	}
-	return originalScale, retryErr
-}
 
-// scaleDeployment scales the specified Deployment to the number of replicas specified in scaleTo.
-func (ds *DiskScaler) scaleDeployment(ctx context.Context, deploymentName string, namespace string, scaleTo int32) (int32, error) {
-	deployment := ds.basicK8sClient.AppsV1().
-		Deployments(namespace)
+	return details, nil
+}
 
-	s, err := deployment.
-		GetScale(ctx, deploymentName, metav1.GetOptions{})
-	if err != nil {
-		return 0, fmt.Errorf("failed to get deployment to scale with err: %w", err)
-	}
+// canExpand returns true when the claim grows and the storage class allows expanding it in place.
+func (p *pvcDetails) canExpand() bool {
+	return p.allowVolumeExpansion && isGreaterQuantity(p.currentSize, p.resizeTo)
+}
 
-	sc := *s
-	originalScale := sc.Spec.Replicas
-	sc.Spec.Replicas = scaleTo
+// needsCopy returns true when the data has to be copied to a new volume of the recommended size.
+func (p *pvcDetails) needsCopy() bool {
+	return !isEqualQuantity(p.currentSize, p.resizeTo) && !p.canExpand()
+}
 
-	if originalScale == scaleTo {
-		log.Info().Msgf("scaling the deployment skipped as the existing deployment scale is the same: %d", originalScale)
+// needsQuiesce returns true when a claim has to be detached from the workload to be resized,
+// either to copy its data or because the provisioner cannot expand it online.
+func needsQuiesce(volMap map[string]*pvcDetails) bool {
+	for _, pvcDetails := range volMap {
+		if pvcDetails.needsCopy() {
+			return true
+		}
+		if pvcDetails.canExpand() && !pvcDetails.driver.OnlineExpansion {
+			return true
+		}
 	}
+	return false
+}
 
-	_, err = deployment.
-		UpdateScale(ctx,
-			deploymentName, &sc, metav1.UpdateOptions{})
+// lastResizedTime returns when the claim was last expanded by the disk auto scaler.
+func lastResizedTime(pvc *v1.PersistentVolumeClaim) time.Time {
+	lastResized, err := time.Parse(timeFormat, pvc.GetAnnotations()[PVCAnnotationLastResized])
 	if err != nil {
-		return 0, fmt.Errorf("unable to scale the deployment: %w", err)
+		return time.Time{}
 	}
-
-	log.Info().Msgf("ctx: %s, successfully scaled deployment: %s from %d to %d", ctx.Value(diskScalerRunContextKey), deploymentName, originalScale, scaleTo)
-	return originalScale, nil
+	return lastResized
 }
 
 // getPVCInfo retrieves information about the PersistentVolumeClaim (PVC) that is to be shrunk.
@@ -589,8 +815,10 @@ func (ds *DiskScaler) deletePVC(ctx context.Context, namespace string, pvcName s
 	return nil
 }
 
-// createPVCFromASpec is used to keep the spec between original PVC and new PVC same except the size
-func (ds *DiskScaler) createPVCFromASpec(ctx context.Context, namespace string, pvc string, spec v1.PersistentVolumeClaimSpec, newSize resource.Quantity, newPVCName string) (*v1.PersistentVolumeClaim, error) {
+// createPVCFromASpec is used to keep the spec between original PVC and new PVC same except the size.
+// The snapshot of the original PVC taken before the resize, if any, is recorded on the new PVC,
+// and the new PVC is provisioned for selectedNode, if any.
+func (ds *DiskScaler) createPVCFromASpec(ctx context.Context, namespace string, pvc string, spec v1.PersistentVolumeClaimSpec, newSize resource.Quantity, newPVCName string, snapshotName string, selectedNode string) (*v1.PersistentVolumeClaim, error) {
 	spec.Resources.Requests[v1.ResourceStorage] = newSize
 	// volumename should be set to empty otherwise there will be resource creation failure
 	spec.VolumeName = ""
@@ -605,6 +833,12 @@ func (ds *DiskScaler) createPVCFromASpec(ctx context.Context, namespace string,
 		},
 		Spec: spec,
 	}
+	if snapshotName != "" {
+		pvcObj.Annotations[PVCAnnotationLastSnapshot] = snapshotName
+	}
+	if selectedNode != "" {
+		pvcObj.Annotations[selectedNodeAnnotation] = selectedNode
+	}
 
 	smallerPvc, err := ds.basicK8sClient.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvcObj, metav1.CreateOptions{})
 	if err != nil {
@@ -613,212 +847,6 @@ func (ds *DiskScaler) createPVCFromASpec(ctx context.Context, namespace string,
 	return smallerPvc, nil
 }
 
-// dataMoverTransientPod create a transient pod to move data between original PV claim volume source to new PV Claim volume source
-func (ds *DiskScaler) dataMoverTransientPod(ctx context.Context, namespace string, copierPodName string, originalPVC string, newPVC string) error {
-	cpCommand := "if [ -z \"$(ls -A /oldData)\" ]; then echo \"directory is empty no need to copy\"; else  cp -r /oldData/* /newData/; fi"
-	req := &v1.Pod{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "Pod",
-			APIVersion: "v1",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name: copierPodName,
-		},
-		Spec: v1.PodSpec{
-			Containers: []v1.Container{
-				{
-					Name:    "temp-container",
-					Image:   "ubuntu",
-					Command: []string{"/bin/bash", "-c", "sleep infinity"},
-					VolumeMounts: []v1.VolumeMount{
-						{
-							Name:      "orig-vol-mount",
-							MountPath: "/oldData",
-						},
-						{
-							Name:      "backup-vol-mount",
-							MountPath: "/newData",
-						},
-					},
-				},
-			},
-			Volumes: []v1.Volume{
-				{
-					Name: "orig-vol-mount",
-					VolumeSource: v1.VolumeSource{
-						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
-							ClaimName: originalPVC,
-						},
-					},
-				},
-				{
-					Name: "backup-vol-mount",
-					VolumeSource: v1.VolumeSource{
-						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
-							ClaimName: newPVC,
-						},
-					},
-				},
-			},
-		},
-	}
-
-	resp, err := ds.basicK8sClient.CoreV1().Pods(namespace).Create(ctx, req, metav1.CreateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to create copier pod %s in namespace %s with err: %w ", copierPodName, namespace, err)
-	}
-
-	status := resp.Status
-
-	var w watch.Interface
-	if w, err = ds.basicK8sClient.CoreV1().Pods(namespace).Watch(ctx, metav1.ListOptions{
-		Watch:           true,
-		ResourceVersion: resp.ResourceVersion,
-	}); err != nil {
-		return fmt.Errorf("failed to create watcher for creation of copier pod %s in namespace %s with err: %w", copierPodName, namespace, err)
-	}
-
-	func() {
-		for {
-			select {
-			case events, ok := <-w.ResultChan():
-				if !ok {
-					return
-				}
-				switch events.Object.(type) {
-				case *v1.Pod:
-					resp = events.Object.(*v1.Pod)
-					status = resp.Status
-					// Check for if pod is in running state before the diskScalingOperationTimeout
-					if resp.Status.Phase == v1.PodRunning {
-						w.Stop()
-					}
-				default:
-					// intermittent typecasting to *metav1.Status... In this case we continue
-					// and look for if pod is running set diskScalingOperationTimeout
-				}
-
-			case <-time.After(diskScalingOperationTimeout):
-				log.Error().Msgf("ctx: %s, timeout to wait for pod %s in namespace %s to be in running state", ctx.Value(diskScalerRunContextKey), copierPodName, namespace)
-				w.Stop()
-			}
-		}
-	}()
-
-	// The check ensure after diskScalingOperationTimeout, did the data mover pod moved to running state.
-	if status.Phase != v1.PodRunning {
-		return fmt.Errorf("timeout to wait for pod %s in namespace %s to be in running state", copierPodName, namespace)
-	}
-
-	log.Debug().Msgf("ctx: %s, successfully created transient pod: %s in namespace: %s", ctx.Value(diskScalerRunContextKey), copierPodName, namespace)
-
-	buf := &bytes.Buffer{}
-	errBuf := &bytes.Buffer{}
-	request := ds.basicK8sClient.CoreV1().RESTClient().
-		Post().
-		Namespace(namespace).
-		Resource("pods").
-		Name(copierPodName).
-		SubResource("exec").
-		VersionedParams(&v1.PodExecOptions{
-			Command: []string{"/bin/sh", "-c", cpCommand},
-			Stdin:   false,
-			Stdout:  true,
-			Stderr:  true,
-			TTY:     true,
-		}, scheme.ParameterCodec)
-	exec, err := remotecommand.NewSPDYExecutor(ds.clientConfig, "POST", request.URL())
-	if err != nil {
-		return fmt.Errorf("failed to create remote command executor object on namespace:%s pod:%s err: %w", namespace, copierPodName, err)
-	}
-	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-		Stdout: buf,
-		Stderr: errBuf,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to perform copy operation on namespace:%s pod:%s with err: %w", namespace, copierPodName, err)
-	}
-
-	log.Debug().Msgf("ctx: %s, successfully executed command on pod: %s", ctx.Value(diskScalerRunContextKey), copierPodName)
-
-	return nil
-}
-
-// retryDeleteTransientPod attempts to delete the transient pod whenever there are any intermittent failure
-func (ds *DiskScaler) retryDeleteTransientPod(ctx context.Context, namespace string, copierPodName string, isForceful bool) error {
-	var retryErr error
-	for i := 0; i < maxRetries; i++ {
-		retryErr = ds.deleteTransientPod(ctx, namespace, copierPodName, isForceful)
-		if retryErr == nil {
-			break
-		}
-		log.Debug().Msgf("ctx: %s, deletion of transient pod failed in attempt %d with err %v", ctx.Value(diskScalerRunContextKey), i, retryErr)
-		if i < maxRetries-1 {
-			time.Sleep(inactivityDuringDelay)
-		}
-	}
-	return retryErr
-}
-
-// deleteTransientPod deletes the transient copier pod used for the copy operation between pvs
-func (ds *DiskScaler) deleteTransientPod(ctx context.Context, namespace string, copierPodName string, isForceful bool) error {
-	var err error
-	var w watch.Interface
-
-	v1Pod, _ := ds.basicK8sClient.CoreV1().Pods(namespace).Get(ctx, copierPodName, metav1.GetOptions{})
-	if v1Pod == nil || v1Pod.Name == "" {
-		log.Debug().Msgf("pod name : %s doesn't exist in namespace: %s", copierPodName, namespace)
-		return nil
-	}
-
-	var deletionOption metav1.DeleteOptions
-	deletePolicy := metav1.DeletePropagationForeground
-	deletionOption.PropagationPolicy = &deletePolicy
-	if isForceful {
-		force := int64(0)
-		deletionOption.GracePeriodSeconds = &force
-	}
-	err = ds.basicK8sClient.CoreV1().Pods(namespace).Delete(ctx, copierPodName, metav1.DeleteOptions{
-		PropagationPolicy: &deletePolicy,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to delete transient pod: %s: %w", copierPodName, err)
-	}
-
-	v1Pod, err = ds.basicK8sClient.CoreV1().Pods(namespace).Get(ctx, copierPodName, metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("unable to get transient pod: %s: %w", copierPodName, err)
-	}
-
-	if w, err = ds.basicK8sClient.CoreV1().Pods(namespace).Watch(ctx, metav1.ListOptions{
-		Watch:           true,
-		ResourceVersion: v1Pod.ResourceVersion,
-	}); err != nil {
-		return err
-	}
-
-	func() {
-		for {
-			select {
-			case events, ok := <-w.ResultChan():
-				if !ok {
-					return
-				}
-				if events.Type == watch.Deleted {
-					return
-				}
-
-			case <-time.After(120 * time.Second):
-				log.Error().Msgf("ctx: %s, timeout to wait for pod deletion %s in namespace %s", ctx.Value(diskScalerRunContextKey), copierPodName, namespace)
-				w.Stop()
-			}
-		}
-	}()
-
-	log.Debug().Msgf("ctx: %s, successfully delete transient pod: %s in namespace: %s", ctx.Value(diskScalerRunContextKey), copierPodName, namespace)
-	return nil
-}
-
 // isGreaterQuantity returns true if resizeTo is greater than original size
 func isGreaterQuantity(originalSize resource.Quantity, resizeTo resource.Quantity) bool {
 	return resizeTo.Cmp(originalSize) == 1
@@ -1,10 +1,21 @@
 package diskscaler
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"strings"
 	"testing"
 
+	"github.com/kubecost/disk-autoscaler/pkg/pvsizingrecommendation"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 func Test_isGreaterQuantity(t *testing.T) {
@@ -42,3 +53,276 @@ func Test_isGreaterQuantity(t *testing.T) {
 	}
 
 }
+
+func Test_claimTemplateSizes(t *testing.T) {
+	cases := map[string]struct {
+		volMap   map[string]*pvcDetails
+		expected map[string]string
+	}{
+		"when ordinals are resized to different sizes the largest is used": {
+			volMap: map[string]*pvcDetails{
+				"data-db-0": {claimTemplate: "data", currentSize: resource.MustParse("10Gi"), resizeTo: resource.MustParse("3Gi")},
+				"data-db-1": {claimTemplate: "data", currentSize: resource.MustParse("10Gi"), resizeTo: resource.MustParse("5Gi")},
+			},
+			expected: map[string]string{"data": "5Gi"},
+		},
+		"when a resize failed the current size is kept": {
+			volMap: map[string]*pvcDetails{
+				"data-db-0": {claimTemplate: "data", currentSize: resource.MustParse("10Gi"), resizeTo: resource.MustParse("3Gi")},
+				"data-db-1": {claimTemplate: "data", currentSize: resource.MustParse("10Gi"), resizeTo: resource.MustParse("3Gi"), err: fmt.Errorf("copy failed")},
+			},
+			expected: map[string]string{"data": "10Gi"},
+		},
+		"when there are multiple templates": {
+			volMap: map[string]*pvcDetails{
+				"data-db-0": {claimTemplate: "data", currentSize: resource.MustParse("10Gi"), resizeTo: resource.MustParse("3Gi")},
+				"logs-db-0": {claimTemplate: "logs", currentSize: resource.MustParse("1Gi"), resizeTo: resource.MustParse("2Gi")},
+			},
+			expected: map[string]string{"data": "3Gi", "logs": "2Gi"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			sizes := claimTemplateSizes(tc.volMap)
+			if len(sizes) != len(tc.expected) {
+				t.Fatalf("for test case: `%s`, expected %d templates but received %d", name, len(tc.expected), len(sizes))
+			}
+			for template, expected := range tc.expected {
+				size := sizes[template]
+				if !isEqualQuantity(resource.MustParse(expected), size) {
+					t.Fatalf("for test case: `%s`, expected size %s for template %s but received %s", name, expected, template, size.String())
+				}
+			}
+		})
+	}
+}
+
+// stubRecommender recommends the sizes it holds per PV and has no recommendation for any other PV.
+type stubRecommender map[string]string
+
+func (sr stubRecommender) GetRecommendation(ctx context.Context, pvName string, targetUtilization int, interval string) (pvsizingrecommendation.RecommendationSizeWithSavings, error) {
+	size, ok := sr[pvName]
+	if !ok {
+		return pvsizingrecommendation.RecommendationSizeWithSavings{}, pvsizingrecommendation.ErrNoRecommendation
+	}
+	return pvsizingrecommendation.RecommendationSizeWithSavings{RecommendedResourceSize: resource.MustParse(size)}, nil
+}
+
+// receivedEvent returns true when the recorder received an event containing every one of the given strings.
+func receivedEvent(recorder *record.FakeRecorder, contains ...string) bool {
+	for {
+		select {
+		case event := <-recorder.Events:
+			matches := true
+			for _, c := range contains {
+				matches = matches && strings.Contains(event, c)
+			}
+			if matches {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+func Test_getPVCDetails(t *testing.T) {
+	type testCase struct {
+		name             string
+		provisioner      string
+		bindingMode      storagev1.VolumeBindingMode
+		allowExpansion   bool
+		currentSize      string
+		recommendedSize  string
+		pvZone           string
+		volumeMode       v1.PersistentVolumeMode
+		expectedResizeTo resource.Quantity
+		expectedQuiesce  bool
+		expectedNode     string
+		expectedErr      bool
+	}
+
+	testCases := []testCase{
+		{
+			name:             "when an ebs volume is shrunk",
+			provisioner:      "ebs.csi.aws.com",
+			bindingMode:      storagev1.VolumeBindingWaitForFirstConsumer,
+			currentSize:      "100Gi",
+			recommendedSize:  "10Gi",
+			expectedResizeTo: resource.MustParse("10Gi"),
+			expectedQuiesce:  true,
+		},
+		{
+			name:             "when the recommendation is below the minimum size of the provisioner",
+			provisioner:      "pd.csi.storage.gke.io",
+			bindingMode:      storagev1.VolumeBindingWaitForFirstConsumer,
+			currentSize:      "100Gi",
+			recommendedSize:  "2Gi",
+			expectedResizeTo: resource.MustParse("10Gi"),
+			expectedQuiesce:  true,
+		},
+		{
+			name:             "when an ebs volume with immediate binding is shrunk",
+			provisioner:      "ebs.csi.aws.com",
+			bindingMode:      storagev1.VolumeBindingImmediate,
+			currentSize:      "100Gi",
+			recommendedSize:  "10Gi",
+			pvZone:           "us-east-1a",
+			expectedResizeTo: resource.MustParse("10Gi"),
+			expectedQuiesce:  true,
+			expectedNode:     "node-a",
+		},
+		{
+			name:            "when an ebs volume with immediate binding and no node affinity is shrunk",
+			provisioner:     "ebs.csi.aws.com",
+			bindingMode:     storagev1.VolumeBindingImmediate,
+			currentSize:     "100Gi",
+			recommendedSize: "10Gi",
+			expectedErr:     true,
+		},
+		{
+			name:            "when an ebs volume with immediate binding in a zone without nodes is shrunk",
+			provisioner:     "ebs.csi.aws.com",
+			bindingMode:     storagev1.VolumeBindingImmediate,
+			currentSize:     "100Gi",
+			recommendedSize: "10Gi",
+			pvZone:          "us-east-1b",
+			expectedErr:     true,
+		},
+		{
+			name:             "when an ebs volume with immediate binding is expanded online",
+			provisioner:      "ebs.csi.aws.com",
+			bindingMode:      storagev1.VolumeBindingImmediate,
+			allowExpansion:   true,
+			currentSize:      "10Gi",
+			recommendedSize:  "20Gi",
+			expectedResizeTo: resource.MustParse("20Gi"),
+			expectedQuiesce:  false,
+		},
+		{
+			name:             "when a longhorn volume is expanded offline",
+			provisioner:      "driver.longhorn.io",
+			bindingMode:      storagev1.VolumeBindingImmediate,
+			allowExpansion:   true,
+			currentSize:      "10Gi",
+			recommendedSize:  "20Gi",
+			expectedResizeTo: resource.MustParse("20Gi"),
+			expectedQuiesce:  true,
+		},
+		{
+			name:            "when a raw block volume is shrunk",
+			provisioner:     "ebs.csi.aws.com",
+			bindingMode:     storagev1.VolumeBindingWaitForFirstConsumer,
+			volumeMode:      v1.PersistentVolumeBlock,
+			currentSize:     "100Gi",
+			recommendedSize: "10Gi",
+			expectedErr:     true,
+		},
+		{
+			name:             "when a raw block volume is expanded online",
+			provisioner:      "ebs.csi.aws.com",
+			bindingMode:      storagev1.VolumeBindingWaitForFirstConsumer,
+			volumeMode:       v1.PersistentVolumeBlock,
+			allowExpansion:   true,
+			currentSize:      "10Gi",
+			recommendedSize:  "20Gi",
+			expectedResizeTo: resource.MustParse("20Gi"),
+			expectedQuiesce:  false,
+		},
+		{
+			name:            "when the provisioner is not supported",
+			provisioner:     "example.com/nfs",
+			bindingMode:     storagev1.VolumeBindingWaitForFirstConsumer,
+			currentSize:     "100Gi",
+			recommendedSize: "10Gi",
+			expectedErr:     true,
+		},
+		{
+			name:        "when the recommender has no data for the volume",
+			provisioner: "ebs.csi.aws.com",
+			bindingMode: storagev1.VolumeBindingWaitForFirstConsumer,
+			currentSize: "100Gi",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		storageClassName := "test-sc"
+		bindingMode := tc.bindingMode
+		allowExpansion := tc.allowExpansion
+		pvc := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "test"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &storageClassName,
+				VolumeName:       "pv-1",
+			},
+			Status: v1.PersistentVolumeClaimStatus{
+				Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse(tc.currentSize)},
+			},
+		}
+		if tc.volumeMode != "" {
+			volumeMode := tc.volumeMode
+			pvc.Spec.VolumeMode = &volumeMode
+		}
+		pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}}
+		if tc.pvZone != "" {
+			pv.Spec.NodeAffinity = &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+				MatchExpressions: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{tc.pvZone}}},
+			}}}}
+		}
+		node := newNode("node-a", "us-east-1a", true, false)
+		sc := &storagev1.StorageClass{
+			ObjectMeta:           metav1.ObjectMeta{Name: storageClassName},
+			Provisioner:          tc.provisioner,
+			VolumeBindingMode:    &bindingMode,
+			AllowVolumeExpansion: &allowExpansion,
+		}
+		recommender := stubRecommender{}
+		if tc.recommendedSize != "" {
+			recommender["pv-1"] = tc.recommendedSize
+		}
+		ds, err := NewDiskScaler(nil, fake.NewSimpleClientset(pvc, pv, sc, node), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), "test", recommender, false)
+		if err != nil {
+			t.Fatalf("test '%s': unable to create disk scaler: %s", tc.name, err)
+		}
+
+		recorder := record.NewFakeRecorder(10)
+		recorder.IncludeObject = true
+		ds.recorder = recorder
+
+		owner := &v1.ObjectReference{APIVersion: "apps/v1", Kind: workloadKindDeployment, Namespace: "test", Name: "app"}
+		// No resize is attempted in a dry run, so neither the recommendation nor a skip is recorded
+		dryRunCtx := context.WithValue(context.Background(), dryRunContextKey, true)
+		if _, err := ds.getPVCDetails(dryRunCtx, "test", owner, "data", scalingPolicy{targetUtilization: 70, interval: "7h", direction: PolicyDirectionBoth}); err != nil {
+			t.Fatalf("test '%s': received unexpected err in a dry run: %s", tc.name, err)
+		}
+		if len(recorder.Events) != 0 {
+			t.Fatalf("test '%s': expected no event to be recorded in a dry run but received %s", tc.name, <-recorder.Events)
+		}
+		details, err := ds.getPVCDetails(context.Background(), "test", owner, "data", scalingPolicy{targetUtilization: 70, interval: "7h", direction: PolicyDirectionBoth})
+		if tc.expectedErr {
+			if err == nil {
+				t.Fatalf("test '%s': expected an error but received none", tc.name)
+			}
+			// The reason the claim is skipped is recorded on the workload
+			if !receivedEvent(recorder, v1.EventTypeWarning+" "+EventReasonResizeSkipped, "kind="+workloadKindDeployment) {
+				t.Fatalf("test '%s': expected a %s event on the deployment", tc.name, EventReasonResizeSkipped)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test '%s': received unexpected err: %s", tc.name, err)
+		}
+		if details.resizeTo.Cmp(tc.expectedResizeTo) != 0 {
+			t.Fatalf("test '%s': failed expected resize to %s but received %s", tc.name, tc.expectedResizeTo.String(), details.resizeTo.String())
+		}
+		quiesce := needsQuiesce(map[string]*pvcDetails{"data": details})
+		if quiesce != tc.expectedQuiesce {
+			t.Fatalf("test '%s': failed expected quiesce %t but received %t", tc.name, tc.expectedQuiesce, quiesce)
+		}
+		if details.selectedNode != tc.expectedNode {
+			t.Fatalf("test '%s': failed expected selected node '%s' but received '%s'", tc.name, tc.expectedNode, details.selectedNode)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubecost/disk-autoscaler/pkg/provisioner"
+	"github.com/kubecost/disk-autoscaler/pkg/pvsizingrecommendation"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultEmergencyPollInterval = time.Minute
+
+// startEmergencyScaling polls the utilization of the volumes of every workload annotated with
+// request.autodiskscaling.kubecost.com/emergencyUtilization and expands a volume online as soon
+// as its utilization crosses the threshold. Unlike the hourly loop it ignores the interval since
+// the workload was last scaled, but it still respects the resize cooldown of the provisioner
+// and it never shrinks a volume.
+func (dss *DiskScalerService) startEmergencyScaling(source pvsizingrecommendation.UsageSource, pollInterval time.Duration) {
+	if pollInterval <= 0 {
+		pollInterval = defaultEmergencyPollInterval
+	}
+	log.Info().Msgf("Starting emergency disk scaling loop every %s", pollInterval)
+	ticker := time.NewTicker(pollInterval)
+
+	go func() {
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), diskScalerServiceContextKey, "emergency"), pollInterval)
+			err := dss.runEmergencyScaling(ctx, source)
+			cancel()
+			if err != nil {
+				log.Error().Err(err).Msgf("Emergency disk scaling attempt failed")
+			}
+		}
+	}()
+}
+
+// runEmergencyScaling expands every volume over the emergency utilization of its workload once.
+func (dss *DiskScalerService) runEmergencyScaling(ctx context.Context, source pvsizingrecommendation.UsageSource) error {
+	err := dss.ds.refreshPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	var usage map[string]pvsizingrecommendation.VolumeUsage
+	for _, wl := range dss.ds.workloads {
+		objects, err := wl.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			if _, ok := obj.meta.Annotations[AnnotationEmergencyUtilization]; !ok {
+				continue
+			}
+			// Emergency expansion ignores maintenance windows but never grows a volume a policy only allows to shrink
+			policy := dss.ds.policyFor(obj.meta)
+			if !dss.workloadIsEnabled(obj.meta, policy) || policy.direction == PolicyDirectionDown {
+				continue
+			}
+			threshold, ok := dss.emergencyUtilization(wl.Kind(), obj.meta, policy.targetUtilization)
+			if !ok {
+				continue
+			}
+			// The usage of all volumes is only read when there is a workload to check
+			if usage == nil {
+				usage, err = source.CurrentUsage(ctx)
+				if err != nil {
+					return fmt.Errorf("unable to get current usage of volumes: %w", err)
+				}
+			}
+
+			release, err := dss.ds.startScaling(obj.meta.Namespace, wl.Kind(), obj.meta.Name)
+			if err != nil {
+				log.Debug().Msgf("skipping emergency disk scaling: %v", err)
+				continue
+			}
+			runCtx := context.WithValue(ctx, diskScalerRunContextKey, fmt.Sprintf("%s:%s", obj.meta.Namespace, obj.meta.Name))
+			err = dss.ds.emergencyExpandWorkload(runCtx, wl, workloadReference(wl.Kind(), obj.meta), usage, threshold, policy)
+			release()
+			if err != nil {
+				log.Error().Msgf("ctx: %s, emergency disk scaling of %s %s failed: %v", runCtx.Value(diskScalerRunContextKey), strings.ToLower(wl.Kind()), obj.meta.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// emergencyUtilization returns the emergency utilization threshold of the workload, if set. A threshold
+// not greater than the target utilization is ignored since the volume would never be expanded by it.
+// An ignored threshold is warned about once until the threshold or the target utilization changes.
+func (dss *DiskScalerService) emergencyUtilization(kind string, meta metav1.ObjectMeta, targetUtilization int) (int, bool) {
+	key := workloadKey(meta.Namespace, kind, meta.Name)
+	val, ok := meta.Annotations[AnnotationEmergencyUtilization]
+	if !ok {
+		dss.emergencyWarnings.Delete(key)
+		return 0, false
+	}
+	threshold, err := strconv.Atoi(val)
+	if err != nil || threshold <= 0 || threshold > 100 {
+		if dss.warnEmergencyOnce(key, val) {
+			log.Warn().Msgf("emergency utilization %q of workload %s is invalid and is ignored", val, meta.Name)
+		}
+		return 0, false
+	}
+	if threshold <= targetUtilization {
+		if dss.warnEmergencyOnce(key, fmt.Sprintf("%s/%d", val, targetUtilization)) {
+			log.Warn().Msgf("emergency utilization %d%% of workload %s is not greater than its target utilization of %d%% and is ignored", threshold, meta.Name, targetUtilization)
+		}
+		return 0, false
+	}
+	dss.emergencyWarnings.Delete(key)
+	return threshold, true
+}
+
+// warnEmergencyOnce returns true when the workload was not warned about the same setting yet.
+func (dss *DiskScalerService) warnEmergencyOnce(key string, setting string) bool {
+	previous, loaded := dss.emergencyWarnings.Swap(key, setting)
+	return !loaded || previous != setting
+}
+
+// emergencyExpandWorkload expands every claim of the workload, referenced by ref, whose utilization is at least threshold.
+func (ds *DiskScaler) emergencyExpandWorkload(ctx context.Context, wl workload, ref *v1.ObjectReference, usage map[string]pvsizingrecommendation.VolumeUsage, threshold int, policy scalingPolicy) error {
+	claims, err := ds.workloadClaims(ctx, wl, ref.Namespace, ref.Name)
+	if err != nil {
+		return err
+	}
+	var expandErr error
+	for _, pvcName := range claims {
+		err := ds.emergencyExpand(ctx, ref, pvcName, usage, threshold, policy)
+		if err != nil {
+			log.Error().Msgf("ctx: %s, emergency expansion of pvc %s failed: %v", ctx.Value(diskScalerRunContextKey), pvcName, err)
+			expandErr = err
+		}
+	}
+	return expandErr
+}
+
+// emergencyExpand expands the claim online to hold its current usage at the target utilization
+// when its utilization is at least threshold, up to the maximum size of the policy. The outcome
+// of the expansion is recorded as an event on the workload, referenced by ref, and on the claim.
+func (ds *DiskScaler) emergencyExpand(ctx context.Context, ref *v1.ObjectReference, pvcName string, usage map[string]pvsizingrecommendation.VolumeUsage, threshold int, policy scalingPolicy) error {
+	namespace := ref.Namespace
+	pvc, err := ds.getPVCInfo(ctx, namespace, pvcName)
+	if err != nil {
+		return err
+	}
+	current, ok := usage[pvc.Spec.VolumeName]
+	if !ok || current.CapacityBytes <= 0 {
+		return nil
+	}
+	utilization := current.UsedBytes / current.CapacityBytes * 100
+	if utilization < float64(threshold) {
+		return nil
+	}
+
+	if pvc.Spec.StorageClassName == nil {
+		return fmt.Errorf("pvc %s has no storage class", pvcName)
+	}
+	sc, err := ds.getStorageClassInfo(ctx, pvcName, *pvc.Spec.StorageClassName)
+	if err != nil {
+		return err
+	}
+	driver, ok := provisioner.Lookup(sc.Provisioner)
+	if !ok {
+		return fmt.Errorf("unsupported provisioner %s for storage class %s", sc.Provisioner, sc.Name)
+	}
+	if sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion || !driver.OnlineExpansion {
+		return fmt.Errorf("pvc %s is %.0f%% full but storage class %s cannot expand it online", pvcName, utilization, sc.Name)
+	}
+	lastResized := lastResizedTime(pvc)
+	if remaining := driver.CooldownRemaining(lastResized, time.Now()); remaining > 0 {
+		return fmt.Errorf("pvc %s is %.0f%% full but provisioner %s allows another resize in %s", pvcName, utilization, driver.Name, remaining.Round(time.Minute))
+	}
+
+	currentSize := pvc.Status.Capacity[v1.ResourceStorage]
+	resizeTo := *resource.NewQuantity(int64(math.Ceil(current.UsedBytes/(float64(policy.targetUtilization)/100))), resource.BinarySI)
+	resizeTo = driver.Normalize(policy.limit(currentSize, resizeTo))
+	// Never shrink, and never request less than the claim already requests
+	requested := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	if !isGreaterQuantity(currentSize, resizeTo) || !isGreaterQuantity(requested, resizeTo) {
+		log.Warn().Msgf("ctx: %s, pvc %s is %.0f%% full which is over the emergency utilization of %d%%, but it cannot be expanded past %s", ctx.Value(diskScalerRunContextKey), pvcName, utilization, threshold, currentSize.String())
+		return nil
+	}
+
+	log.Warn().Msgf("ctx: %s, pvc %s is %.0f%% full which is over the emergency utilization of %d%%, expanding it from %s to %s", ctx.Value(diskScalerRunContextKey), pvcName, utilization, threshold, currentSize.String(), resizeTo.String())
+	err = ds.patchPVCWithResize(ctx, namespace, pvcName, resizeTo)
+	recordEmergencyExpansion(ctx, err)
+	ds.recordExpansion(ctx, ref, pvcName, &pvcDetails{currentSize: currentSize, resizeTo: resizeTo, claimRef: claimReference(pvc), err: err})
+	return err
+}
+
+// workloadClaims returns the names of the claims mounted by the workload, including
+// the claims created from the volumeClaimTemplates of a StatefulSet.
+func (ds *DiskScaler) workloadClaims(ctx context.Context, wl workload, namespace, name string) ([]string, error) {
+	_, template, err := wl.Get(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	claims := []string{}
+	for _, vol := range template.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil {
+			claims = append(claims, vol.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	if _, ok := wl.(*statefulSetWorkload); !ok {
+		return claims, nil
+	}
+
+	sts, err := ds.basicK8sClient.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get statefulset for the name %s err: %w", name, err)
+	}
+	start := ordinalStart(sts)
+	for _, claimTemplate := range sts.Spec.VolumeClaimTemplates {
+		for ordinal := start; ordinal < start+int(statefulSetReplicas(sts)); ordinal++ {
+			claims = append(claims, fmt.Sprintf("%s-%s-%d", claimTemplate.Name, name, ordinal))
+		}
+	}
+	return claims, nil
+}
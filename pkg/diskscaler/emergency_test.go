@@ -0,0 +1,191 @@
+package diskscaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kubecost/disk-autoscaler/pkg/metrics"
+	"github.com/kubecost/disk-autoscaler/pkg/pvsizingrecommendation"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// stubUsageSource returns the usage it holds for every PV.
+type stubUsageSource map[string]pvsizingrecommendation.VolumeUsage
+
+func (su stubUsageSource) CurrentUsage(ctx context.Context) (map[string]pvsizingrecommendation.VolumeUsage, error) {
+	return su, nil
+}
+
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		rolloutGVR:                  "RolloutList",
+		diskScalingPolicyGVR:        "DiskScalingPolicyList",
+		clusterDiskScalingPolicyGVR: "ClusterDiskScalingPolicyList",
+		volumeSnapshotGVR:           "VolumeSnapshotList",
+	}, objects...)
+}
+
+func Test_runEmergencyScaling(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	type testCase struct {
+		name                 string
+		provisioner          string
+		emergencyUtilization string
+		usedGiB              float64
+		lastResized          time.Time
+		expectedSize         resource.Quantity
+	}
+
+	testCases := []testCase{
+		{
+			name:                 "when utilization is over the emergency threshold the volume is expanded",
+			provisioner:          "ebs.csi.aws.com",
+			emergencyUtilization: "90",
+			usedGiB:              9.5,
+			expectedSize:         resource.MustParse("14Gi"),
+		},
+		{
+			name:                 "when utilization is under the emergency threshold the volume is unchanged",
+			provisioner:          "ebs.csi.aws.com",
+			emergencyUtilization: "90",
+			usedGiB:              8,
+			expectedSize:         resource.MustParse("10Gi"),
+		},
+		{
+			name:                 "when the volume was resized within the cooldown of the provisioner it is unchanged",
+			provisioner:          "ebs.csi.aws.com",
+			emergencyUtilization: "90",
+			usedGiB:              9.5,
+			lastResized:          time.Now().Add(-time.Hour),
+			expectedSize:         resource.MustParse("10Gi"),
+		},
+		{
+			name:                 "when the provisioner cannot expand online the volume is unchanged",
+			provisioner:          "driver.longhorn.io",
+			emergencyUtilization: "90",
+			usedGiB:              9.5,
+			expectedSize:         resource.MustParse("10Gi"),
+		},
+		{
+			name:                 "when the emergency utilization is not greater than the target utilization the volume is unchanged",
+			provisioner:          "ebs.csi.aws.com",
+			emergencyUtilization: "60",
+			usedGiB:              9.5,
+			expectedSize:         resource.MustParse("10Gi"),
+		},
+		{
+			name:         "when the workload has no emergency utilization the volume is unchanged",
+			provisioner:  "ebs.csi.aws.com",
+			usedGiB:      9.9,
+			expectedSize: resource.MustParse("10Gi"),
+		},
+	}
+
+	for _, tc := range testCases {
+		annotations := map[string]string{AnnotationEnabled: "true"}
+		if tc.emergencyUtilization != "" {
+			annotations[AnnotationEmergencyUtilization] = tc.emergencyUtilization
+		}
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test", Annotations: annotations},
+			Spec: appsv1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{
+						Volumes: []v1.Volume{{
+							Name:         "data",
+							VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+						}},
+					},
+				},
+			},
+		}
+		storageClassName := "test-sc"
+		pvcAnnotations := map[string]string{}
+		if !tc.lastResized.IsZero() {
+			pvcAnnotations[PVCAnnotationLastResized] = tc.lastResized.Format(timeFormat)
+		}
+		pvc := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "test", Annotations: pvcAnnotations},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &storageClassName,
+				VolumeName:       "pv-1",
+				Resources: v1.VolumeResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")},
+				},
+			},
+			Status: v1.PersistentVolumeClaimStatus{
+				Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+		}
+		allowExpansion := true
+		sc := &storagev1.StorageClass{
+			ObjectMeta:           metav1.ObjectMeta{Name: storageClassName},
+			Provisioner:          tc.provisioner,
+			AllowVolumeExpansion: &allowExpansion,
+		}
+		client := fake.NewSimpleClientset(deployment, pvc, sc)
+		dss, err := NewDiskScalerService(nil, client, newFakeDynamicClient(), false, false, stubRecommender{}, []string{KubecostNamespace})
+		if err != nil {
+			t.Fatalf("test '%s': unable to create disk scaler service: %s", tc.name, err)
+		}
+		recorder := record.NewFakeRecorder(10)
+		recorder.IncludeObject = true
+		dss.ds.recorder = recorder
+
+		expansions := testutil.ToFloat64(metrics.Resizes.WithLabelValues(metrics.DirectionExpand, metrics.OutcomeSuccess, "true"))
+		usage := stubUsageSource{"pv-1": {UsedBytes: tc.usedGiB * gib, CapacityBytes: 10 * gib}}
+		err = dss.runEmergencyScaling(context.Background(), usage)
+		if err != nil {
+			t.Fatalf("test '%s': received unexpected err: %s", tc.name, err)
+		}
+
+		updated, err := client.CoreV1().PersistentVolumeClaims("test").Get(context.Background(), "data", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("test '%s': unable to get pvc: %s", tc.name, err)
+		}
+		size := updated.Spec.Resources.Requests[v1.ResourceStorage]
+		if size.Cmp(tc.expectedSize) != 0 {
+			t.Fatalf("test '%s': failed expected size %s but received %s", tc.name, tc.expectedSize.String(), size.String())
+		}
+		expectedExpansions := 0.0
+		if !isEqualQuantity(tc.expectedSize, resource.MustParse("10Gi")) {
+			expectedExpansions = 1
+		}
+		if delta := testutil.ToFloat64(metrics.Resizes.WithLabelValues(metrics.DirectionExpand, metrics.OutcomeSuccess, "true")) - expansions; delta != expectedExpansions {
+			t.Fatalf("test '%s': expected %.0f emergency expansions to be counted but received %.0f", tc.name, expectedExpansions, delta)
+		}
+		if expectedExpansions > 0 && !receivedEvent(recorder, v1.EventTypeNormal+" "+EventReasonExpanded, "kind="+workloadKindDeployment) {
+			t.Fatalf("test '%s': expected an %s event on the deployment", tc.name, EventReasonExpanded)
+		}
+	}
+}
+
+func Test_warnEmergencyOnce(t *testing.T) {
+	dss := &DiskScalerService{}
+	testCases := []struct {
+		name     string
+		setting  string
+		expected bool
+	}{
+		{name: "when the workload was never warned about", setting: "60/70", expected: true},
+		{name: "when the workload was warned about the same setting", setting: "60/70", expected: false},
+		{name: "when the setting changed since the last warning", setting: "abc", expected: true},
+	}
+	for _, tc := range testCases {
+		if actual := dss.warnEmergencyOnce("test/Deployment/app", tc.setting); actual != tc.expected {
+			t.Fatalf("test '%s': expected %t but received %t", tc.name, tc.expected, actual)
+		}
+	}
+}
@@ -9,22 +9,42 @@ import (
 // Custom error to return to the service calling the
 // disk autoscaler workflow all PVC scaling failed
 type DiskScalingAllFailedError struct {
-	namespace  string
-	deployment string
+	namespace string
+	kind      string
+	workload  string
 }
 
 func (e *DiskScalingAllFailedError) Error() string {
-	return fmt.Sprintf("failed to scale all the persistent volume claims in deployment %s belonging to namespace %s", e.deployment, e.namespace)
+	return fmt.Sprintf("failed to scale all the persistent volume claims in %s %s belonging to namespace %s", strings.ToLower(e.kind), e.workload, e.namespace)
 }
 
 // Custom error to return to the service calling the
 // disk autoscaler workflow when some of PVC in scaling failed
 type DiskScalingPartialFailedError struct {
-	namespace  string
-	deployment string
-	pvc        []string
+	namespace string
+	kind      string
+	workload  string
+	pvc       []string
 }
 
 func (e *DiskScalingPartialFailedError) Error() string {
-	return fmt.Sprintf("failed to scale persistent volume claims %s in deployment %s belonging to namespace %s", strings.Join(e.pvc, ","), e.deployment, e.namespace)
+	return fmt.Sprintf("failed to scale persistent volume claims %s in %s %s belonging to namespace %s", strings.Join(e.pvc, ","), strings.ToLower(e.kind), e.workload, e.namespace)
+}
+
+// Custom error to return when a claim being rebound behind its name is missing
+// or not bound to either of its volumes, the workload must not be restored without it
+type RebindIncompleteError struct {
+	namespace  string
+	pvc        string
+	pv         string
+	originalPV string
+	err        error
+}
+
+func (e *RebindIncompleteError) Error() string {
+	return fmt.Sprintf("pvc %s in namespace %s is not bound after its rebind failed, bind it to pv %s holding the copied data or to pv %s holding its original data: %v", e.pvc, e.namespace, e.pv, e.originalPV, e.err)
+}
+
+func (e *RebindIncompleteError) Unwrap() error {
+	return e.err
 }
@@ -0,0 +1,106 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventComponent = "disk-autoscaler"
+
+	EventReasonResizeRecommended = "ResizeRecommended"
+	EventReasonResizeSkipped     = "ResizeSkipped"
+	EventReasonScaledDown        = "ScaledDown"
+	EventReasonScaledUp          = "ScaledUp"
+	EventReasonExpanded          = "Expanded"
+	EventReasonCopyStarted       = "CopyStarted"
+	EventReasonCopyCompleted     = "CopyCompleted"
+	EventReasonCopyFailed        = "CopyFailed"
+	EventReasonClaimSwapped      = "ClaimSwapped"
+	EventReasonClaimRebound      = "ClaimRebound"
+	EventReasonResizeFailed      = "ResizeFailed"
+	EventReasonRolledBack        = "RolledBack"
+	EventReasonRollbackFailed    = "RollbackFailed"
+)
+
+// workloadAPIVersions is the API version of every supported workload kind.
+var workloadAPIVersions = map[string]string{
+	workloadKindDeployment:  "apps/v1",
+	workloadKindStatefulSet: "apps/v1",
+	workloadKindReplicaSet:  "apps/v1",
+	workloadKindPod:         "v1",
+	workloadKindRollout:     rolloutGVR.GroupVersion().String(),
+}
+
+// newEventRecorder returns a recorder writing the events of the disk auto scaler through the client.
+func newEventRecorder(k8sClient kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8sClient.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent})
+}
+
+// workloadReference returns the reference events about the workload are recorded on.
+func workloadReference(kind string, meta metav1.ObjectMeta) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: workloadAPIVersions[kind],
+		Kind:       kind,
+		Namespace:  meta.Namespace,
+		Name:       meta.Name,
+		UID:        meta.UID,
+	}
+}
+
+// claimReference returns the reference events about the claim are recorded on.
+func claimReference(pvc *v1.PersistentVolumeClaim) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "PersistentVolumeClaim",
+		Namespace:  pvc.GetNamespace(),
+		Name:       pvc.GetName(),
+		UID:        pvc.GetUID(),
+	}
+}
+
+// recordEvent records the event on the workload and on the claim, either of which may be nil,
+// so that it shows up when describing either of them. Nothing is recorded when ctx is not recording.
+func (ds *DiskScaler) recordEvent(ctx context.Context, workload *v1.ObjectReference, claim *v1.ObjectReference, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if !recording(ctx) {
+		return
+	}
+	message := fmt.Sprintf(messageFmt, args...)
+	for _, ref := range []*v1.ObjectReference{workload, claim} {
+		if ref != nil {
+			ds.recorder.Event(ref, eventType, reason, message)
+		}
+	}
+}
+
+// recordExpansion records the outcome of the expansion of a claim in place.
+func (ds *DiskScaler) recordExpansion(ctx context.Context, workload *v1.ObjectReference, pvcName string, pvcDetails *pvcDetails) {
+	if pvcDetails.err != nil {
+		ds.recordResizeFailure(ctx, workload, pvcName, pvcDetails)
+		return
+	}
+	ds.recordEvent(ctx, workload, pvcDetails.claimRef, v1.EventTypeNormal, EventReasonExpanded, "expanded pvc %s from %s to %s", pvcName, pvcDetails.currentSize.String(), pvcDetails.resizeTo.String())
+}
+
+// recordRebind records the outcome of the resize of a claim rebound to a new volume behind its name.
+func (ds *DiskScaler) recordRebind(ctx context.Context, workload *v1.ObjectReference, pvcName string, pvcDetails *pvcDetails) {
+	if pvcDetails.err != nil {
+		ds.recordResizeFailure(ctx, workload, pvcName, pvcDetails)
+		return
+	}
+	ds.recordEvent(ctx, workload, pvcDetails.claimRef, v1.EventTypeNormal, EventReasonClaimRebound, "rebound pvc %s from a volume of %s to a volume of %s", pvcName, pvcDetails.currentSize.String(), pvcDetails.resizeTo.String())
+}
+
+// recordResizeFailure records the failure of the resize of a claim.
+func (ds *DiskScaler) recordResizeFailure(ctx context.Context, workload *v1.ObjectReference, pvcName string, pvcDetails *pvcDetails) {
+	ds.recordEvent(ctx, workload, pvcDetails.claimRef, v1.EventTypeWarning, EventReasonResizeFailed, "resize of pvc %s from %s to %s failed: %v", pvcName, pvcDetails.currentSize.String(), pvcDetails.resizeTo.String(), pvcDetails.err)
+}
@@ -2,17 +2,25 @@ package diskscaler
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/kubecost/disk-autoscaler/pkg/pvsizingrecommendation"
+	"github.com/rs/zerolog/log"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 func (dss *DiskScalerService) enableDiskAutoScaling(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	q := r.URL.Query()
 	namespace := q.Get("namespace")
-	deployment := q.Get("deployment")
+	kind, name := workloadFromQuery(q)
 	interval := q.Get("interval")
 	targetUtilization := q.Get("targetUtilization")
 	if namespace == "" {
@@ -20,8 +28,8 @@ func (dss *DiskScalerService) enableDiskAutoScaling(w http.ResponseWriter, r *ht
 		return
 	}
 
-	if deployment == "" {
-		http.Error(w, "deployment is empty", http.StatusInternalServerError)
+	if name == "" {
+		http.Error(w, "workload name is empty", http.StatusInternalServerError)
 		return
 	}
 
@@ -29,7 +37,7 @@ func (dss *DiskScalerService) enableDiskAutoScaling(w http.ResponseWriter, r *ht
 		interval = defaultInterval
 	}
 
-	_, err := time.ParseDuration(interval)
+	_, err := pvsizingrecommendation.ParseInterval(interval)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("interval duration parsing failed with err: %v", err), http.StatusInternalServerError)
 		return
@@ -41,11 +49,11 @@ func (dss *DiskScalerService) enableDiskAutoScaling(w http.ResponseWriter, r *ht
 	}
 
 	ctx := context.Background()
-	ctx = context.WithValue(ctx, diskScalerServiceAnnotateContextKey, fmt.Sprintf("%s:%s", namespace, deployment))
+	ctx = context.WithValue(ctx, diskScalerServiceAnnotateContextKey, fmt.Sprintf("%s:%s", namespace, name))
 
-	err = dss.enableDeployment(ctx, namespace, deployment, interval, targetUtilization)
+	err = dss.enableWorkload(ctx, namespace, kind, name, interval, targetUtilization)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("unable to annotate namespace: %s, deployment: %s with err: %v", namespace, deployment, err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("unable to annotate namespace: %s, %s: %s with err: %v", namespace, strings.ToLower(kind), name, err), http.StatusInternalServerError)
 		return
 	}
 }
@@ -54,23 +62,281 @@ func (dss *DiskScalerService) excludeDiskAutoScaling(w http.ResponseWriter, r *h
 	w.Header().Set("Content-Type", "application/json")
 	q := r.URL.Query()
 	namespace := q.Get("namespace")
-	deployment := q.Get("deployment")
+	kind, name := workloadFromQuery(q)
+	if namespace == "" {
+		http.Error(w, "namespace is empty", http.StatusInternalServerError)
+		return
+	}
+
+	if name == "" {
+		http.Error(w, "workload name is empty", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, diskScalerServiceAnnotateContextKey, fmt.Sprintf("%s:%s", namespace, name))
+
+	err := dss.excludeWorkload(ctx, namespace, kind, name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to annotate namespace: %s, %s: %s with err: %v", namespace, strings.ToLower(kind), name, err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// workloadFromQuery returns the kind and name of the workload addressed either by the kind
+// and name query parameters or by the deployment or the statefulset query parameter.
+func workloadFromQuery(q url.Values) (string, string) {
+	if name := q.Get("name"); name != "" {
+		kind := q.Get("kind")
+		if kind == "" {
+			kind = workloadKindDeployment
+		}
+		return kind, name
+	}
+	if statefulSet := q.Get("statefulset"); statefulSet != "" {
+		return workloadKindStatefulSet, statefulSet
+	}
+	return workloadKindDeployment, q.Get("deployment")
+}
+
+func (dss *DiskScalerService) rollbackDiskAutoScaling(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+	namespace := q.Get("namespace")
+	kind, name := workloadFromQuery(q)
 	if namespace == "" {
 		http.Error(w, "namespace is empty", http.StatusInternalServerError)
 		return
 	}
 
-	if deployment == "" {
-		http.Error(w, "deployment is empty", http.StatusInternalServerError)
+	if name == "" {
+		http.Error(w, "workload name is empty", http.StatusInternalServerError)
+		return
+	}
+
+	if dss.auditMode {
+		http.Error(w, "rollback is not available in audit mode", http.StatusBadRequest)
 		return
 	}
 
 	ctx := context.Background()
-	ctx = context.WithValue(ctx, diskScalerServiceAnnotateContextKey, fmt.Sprintf("%s:%s", namespace, deployment))
+	ctx = context.WithValue(ctx, diskScalerRunContextKey, fmt.Sprintf("%s:%s", namespace, name))
 
-	err := dss.excludeDeployment(ctx, namespace, deployment)
+	err := dss.ds.runRollbackWorkflow(ctx, namespace, kind, name)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("unable to annotate namespace: %s, deployment: %s with err: %v", namespace, deployment, err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("unable to roll back namespace: %s, %s: %s with err: %v", namespace, strings.ToLower(kind), name, err), http.StatusInternalServerError)
 		return
 	}
 }
+
+// listWorkloads reports every workload with disk auto scaling enabled, optionally in a single
+// namespace, with its resolved settings and when it is next eligible for disk scaling.
+func (dss *DiskScalerService) listWorkloads(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), diskScalerServiceContextKey, "listWorkloads")
+	statuses, err := dss.workloadStatuses(ctx, r.URL.Query().Get("namespace"), time.Now())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to list workloads with err: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// getWorkload reports a workload along with the current size and recommendation of its claims,
+// its last resizes and the operation in progress, if any.
+func (dss *DiskScalerService) getWorkload(w http.ResponseWriter, r *http.Request) {
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = workloadKindDeployment
+	}
+	if _, err := dss.ds.workloadFor(kind); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), diskScalerServiceContextKey, fmt.Sprintf("%s:%s", namespace, name))
+	details, err := dss.workloadDetails(ctx, namespace, kind, name, time.Now())
+	if k8serrors.IsNotFound(err) {
+		http.Error(w, fmt.Sprintf("%s %s not found in namespace %s", strings.ToLower(kind), name, namespace), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to get namespace: %s, %s: %s with err: %v", namespace, strings.ToLower(kind), name, err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+// getPlan reports the steps the disk scaling workflow would take to resize the claims of a
+// workload and the preconditions it would fail, without changing anything.
+func (dss *DiskScalerService) getPlan(w http.ResponseWriter, r *http.Request) {
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = workloadKindDeployment
+	}
+	if _, err := dss.ds.workloadFor(kind); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), diskScalerRunContextKey, fmt.Sprintf("%s:%s", namespace, name))
+	plan, err := dss.workloadPlan(ctx, namespace, kind, name, time.Now())
+	if k8serrors.IsNotFound(err) {
+		http.Error(w, fmt.Sprintf("%s %s not found in namespace %s", strings.ToLower(kind), name, namespace), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to plan namespace: %s, %s: %s with err: %v", namespace, strings.ToLower(kind), name, err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// listRuns reports the last runs of the disk scaling loop, latest first, limited by the limit query parameter.
+func (dss *DiskScalerService) listRuns(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			http.Error(w, fmt.Sprintf("limit must be a positive integer, got %q", l), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, dss.runs.last(limit))
+}
+
+// getReport serves the recommendations found by the last audit run as JSON, or as CSV with the format query parameter set to csv.
+func (dss *DiskScalerService) getReport(w http.ResponseWriter, r *http.Request) {
+	if !dss.auditMode {
+		http.Error(w, "report is only available in audit mode", http.StatusBadRequest)
+		return
+	}
+	report := dss.ds.report.get()
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, report)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="disk-autoscaler-report.csv"`)
+		err := report.writeCSV(w)
+		if err != nil {
+			log.Error().Msgf("unable to write report: %v", err)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("unsupported report format %q, supported formats are json and csv", format), http.StatusBadRequest)
+	}
+}
+
+// runNow triggers a run of every workload with disk auto scaling enabled, optionally narrowed down
+// to a namespace and to the workloads matching a label selector, regardless of their interval.
+func (dss *DiskScalerService) runNow(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := runOptions{namespace: q.Get("namespace"), force: true}
+	if selector := q.Get("selector"); selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("selector parsing failed with err: %v", err), http.StatusBadRequest)
+			return
+		}
+		opts.selector = parsed
+	}
+	var err error
+	opts.dryRun, err = dryRunFromQuery(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"runId": dss.trigger(opts)})
+}
+
+// scaleNow triggers a run of the disk scaling workflow of a single workload regardless of its interval.
+func (dss *DiskScalerService) scaleNow(w http.ResponseWriter, r *http.Request) {
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+	q := r.URL.Query()
+	kind := q.Get("kind")
+	if kind == "" {
+		kind = workloadKindDeployment
+	}
+	wl, err := dss.ds.workloadFor(kind)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dryRun, err := dryRunFromQuery(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), diskScalerServiceContextKey, fmt.Sprintf("%s:%s", namespace, name))
+	meta, _, err := wl.Get(ctx, namespace, name)
+	if k8serrors.IsNotFound(err) {
+		http.Error(w, fmt.Sprintf("%s %s not found in namespace %s", strings.ToLower(kind), name, namespace), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to get namespace: %s, %s: %s with err: %v", namespace, strings.ToLower(kind), name, err), http.StatusInternalServerError)
+		return
+	}
+	if !dss.workloadIsEnabled(meta, dss.ds.policyFor(meta)) {
+		http.Error(w, fmt.Sprintf("disk auto scaling is not enabled for %s %s in namespace %s", strings.ToLower(kind), name, namespace), http.StatusConflict)
+		return
+	}
+	available, err := workloadAvailable(ctx, wl, namespace, name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to list %ss: %v", strings.ToLower(kind), err), http.StatusInternalServerError)
+		return
+	}
+	if !available {
+		http.Error(w, fmt.Sprintf("%s %s in namespace %s does not have all of its replicas available", strings.ToLower(kind), name, namespace), http.StatusConflict)
+		return
+	}
+	if _, busy := dss.ds.scaling.Load(workloadKey(namespace, wl.Kind(), name)); busy {
+		http.Error(w, fmt.Sprintf("%s %s in namespace %s is already being scaled", strings.ToLower(kind), name, namespace), http.StatusConflict)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"runId": dss.trigger(runOptions{namespace: namespace, kind: wl.Kind(), name: name, force: true, dryRun: dryRun})})
+}
+
+// workloadAvailable returns true when all of the replicas of the workload are available, which
+// the scheduled runs require before scaling a workload as well.
+func workloadAvailable(ctx context.Context, wl workload, namespace, name string) (bool, error) {
+	objects, err := wl.List(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, obj := range objects {
+		if obj.meta.Namespace == namespace && obj.meta.Name == name {
+			return obj.available, nil
+		}
+	}
+	return false, nil
+}
+
+// dryRunFromQuery returns the dryRun query parameter, which is false when unset.
+func dryRunFromQuery(q url.Values) (bool, error) {
+	dryRun := q.Get("dryRun")
+	if dryRun == "" {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(dryRun)
+	if err != nil {
+		return false, fmt.Errorf("dryRun parsing failed with err: %v", err)
+	}
+	return parsed, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Error().Msgf("unable to write response: %v", err)
+	}
+}
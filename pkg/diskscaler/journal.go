@@ -0,0 +1,430 @@
+package diskscaler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const journalConfigMapName = "disk-autoscaler-journal"
+
+// operationPhase is the step a disk scaling operation of a workload has reached.
+type operationPhase string
+
+const (
+	// operationStarted is recorded before the workload is quiesced, along with the replicas it runs
+	operationStarted  operationPhase = "Started"
+	operationQuiesced operationPhase = "Quiesced"
+	operationRestored operationPhase = "Restored"
+	// operationRecreating is recorded before a StatefulSet is deleted to be recreated with new volumeClaimTemplates
+	operationRecreating operationPhase = "Recreating"
+)
+
+// claimPhase is the step the resize of a single claim by copy has reached.
+type claimPhase string
+
+const (
+	// claimCreated is recorded before the new claim and the copier job are created
+	claimCreated claimPhase = "Created"
+	// claimPrecopied is recorded once the new claim holds the data of a snapshot taken while the workload was running
+	claimPrecopied claimPhase = "Precopied"
+	claimCopied    claimPhase = "Copied"
+	// claimRebinding is recorded while the volume holding the copied data is moved behind the original claim name
+	claimRebinding claimPhase = "Rebinding"
+	claimSwapped   claimPhase = "Swapped"
+	claimFailed    claimPhase = "Failed"
+)
+
+// operation is the journal entry of a disk scaling operation which quiesces a workload or copies
+// its data to new claims. It is persisted after every step so that an operation interrupted by
+// a restart of the disk auto scaler is resumed or rolled back when it starts again.
+type operation struct {
+	Namespace     string                     `json:"namespace"`
+	Kind          string                     `json:"kind"`
+	Workload      string                     `json:"workload"`
+	Phase         operationPhase             `json:"phase"`
+	OriginalScale int32                      `json:"originalScale"`
+	Claims        map[string]*claimOperation `json:"claims,omitempty"`
+	// Pod is the definition of a quiesced bare Pod, which only exists in the journal until it is restored
+	Pod *v1.Pod `json:"pod,omitempty"`
+	// StatefulSet is the StatefulSet being recreated, which only exists in the journal while it is deleted
+	StatefulSet *appsv1.StatefulSet `json:"statefulSet,omitempty"`
+	StartedAt   time.Time           `json:"startedAt"`
+	UpdatedAt   time.Time           `json:"updatedAt"`
+}
+
+type claimOperation struct {
+	Phase     claimPhase `json:"phase"`
+	NewClaim  string     `json:"newClaim"`
+	CopierJob string     `json:"copierJob,omitempty"`
+	// Clone is the claim restored from the snapshot of the original claim to pre-copy its data
+	Clone string `json:"clone,omitempty"`
+	// PV is the volume holding the copied data, which is retained while it is rebound,
+	// and ReclaimPolicy is the policy it is set back to
+	PV            string                           `json:"pv,omitempty"`
+	ReclaimPolicy v1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy,omitempty"`
+	// OriginalPV is the volume of the original claim, which is retained while the claim is
+	// recreated, and OriginalReclaimPolicy is the policy it is set back to
+	OriginalPV            string                           `json:"originalPV,omitempty"`
+	OriginalReclaimPolicy v1.PersistentVolumeReclaimPolicy `json:"originalReclaimPolicy,omitempty"`
+	// Snapshot is the VolumeSnapshot of the original claim taken before its data was copied
+	Snapshot string `json:"snapshot,omitempty"`
+	// KeepsName is true when the new volume is rebound behind the original claim name
+	KeepsName bool `json:"keepsName,omitempty"`
+}
+
+func newOperation(namespace, kind, name string) *operation {
+	return &operation{
+		Namespace: namespace,
+		Kind:      kind,
+		Workload:  name,
+		Phase:     operationStarted,
+		Claims:    map[string]*claimOperation{},
+		StartedAt: time.Now(),
+	}
+}
+
+// rebinding returns true while a claim of the operation is left between its volumes by a failed
+// rebind, the operation then stays in the journal so that the claim is settled on the next start.
+func (op *operation) rebinding() bool {
+	for _, claimOp := range op.Claims {
+		if claimOp.Phase == claimRebinding {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the ConfigMap data key of the operation, which is unique per workload.
+func (op *operation) key() string {
+	return fmt.Sprintf("%s.%s.%s", op.Namespace, strings.ToLower(op.Kind), op.Workload)
+}
+
+// journal persists the operations in flight to a ConfigMap in the namespace of the disk auto scaler.
+type journal struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	// mu serializes the updates of the ConfigMap by concurrent workflows
+	mu sync.Mutex
+}
+
+func newJournal(client kubernetes.Interface, namespace string) *journal {
+	return &journal{
+		client:    client,
+		namespace: namespace,
+		name:      journalConfigMapName,
+	}
+}
+
+// record persists the current state of the operation.
+func (j *journal) record(ctx context.Context, op *operation) error {
+	op.UpdatedAt = time.Now()
+	data, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("unable to encode operation of %s %s: %w", strings.ToLower(op.Kind), op.Workload, err)
+	}
+	return j.update(ctx, func(cm *v1.ConfigMap) {
+		cm.Data[op.key()] = string(data)
+	})
+}
+
+// remove deletes the operation from the journal once it has completed.
+func (j *journal) remove(ctx context.Context, op *operation) error {
+	return j.update(ctx, func(cm *v1.ConfigMap) {
+		delete(cm.Data, op.key())
+	})
+}
+
+// operations returns every operation left in the journal.
+func (j *journal) operations(ctx context.Context) ([]*operation, error) {
+	cm, err := j.client.CoreV1().ConfigMaps(j.namespace).Get(ctx, j.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get journal configmap %s err: %w", j.name, err)
+	}
+	ops := make([]*operation, 0, len(cm.Data))
+	for key, data := range cm.Data {
+		op := &operation{}
+		if err := json.Unmarshal([]byte(data), op); err != nil {
+			log.Warn().Msgf("unable to decode journaled operation %s, it is ignored: %v", key, err)
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func (j *journal) update(ctx context.Context, mutate func(cm *v1.ConfigMap)) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	configMaps := j.client.CoreV1().ConfigMaps(j.namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, j.name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			cm = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      j.name,
+					Namespace: j.namespace,
+				},
+				Data: map[string]string{},
+			}
+			mutate(cm)
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		mutate(cm)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update journal configmap %s err: %w", j.name, err)
+	}
+	return nil
+}
+
+// checkpoint persists the operation after a step of the workflow. The workflow carries on when
+// the journal cannot be written since stopping halfway would leave the workload quiesced.
+func (ds *DiskScaler) checkpoint(ctx context.Context, op *operation) {
+	if op == nil {
+		return
+	}
+	if wl, err := ds.workloadFor(op.Kind); err == nil {
+		if pw, ok := wl.(*podWorkload); ok {
+			op.Pod = pw.quiescedPod(op.Namespace, op.Workload)
+		}
+	}
+	if err := ds.journal.record(ctx, op); err != nil {
+		log.Error().Msgf("ctx: %s, unable to journal %s phase of %s %s: %v", ctx.Value(diskScalerRunContextKey), op.Phase, strings.ToLower(op.Kind), op.Workload, err)
+	}
+}
+
+// completeOperation removes the finished operation from the journal.
+func (ds *DiskScaler) completeOperation(ctx context.Context, op *operation) {
+	if op == nil {
+		return
+	}
+	if err := ds.journal.remove(ctx, op); err != nil {
+		log.Error().Msgf("ctx: %s, unable to remove completed operation of %s %s from the journal: %v", ctx.Value(diskScalerRunContextKey), strings.ToLower(op.Kind), op.Workload, err)
+	}
+}
+
+// recoverOperations finishes every operation left in the journal by a previous run of the disk
+// auto scaler. Claims already swapped into the workload are kept and the claims they replace are
+// deleted, every other claim is rolled back by deleting the new claim and its copier job, and
+// the workload is restored to its original scale. An operation which cannot be fully recovered
+// is kept in the journal to be retried on the next start.
+func (ds *DiskScaler) recoverOperations(ctx context.Context) error {
+	ops, err := ds.journal.operations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		runCtx := context.WithValue(ctx, diskScalerRunContextKey, fmt.Sprintf("%s:%s", op.Namespace, op.Workload))
+		log.Warn().Msgf("ctx: %s, recovering disk scaling of %s %s interrupted in phase %s at %s", runCtx.Value(diskScalerRunContextKey), strings.ToLower(op.Kind), op.Workload, op.Phase, op.UpdatedAt.Format(timeFormat))
+		release, err := ds.startScaling(op.Namespace, op.Kind, op.Workload)
+		if err != nil {
+			log.Error().Msgf("ctx: %s, unable to recover operation: %v", runCtx.Value(diskScalerRunContextKey), err)
+			continue
+		}
+		err = ds.recoverOperation(runCtx, op)
+		release()
+		if err != nil {
+			log.Error().Msgf("ctx: %s, unable to recover disk scaling of %s %s, it is retried on the next start: %v", runCtx.Value(diskScalerRunContextKey), strings.ToLower(op.Kind), op.Workload, err)
+			continue
+		}
+		ds.completeOperation(runCtx, op)
+		log.Info().Msgf("ctx: %s, recovered disk scaling of %s %s", runCtx.Value(diskScalerRunContextKey), strings.ToLower(op.Kind), op.Workload)
+	}
+	return nil
+}
+
+func (ds *DiskScaler) recoverOperation(ctx context.Context, op *operation) error {
+	wl, err := ds.workloadFor(op.Kind)
+	if err != nil {
+		return err
+	}
+	if pw, ok := wl.(*podWorkload); ok && op.Pod != nil && op.Phase != operationRestored {
+		pw.setQuiescedPod(op.Namespace, op.Workload, op.Pod)
+	}
+	var recoverErr error
+	for claim, claimOp := range op.Claims {
+		if claimOp.CopierJob != "" {
+			if err := ds.retryDeleteCopierJob(ctx, op.Namespace, claimOp.CopierJob); err != nil {
+				recoverErr = fmt.Errorf("unable to delete copier job %s: %w", claimOp.CopierJob, err)
+				continue
+			}
+		}
+		if claimOp.Clone != "" {
+			if err := ds.deleteClone(ctx, op.Namespace, claimOp.Clone); err != nil {
+				recoverErr = err
+				continue
+			}
+		}
+		// The claim may have been swapped into the workload just before the swap was journaled
+		if claimOp.Phase == claimCopied && op.Phase != operationRestored {
+			_, template, err := wl.Get(ctx, op.Namespace, op.Workload)
+			if err != nil {
+				recoverErr = err
+				continue
+			}
+			if usesClaim(template, claimOp.NewClaim) {
+				claimOp.Phase = claimSwapped
+			}
+		}
+		switch claimOp.Phase {
+		case claimSwapped:
+			// A claim rebound behind its name was not replaced, so there is no replaced claim to retain
+			if op.Kind == workloadKindStatefulSet || claimOp.KeepsName {
+				continue
+			}
+			log.Info().Msgf("ctx: %s, pvc %s was swapped for pvc %s, retaining pvc %s", ctx.Value(diskScalerRunContextKey), claim, claimOp.NewClaim, claim)
+			if err := ds.retainReplacedPVC(ctx, op.Namespace, claim, claimOp.NewClaim); err != nil {
+				recoverErr = err
+			}
+		case claimRebinding:
+			phase, err := ds.recoverRebind(ctx, op.Namespace, claim, claimOp)
+			claimOp.Phase = phase
+			if err != nil {
+				recoverErr = err
+			}
+		default:
+			log.Info().Msgf("ctx: %s, rolling back resize of pvc %s by deleting pvc %s", ctx.Value(diskScalerRunContextKey), claim, claimOp.NewClaim)
+			if err := ds.deletePVC(ctx, op.Namespace, claimOp.NewClaim); err != nil {
+				recoverErr = err
+			}
+		}
+	}
+
+	// The workload must not start without a claim whose rebind is incomplete
+	var incomplete *RebindIncompleteError
+	if errors.As(recoverErr, &incomplete) {
+		return recoverErr
+	}
+
+	switch op.Phase {
+	case operationStarted:
+		// The workload may have been scaled down before the Quiesced phase was journaled
+		if op.OriginalScale <= 0 {
+			log.Warn().Msgf("ctx: %s, %s %s may have been quiesced before its scale was journaled, check its replicas", ctx.Value(diskScalerRunContextKey), strings.ToLower(op.Kind), op.Workload)
+			break
+		}
+		replicas, err := wl.Replicas(ctx, op.Namespace, op.Workload)
+		if k8serrors.IsNotFound(err) {
+			log.Warn().Msgf("ctx: %s, %s %s may have been quiesced before it was journaled and cannot be restored, check its replicas", ctx.Value(diskScalerRunContextKey), strings.ToLower(op.Kind), op.Workload)
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to get replicas of %s %s: %w", strings.ToLower(op.Kind), op.Workload, err)
+		}
+		// The operation only ever scales the workload down, to 0 or to release an ordinal of a StatefulSet
+		if replicas >= op.OriginalScale {
+			break
+		}
+		log.Info().Msgf("ctx: %s, %s %s was scaled down to %d replicas before it was journaled, restoring it to %d replicas", ctx.Value(diskScalerRunContextKey), strings.ToLower(op.Kind), op.Workload, replicas, op.OriginalScale)
+		err = withRetries(ctx, fmt.Sprintf("restore %s", strings.ToLower(op.Kind)), func() error {
+			return wl.Restore(ctx, op.Namespace, op.Workload, op.OriginalScale)
+		})
+		if err != nil {
+			return fmt.Errorf("unable to restore %s %s to %d replicas: %w", strings.ToLower(op.Kind), op.Workload, op.OriginalScale, err)
+		}
+	case operationRecreating:
+		if op.StatefulSet == nil {
+			break
+		}
+		log.Info().Msgf("ctx: %s, recreating statefulset %s deleted to update its volumeClaimTemplates", ctx.Value(diskScalerRunContextKey), op.Workload)
+		err := ds.recreateStatefulSet(ctx, op.StatefulSet)
+		if err != nil {
+			return fmt.Errorf("unable to recreate statefulset %s: %w", op.Workload, err)
+		}
+	case operationQuiesced:
+		err := withRetries(ctx, fmt.Sprintf("restore %s", strings.ToLower(op.Kind)), func() error {
+			return wl.Restore(ctx, op.Namespace, op.Workload, op.OriginalScale)
+		})
+		if err != nil {
+			return fmt.Errorf("unable to restore %s %s to %d replicas: %w", strings.ToLower(op.Kind), op.Workload, op.OriginalScale, err)
+		}
+	}
+	return recoverErr
+}
+
+// recoverRebind settles a claim whose rebind behind its name was interrupted or failed. The claim
+// is swapped when it is bound to the volume holding the copied data and rolled back when it is
+// still bound to its original volume. The retained volume cannot be rebound safely without knowing
+// how far the rebind got, so a RebindIncompleteError is returned for the user when the claim is
+// missing or not bound, and the workload is not restored until it is.
+func (ds *DiskScaler) recoverRebind(ctx context.Context, namespace, claim string, claimOp *claimOperation) (claimPhase, error) {
+	if claimOp.PV == "" {
+		return claimFailed, ds.deletePVC(ctx, namespace, claimOp.NewClaim)
+	}
+	pvc, err := ds.basicK8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, claim, metav1.GetOptions{})
+	bound := err == nil && pvc.Status.Phase == v1.ClaimBound && pvc.DeletionTimestamp == nil
+	if bound && pvc.Spec.VolumeName == claimOp.PV {
+		log.Info().Msgf("ctx: %s, pvc %s is bound to pv %s holding the copied data", ctx.Value(diskScalerRunContextKey), claim, claimOp.PV)
+		if err := ds.deletePVC(ctx, namespace, claimOp.NewClaim); err != nil {
+			return claimRebinding, err
+		}
+		if err := ds.patchPVReclaimPolicy(ctx, claimOp.PV, claimOp.ReclaimPolicy); err != nil {
+			return claimRebinding, err
+		}
+		if claimOp.OriginalPV != "" {
+			if err := ds.retainReplacedPV(ctx, claimOp.OriginalPV, claimOp.PV, claimOp.OriginalReclaimPolicy); err != nil {
+				return claimRebinding, err
+			}
+		}
+		return claimSwapped, nil
+	}
+	if bound && pvc.Spec.VolumeName != "" {
+		// Releasing the retained volume with its original policy deletes it unless the storage class retains volumes
+		log.Info().Msgf("ctx: %s, pvc %s is still bound to its original volume, rolling back by deleting pvc %s", ctx.Value(diskScalerRunContextKey), claim, claimOp.NewClaim)
+		if err := ds.deletePVC(ctx, namespace, claimOp.NewClaim); err != nil {
+			return claimRebinding, err
+		}
+		if err := ds.patchPVReclaimPolicy(ctx, claimOp.PV, claimOp.ReclaimPolicy); err != nil {
+			return claimRebinding, err
+		}
+		if claimOp.OriginalPV != "" && pvc.Spec.VolumeName == claimOp.OriginalPV {
+			if err := ds.patchPVReclaimPolicy(ctx, claimOp.OriginalPV, claimOp.OriginalReclaimPolicy); err != nil {
+				return claimRebinding, err
+			}
+		}
+		return claimFailed, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("pvc %s is %s", claim, pvc.Status.Phase)
+	}
+	if claimOp.Snapshot != "" {
+		log.Error().Msgf("ctx: %s, the data of pvc %s can also be restored from volume snapshot %s", ctx.Value(diskScalerRunContextKey), claim, claimOp.Snapshot)
+	}
+	return claimRebinding, &RebindIncompleteError{namespace: namespace, pvc: claim, pv: claimOp.PV, originalPV: claimOp.OriginalPV, err: err}
+}
+
+// usesClaim returns true when a volume of the pod template mounts the claim.
+func usesClaim(template *v1.PodTemplateSpec, claim string) bool {
+	for _, vol := range template.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil && vol.PersistentVolumeClaim.ClaimName == claim {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package diskscaler
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_recoverOperations(t *testing.T) {
+	type testCase struct {
+		name          string
+		phase         operationPhase
+		podClaim      string
+		claim         *claimOperation
+		expectedClaim string
+		expectPod     bool
+	}
+
+	testCases := []testCase{
+		{
+			name:          "when a quiesced pod is recreated from the journal with the swapped claim",
+			phase:         operationQuiesced,
+			podClaim:      "data-abcde",
+			claim:         &claimOperation{Phase: claimSwapped, NewClaim: "data-abcde"},
+			expectedClaim: "data-abcde",
+			expectPod:     true,
+		},
+		{
+			name:          "when a copied claim was swapped into the pod before the swap was journaled",
+			phase:         operationQuiesced,
+			podClaim:      "data-abcde",
+			claim:         &claimOperation{Phase: claimCopied, NewClaim: "data-abcde"},
+			expectedClaim: "data-abcde",
+			expectPod:     true,
+		},
+		{
+			name:          "when a quiesced pod is recreated with its original claim",
+			phase:         operationQuiesced,
+			podClaim:      "data",
+			expectedClaim: "data",
+			expectPod:     true,
+		},
+		{
+			name:  "when the operation was interrupted before the pod was quiesced",
+			phase: operationStarted,
+		},
+	}
+
+	for _, tc := range testCases {
+		newClaim := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data-abcde", Namespace: "test"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				Resources: v1.VolumeResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("5Gi")},
+				},
+			},
+		}
+		client := fake.NewSimpleClientset(newClaim)
+		ds, err := NewDiskScaler(nil, client, newFakeDynamicClient(), "test", stubRecommender{}, false)
+		if err != nil {
+			t.Fatalf("test '%s': unable to create disk scaler: %s", tc.name, err)
+		}
+
+		op := newOperation("test", workloadKindPod, "app")
+		op.Phase = tc.phase
+		op.OriginalScale = 1
+		if tc.podClaim != "" {
+			op.Pod = &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test"},
+				Spec: v1.PodSpec{
+					Volumes: []v1.Volume{{
+						Name:         "data",
+						VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: tc.podClaim}},
+					}},
+				},
+			}
+		}
+		if tc.claim != nil {
+			op.Claims["data"] = tc.claim
+		}
+		if err := ds.journal.record(context.Background(), op); err != nil {
+			t.Fatalf("test '%s': unable to journal operation: %s", tc.name, err)
+		}
+
+		err = ds.recoverOperations(context.Background())
+		if err != nil {
+			t.Fatalf("test '%s': received unexpected err: %s", tc.name, err)
+		}
+
+		ops, err := ds.journal.operations(context.Background())
+		if err != nil {
+			t.Fatalf("test '%s': unable to read journal: %s", tc.name, err)
+		}
+		if len(ops) != 0 {
+			t.Fatalf("test '%s': expected the recovered operation to be removed from the journal but found %d", tc.name, len(ops))
+		}
+
+		pod, err := client.CoreV1().Pods("test").Get(context.Background(), "app", metav1.GetOptions{})
+		if !tc.expectPod {
+			if err == nil {
+				t.Fatalf("test '%s': expected no pod to be recreated", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test '%s': expected pod to be recreated: %s", tc.name, err)
+		}
+		if claim := pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName; claim != tc.expectedClaim {
+			t.Fatalf("test '%s': expected recreated pod to use claim %s but received %s", tc.name, tc.expectedClaim, claim)
+		}
+		if _, err := client.CoreV1().PersistentVolumeClaims("test").Get(context.Background(), tc.expectedClaim, metav1.GetOptions{}); tc.expectedClaim == "data-abcde" && err != nil {
+			t.Fatalf("test '%s': expected pvc %s to be kept: %s", tc.name, tc.expectedClaim, err)
+		}
+	}
+}
+
+func Test_recoverRecreatingStatefulSet(t *testing.T) {
+	testCases := map[string]struct {
+		existing bool
+	}{
+		"when the statefulset was deleted but not recreated":                {existing: false},
+		"when the statefulset was recreated before the journal was updated": {existing: true},
+	}
+	for name, tc := range testCases {
+		replicas := int32(2)
+		sts := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "test"},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: &replicas,
+				VolumeClaimTemplates: []v1.PersistentVolumeClaim{{
+					ObjectMeta: metav1.ObjectMeta{Name: "data"},
+					Spec: v1.PersistentVolumeClaimSpec{Resources: v1.VolumeResourceRequirements{
+						Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")},
+					}},
+				}},
+			},
+		}
+		client := fake.NewSimpleClientset()
+		if tc.existing {
+			client = fake.NewSimpleClientset(sts.DeepCopy())
+		}
+		ds, err := NewDiskScaler(nil, client, newFakeDynamicClient(), "test", stubRecommender{}, false)
+		if err != nil {
+			t.Fatalf("for test case: `%s`, unable to create disk scaler: %s", name, err)
+		}
+		op := newOperation("test", workloadKindStatefulSet, "db")
+		op.Phase = operationRecreating
+		op.StatefulSet = sts
+		if err := ds.journal.record(context.Background(), op); err != nil {
+			t.Fatalf("for test case: `%s`, unable to journal operation: %s", name, err)
+		}
+
+		if err := ds.recoverOperations(context.Background()); err != nil {
+			t.Fatalf("for test case: `%s`, received unexpected err: %s", name, err)
+		}
+		recreated, err := client.AppsV1().StatefulSets("test").Get(context.Background(), "db", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("for test case: `%s`, expected the statefulset to be recreated: %s", name, err)
+		}
+		if size := recreated.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[v1.ResourceStorage]; size.String() != "10Gi" {
+			t.Fatalf("for test case: `%s`, expected the recreated statefulset to request 10Gi but received %s", name, size.String())
+		}
+		ops, err := ds.journal.operations(context.Background())
+		if err != nil || len(ops) != 0 {
+			t.Fatalf("for test case: `%s`, expected the recovered operation to be removed from the journal but found %v, err: %v", name, ops, err)
+		}
+	}
+}
+
+func Test_recoverStartedOperation(t *testing.T) {
+	testCases := map[string]struct {
+		replicas         int32
+		expectedReplicas int32
+	}{
+		"when the deployment was scaled down before it was journaled as quiesced": {replicas: 0, expectedReplicas: 2},
+		"when the deployment was not scaled down yet":                             {replicas: 2, expectedReplicas: 2},
+	}
+	for name, tc := range testCases {
+		replicas := tc.replicas
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		}
+		client := fake.NewSimpleClientset(deployment)
+		// the fake clientset does not serve the scale subresource, so it is backed by the deployment replicas.
+		client.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "scale" {
+				return false, nil, nil
+			}
+			return true, &autoscalingv1.Scale{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test"},
+				Spec:       autoscalingv1.ScaleSpec{Replicas: replicas},
+			}, nil
+		})
+		client.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "scale" {
+				return false, nil, nil
+			}
+			scale := action.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale)
+			replicas = scale.Spec.Replicas
+			return true, scale, nil
+		})
+		ds, err := NewDiskScaler(nil, client, newFakeDynamicClient(), "test", stubRecommender{}, false)
+		if err != nil {
+			t.Fatalf("for test case: `%s`, unable to create disk scaler: %s", name, err)
+		}
+		op := newOperation("test", workloadKindDeployment, "app")
+		op.OriginalScale = 2
+		if err := ds.journal.record(context.Background(), op); err != nil {
+			t.Fatalf("for test case: `%s`, unable to journal operation: %s", name, err)
+		}
+
+		if err := ds.recoverOperations(context.Background()); err != nil {
+			t.Fatalf("for test case: `%s`, received unexpected err: %s", name, err)
+		}
+		if replicas != tc.expectedReplicas {
+			t.Fatalf("for test case: `%s`, expected %d replicas but received %d", name, tc.expectedReplicas, replicas)
+		}
+	}
+}
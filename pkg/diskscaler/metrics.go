@@ -0,0 +1,62 @@
+package diskscaler
+
+import (
+	"context"
+	"time"
+
+	"github.com/kubecost/disk-autoscaler/pkg/metrics"
+	"github.com/kubecost/disk-autoscaler/pkg/pvsizingrecommendation"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// recordRecommendation records the current and recommended size of the volume of a claim along
+// with the projected monthly savings of resizing it. Like every metric setter, it records nothing
+// when ctx is not recording.
+func recordRecommendation(ctx context.Context, namespace string, pvcName string, pvName string, currentSize resource.Quantity, recommendation pvsizingrecommendation.RecommendationSizeWithSavings) {
+	if !recording(ctx) {
+		return
+	}
+	metrics.VolumeCurrentSize.WithLabelValues(namespace, pvcName, pvName).Set(currentSize.AsApproximateFloat64())
+	metrics.VolumeRecommendedSize.WithLabelValues(namespace, pvcName, pvName).Set(recommendation.RecommendedResourceSize.AsApproximateFloat64())
+	metrics.VolumeProjectedSavings.WithLabelValues(namespace, pvcName, pvName).Set(recommendation.Savings)
+}
+
+// recordResizes counts the resize of every claim of a workload which was resized, or failed to be.
+// The series of a claim whose volume was replaced by a copy are dropped until its next evaluation.
+func recordResizes(ctx context.Context, namespace string, volMap map[string]*pvcDetails) {
+	if !recording(ctx) {
+		return
+	}
+	for pvcName, pvcDetails := range volMap {
+		if isEqualQuantity(pvcDetails.currentSize, pvcDetails.resizeTo) {
+			continue
+		}
+		direction := metrics.DirectionShrink
+		if isGreaterQuantity(pvcDetails.currentSize, pvcDetails.resizeTo) {
+			direction = metrics.DirectionExpand
+		}
+		metrics.Resizes.WithLabelValues(direction, metrics.Outcome(pvcDetails.err), "false").Inc()
+		if pvcDetails.err == nil && pvcDetails.needsCopy() {
+			metrics.ForgetVolume(namespace, pvcName)
+		}
+	}
+}
+
+// recordEmergencyExpansion counts an emergency expansion of a claim, which failed when err is set.
+func recordEmergencyExpansion(ctx context.Context, err error) {
+	if !recording(ctx) {
+		return
+	}
+	metrics.Resizes.WithLabelValues(metrics.DirectionExpand, metrics.Outcome(err), "true").Inc()
+}
+
+// recordCopy records the duration of a copy by the data mover, and the bytes copied when known.
+func recordCopy(ctx context.Context, duration time.Duration, copiedBytes int64, err error) {
+	if !recording(ctx) {
+		return
+	}
+	metrics.CopyDuration.WithLabelValues(metrics.Outcome(err)).Observe(duration.Seconds())
+	if err == nil && copiedBytes >= 0 {
+		metrics.CopyBytes.Observe(float64(copiedBytes))
+	}
+}
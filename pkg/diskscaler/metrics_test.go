@@ -0,0 +1,42 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/kubecost/disk-autoscaler/pkg/metrics"
+	"github.com/kubecost/disk-autoscaler/pkg/pvsizingrecommendation"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func Test_recordResizes(t *testing.T) {
+	recordRecommendation(context.Background(), "test", "data", "pv-1", resource.MustParse("100Gi"), pvsizingrecommendation.RecommendationSizeWithSavings{RecommendedResourceSize: resource.MustParse("10Gi"), Savings: 7.2})
+	recordRecommendation(context.Background(), "test", "logs", "pv-2", resource.MustParse("10Gi"), pvsizingrecommendation.RecommendationSizeWithSavings{RecommendedResourceSize: resource.MustParse("20Gi")})
+	if savings := testutil.ToFloat64(metrics.VolumeProjectedSavings.WithLabelValues("test", "data", "pv-1")); savings != 7.2 {
+		t.Fatalf("expected projected savings of 7.2 but received %f", savings)
+	}
+
+	shrunk := testutil.ToFloat64(metrics.Resizes.WithLabelValues(metrics.DirectionShrink, metrics.OutcomeSuccess, "false"))
+	expanded := testutil.ToFloat64(metrics.Resizes.WithLabelValues(metrics.DirectionExpand, metrics.OutcomeFailure, "false"))
+	recordResizes(context.Background(), "test", map[string]*pvcDetails{
+		"data":    {currentSize: resource.MustParse("100Gi"), resizeTo: resource.MustParse("10Gi")},
+		"logs":    {currentSize: resource.MustParse("10Gi"), resizeTo: resource.MustParse("20Gi"), allowVolumeExpansion: true, err: fmt.Errorf("patch failed")},
+		"optimal": {currentSize: resource.MustParse("10Gi"), resizeTo: resource.MustParse("10Gi")},
+	})
+
+	if delta := testutil.ToFloat64(metrics.Resizes.WithLabelValues(metrics.DirectionShrink, metrics.OutcomeSuccess, "false")) - shrunk; delta != 1 {
+		t.Fatalf("expected 1 successful shrink but received %f", delta)
+	}
+	if delta := testutil.ToFloat64(metrics.Resizes.WithLabelValues(metrics.DirectionExpand, metrics.OutcomeFailure, "false")) - expanded; delta != 1 {
+		t.Fatalf("expected 1 failed expansion but received %f", delta)
+	}
+	// The volume of the shrunk claim was replaced, while the claim which failed to expand keeps its volume
+	if metrics.VolumeCurrentSize.DeleteLabelValues("test", "data", "pv-1") {
+		t.Fatalf("expected the series of the replaced volume to be dropped")
+	}
+	if !metrics.VolumeCurrentSize.DeleteLabelValues("test", "logs", "pv-2") {
+		t.Fatalf("expected the series of the volume which was not replaced to be kept")
+	}
+}
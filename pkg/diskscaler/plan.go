@@ -0,0 +1,517 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Actions of the steps of a plan
+const (
+	planActionScale                = "scale"
+	planActionWaitReady            = "waitReady"
+	planActionExpand               = "expand"
+	planActionSnapshot             = "snapshot"
+	planActionCreateClaim          = "createClaim"
+	planActionCopy                 = "copy"
+	planActionSwapClaim            = "swapClaim"
+	planActionRebindClaim          = "rebindClaim"
+	planActionRetainClaim          = "retainClaim"
+	planActionDeleteClaim          = "deleteClaim"
+	planActionUpdateClaimTemplates = "updateClaimTemplates"
+)
+
+// Preconditions a resize can fail
+const (
+	preconditionEnabled        = "enabled"
+	preconditionAuditMode      = "auditMode"
+	preconditionInProgress     = "inProgress"
+	preconditionClaim          = "claim"
+	preconditionVolume         = "volume"
+	preconditionRecommendation = "recommendation"
+	preconditionProvisioner    = "provisioner"
+	preconditionVolumeMode     = "volumeMode"
+	preconditionBindingMode    = "bindingMode"
+	preconditionCooldown       = "cooldown"
+	preconditionQuota          = "quota"
+	preconditionStop           = "stop"
+)
+
+// storageClassQuotaSuffix is the suffix of the quota resources limiting the claims of a storage class
+const storageClassQuotaSuffix = ".storageclass.storage.k8s.io/"
+
+// Plan is what the disk scaling workflow would do to resize the claims of a workload, without doing it.
+type Plan struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Replicas  int32  `json:"replicas"`
+	// Steps are run in order, claims blocked by a precondition are left out
+	Steps []PlanStep `json:"steps"`
+	// Preconditions are the checks the resize would fail
+	Preconditions []Precondition `json:"preconditions"`
+	// Executable is true when no precondition fails
+	Executable bool `json:"executable"`
+}
+
+// PlanStep is a single action of a plan. Names of new claims are generated again when the resize runs.
+type PlanStep struct {
+	Action       string `json:"action"`
+	Description  string `json:"description"`
+	Claim        string `json:"claim,omitempty"`
+	NewClaim     string `json:"newClaim,omitempty"`
+	Size         string `json:"size,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+	Node         string `json:"node,omitempty"`
+	FromReplicas *int32 `json:"fromReplicas,omitempty"`
+	ToReplicas   *int32 `json:"toReplicas,omitempty"`
+	// EstimatedBytes is the data expected to be copied, derived from the recommendation at the target utilization
+	EstimatedBytes int64 `json:"estimatedBytes,omitempty"`
+}
+
+// Precondition is a check the resize would fail, for the whole workload when Claim is empty.
+type Precondition struct {
+	Check   string `json:"check"`
+	Claim   string `json:"claim,omitempty"`
+	Message string `json:"message"`
+}
+
+// workloadPlan returns the plan to resize the claims of the workload, along with the
+// preconditions which would stop it from being disk scaled.
+func (dss *DiskScalerService) workloadPlan(ctx context.Context, namespace, kind, name string, now time.Time) (*Plan, error) {
+	wl, err := dss.ds.workloadFor(kind)
+	if err != nil {
+		return nil, err
+	}
+	meta, _, err := wl.Get(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := dss.ds.plan(ctx, wl, namespace, name, now)
+	if err != nil {
+		return nil, err
+	}
+	if !dss.workloadIsEnabled(meta, dss.ds.policyFor(meta)) {
+		plan.fail(preconditionEnabled, "", "disk auto scaling is not enabled for %s %s", strings.ToLower(wl.Kind()), name)
+	}
+	return plan, nil
+}
+
+// plan computes the details of the claims of the workload as a dry run and lists the steps the
+// disk scaling workflow would take to resize them, in the order it would take them.
+func (ds *DiskScaler) plan(ctx context.Context, wl workload, namespace, name string, now time.Time) (*Plan, error) {
+	ctx = context.WithValue(context.WithValue(ctx, dryRunContextKey, true), noRecordContextKey, true)
+	replicas, err := wl.Replicas(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{
+		Namespace:     namespace,
+		Kind:          wl.Kind(),
+		Name:          name,
+		Replicas:      replicas,
+		Steps:         []PlanStep{},
+		Preconditions: []Precondition{},
+		Executable:    true,
+	}
+	if ds.auditMode {
+		plan.fail(preconditionAuditMode, "", "disk auto scaler is in audit mode and does not resize any volume")
+	}
+	if _, busy := ds.scaling.Load(workloadKey(namespace, wl.Kind(), name)); busy {
+		plan.fail(preconditionInProgress, "", "%s %s is already being scaled", strings.ToLower(wl.Kind()), name)
+	}
+
+	_, sts := wl.(*statefulSetWorkload)
+	var volMap map[string]*pvcDetails
+	if sts {
+		_, volMap, err = ds.getStatefulSetPVCMap(ctx, namespace, name)
+	} else {
+		_, volMap, err = ds.getPVCMap(ctx, wl, namespace, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = ds.checkPlannedClaims(ctx, plan, wl, volMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claims which would fail are left out so that the steps are those of the claims which can be resized
+	claims := []string{}
+	for pvcName, pvcDetails := range volMap {
+		if pvcDetails.blocker != nil {
+			plan.fail(pvcDetails.blockedBy, pvcName, "%v", pvcDetails.blocker)
+			delete(volMap, pvcName)
+			continue
+		}
+		claims = append(claims, pvcName)
+	}
+	sort.Strings(claims)
+
+	if sts {
+		ds.planStatefulSet(plan, claims, volMap, now)
+	} else {
+		ds.planWorkload(plan, claims, volMap, now)
+	}
+
+	err = ds.checkQuotas(ctx, plan, namespace, volMap)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// checkPlannedClaims adds the preconditions failed by the volumes of the workload which a dry
+// run skips without a blocker, volumes other than claims and claims which were not found.
+func (ds *DiskScaler) checkPlannedClaims(ctx context.Context, plan *Plan, wl workload, volMap map[string]*pvcDetails) error {
+	_, template, err := wl.Get(ctx, plan.Namespace, plan.Name)
+	if err != nil {
+		return err
+	}
+	_, sts := wl.(*statefulSetWorkload)
+	mounted := map[string]bool{}
+	for _, vol := range template.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil {
+			mounted[vol.PersistentVolumeClaim.ClaimName] = true
+			continue
+		}
+		// Volumes of a StatefulSet other than its volumeClaimTemplates are not resized
+		if sts || (vol.Projected != nil && strings.HasPrefix(vol.Name, serviceAccountTokenVolumePrefix)) {
+			continue
+		}
+		plan.fail(preconditionVolume, "", "volume %s is not a persistent volume claim, volumes of the %s are not resized", vol.Name, strings.ToLower(wl.Kind()))
+	}
+
+	claims, err := ds.workloadClaims(ctx, wl, plan.Namespace, plan.Name)
+	if err != nil {
+		return err
+	}
+	for _, claim := range claims {
+		// Claims shared by every replica of a StatefulSet are not resized
+		if sts && mounted[claim] {
+			continue
+		}
+		if _, ok := volMap[claim]; !ok {
+			plan.fail(preconditionClaim, claim, "pvc %s or its persistent volume was not found", claim)
+		}
+	}
+	return nil
+}
+
+// planWorkload adds the steps of the disk scaling workflow of a workload which is stopped while its claims are copied.
+func (ds *DiskScaler) planWorkload(plan *Plan, claims []string, volMap map[string]*pvcDetails, now time.Time) {
+	kind := strings.ToLower(plan.Kind)
+	// The workflow decides to stop the workload before it skips the claims within their cooldown
+	quiesce := needsQuiesce(volMap)
+	ds.planCooldowns(plan, claims, volMap, now)
+
+	swapped := []string{}
+	if quiesce {
+		if ds.shrinkFromSnapshot {
+			for _, pvcName := range claims {
+				pvcDetails := volMap[pvcName]
+				if !pvcDetails.needsCopy() || pvcDetails.keepName {
+					continue
+				}
+				ds.planSnapshot(plan, pvcName)
+				plan.addStep(PlanStep{
+					Action:       planActionCreateClaim,
+					Description:  fmt.Sprintf("create pvc %s of %s in storage class %s", pvcDetails.resizedPVCName, pvcDetails.resizeTo.String(), pvcDetails.storageClass),
+					Claim:        pvcName,
+					NewClaim:     pvcDetails.resizedPVCName,
+					Size:         pvcDetails.resizeTo.String(),
+					StorageClass: pvcDetails.storageClass,
+					Node:         pvcDetails.selectedNode,
+				})
+				plan.addStep(PlanStep{
+					Action:         planActionCopy,
+					Description:    fmt.Sprintf("pre-copy about %s from a clone of pvc %s restored from its snapshot to pvc %s while the %s is running", formatBytes(pvcDetails.dataEstimate), pvcName, pvcDetails.resizedPVCName, kind),
+					Claim:          pvcName,
+					NewClaim:       pvcDetails.resizedPVCName,
+					EstimatedBytes: pvcDetails.dataEstimate,
+				})
+			}
+		}
+		plan.addScaleStep(plan.Replicas, 0, fmt.Sprintf("scale %s %s from %d to 0 replicas", kind, plan.Name, plan.Replicas))
+	}
+
+	for _, pvcName := range claims {
+		pvcDetails := volMap[pvcName]
+		switch {
+		case isEqualQuantity(pvcDetails.currentSize, pvcDetails.resizeTo):
+		case pvcDetails.canExpand():
+			plan.addExpandStep(pvcName, pvcDetails)
+		case pvcDetails.keepName:
+			ds.planCopyKeepingName(plan, pvcName, pvcDetails)
+		default:
+			precopied := ds.shrinkFromSnapshot
+			if !precopied {
+				ds.planSnapshot(plan, pvcName)
+				plan.addStep(PlanStep{
+					Action:       planActionCreateClaim,
+					Description:  fmt.Sprintf("create pvc %s of %s in storage class %s", pvcDetails.resizedPVCName, pvcDetails.resizeTo.String(), pvcDetails.storageClass),
+					Claim:        pvcName,
+					NewClaim:     pvcDetails.resizedPVCName,
+					Size:         pvcDetails.resizeTo.String(),
+					StorageClass: pvcDetails.storageClass,
+					Node:         pvcDetails.selectedNode,
+				})
+			}
+			copyStep := PlanStep{
+				Action:         planActionCopy,
+				Description:    fmt.Sprintf("copy about %s from pvc %s to pvc %s", formatBytes(pvcDetails.dataEstimate), pvcName, pvcDetails.resizedPVCName),
+				Claim:          pvcName,
+				NewClaim:       pvcDetails.resizedPVCName,
+				EstimatedBytes: pvcDetails.dataEstimate,
+			}
+			if precopied {
+				copyStep.Description = fmt.Sprintf("sync the changes made to pvc %s since its snapshot to pvc %s", pvcName, pvcDetails.resizedPVCName)
+				copyStep.EstimatedBytes = 0
+			}
+			plan.addStep(copyStep)
+			plan.addStep(PlanStep{
+				Action:      planActionSwapClaim,
+				Description: fmt.Sprintf("repoint %s %s from pvc %s to pvc %s", kind, plan.Name, pvcName, pvcDetails.resizedPVCName),
+				Claim:       pvcName,
+				NewClaim:    pvcDetails.resizedPVCName,
+			})
+			swapped = append(swapped, pvcName)
+		}
+	}
+
+	if quiesce {
+		plan.addScaleStep(0, plan.Replicas, fmt.Sprintf("restore %s %s to %d replicas", kind, plan.Name, plan.Replicas))
+	}
+
+	for _, pvcName := range swapped {
+		if ds.rollbackWindow <= 0 {
+			plan.addStep(PlanStep{Action: planActionDeleteClaim, Description: fmt.Sprintf("delete pvc %s", pvcName), Claim: pvcName})
+			continue
+		}
+		plan.addStep(PlanStep{
+			Action:      planActionRetainClaim,
+			Description: fmt.Sprintf("retain pvc %s for %s to allow a rollback, then delete it", pvcName, ds.rollbackWindow),
+			Claim:       pvcName,
+		})
+	}
+}
+
+// planStatefulSet adds the steps of the disk scaling workflow of a StatefulSet, which expands claims
+// online and releases one ordinal at a time, highest first, to resize the others. Releasing an
+// ordinal stops every ordinal above it, so the lowest ordinal fails a precondition unless allowed.
+func (ds *DiskScaler) planStatefulSet(plan *Plan, claims []string, volMap map[string]*pvcDetails, now time.Time) {
+	ds.planCooldowns(plan, claims, volMap, now)
+
+	ordinalsToCopy := map[int][]string{}
+	for _, pvcName := range claims {
+		pvcDetails := volMap[pvcName]
+		if isEqualQuantity(pvcDetails.currentSize, pvcDetails.resizeTo) {
+			continue
+		}
+		if pvcDetails.canExpand() && pvcDetails.driver.OnlineExpansion {
+			plan.addExpandStep(pvcName, pvcDetails)
+			continue
+		}
+		if pvcDetails.ordinal == pvcDetails.ordinalStart && !pvcDetails.allowStop {
+			plan.fail(preconditionStop, pvcName, "resizing pvc %s releases the lowest ordinal %d which scales statefulset %s to 0 replicas, annotate it with %s=true to allow it", pvcName, pvcDetails.ordinal, plan.Name, AnnotationAllowStop)
+			continue
+		}
+		ordinalsToCopy[pvcDetails.ordinal] = append(ordinalsToCopy[pvcDetails.ordinal], pvcName)
+	}
+
+	ordinals := make([]int, 0, len(ordinalsToCopy))
+	for ordinal := range ordinalsToCopy {
+		ordinals = append(ordinals, ordinal)
+	}
+	slices.Sort(ordinals)
+	slices.Reverse(ordinals)
+
+	for _, ordinal := range ordinals {
+		ordinalClaims := ordinalsToCopy[ordinal]
+		releasedTo := int32(ordinal - volMap[ordinalClaims[0]].ordinalStart)
+		plan.addScaleStep(plan.Replicas, releasedTo, fmt.Sprintf("scale statefulset %s from %d to %d replicas to release ordinal %d, stopping %d replicas from ordinal %d up", plan.Name, plan.Replicas, releasedTo, ordinal, plan.Replicas-releasedTo, ordinal))
+		for _, pvcName := range ordinalClaims {
+			pvcDetails := volMap[pvcName]
+			if pvcDetails.canExpand() {
+				plan.addExpandStep(pvcName, pvcDetails)
+				continue
+			}
+			ds.planCopyKeepingName(plan, pvcName, pvcDetails)
+		}
+		plan.addScaleStep(releasedTo, plan.Replicas, fmt.Sprintf("restore statefulset %s to %d replicas", plan.Name, plan.Replicas))
+		plan.addStep(PlanStep{
+			Action:      planActionWaitReady,
+			Description: fmt.Sprintf("wait for the %d replicas of statefulset %s to be ready", plan.Replicas, plan.Name),
+		})
+	}
+
+	if len(plan.Steps) == 0 {
+		return
+	}
+	sizes := claimTemplateSizes(volMap)
+	templates := make([]string, 0, len(sizes))
+	for template, size := range sizes {
+		templates = append(templates, fmt.Sprintf("%s to %s", template, size.String()))
+	}
+	sort.Strings(templates)
+	plan.addStep(PlanStep{
+		Action:      planActionUpdateClaimTemplates,
+		Description: fmt.Sprintf("recreate statefulset %s without restarting its pods to set the volumeClaimTemplates %s", plan.Name, strings.Join(templates, ", ")),
+	})
+}
+
+// planCooldowns leaves out the claims the provisioner does not allow to be expanded again yet, as the workflow would.
+func (ds *DiskScaler) planCooldowns(plan *Plan, claims []string, volMap map[string]*pvcDetails, now time.Time) {
+	for _, pvcName := range claims {
+		pvcDetails := volMap[pvcName]
+		if !pvcDetails.canExpand() {
+			continue
+		}
+		if remaining := pvcDetails.driver.CooldownRemaining(pvcDetails.lastResized, now); remaining > 0 {
+			plan.fail(preconditionCooldown, pvcName, "pvc was resized at %s and provisioner %s allows another resize in %s", pvcDetails.lastResized.Format(timeFormat), pvcDetails.provisioner, remaining.Round(time.Minute))
+			pvcDetails.resizeTo = pvcDetails.currentSize
+		}
+	}
+}
+
+// planCopyKeepingName adds the steps copying a claim to a transient claim whose volume is then rebound behind the claim name.
+func (ds *DiskScaler) planCopyKeepingName(plan *Plan, pvcName string, pvcDetails *pvcDetails) {
+	ds.planSnapshot(plan, pvcName)
+	plan.addStep(PlanStep{
+		Action:       planActionCreateClaim,
+		Description:  fmt.Sprintf("create transient pvc %s of %s in storage class %s", pvcDetails.resizedPVCName, pvcDetails.resizeTo.String(), pvcDetails.storageClass),
+		Claim:        pvcName,
+		NewClaim:     pvcDetails.resizedPVCName,
+		Size:         pvcDetails.resizeTo.String(),
+		StorageClass: pvcDetails.storageClass,
+		Node:         pvcDetails.selectedNode,
+	})
+	plan.addStep(PlanStep{
+		Action:         planActionCopy,
+		Description:    fmt.Sprintf("copy about %s from pvc %s to pvc %s", formatBytes(pvcDetails.dataEstimate), pvcName, pvcDetails.resizedPVCName),
+		Claim:          pvcName,
+		NewClaim:       pvcDetails.resizedPVCName,
+		EstimatedBytes: pvcDetails.dataEstimate,
+	})
+	plan.addStep(PlanStep{
+		Action:      planActionRebindClaim,
+		Description: fmt.Sprintf("delete pvc %s and recreate it bound to the volume of pvc %s", pvcName, pvcDetails.resizedPVCName),
+		Claim:       pvcName,
+		NewClaim:    pvcDetails.resizedPVCName,
+	})
+}
+
+// planSnapshot adds the step taking a snapshot of the claim before its data is copied, unless snapshots are disabled.
+func (ds *DiskScaler) planSnapshot(plan *Plan, pvcName string) {
+	if ds.snapshotter.disabled {
+		return
+	}
+	plan.addStep(PlanStep{Action: planActionSnapshot, Description: fmt.Sprintf("take a volume snapshot of pvc %s", pvcName), Claim: pvcName})
+}
+
+// checkQuotas adds a precondition for every ResourceQuota of the namespace which the claims created
+// or expanded by the plan would exceed. The claims replaced are only deleted once the resize is done,
+// so they still count against the quotas.
+func (ds *DiskScaler) checkQuotas(ctx context.Context, plan *Plan, namespace string, volMap map[string]*pvcDetails) error {
+	requested := v1.ResourceList{}
+	add := func(name v1.ResourceName, quantity resource.Quantity) {
+		total := requested[name]
+		total.Add(quantity)
+		requested[name] = total
+	}
+	for _, pvcDetails := range volMap {
+		classPrefix := v1.ResourceName(pvcDetails.storageClass + storageClassQuotaSuffix)
+		switch {
+		case isEqualQuantity(pvcDetails.currentSize, pvcDetails.resizeTo):
+		case pvcDetails.canExpand():
+			growth := pvcDetails.resizeTo.DeepCopy()
+			growth.Sub(pvcDetails.currentSize)
+			add(v1.ResourceRequestsStorage, growth)
+			add(classPrefix+v1.ResourceRequestsStorage, growth)
+		default:
+			claims := *resource.NewQuantity(1, resource.DecimalSI)
+			add(v1.ResourceRequestsStorage, pvcDetails.resizeTo)
+			add(classPrefix+v1.ResourceRequestsStorage, pvcDetails.resizeTo)
+			add(v1.ResourcePersistentVolumeClaims, claims)
+			add(classPrefix+v1.ResourcePersistentVolumeClaims, claims)
+			// A pre-copy restores the snapshot to a clone of the claim alongside the new claim
+			if ds.shrinkFromSnapshot && !pvcDetails.keepName {
+				add(v1.ResourceRequestsStorage, pvcDetails.currentSize)
+				add(classPrefix+v1.ResourceRequestsStorage, pvcDetails.currentSize)
+				add(v1.ResourcePersistentVolumeClaims, claims)
+				add(classPrefix+v1.ResourcePersistentVolumeClaims, claims)
+			}
+		}
+	}
+	if len(requested) == 0 {
+		return nil
+	}
+
+	quotas, err := ds.basicK8sClient.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list resource quotas in namespace %s: %w", namespace, err)
+	}
+	names := make([]string, 0, len(requested))
+	for name := range requested {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, quota := range quotas.Items {
+		for _, name := range names {
+			hard, ok := quota.Status.Hard[v1.ResourceName(name)]
+			if !ok {
+				continue
+			}
+			used := quota.Status.Used[v1.ResourceName(name)]
+			total := used.DeepCopy()
+			total.Add(requested[v1.ResourceName(name)])
+			if total.Cmp(hard) > 0 {
+				needed := requested[v1.ResourceName(name)]
+				plan.fail(preconditionQuota, "", "resource quota %s allows %s of %s, %s is used and the resize requires another %s", quota.Name, hard.String(), name, used.String(), needed.String())
+			}
+		}
+	}
+	return nil
+}
+
+// fail adds a precondition the resize would fail.
+func (p *Plan) fail(check string, claim string, messageFmt string, args ...interface{}) {
+	p.Executable = false
+	p.Preconditions = append(p.Preconditions, Precondition{Check: check, Claim: claim, Message: fmt.Sprintf(messageFmt, args...)})
+}
+
+func (p *Plan) addStep(step PlanStep) {
+	p.Steps = append(p.Steps, step)
+}
+
+func (p *Plan) addScaleStep(from int32, to int32, description string) {
+	p.addStep(PlanStep{Action: planActionScale, Description: description, FromReplicas: &from, ToReplicas: &to})
+}
+
+func (p *Plan) addExpandStep(pvcName string, pvcDetails *pvcDetails) {
+	p.addStep(PlanStep{
+		Action:      planActionExpand,
+		Description: fmt.Sprintf("expand pvc %s from %s to %s in place", pvcName, pvcDetails.currentSize.String(), pvcDetails.resizeTo.String()),
+		Claim:       pvcName,
+		Size:        pvcDetails.resizeTo.String(),
+	})
+}
+
+// formatBytes formats a number of bytes as a binary quantity rounded to the closest unit.
+func formatBytes(bytes int64) string {
+	units := []string{"", "Ki", "Mi", "Gi", "Ti", "Pi"}
+	value := float64(bytes)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
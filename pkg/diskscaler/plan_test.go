@@ -0,0 +1,186 @@
+package diskscaler
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubecost/disk-autoscaler/pkg/metrics"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_workloadPlan(t *testing.T) {
+	type testCase struct {
+		name                  string
+		provisioner           string
+		recommendedSize       string
+		quota                 *v1.ResourceQuota
+		disableSnapshots      bool
+		rollbackWindow        time.Duration
+		expectedActions       []string
+		expectedPreconditions []string
+	}
+
+	testCases := []testCase{
+		{
+			name:            "when a volume is shrunk by copy",
+			provisioner:     "ebs.csi.aws.com",
+			recommendedSize: "10Gi",
+			rollbackWindow:  time.Hour,
+			expectedActions: []string{
+				planActionScale,
+				planActionSnapshot,
+				planActionCreateClaim,
+				planActionCopy,
+				planActionSwapClaim,
+				planActionScale,
+				planActionRetainClaim,
+			},
+		},
+		{
+			name:             "when a volume is shrunk without snapshot or rollback window",
+			provisioner:      "ebs.csi.aws.com",
+			recommendedSize:  "10Gi",
+			disableSnapshots: true,
+			expectedActions: []string{
+				planActionScale,
+				planActionCreateClaim,
+				planActionCopy,
+				planActionSwapClaim,
+				planActionScale,
+				planActionDeleteClaim,
+			},
+		},
+		{
+			name:            "when a volume is expanded online",
+			provisioner:     "ebs.csi.aws.com",
+			recommendedSize: "200Gi",
+			expectedActions: []string{planActionExpand},
+		},
+		{
+			name:             "when the new volume would exceed the storage quota of the namespace",
+			provisioner:      "ebs.csi.aws.com",
+			recommendedSize:  "10Gi",
+			disableSnapshots: true,
+			quota: &v1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: "storage", Namespace: "test"},
+				Status: v1.ResourceQuotaStatus{
+					Hard: v1.ResourceList{v1.ResourceRequestsStorage: resource.MustParse("105Gi")},
+					Used: v1.ResourceList{v1.ResourceRequestsStorage: resource.MustParse("100Gi")},
+				},
+			},
+			expectedActions: []string{
+				planActionScale,
+				planActionCreateClaim,
+				planActionCopy,
+				planActionSwapClaim,
+				planActionScale,
+				planActionDeleteClaim,
+			},
+			expectedPreconditions: []string{preconditionQuota},
+		},
+		{
+			name:                  "when the provisioner is not supported",
+			provisioner:           "example.com/nfs",
+			recommendedSize:       "10Gi",
+			expectedActions:       []string{},
+			expectedPreconditions: []string{preconditionProvisioner},
+		},
+		{
+			name:                  "when the recommender has no data for the volume",
+			provisioner:           "ebs.csi.aws.com",
+			expectedActions:       []string{},
+			expectedPreconditions: []string{preconditionRecommendation},
+		},
+	}
+
+	for _, tc := range testCases {
+		storageClassName := "gp3"
+		bindingMode := storagev1.VolumeBindingWaitForFirstConsumer
+		allowExpansion := true
+		replicas := int32(2)
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "test", Annotations: map[string]string{AnnotationEnabled: "true"}},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Volumes: []v1.Volume{{
+					Name:         "data",
+					VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+				}}}},
+			},
+		}
+		pvc := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "test"},
+			Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &storageClassName, VolumeName: "pv-plan"},
+			Status:     v1.PersistentVolumeClaimStatus{Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("100Gi")}},
+		}
+		pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-plan"}}
+		sc := &storagev1.StorageClass{
+			ObjectMeta:           metav1.ObjectMeta{Name: storageClassName},
+			Provisioner:          tc.provisioner,
+			VolumeBindingMode:    &bindingMode,
+			AllowVolumeExpansion: &allowExpansion,
+		}
+		objects := []runtime.Object{deployment, pvc, pv, sc}
+		if tc.quota != nil {
+			objects = append(objects, tc.quota)
+		}
+		recommender := stubRecommender{}
+		if tc.recommendedSize != "" {
+			recommender["pv-plan"] = tc.recommendedSize
+		}
+		client := fake.NewSimpleClientset(objects...)
+		dss, err := NewDiskScalerService(nil, client, newFakeDynamicClient(), false, false, recommender, []string{KubecostNamespace})
+		if err != nil {
+			t.Fatalf("test '%s': unable to create disk scaler service: %s", tc.name, err)
+		}
+		recorder := record.NewFakeRecorder(10)
+		dss.ds.recorder = recorder
+		dss.ds.snapshotter.disabled = tc.disableSnapshots
+		dss.ds.rollbackWindow = tc.rollbackWindow
+
+		plan, err := dss.workloadPlan(context.Background(), "test", workloadKindDeployment, "db", time.Now())
+		if err != nil {
+			t.Fatalf("test '%s': unable to plan the deployment: %s", tc.name, err)
+		}
+		actions := []string{}
+		for _, step := range plan.Steps {
+			actions = append(actions, step.Action)
+		}
+		if strings.Join(actions, ",") != strings.Join(tc.expectedActions, ",") {
+			t.Fatalf("test '%s': expected steps %v but received %v", tc.name, tc.expectedActions, actions)
+		}
+		checks := []string{}
+		for _, precondition := range plan.Preconditions {
+			checks = append(checks, precondition.Check)
+		}
+		if strings.Join(checks, ",") != strings.Join(tc.expectedPreconditions, ",") {
+			t.Fatalf("test '%s': expected failed preconditions %v but received %+v", tc.name, tc.expectedPreconditions, plan.Preconditions)
+		}
+		if plan.Executable != (len(tc.expectedPreconditions) == 0) {
+			t.Fatalf("test '%s': expected the plan to be executable only without failed preconditions but received %t", tc.name, plan.Executable)
+		}
+		if plan.Replicas != replicas || (len(plan.Steps) > 1 && *plan.Steps[0].FromReplicas != replicas) {
+			t.Fatalf("test '%s': expected the deployment to be scaled from %d replicas but received %+v", tc.name, replicas, plan.Steps)
+		}
+		for _, action := range client.Fake.Actions() {
+			if action.GetVerb() != "get" && action.GetVerb() != "list" {
+				t.Fatalf("test '%s': expected a plan not to change anything but received %s %s", tc.name, action.GetVerb(), action.GetResource().Resource)
+			}
+		}
+		if len(recorder.Events) != 0 {
+			t.Fatalf("test '%s': expected a plan not to record events but received %s", tc.name, <-recorder.Events)
+		}
+		if metrics.VolumeRecommendedSize.DeleteLabelValues("test", "data", "pv-plan") {
+			t.Fatalf("test '%s': expected a plan not to record the recommendation metrics", tc.name)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package diskscaler
+
+import (
+	"fmt"
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// loadDataMoverPodTemplate reads the pod template of the copier job from a YAML or JSON file,
+// typically mounted from a ConfigMap.
+func loadDataMoverPodTemplate(path string) (*v1.PodTemplateSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read data mover pod template %s: %w", path, err)
+	}
+	template := &v1.PodTemplateSpec{}
+	err = yaml.UnmarshalStrict(data, template)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse data mover pod template %s: %w", path, err)
+	}
+	if len(template.Spec.Containers) > 1 {
+		return nil, fmt.Errorf("data mover pod template %s must have at most one container, found %d", path, len(template.Spec.Containers))
+	}
+	return template, nil
+}
+
+// copierPodTemplate returns the pod template of the job copying the data of originalPVC to newPVC with dm.
+// It starts from the configured pod template, and whatever the template leaves unset is inherited
+// from the pod template of the workload, so that the copier is scheduled where the workload may
+// run and can read the files of its volumes. The container, its volumes and the restart policy
+// are always set by the disk auto scaler.
+func (ds *DiskScaler) copierPodTemplate(dm dataMover, workloadSpec *v1.PodSpec, originalPVC string, newPVC string) v1.PodTemplateSpec {
+	template := v1.PodTemplateSpec{}
+	if dm.podTemplate != nil {
+		template = *dm.podTemplate.DeepCopy()
+	}
+	spec := &template.Spec
+	if workloadSpec != nil {
+		inheritWorkloadPodSpec(spec, workloadSpec)
+	}
+
+	container := v1.Container{}
+	if len(spec.Containers) > 0 {
+		container = spec.Containers[0]
+	}
+	container.Name = copierContainerName
+	if container.Image == "" {
+		container.Image = dm.image
+	}
+	container.Command = []string{"/bin/sh", "-c", dm.jobScript()}
+	container.Args = nil
+	// The output of a failed copy is reported through the termination message
+	container.TerminationMessagePolicy = v1.TerminationMessageFallbackToLogsOnError
+	container.VolumeMounts = append(container.VolumeMounts,
+		v1.VolumeMount{
+			Name:      "orig-vol-mount",
+			MountPath: oldDataMountPath,
+		},
+		v1.VolumeMount{
+			Name:      "backup-vol-mount",
+			MountPath: newDataMountPath,
+		},
+	)
+	if container.Resources.Requests == nil && container.Resources.Limits == nil {
+		container.Resources.Requests = v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("100m"),
+			v1.ResourceMemory: resource.MustParse("64Mi"),
+		}
+	}
+	if container.SecurityContext == nil {
+		container.SecurityContext = copierSecurityContext(spec.SecurityContext)
+	}
+	spec.Containers = []v1.Container{container}
+	spec.RestartPolicy = v1.RestartPolicyNever
+	spec.Volumes = append(spec.Volumes,
+		v1.Volume{
+			Name: "orig-vol-mount",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: originalPVC,
+				},
+			},
+		},
+		v1.Volume{
+			Name: "backup-vol-mount",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: newPVC,
+				},
+			},
+		},
+	)
+	return template
+}
+
+// inheritWorkloadPodSpec copies the tolerations, node selector, node affinity, fsGroup and
+// runAsUser of the workload into the copier pod spec where the copier pod spec leaves them unset.
+// Pod affinities are not inherited as the pods they refer to are typically stopped for the copy.
+func inheritWorkloadPodSpec(spec *v1.PodSpec, workloadSpec *v1.PodSpec) {
+	if spec.Tolerations == nil {
+		spec.Tolerations = workloadSpec.Tolerations
+	}
+	if spec.NodeSelector == nil {
+		spec.NodeSelector = workloadSpec.NodeSelector
+	}
+	if workloadSpec.Affinity != nil && workloadSpec.Affinity.NodeAffinity != nil {
+		if spec.Affinity == nil {
+			spec.Affinity = &v1.Affinity{}
+		}
+		if spec.Affinity.NodeAffinity == nil {
+			spec.Affinity.NodeAffinity = workloadSpec.Affinity.NodeAffinity.DeepCopy()
+		}
+	}
+
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = &v1.PodSecurityContext{}
+	}
+	if workloadSpec.SecurityContext != nil && spec.SecurityContext.FSGroup == nil {
+		spec.SecurityContext.FSGroup = workloadSpec.SecurityContext.FSGroup
+	}
+	if spec.SecurityContext.RunAsUser == nil {
+		spec.SecurityContext.RunAsUser = workloadRunAsUser(workloadSpec)
+	}
+}
+
+// workloadRunAsUser returns the user the workload runs as, set either for the pod or for its first
+// container which sets one.
+func workloadRunAsUser(workloadSpec *v1.PodSpec) *int64 {
+	if workloadSpec.SecurityContext != nil && workloadSpec.SecurityContext.RunAsUser != nil {
+		return workloadSpec.SecurityContext.RunAsUser
+	}
+	for _, container := range workloadSpec.Containers {
+		if container.SecurityContext != nil && container.SecurityContext.RunAsUser != nil {
+			return container.SecurityContext.RunAsUser
+		}
+	}
+	return nil
+}
+
+// copierSecurityContext returns the security context of the copier container. Privilege escalation
+// is never allowed. A copier running as a non-root user also drops every capability, which meets
+// the restricted Pod Security Standard, while a copier running as root keeps the default
+// capabilities it needs to preserve the ownership of the copied files.
+func copierSecurityContext(podSecurityContext *v1.PodSecurityContext) *v1.SecurityContext {
+	allowPrivilegeEscalation := false
+	securityContext := &v1.SecurityContext{
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		SeccompProfile:           &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault},
+	}
+	if podSecurityContext != nil && podSecurityContext.RunAsUser != nil && *podSecurityContext.RunAsUser != 0 {
+		runAsNonRoot := true
+		securityContext.RunAsNonRoot = &runAsNonRoot
+		securityContext.Capabilities = &v1.Capabilities{Drop: []v1.Capability{"ALL"}}
+	}
+	return securityContext
+}
@@ -0,0 +1,143 @@
+package diskscaler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func Test_copierPodTemplate(t *testing.T) {
+	runAsUser := int64(1000)
+	fsGroup := int64(2000)
+	templateUser := int64(3000)
+	dbToleration := v1.Toleration{Key: "dedicated", Value: "database", Effect: v1.TaintEffectNoSchedule}
+	workloadSpec := &v1.PodSpec{
+		Tolerations:  []v1.Toleration{dbToleration},
+		NodeSelector: map[string]string{"pool": "database"},
+		Affinity: &v1.Affinity{
+			NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{}},
+			PodAffinity:  &v1.PodAffinity{},
+		},
+		SecurityContext: &v1.PodSecurityContext{FSGroup: &fsGroup},
+		Containers: []v1.Container{{
+			Name:            "db",
+			SecurityContext: &v1.SecurityContext{RunAsUser: &runAsUser},
+		}},
+	}
+
+	type testCase struct {
+		name              string
+		podTemplate       *v1.PodTemplateSpec
+		expectedImage     string
+		expectedRunAsUser int64
+		expectedNonRoot   bool
+		expectedPullCreds int
+	}
+
+	testCases := []testCase{
+		{
+			name:              "when no pod template is configured the workload is inherited",
+			expectedImage:     defaultDataMoverImage,
+			expectedRunAsUser: runAsUser,
+			expectedNonRoot:   true,
+		},
+		{
+			name: "when the pod template sets the image, pull secrets and user",
+			podTemplate: &v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					ImagePullSecrets: []v1.LocalObjectReference{{Name: "registry"}},
+					SecurityContext:  &v1.PodSecurityContext{RunAsUser: &templateUser},
+					Containers:       []v1.Container{{Image: "registry.example.com/tools/rsync:3.2"}},
+				},
+			},
+			expectedImage:     "registry.example.com/tools/rsync:3.2",
+			expectedRunAsUser: templateUser,
+			expectedNonRoot:   true,
+			expectedPullCreds: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		ds := &DiskScaler{dataMover: dataMover{mode: dataMoverTar, verify: verifyCount, image: defaultDataMoverImage, podTemplate: tc.podTemplate}}
+		template := ds.copierPodTemplate(ds.dataMover, workloadSpec, "data", "data-abcde")
+		spec := template.Spec
+
+		if len(spec.Containers) != 1 || spec.Containers[0].Name != copierContainerName {
+			t.Fatalf("test '%s': expected a single %s container but received %+v", tc.name, copierContainerName, spec.Containers)
+		}
+		container := spec.Containers[0]
+		if container.Image != tc.expectedImage {
+			t.Fatalf("test '%s': expected image %s but received %s", tc.name, tc.expectedImage, container.Image)
+		}
+		if len(spec.Tolerations) != 1 || spec.Tolerations[0] != dbToleration || spec.NodeSelector["pool"] != "database" {
+			t.Fatalf("test '%s': expected tolerations and node selector to be inherited from the workload", tc.name)
+		}
+		if spec.Affinity == nil || spec.Affinity.NodeAffinity == nil || spec.Affinity.PodAffinity != nil {
+			t.Fatalf("test '%s': expected only the node affinity to be inherited from the workload", tc.name)
+		}
+		if spec.SecurityContext.FSGroup == nil || *spec.SecurityContext.FSGroup != fsGroup {
+			t.Fatalf("test '%s': expected fsGroup %d to be inherited from the workload", tc.name, fsGroup)
+		}
+		if spec.SecurityContext.RunAsUser == nil || *spec.SecurityContext.RunAsUser != tc.expectedRunAsUser {
+			t.Fatalf("test '%s': expected runAsUser %d but received %v", tc.name, tc.expectedRunAsUser, spec.SecurityContext.RunAsUser)
+		}
+		securityContext := container.SecurityContext
+		if securityContext == nil || securityContext.AllowPrivilegeEscalation == nil || *securityContext.AllowPrivilegeEscalation {
+			t.Fatalf("test '%s': expected privilege escalation to be disallowed", tc.name)
+		}
+		if nonRoot := securityContext.RunAsNonRoot != nil && *securityContext.RunAsNonRoot; nonRoot != tc.expectedNonRoot {
+			t.Fatalf("test '%s': expected runAsNonRoot %t but received %t", tc.name, tc.expectedNonRoot, nonRoot)
+		}
+		if len(spec.ImagePullSecrets) != tc.expectedPullCreds {
+			t.Fatalf("test '%s': expected %d image pull secrets but received %d", tc.name, tc.expectedPullCreds, len(spec.ImagePullSecrets))
+		}
+		if spec.RestartPolicy != v1.RestartPolicyNever || len(spec.Volumes) != 2 || len(container.VolumeMounts) != 2 {
+			t.Fatalf("test '%s': expected the copier to mount both volumes and never restart", tc.name)
+		}
+	}
+}
+
+func Test_loadDataMoverPodTemplate(t *testing.T) {
+	testCases := map[string]struct {
+		content   string
+		expectErr bool
+	}{
+		"when the template sets resources and tolerations": {
+			content: `
+spec:
+  tolerations:
+  - key: dedicated
+    operator: Equal
+    value: database
+    effect: NoSchedule
+  containers:
+  - resources:
+      requests:
+        cpu: 500m
+`,
+		},
+		"when the template has an unknown field": {
+			content:   "spec:\n  tolerration: []\n",
+			expectErr: true,
+		},
+		"when the template has more than one container": {
+			content:   "spec:\n  containers:\n  - name: a\n  - name: b\n",
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		path := filepath.Join(t.TempDir(), "template.yaml")
+		if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+			t.Fatalf("for test case: `%s`, unable to write template: %s", name, err)
+		}
+		_, err := loadDataMoverPodTemplate(path)
+		if tc.expectErr && err == nil {
+			t.Fatalf("for test case: `%s`, expected err but received none", name)
+		}
+		if !tc.expectErr && err != nil {
+			t.Fatalf("for test case: `%s`, received unexpected err: %s", name, err)
+		}
+	}
+}
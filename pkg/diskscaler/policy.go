@@ -0,0 +1,468 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubecost/disk-autoscaler/pkg/pvsizingrecommendation"
+	"github.com/rs/zerolog/log"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	PolicyDirectionUp   = "Up"
+	PolicyDirectionDown = "Down"
+	PolicyDirectionBoth = "Both"
+
+	policySourceAnnotations = "annotations"
+	maintenanceWindowLayout = "15:04"
+)
+
+var (
+	diskScalingPolicyGVR = schema.GroupVersionResource{
+		Group:    "autodiskscaling.kubecost.com",
+		Version:  "v1alpha1",
+		Resource: "diskscalingpolicies",
+	}
+	clusterDiskScalingPolicyGVR = schema.GroupVersionResource{
+		Group:    "autodiskscaling.kubecost.com",
+		Version:  "v1alpha1",
+		Resource: "clusterdiskscalingpolicies",
+	}
+)
+
+// DiskScalingPolicySpec configures disk scaling of the workloads selected by a DiskScalingPolicy,
+// which selects workloads in its own namespace, or by a ClusterDiskScalingPolicy.
+type DiskScalingPolicySpec struct {
+	// Selector selects workloads by their labels, every workload is selected when it is empty
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Namespaces limits a ClusterDiskScalingPolicy to the given namespaces, all namespaces when empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Priority orders policies of the same scope selecting the same workload, the highest wins
+	Priority int `json:"priority,omitempty"`
+	// Enabled opts the selected workloads in to disk scaling, or out when false. Defaults to true.
+	Enabled           *bool              `json:"enabled,omitempty"`
+	TargetUtilization int                `json:"targetUtilization,omitempty"`
+	Interval          string             `json:"interval,omitempty"`
+	MinSize           *resource.Quantity `json:"minSize,omitempty"`
+	MaxSize           *resource.Quantity `json:"maxSize,omitempty"`
+	// Direction is Up, Down or Both. Defaults to Both.
+	Direction          string              `json:"direction,omitempty"`
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty"`
+}
+
+// MaintenanceWindow is a recurring period in which the selected workloads may be scaled.
+type MaintenanceWindow struct {
+	// Days of the week the window opens on, e.g. Mon, every day when empty
+	Days []string `json:"days,omitempty"`
+	// Start is the time of day in UTC the window opens at, e.g. 02:00
+	Start string `json:"start"`
+	// Duration is how long the window stays open, e.g. 4h
+	Duration string `json:"duration"`
+}
+
+// DiskScalingPolicyStatus reports the workloads a policy applies to and the settings resolved for them.
+type DiskScalingPolicyStatus struct {
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	LastEvaluated      string `json:"lastEvaluated,omitempty"`
+	// Message explains why an invalid policy is ignored
+	Message   string                   `json:"message,omitempty"`
+	Workloads []ResolvedWorkloadPolicy `json:"workloads,omitempty"`
+}
+
+// ResolvedWorkloadPolicy is the disk scaling configuration a workload ends up with.
+type ResolvedWorkloadPolicy struct {
+	Namespace           string `json:"namespace"`
+	Kind                string `json:"kind"`
+	Name                string `json:"name"`
+	Enabled             bool   `json:"enabled"`
+	TargetUtilization   int    `json:"targetUtilization"`
+	Interval            string `json:"interval"`
+	MinSize             string `json:"minSize,omitempty"`
+	MaxSize             string `json:"maxSize,omitempty"`
+	Direction           string `json:"direction"`
+	InMaintenanceWindow bool   `json:"inMaintenanceWindow"`
+}
+
+// diskScalingPolicy is a DiskScalingPolicy, or a ClusterDiskScalingPolicy when namespace is empty.
+type diskScalingPolicy struct {
+	namespace  string
+	name       string
+	generation int64
+	spec       DiskScalingPolicySpec
+	selector   labels.Selector
+	// invalid explains why the policy is ignored
+	invalid string
+}
+
+func (p *diskScalingPolicy) String() string {
+	if p.namespace == "" {
+		return fmt.Sprintf("ClusterDiskScalingPolicy %s", p.name)
+	}
+	return fmt.Sprintf("DiskScalingPolicy %s/%s", p.namespace, p.name)
+}
+
+func (p *diskScalingPolicy) key() string {
+	return fmt.Sprintf("%s/%s", p.namespace, p.name)
+}
+
+// selects returns true when the policy applies to the workload.
+func (p *diskScalingPolicy) selects(meta metav1.ObjectMeta) bool {
+	if p.invalid != "" {
+		return false
+	}
+	if p.namespace != "" && p.namespace != meta.Namespace {
+		return false
+	}
+	if p.namespace == "" && len(p.spec.Namespaces) > 0 && !slices.Contains(p.spec.Namespaces, meta.Namespace) {
+		return false
+	}
+	return p.selector.Matches(labels.Set(meta.Labels))
+}
+
+// policySet holds the policies of the cluster, namespaced and cluster-scoped policies are
+// each ordered by precedence.
+type policySet struct {
+	namespaced []*diskScalingPolicy
+	cluster    []*diskScalingPolicy
+}
+
+// all returns every policy, including invalid ones.
+func (ps *policySet) all() []*diskScalingPolicy {
+	return append(append([]*diskScalingPolicy{}, ps.namespaced...), ps.cluster...)
+}
+
+// match returns the policy which applies to the workload. A DiskScalingPolicy takes precedence
+// over a ClusterDiskScalingPolicy, then the policy with the highest priority and finally the
+// first by name.
+func (ps *policySet) match(meta metav1.ObjectMeta) *diskScalingPolicy {
+	if ps == nil {
+		return nil
+	}
+	for _, policies := range [][]*diskScalingPolicy{ps.namespaced, ps.cluster} {
+		for _, p := range policies {
+			if p.selects(meta) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// scalingPolicy is the disk scaling configuration resolved for a workload.
+type scalingPolicy struct {
+	// source is the policy the configuration comes from, nil when it comes from the annotations
+	source            *diskScalingPolicy
+	excluded          bool
+	enabled           bool
+	targetUtilization int
+	interval          string
+	minSize           *resource.Quantity
+	maxSize           *resource.Quantity
+	direction         string
+	windows           []MaintenanceWindow
+}
+
+// resolve returns the configuration of the workload. The settings of the matching policy take
+// precedence over the legacy annotations, which only fill in the settings the policy leaves unset.
+// A workload annotated as excluded is never scaled, whatever the policy.
+func (ps *policySet) resolve(meta metav1.ObjectMeta) scalingPolicy {
+	annotations := meta.GetAnnotations()
+	targetUtilization, interval := scalingSettings(annotations, meta.Name)
+	resolved := scalingPolicy{
+		excluded:          annotations[AnnotationExcluded] == "true",
+		enabled:           annotations[AnnotationEnabled] == "true",
+		targetUtilization: targetUtilization,
+		interval:          interval,
+		direction:         PolicyDirectionBoth,
+	}
+	p := ps.match(meta)
+	if p == nil {
+		return resolved
+	}
+	resolved.source = p
+	resolved.enabled = p.spec.Enabled == nil || *p.spec.Enabled
+	if p.spec.TargetUtilization > 0 {
+		resolved.targetUtilization = p.spec.TargetUtilization
+	}
+	if p.spec.Interval != "" {
+		resolved.interval = p.spec.Interval
+	}
+	if p.spec.Direction != "" {
+		resolved.direction = p.spec.Direction
+	}
+	resolved.minSize = p.spec.MinSize
+	resolved.maxSize = p.spec.MaxSize
+	resolved.windows = p.spec.MaintenanceWindows
+	return resolved
+}
+
+// sourceName describes where the configuration of the workload comes from for logging.
+func (sp scalingPolicy) sourceName() string {
+	if sp.source == nil {
+		return policySourceAnnotations
+	}
+	return sp.source.String()
+}
+
+// inMaintenanceWindow returns true when the workload may be scaled at t, which is always
+// the case when no maintenance window is configured.
+func (sp scalingPolicy) inMaintenanceWindow(t time.Time) bool {
+	if len(sp.windows) == 0 {
+		return true
+	}
+	for _, window := range sp.windows {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// limit bounds the recommended size of a volume by the minimum and maximum size and the
+// allowed direction of the policy.
+func (sp scalingPolicy) limit(currentSize resource.Quantity, resizeTo resource.Quantity) resource.Quantity {
+	if sp.minSize != nil && resizeTo.Cmp(*sp.minSize) < 0 {
+		resizeTo = sp.minSize.DeepCopy()
+	}
+	if sp.maxSize != nil && resizeTo.Cmp(*sp.maxSize) > 0 {
+		resizeTo = sp.maxSize.DeepCopy()
+	}
+	if sp.direction == PolicyDirectionUp && resizeTo.Cmp(currentSize) < 0 {
+		return currentSize
+	}
+	if sp.direction == PolicyDirectionDown && resizeTo.Cmp(currentSize) > 0 {
+		return currentSize
+	}
+	return resizeTo
+}
+
+// status returns the resolved configuration of the workload as reported in the policy status.
+func (sp scalingPolicy) status(kind string, meta metav1.ObjectMeta, now time.Time) ResolvedWorkloadPolicy {
+	resolved := ResolvedWorkloadPolicy{
+		Namespace:           meta.Namespace,
+		Kind:                kind,
+		Name:                meta.Name,
+		Enabled:             sp.enabled && !sp.excluded,
+		TargetUtilization:   sp.targetUtilization,
+		Interval:            sp.interval,
+		Direction:           sp.direction,
+		InMaintenanceWindow: sp.inMaintenanceWindow(now),
+	}
+	if sp.minSize != nil {
+		resolved.MinSize = sp.minSize.String()
+	}
+	if sp.maxSize != nil {
+		resolved.MaxSize = sp.maxSize.String()
+	}
+	return resolved
+}
+
+// contains returns true when the window is open at t.
+func (mw MaintenanceWindow) contains(t time.Time) bool {
+	t = t.UTC()
+	start, err := time.Parse(maintenanceWindowLayout, mw.Start)
+	if err != nil {
+		return false
+	}
+	duration, err := time.ParseDuration(mw.Duration)
+	if err != nil {
+		return false
+	}
+	// A window opened on a previous day may still be open
+	for days := 0; days <= int(duration/(24*time.Hour)); days++ {
+		day := t.AddDate(0, 0, -days)
+		opens := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, time.UTC)
+		if opens.After(t) {
+			opens = opens.AddDate(0, 0, -1)
+		}
+		if !mw.opensOn(opens.Weekday()) {
+			continue
+		}
+		if t.Before(opens.Add(duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mw MaintenanceWindow) opensOn(weekday time.Weekday) bool {
+	if len(mw.Days) == 0 {
+		return true
+	}
+	for _, day := range mw.Days {
+		if len(day) >= 3 && strings.EqualFold(day[:3], weekday.String()[:3]) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns why the spec is invalid, or an empty string.
+func (spec DiskScalingPolicySpec) validate() string {
+	if spec.TargetUtilization < 0 || spec.TargetUtilization > 100 {
+		return fmt.Sprintf("targetUtilization %d must be between 1 and 100", spec.TargetUtilization)
+	}
+	if spec.Interval != "" {
+		if _, err := pvsizingrecommendation.ParseInterval(spec.Interval); err != nil {
+			return fmt.Sprintf("interval %s is invalid: %v", spec.Interval, err)
+		}
+	}
+	if spec.MinSize != nil && spec.MaxSize != nil && spec.MinSize.Cmp(*spec.MaxSize) > 0 {
+		return fmt.Sprintf("minSize %s is larger than maxSize %s", spec.MinSize.String(), spec.MaxSize.String())
+	}
+	switch spec.Direction {
+	case "", PolicyDirectionUp, PolicyDirectionDown, PolicyDirectionBoth:
+	default:
+		return fmt.Sprintf("direction %s must be one of %s, %s or %s", spec.Direction, PolicyDirectionUp, PolicyDirectionDown, PolicyDirectionBoth)
+	}
+	for _, window := range spec.MaintenanceWindows {
+		if _, err := time.Parse(maintenanceWindowLayout, window.Start); err != nil {
+			return fmt.Sprintf("maintenance window start %s must be formatted as HH:MM", window.Start)
+		}
+		if duration, err := time.ParseDuration(window.Duration); err != nil || duration <= 0 {
+			return fmt.Sprintf("maintenance window duration %s is invalid", window.Duration)
+		}
+		for _, day := range window.Days {
+			if !isWeekday(day) {
+				return fmt.Sprintf("maintenance window day %s is not a day of the week", day)
+			}
+		}
+	}
+	return ""
+}
+
+func isWeekday(day string) bool {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if len(day) >= 3 && strings.EqualFold(day[:3], weekday.String()[:3]) {
+			return true
+		}
+	}
+	return false
+}
+
+// listPolicies lists the policies of the given resource. Clusters without the custom resource
+// definition simply have no policies.
+func listPolicies(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource) ([]*diskScalingPolicy, error) {
+	list, err := client.Resource(gvr).Namespace("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			log.Trace().Msgf("%s are not installed, skipping them", gvr.Resource)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("listing all %s: %w", gvr.Resource, err)
+	}
+	policies := make([]*diskScalingPolicy, 0, len(list.Items))
+	for _, item := range list.Items {
+		p := &diskScalingPolicy{
+			namespace:  item.GetNamespace(),
+			name:       item.GetName(),
+			generation: item.GetGeneration(),
+		}
+		specObj, _, _ := unstructured.NestedMap(item.Object, "spec")
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(specObj, &p.spec)
+		if err != nil {
+			p.invalid = fmt.Sprintf("unable to parse spec: %v", err)
+		} else {
+			p.invalid = p.spec.validate()
+		}
+		if p.invalid == "" {
+			p.selector = labels.Everything()
+			if p.spec.Selector != nil {
+				p.selector, err = metav1.LabelSelectorAsSelector(p.spec.Selector)
+				if err != nil {
+					p.invalid = fmt.Sprintf("selector is invalid: %v", err)
+				}
+			}
+		}
+		if p.invalid != "" {
+			log.Warn().Msgf("%s is ignored: %s", p, p.invalid)
+		}
+		policies = append(policies, p)
+	}
+	sort.SliceStable(policies, func(i, j int) bool {
+		if policies[i].spec.Priority != policies[j].spec.Priority {
+			return policies[i].spec.Priority > policies[j].spec.Priority
+		}
+		return policies[i].key() < policies[j].key()
+	})
+	return policies, nil
+}
+
+// refreshPolicies lists the DiskScalingPolicies and ClusterDiskScalingPolicies of the cluster.
+func (ds *DiskScaler) refreshPolicies(ctx context.Context) error {
+	namespaced, err := listPolicies(ctx, ds.dynamicK8sClient, diskScalingPolicyGVR)
+	if err != nil {
+		return err
+	}
+	cluster, err := listPolicies(ctx, ds.dynamicK8sClient, clusterDiskScalingPolicyGVR)
+	if err != nil {
+		return err
+	}
+	ds.policyMu.Lock()
+	ds.policies = &policySet{namespaced: namespaced, cluster: cluster}
+	ds.policyMu.Unlock()
+	return nil
+}
+
+// policyFor resolves the disk scaling configuration of the workload from the last listed policies.
+func (ds *DiskScaler) policyFor(meta metav1.ObjectMeta) scalingPolicy {
+	ds.policyMu.RLock()
+	policies := ds.policies
+	ds.policyMu.RUnlock()
+	return policies.resolve(meta)
+}
+
+// reportPolicyStatus writes the workloads resolved for every policy to its status.
+func (ds *DiskScaler) reportPolicyStatus(ctx context.Context, resolved map[string][]ResolvedWorkloadPolicy, now time.Time) {
+	ds.policyMu.RLock()
+	policies := ds.policies
+	ds.policyMu.RUnlock()
+	if policies == nil {
+		return
+	}
+	for _, p := range policies.all() {
+		status := DiskScalingPolicyStatus{
+			ObservedGeneration: p.generation,
+			LastEvaluated:      now.Format(timeFormat),
+			Message:            p.invalid,
+			Workloads:          resolved[p.key()],
+		}
+		if err := ds.updatePolicyStatus(ctx, p, status); err != nil {
+			log.Warn().Msgf("unable to update status of %s: %v", p, err)
+		}
+	}
+}
+
+func (ds *DiskScaler) updatePolicyStatus(ctx context.Context, p *diskScalingPolicy, status DiskScalingPolicyStatus) error {
+	var resource dynamic.ResourceInterface = ds.dynamicK8sClient.Resource(clusterDiskScalingPolicyGVR)
+	if p.namespace != "" {
+		resource = ds.dynamicK8sClient.Resource(diskScalingPolicyGVR).Namespace(p.namespace)
+	}
+	obj, err := resource.Get(ctx, p.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	statusObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+	err = unstructured.SetNestedField(obj.Object, statusObj, "status")
+	if err != nil {
+		return err
+	}
+	_, err = resource.UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
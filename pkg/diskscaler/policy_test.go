@@ -0,0 +1,258 @@
+package diskscaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPolicyObject(namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	kind := "DiskScalingPolicy"
+	if namespace == "" {
+		kind = "ClusterDiskScalingPolicy"
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "autodiskscaling.kubecost.com/v1alpha1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+		"spec": spec,
+	}}
+}
+
+func Test_policySetResolve(t *testing.T) {
+	type testCase struct {
+		name                      string
+		policies                  []runtime.Object
+		annotations               map[string]string
+		expectedSource            string
+		expectedEnabled           bool
+		expectedTargetUtilization int
+		expectedInterval          string
+	}
+
+	appSelector := map[string]interface{}{"matchLabels": map[string]interface{}{"app": "db"}}
+	testCases := []testCase{
+		{
+			name:                      "when no policy selects the workload the annotations are used",
+			policies:                  []runtime.Object{newPolicyObject("test", "other", map[string]interface{}{"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "web"}}})},
+			annotations:               map[string]string{AnnotationEnabled: "true", AnnotationTargetUtilization: "60", AnnotationInterval: "3h"},
+			expectedSource:            policySourceAnnotations,
+			expectedEnabled:           true,
+			expectedTargetUtilization: 60,
+			expectedInterval:          "3h",
+		},
+		{
+			name:                      "when the policy overrides the annotations and unset settings fall back to them",
+			policies:                  []runtime.Object{newPolicyObject("test", "db", map[string]interface{}{"selector": appSelector, "targetUtilization": int64(80)})},
+			annotations:               map[string]string{AnnotationTargetUtilization: "60", AnnotationInterval: "3h"},
+			expectedSource:            "DiskScalingPolicy test/db",
+			expectedEnabled:           true,
+			expectedTargetUtilization: 80,
+			expectedInterval:          "3h",
+		},
+		{
+			name: "when a namespaced policy takes precedence over a cluster policy with a higher priority",
+			policies: []runtime.Object{
+				newPolicyObject("", "all", map[string]interface{}{"priority": int64(100), "interval": "1h"}),
+				newPolicyObject("test", "db", map[string]interface{}{"selector": appSelector, "interval": "2h"}),
+			},
+			expectedSource:            "DiskScalingPolicy test/db",
+			expectedEnabled:           true,
+			expectedTargetUtilization: 70,
+			expectedInterval:          "2h",
+		},
+		{
+			name: "when the cluster policy with the highest priority wins",
+			policies: []runtime.Object{
+				newPolicyObject("", "a-low", map[string]interface{}{"interval": "1h"}),
+				newPolicyObject("", "b-high", map[string]interface{}{"priority": int64(10), "interval": "2h", "namespaces": []interface{}{"test"}}),
+				newPolicyObject("", "c-other-namespace", map[string]interface{}{"priority": int64(20), "interval": "3h", "namespaces": []interface{}{"prod"}}),
+			},
+			expectedSource:            "ClusterDiskScalingPolicy b-high",
+			expectedEnabled:           true,
+			expectedTargetUtilization: 70,
+			expectedInterval:          "2h",
+		},
+		{
+			name:                      "when the policy disables an annotated workload",
+			policies:                  []runtime.Object{newPolicyObject("test", "db", map[string]interface{}{"enabled": false})},
+			annotations:               map[string]string{AnnotationEnabled: "true"},
+			expectedSource:            "DiskScalingPolicy test/db",
+			expectedTargetUtilization: 70,
+			expectedInterval:          "7h",
+		},
+		{
+			name:                      "when the excluded annotation wins over the policy",
+			policies:                  []runtime.Object{newPolicyObject("test", "db", map[string]interface{}{})},
+			annotations:               map[string]string{AnnotationExcluded: "true"},
+			expectedSource:            "DiskScalingPolicy test/db",
+			expectedTargetUtilization: 70,
+			expectedInterval:          "7h",
+		},
+		{
+			name:                      "when the policy interval is given in days",
+			policies:                  []runtime.Object{newPolicyObject("test", "db", map[string]interface{}{"interval": "2d"})},
+			expectedSource:            "DiskScalingPolicy test/db",
+			expectedEnabled:           true,
+			expectedTargetUtilization: 70,
+			expectedInterval:          "2d",
+		},
+		{
+			name:                      "when an invalid policy is ignored",
+			policies:                  []runtime.Object{newPolicyObject("test", "db", map[string]interface{}{"direction": "Sideways"})},
+			expectedSource:            policySourceAnnotations,
+			expectedTargetUtilization: 70,
+			expectedInterval:          "7h",
+		},
+	}
+
+	for _, tc := range testCases {
+		ds, err := NewDiskScaler(nil, fake.NewSimpleClientset(), newFakeDynamicClient(tc.policies...), "test", stubRecommender{}, false)
+		if err != nil {
+			t.Fatalf("test '%s': unable to create disk scaler: %s", tc.name, err)
+		}
+		if err := ds.refreshPolicies(context.Background()); err != nil {
+			t.Fatalf("test '%s': unable to list policies: %s", tc.name, err)
+		}
+
+		meta := metav1.ObjectMeta{Name: "db", Namespace: "test", Labels: map[string]string{"app": "db"}, Annotations: tc.annotations}
+		policy := ds.policyFor(meta)
+		if policy.sourceName() != tc.expectedSource {
+			t.Fatalf("test '%s': expected source %s but received %s", tc.name, tc.expectedSource, policy.sourceName())
+		}
+		if enabled := policy.enabled && !policy.excluded; enabled != tc.expectedEnabled {
+			t.Fatalf("test '%s': expected enabled %t but received %t", tc.name, tc.expectedEnabled, enabled)
+		}
+		if policy.targetUtilization != tc.expectedTargetUtilization {
+			t.Fatalf("test '%s': expected target utilization %d but received %d", tc.name, tc.expectedTargetUtilization, policy.targetUtilization)
+		}
+		if policy.interval != tc.expectedInterval {
+			t.Fatalf("test '%s': expected interval %s but received %s", tc.name, tc.expectedInterval, policy.interval)
+		}
+	}
+}
+
+func Test_scalingPolicyLimit(t *testing.T) {
+	minSize := resource.MustParse("5Gi")
+	maxSize := resource.MustParse("50Gi")
+	testCases := map[string]struct {
+		policy   scalingPolicy
+		resizeTo string
+		expected string
+	}{
+		"when the recommendation is below the minimum size": {
+			policy:   scalingPolicy{minSize: &minSize, direction: PolicyDirectionBoth},
+			resizeTo: "2Gi",
+			expected: "5Gi",
+		},
+		"when the recommendation is above the maximum size": {
+			policy:   scalingPolicy{maxSize: &maxSize, direction: PolicyDirectionBoth},
+			resizeTo: "80Gi",
+			expected: "50Gi",
+		},
+		"when the policy only allows scaling up": {
+			policy:   scalingPolicy{direction: PolicyDirectionUp},
+			resizeTo: "4Gi",
+			expected: "10Gi",
+		},
+		"when the policy only allows scaling down": {
+			policy:   scalingPolicy{direction: PolicyDirectionDown},
+			resizeTo: "20Gi",
+			expected: "10Gi",
+		},
+	}
+	for name, tc := range testCases {
+		actual := tc.policy.limit(resource.MustParse("10Gi"), resource.MustParse(tc.resizeTo))
+		if actual.Cmp(resource.MustParse(tc.expected)) != 0 {
+			t.Fatalf("for test case: `%s`, expected %s but received %s", name, tc.expected, actual.String())
+		}
+	}
+}
+
+func Test_MaintenanceWindowContains(t *testing.T) {
+	// 2024-01-01 is a Monday
+	monday := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := map[string]struct {
+		window   MaintenanceWindow
+		at       time.Time
+		expected bool
+	}{
+		"when the time is inside a daily window": {
+			window:   MaintenanceWindow{Start: "02:00", Duration: "4h"},
+			at:       monday.Add(3 * time.Hour),
+			expected: true,
+		},
+		"when the time is after a daily window": {
+			window:   MaintenanceWindow{Start: "02:00", Duration: "4h"},
+			at:       monday.Add(6 * time.Hour),
+			expected: false,
+		},
+		"when a window opened the previous day is still open": {
+			window:   MaintenanceWindow{Days: []string{"Sun"}, Start: "22:00", Duration: "4h"},
+			at:       monday.Add(time.Hour),
+			expected: true,
+		},
+		"when the window does not open on that day": {
+			window:   MaintenanceWindow{Days: []string{"Saturday", "Sunday"}, Start: "02:00", Duration: "4h"},
+			at:       monday.Add(3 * time.Hour),
+			expected: false,
+		},
+		"when a window lasts several days": {
+			window:   MaintenanceWindow{Days: []string{"Sat"}, Start: "00:00", Duration: "72h"},
+			at:       monday.Add(12 * time.Hour),
+			expected: true,
+		},
+	}
+	for name, tc := range testCases {
+		if actual := tc.window.contains(tc.at); actual != tc.expected {
+			t.Fatalf("for test case: `%s`, expected %t but received %t", name, tc.expected, actual)
+		}
+	}
+}
+
+func Test_getDiskScalerWorkloadsReportsPolicyStatus(t *testing.T) {
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "test", Labels: map[string]string{"app": "db"}},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: v1.PodTemplateSpec{}},
+		Status:     appsv1.DeploymentStatus{AvailableReplicas: 1},
+	}
+	policyObject := newPolicyObject("test", "db", map[string]interface{}{"targetUtilization": int64(80), "maxSize": "20Gi"})
+	dynamicClient := newFakeDynamicClient(policyObject)
+	dss, err := NewDiskScalerService(nil, fake.NewSimpleClientset(deployment), dynamicClient, false, false, stubRecommender{}, []string{KubecostNamespace})
+	if err != nil {
+		t.Fatalf("unable to create disk scaler service: %s", err)
+	}
+
+	_, workloads, err := dss.getDiskScalerWorkloads(context.Background(), time.Now().Format(timeFormat), runOptions{})
+	if err != nil {
+		t.Fatalf("received unexpected err: %s", err)
+	}
+	if len(workloads) != 1 || workloads[0].Name != "db" {
+		t.Fatalf("expected the deployment selected by the policy to be eligible but received %+v", workloads)
+	}
+
+	updated, err := dynamicClient.Resource(diskScalingPolicyGVR).Namespace("test").Get(context.Background(), "db", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get policy: %s", err)
+	}
+	resolved, _, _ := unstructured.NestedSlice(updated.Object, "status", "workloads")
+	if len(resolved) != 1 {
+		t.Fatalf("expected 1 workload in the policy status but received %d", len(resolved))
+	}
+	workload := resolved[0].(map[string]interface{})
+	if workload["name"] != "db" || workload["targetUtilization"] != int64(80) || workload["maxSize"] != "20Gi" {
+		t.Fatalf("expected the resolved policy of the deployment in the status but received %+v", workload)
+	}
+}
@@ -0,0 +1,155 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// precopyFromSnapshots pre-copies the data of every claim to be resized by copy into its new claim
+// while the workload is still running, so that the workload is only stopped for the final sync.
+// A claim which cannot be pre-copied is copied after the workload is stopped as usual.
+func (ds *DiskScaler) precopyFromSnapshots(ctx context.Context, namespace string, volMap map[string]*pvcDetails, op *operation) {
+	for pvcName, pvcDetails := range volMap {
+		// Claims rebound behind their name are copied after the workload is stopped
+		if !pvcDetails.needsCopy() || pvcDetails.keepName {
+			continue
+		}
+		newPVC, err := ds.precopyFromSnapshot(ctx, namespace, pvcName, pvcDetails, op)
+		if err != nil {
+			log.Warn().Msgf("ctx: %s, unable to pre-copy pvc %s from a snapshot, its data is copied once the workload is stopped: %v", ctx.Value(diskScalerRunContextKey), pvcName, err)
+			continue
+		}
+		pvcDetails.precopiedTo = newPVC
+	}
+}
+
+// precopyFromSnapshot snapshots the claim, restores the snapshot to a clone of the claim and copies
+// the data of the clone to the new claim, so that the claim itself is not read while the workload
+// is running. The clone is deleted once copied. On failure every claim created is deleted and the
+// claim is removed from op.
+func (ds *DiskScaler) precopyFromSnapshot(ctx context.Context, namespace string, pvcName string, pvcDetails *pvcDetails, op *operation) (*v1.PersistentVolumeClaim, error) {
+	snapshotName, err := ds.snapshotter.snapshot(ctx, namespace, pvcName, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	copierJobName := fmt.Sprintf("%s-%s", kubecostDataMoverJobName, randStringRunes(5))
+	cloneName := fmt.Sprintf("%s-clone-%s", pvcName, randStringRunes(5))
+	claimOp := &claimOperation{Phase: claimCreated, NewClaim: pvcDetails.resizedPVCName, Clone: cloneName, CopierJob: copierJobName, Snapshot: snapshotName}
+	op.Claims[pvcName] = claimOp
+	ds.checkpoint(ctx, op)
+
+	newPVC, err := ds.createPVCFromASpec(ctx, namespace, pvcName, *pvcDetails.spec.DeepCopy(), pvcDetails.resizeTo, pvcDetails.resizedPVCName, snapshotName, pvcDetails.selectedNode)
+	if err != nil {
+		ds.discardPrecopy(ctx, namespace, pvcName, op)
+		return nil, err
+	}
+	_, err = ds.createPVCFromSnapshot(ctx, namespace, pvcName, pvcDetails.spec, pvcDetails.currentSize, cloneName, snapshotName, newPVC, pvcDetails.selectedNode)
+	if err != nil {
+		ds.discardPrecopy(ctx, namespace, pvcName, op)
+		return nil, err
+	}
+	log.Info().Msgf("ctx: %s, pre-copying pvc %s from its snapshot %s restored to pvc %s while %s %s is running", ctx.Value(diskScalerRunContextKey), pvcName, snapshotName, cloneName, strings.ToLower(op.Kind), op.Workload)
+
+	copyErr := ds.runCopierJob(ctx, namespace, copierJobName, ds.dataMover.precopy(), pvcDetails.workloadSpec, cloneName, newPVC)
+	err = ds.retryDeleteCopierJob(ctx, namespace, copierJobName)
+	if err != nil {
+		claimOp.Phase = claimFailed
+		ds.checkpoint(ctx, op)
+		return nil, fmt.Errorf("failed to delete copier job after %d attempts, manual deletion needed err: %w", maxRetries, err)
+	}
+	claimOp.CopierJob = ""
+	if copyErr != nil {
+		ds.discardPrecopy(ctx, namespace, pvcName, op)
+		return nil, copyErr
+	}
+	err = ds.deleteClone(ctx, namespace, cloneName)
+	if err != nil {
+		log.Error().Msgf("ctx: %s, %v", ctx.Value(diskScalerRunContextKey), err)
+	} else {
+		claimOp.Clone = ""
+	}
+	claimOp.Phase = claimPrecopied
+	ds.checkpoint(ctx, op)
+	log.Info().Msgf("ctx: %s, pre-copied pvc %s to pvc %s, the changes made since snapshot %s are synced once %s %s is stopped", ctx.Value(diskScalerRunContextKey), pvcName, newPVC.GetName(), snapshotName, strings.ToLower(op.Kind), op.Workload)
+	return newPVC, nil
+}
+
+// discardPrecopy deletes the new claim and the clone of a claim whose pre-copy failed or is given
+// up, and removes the claim from op so that it can be copied as usual.
+func (ds *DiskScaler) discardPrecopy(ctx context.Context, namespace string, pvcName string, op *operation) {
+	claimOp, ok := op.Claims[pvcName]
+	if !ok {
+		return
+	}
+	if claimOp.Clone != "" {
+		if err := ds.deleteClone(ctx, namespace, claimOp.Clone); err != nil {
+			log.Error().Msgf("ctx: %s, %v", ctx.Value(diskScalerRunContextKey), err)
+		}
+	}
+	if err := ds.deletePVC(ctx, namespace, claimOp.NewClaim); err != nil {
+		log.Error().Msgf("ctx: %s, unable to delete PVC created in disk scaling operation: %s", ctx.Value(diskScalerRunContextKey), claimOp.NewClaim)
+	}
+	delete(op.Claims, pvcName)
+	ds.checkpoint(ctx, op)
+}
+
+// createPVCFromSnapshot restores the snapshot of a claim to a clone of the claim of the given size.
+// The clone is owned by the new claim, so that it is garbage collected along with a new claim which is given up,
+// and is provisioned for selectedNode, if any, so that the copier can mount it along with the new claim.
+func (ds *DiskScaler) createPVCFromSnapshot(ctx context.Context, namespace string, pvc string, spec v1.PersistentVolumeClaimSpec, size resource.Quantity, cloneName string, snapshotName string, owner *v1.PersistentVolumeClaim, selectedNode string) (*v1.PersistentVolumeClaim, error) {
+	spec = *spec.DeepCopy()
+	spec.Resources.Requests = v1.ResourceList{v1.ResourceStorage: size}
+	// volumename should be set to empty otherwise there will be resource creation failure
+	spec.VolumeName = ""
+	snapshotAPIGroup := volumeSnapshotGVR.Group
+	spec.DataSource = &v1.TypedLocalObjectReference{
+		APIGroup: &snapshotAPIGroup,
+		Kind:     "VolumeSnapshot",
+		Name:     snapshotName,
+	}
+	spec.DataSourceRef = nil
+
+	pvcObj := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cloneName,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				PVCAnnotationCreatedBy:      DiskAutoScaler,
+				SnapshotAnnotationSourcePVC: pvc,
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "PersistentVolumeClaim",
+				Name:       owner.GetName(),
+				UID:        owner.GetUID(),
+			}},
+		},
+		Spec: spec,
+	}
+	if selectedNode != "" {
+		pvcObj.Annotations[selectedNodeAnnotation] = selectedNode
+	}
+	clone, err := ds.basicK8sClient.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvcObj, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to restore snapshot %s of pvc %s: %w", snapshotName, pvc, err)
+	}
+	return clone, nil
+}
+
+// deleteClone deletes the clone of a claim restored from its snapshot. Nothing mounts the clone
+// once its copier job is deleted, so its deletion is not waited for.
+func (ds *DiskScaler) deleteClone(ctx context.Context, namespace string, cloneName string) error {
+	err := ds.basicK8sClient.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, cloneName, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete pvc %s restored from a snapshot: %w", cloneName, err)
+	}
+	return nil
+}
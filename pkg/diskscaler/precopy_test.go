@@ -0,0 +1,112 @@
+package diskscaler
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_precopyFromSnapshots(t *testing.T) {
+	type testCase struct {
+		name           string
+		snapshotStatus map[string]interface{}
+		expectPrecopy  bool
+	}
+
+	testCases := []testCase{
+		{
+			name:           "when the snapshot is restored to a clone which is copied",
+			snapshotStatus: map[string]interface{}{"readyToUse": true},
+			expectPrecopy:  true,
+		},
+		{
+			name:           "when the snapshot fails the claim is left to be copied once the workload is stopped",
+			snapshotStatus: map[string]interface{}{"readyToUse": false, "error": map[string]interface{}{"message": "snapshots are not supported"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		var clone *v1.PersistentVolumeClaim
+		var script string
+		client.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			pvc := action.(k8stesting.CreateAction).GetObject().(*v1.PersistentVolumeClaim)
+			if pvc.Spec.DataSource != nil {
+				clone = pvc.DeepCopy()
+			}
+			return false, nil, nil
+		})
+		client.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: v1.ConditionTrue}}
+			for _, container := range job.Spec.Template.Spec.Containers {
+				script += strings.Join(container.Command, " ")
+			}
+			return false, nil, nil
+		})
+		dyn := newFakeDynamicClient()
+		dyn.PrependReactor("create", "volumesnapshots", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			obj := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+			obj.Object["status"] = tc.snapshotStatus
+			return false, nil, nil
+		})
+		ds, err := NewDiskScaler(nil, client, dyn, "test", stubRecommender{}, false)
+		if err != nil {
+			t.Fatalf("test '%s': unable to create disk scaler: %s", tc.name, err)
+		}
+		ds.snapshotter = newSnapshotter(dyn, snapshotterOptions{ReadyTimeout: 10 * time.Millisecond})
+		ds.dataMover.verify = verifyNone
+
+		storageClass := "standard"
+		details := &pvcDetails{
+			currentSize:    resource.MustParse("10Gi"),
+			resizeTo:       resource.MustParse("5Gi"),
+			resizedPVCName: "data-abcde",
+			spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &storageClass,
+				VolumeName:       "pv-data",
+				Resources:        v1.VolumeResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")}},
+			},
+		}
+		op := newOperation("test", workloadKindPod, "app")
+		ds.precopyFromSnapshots(context.Background(), "test", map[string]*pvcDetails{"data": details}, op)
+
+		if !tc.expectPrecopy {
+			if details.precopiedTo != nil || len(op.Claims) != 0 {
+				t.Fatalf("test '%s': expected pvc data not to be pre-copied", tc.name)
+			}
+			continue
+		}
+		if details.precopiedTo == nil || details.precopiedTo.GetName() != "data-abcde" {
+			t.Fatalf("test '%s': expected pvc data to be pre-copied to pvc data-abcde", tc.name)
+		}
+		if claimOp := op.Claims["data"]; claimOp == nil || claimOp.Phase != claimPrecopied || claimOp.Clone != "" || claimOp.CopierJob != "" {
+			t.Fatalf("test '%s': expected the pre-copy to be journaled without a clone or copier job left, received %+v", tc.name, claimOp)
+		}
+		if clone == nil || clone.Spec.DataSource.Kind != "VolumeSnapshot" || !strings.HasPrefix(clone.Spec.DataSource.Name, "data-") {
+			t.Fatalf("test '%s': expected a clone restored from the snapshot of pvc data", tc.name)
+		}
+		if size := clone.Spec.Resources.Requests[v1.ResourceStorage]; !isEqualQuantity(size, details.currentSize) {
+			t.Fatalf("test '%s': expected the clone to have the size of pvc data but received %s", tc.name, size.String())
+		}
+		if _, err := client.CoreV1().PersistentVolumeClaims("test").Get(context.Background(), clone.GetName(), metav1.GetOptions{}); err == nil {
+			t.Fatalf("test '%s': expected the clone to be deleted once copied", tc.name)
+		}
+		if !strings.Contains(script, rsyncCheckCommand) {
+			t.Fatalf("test '%s': expected the pre-copy to fail when the data mover image has no rsync", tc.name)
+		}
+		if !strings.Contains(ds.dataMover.finalSync().copyCommand(), "rsync -aHAXS --numeric-ids --delete") {
+			t.Fatalf("test '%s': expected the final sync to delete the files removed since the snapshot", tc.name)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package diskscaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const pvcBoundPollInterval = 2 * time.Second
+
+// Strategies of copying the data of a claim to a new volume of a different size
+const (
+	// shrinkStrategySwap points the workload at a new claim with a random suffix
+	shrinkStrategySwap = "swap"
+	// shrinkStrategyRebind binds the new volume behind the original claim name
+	shrinkStrategyRebind = "rebind"
+)
+
+// shrinkStrategyFor returns the shrink strategy of the workload, set by its annotation or else by DAS_SHRINK_STRATEGY.
+func (ds *DiskScaler) shrinkStrategyFor(meta metav1.ObjectMeta) string {
+	strategy, ok := meta.GetAnnotations()[AnnotationShrinkStrategy]
+	if !ok {
+		return ds.shrinkStrategy
+	}
+	strategy = strings.ToLower(strategy)
+	if strategy != shrinkStrategySwap && strategy != shrinkStrategyRebind {
+		log.Warn().Msgf("shrinkStrategy %s is invalid for workload name %s, defaulting to %s", strategy, meta.Name, ds.shrinkStrategy)
+		return ds.shrinkStrategy
+	}
+	return strategy
+}
+
+// replacePVCKeepingName moves the PersistentVolume bound to transientPVC behind the claim
+// originalPVC so that workloads referencing originalPVC by name never see a different claim.
+// The volume is retained while both claims are deleted and originalPVC is then recreated
+// pre-bound to it through the PV claimRef and the PVC volumeName. The volume of originalPVC
+// is retained as well and kept for the rollback window once the rebind succeeded, for manual recovery only.
+func (ds *DiskScaler) replacePVCKeepingName(ctx context.Context, namespace string, originalPVC string, transientPVC string) error {
+	transient, err := ds.getPVCInfo(ctx, namespace, transientPVC)
+	if err != nil {
+		return fmt.Errorf("unable to get transient pvc %s: %w", transientPVC, err)
+	}
+	pvName := transient.Spec.VolumeName
+	if pvName == "" {
+		return fmt.Errorf("transient pvc %s is not bound to a persistent volume", transientPVC)
+	}
+
+	original, err := ds.getPVCInfo(ctx, namespace, originalPVC)
+	if err != nil {
+		return fmt.Errorf("unable to get original pvc %s: %w", originalPVC, err)
+	}
+
+	pv, err := ds.getPVInfo(ctx, pvName)
+	if err != nil {
+		return err
+	}
+	reclaimPolicy := pv.Spec.PersistentVolumeReclaimPolicy
+
+	// Retain the volume holding the copied data so that deleting the transient claim does not delete it
+	err = ds.patchPVReclaimPolicy(ctx, pvName, v1.PersistentVolumeReclaimRetain)
+	if err != nil {
+		return err
+	}
+
+	// Retain the original volume as well so that its data survives deleting the original claim
+	var originalReclaimPolicy v1.PersistentVolumeReclaimPolicy
+	originalPVName := original.Spec.VolumeName
+	if originalPVName != "" {
+		originalPV, err := ds.getPVInfo(ctx, originalPVName)
+		if err != nil {
+			return err
+		}
+		originalReclaimPolicy = originalPV.Spec.PersistentVolumeReclaimPolicy
+		err = ds.patchPVReclaimPolicy(ctx, originalPVName, v1.PersistentVolumeReclaimRetain)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ds.deletePVC(ctx, namespace, transientPVC)
+	if err != nil {
+		return fmt.Errorf("unable to delete transient pvc %s, pv %s is retained: %w", transientPVC, pvName, err)
+	}
+
+	err = ds.deletePVC(ctx, namespace, originalPVC)
+	if err != nil {
+		return fmt.Errorf("unable to delete original pvc %s, pv %s is retained: %w", originalPVC, pvName, err)
+	}
+
+	// Reserve the released volume for the claim about to be recreated with the original name
+	claimRefPatch := fmt.Sprintf(`{"spec":{"claimRef":{"namespace":"%s","name":"%s","uid":null,"resourceVersion":null}}}`, namespace, originalPVC)
+	_, err = ds.basicK8sClient.CoreV1().PersistentVolumes().Patch(ctx, pvName, types.MergePatchType, []byte(claimRefPatch), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to reserve pv %s for pvc %s, pv is retained: %w", pvName, originalPVC, err)
+	}
+
+	spec := original.Spec.DeepCopy()
+	spec.VolumeName = pvName
+	spec.DataSource = nil
+	spec.DataSourceRef = nil
+	spec.Resources.Requests = v1.ResourceList{
+		v1.ResourceStorage: transient.Spec.Resources.Requests[v1.ResourceStorage],
+	}
+	annotations := userAnnotations(original.GetAnnotations())
+	if snapshotName, ok := transient.GetAnnotations()[PVCAnnotationLastSnapshot]; ok {
+		annotations[PVCAnnotationLastSnapshot] = snapshotName
+	}
+	recreated := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        originalPVC,
+			Namespace:   namespace,
+			Labels:      original.GetLabels(),
+			Annotations: annotations,
+		},
+		Spec: *spec,
+	}
+	_, err = ds.basicK8sClient.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, recreated, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to recreate pvc %s bound to pv %s, pv is retained: %w", originalPVC, pvName, err)
+	}
+
+	err = ds.waitForPVCBound(ctx, namespace, originalPVC)
+	if err != nil {
+		return fmt.Errorf("recreated pvc %s did not bind to pv %s: %w", originalPVC, pvName, err)
+	}
+
+	err = ds.patchPVReclaimPolicy(ctx, pvName, reclaimPolicy)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("ctx: %s, pvc %s in namespace %s is now bound to pv %s", ctx.Value(diskScalerRunContextKey), originalPVC, namespace, pvName)
+	if originalPVName != "" {
+		return ds.retainReplacedPV(ctx, originalPVName, pvName, originalReclaimPolicy)
+	}
+	return nil
+}
+
+// copyResizeKeepingName copies the data of a claim to a transient claim of the new size and
+// then rebinds the new volume behind the original claim name, so that the workload spec never
+// changes. A StatefulSet requires it and other workloads use it with the rebind shrink strategy.
+// Every step is journaled in op.
+func (ds *DiskScaler) copyResizeKeepingName(ctx context.Context, namespace string, name string, pvcDetails *pvcDetails, op *operation) error {
+	snapshotName, err := ds.snapshotter.snapshot(ctx, namespace, name, time.Now())
+	if err != nil {
+		return err
+	}
+	copierJobName := fmt.Sprintf("%s-%s", kubecostDataMoverJobName, randStringRunes(5))
+	claimOp := &claimOperation{Phase: claimCreated, NewClaim: pvcDetails.resizedPVCName, CopierJob: copierJobName, Snapshot: snapshotName, KeepsName: true}
+	op.Claims[name] = claimOp
+	ds.checkpoint(ctx, op)
+
+	newPVC, err := ds.createPVCFromASpec(ctx, namespace, name, pvcDetails.spec, pvcDetails.resizeTo, pvcDetails.resizedPVCName, snapshotName, pvcDetails.selectedNode)
+	if err != nil {
+		claimOp.Phase = claimFailed
+		ds.checkpoint(ctx, op)
+		return err
+	}
+	log.Debug().Msgf("ctx: %s, created pvc of name %s of size: %s", ctx.Value(diskScalerRunContextKey), newPVC.GetName(), pvcDetails.resizeTo.String())
+
+	copyErr := ds.runCopierJob(ctx, namespace, copierJobName, ds.dataMover, pvcDetails.workloadSpec, name, newPVC)
+
+	err = ds.retryDeleteCopierJob(ctx, namespace, copierJobName)
+	if err != nil {
+		claimOp.Phase = claimFailed
+		ds.checkpoint(ctx, op)
+		return fmt.Errorf("ctx: %s, failed to delete copier job after %d attempts, manual deletion needed err: %w", ctx.Value(diskScalerRunContextKey), maxRetries, err)
+	}
+	claimOp.CopierJob = ""
+
+	if copyErr != nil {
+		if err := ds.deletePVC(ctx, namespace, pvcDetails.resizedPVCName); err != nil {
+			log.Error().Msgf("ctx: %s, unable to delete PVC created in disk scaling operation: %s", ctx.Value(diskScalerRunContextKey), pvcDetails.resizedPVCName)
+		}
+		claimOp.Phase = claimFailed
+		ds.checkpoint(ctx, op)
+		return copyErr
+	}
+	log.Debug().Msgf("ctx: %s, successfully moved data between PVC: %s to PVC: %s", ctx.Value(diskScalerRunContextKey), name, newPVC.GetName())
+	return ds.rebindCopiedClaim(ctx, namespace, name, pvcDetails.resizedPVCName, op)
+}
+
+// rebindCopiedClaim moves the volume of the transient claim holding the copied data behind the claim
+// name. When the rebind fails, the claim is settled on whichever volume it is bound to and is left
+// rebinding in the journal op otherwise, along with a RebindIncompleteError naming both volumes.
+func (ds *DiskScaler) rebindCopiedClaim(ctx context.Context, namespace string, name string, transientPVC string, op *operation) error {
+	claimOp := op.Claims[name]
+	// The volume holding the copied data is recorded before it is retained and rebound
+	transient, err := ds.getPVCInfo(ctx, namespace, transientPVC)
+	if err != nil {
+		claimOp.Phase = claimFailed
+		ds.checkpoint(ctx, op)
+		return err
+	}
+	if transient.Spec.VolumeName != "" {
+		pv, err := ds.getPVInfo(ctx, transient.Spec.VolumeName)
+		if err != nil {
+			claimOp.Phase = claimFailed
+			ds.checkpoint(ctx, op)
+			return err
+		}
+		claimOp.PV = pv.Name
+		claimOp.ReclaimPolicy = pv.Spec.PersistentVolumeReclaimPolicy
+	}
+	// The original volume is recorded as well, it is retained while its claim is deleted
+	original, err := ds.getPVCInfo(ctx, namespace, name)
+	if err != nil {
+		claimOp.Phase = claimFailed
+		ds.checkpoint(ctx, op)
+		return err
+	}
+	if original.Spec.VolumeName != "" {
+		pv, err := ds.getPVInfo(ctx, original.Spec.VolumeName)
+		if err != nil {
+			claimOp.Phase = claimFailed
+			ds.checkpoint(ctx, op)
+			return err
+		}
+		claimOp.OriginalPV = pv.Name
+		claimOp.OriginalReclaimPolicy = pv.Spec.PersistentVolumeReclaimPolicy
+	}
+	claimOp.Phase = claimRebinding
+	ds.checkpoint(ctx, op)
+
+	err = ds.replacePVCKeepingName(ctx, namespace, name, transientPVC)
+	if err != nil {
+		// The claim is settled on whichever volume it is bound to, it stays rebinding in the journal otherwise
+		phase, settleErr := ds.recoverRebind(ctx, namespace, name, claimOp)
+		claimOp.Phase = phase
+		ds.checkpoint(ctx, op)
+		var incomplete *RebindIncompleteError
+		switch {
+		case errors.As(settleErr, &incomplete):
+			incomplete.err = err
+			return incomplete
+		case settleErr != nil:
+			return fmt.Errorf("%w, unable to settle pvc %s between pv %s and pv %s: %v", err, name, claimOp.PV, claimOp.OriginalPV, settleErr)
+		case phase == claimSwapped:
+			log.Warn().Msgf("ctx: %s, pvc %s is bound to pv %s despite rebind err: %v", ctx.Value(diskScalerRunContextKey), name, claimOp.PV, err)
+			return nil
+		}
+		return err
+	}
+	claimOp.Phase = claimSwapped
+	ds.checkpoint(ctx, op)
+	return nil
+}
+
+// incompleteRebind returns the error of a claim left missing or unbound by a failed rebind, if any.
+// The workload must not be restored without the claim, a StatefulSet would recreate it empty.
+func incompleteRebind(volMap map[string]*pvcDetails) error {
+	for _, pvcDetails := range volMap {
+		var incomplete *RebindIncompleteError
+		if errors.As(pvcDetails.err, &incomplete) {
+			return incomplete
+		}
+	}
+	return nil
+}
+
+// patchPVReclaimPolicy sets the reclaim policy of the given PersistentVolume.
+func (ds *DiskScaler) patchPVReclaimPolicy(ctx context.Context, pvName string, policy v1.PersistentVolumeReclaimPolicy) error {
+	data := fmt.Sprintf(`{"spec":{"persistentVolumeReclaimPolicy":"%s"}}`, policy)
+	_, err := ds.basicK8sClient.CoreV1().PersistentVolumes().Patch(ctx, pvName, types.MergePatchType, []byte(data), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to set reclaim policy %s on pv %s: %w", policy, pvName, err)
+	}
+	return nil
+}
+
+// waitForPVCBound waits for the claim to reach the Bound phase.
+func (ds *DiskScaler) waitForPVCBound(ctx context.Context, namespace string, pvcName string) error {
+	return wait.PollUntilContextTimeout(ctx, pvcBoundPollInterval, diskScalingOperationTimeout, true, func(ctx context.Context) (bool, error) {
+		pvc, err := ds.basicK8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return pvc.Status.Phase == v1.ClaimBound, nil
+	})
+}
+
+// userAnnotations drops the annotations owned by Kubernetes controllers, which must not be
+// copied to a recreated claim.
+func userAnnotations(annotations map[string]string) map[string]string {
+	filtered := map[string]string{}
+	for key, val := range annotations {
+		if strings.Contains(key, "kubernetes.io/") {
+			continue
+		}
+		filtered[key] = val
+	}
+	return filtered
+}
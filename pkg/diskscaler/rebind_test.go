@@ -0,0 +1,219 @@
+package diskscaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_shrinkStrategyFor(t *testing.T) {
+	testCases := map[string]struct {
+		defaultStrategy string
+		annotations     map[string]string
+		expected        string
+	}{
+		"when the workload has no shrink strategy annotation": {
+			defaultStrategy: shrinkStrategySwap,
+			expected:        shrinkStrategySwap,
+		},
+		"when the annotation overrides the default strategy": {
+			defaultStrategy: shrinkStrategySwap,
+			annotations:     map[string]string{AnnotationShrinkStrategy: "Rebind"},
+			expected:        shrinkStrategyRebind,
+		},
+		"when the annotation is invalid": {
+			defaultStrategy: shrinkStrategyRebind,
+			annotations:     map[string]string{AnnotationShrinkStrategy: "move"},
+			expected:        shrinkStrategyRebind,
+		},
+	}
+	for name, tc := range testCases {
+		ds := &DiskScaler{shrinkStrategy: tc.defaultStrategy}
+		actual := ds.shrinkStrategyFor(metav1.ObjectMeta{Name: "app", Annotations: tc.annotations})
+		if actual != tc.expected {
+			t.Fatalf("for test case: `%s`, expected %s but received %s", name, tc.expected, actual)
+		}
+	}
+}
+
+func Test_recoverOperationKeepingName(t *testing.T) {
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "test"},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-new"},
+	}
+	client := fake.NewSimpleClientset(claim, newTestPV("pv-new", v1.PersistentVolumeReclaimDelete))
+	ds, err := NewDiskScaler(nil, client, newFakeDynamicClient(), "test", stubRecommender{}, false)
+	if err != nil {
+		t.Fatalf("unable to create disk scaler: %s", err)
+	}
+
+	op := newOperation("test", workloadKindPod, "app")
+	op.Phase = operationQuiesced
+	op.OriginalScale = 1
+	op.Pod = newTestPod("data")
+	op.Claims["data"] = &claimOperation{Phase: claimSwapped, NewClaim: "data-abcde", KeepsName: true}
+	if err := ds.journal.record(context.Background(), op); err != nil {
+		t.Fatalf("unable to journal operation: %s", err)
+	}
+
+	err = ds.recoverOperations(context.Background())
+	if err != nil {
+		t.Fatalf("received unexpected err: %s", err)
+	}
+
+	recovered, err := client.CoreV1().PersistentVolumeClaims("test").Get(context.Background(), "data", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected pvc data to be kept: %s", err)
+	}
+	if _, ok := recovered.GetLabels()[PVCLabelRetained]; ok {
+		t.Fatalf("expected pvc data rebound behind its name not to be retained as a replaced claim")
+	}
+	pod, err := client.CoreV1().Pods("test").Get(context.Background(), "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected pod to be recreated: %s", err)
+	}
+	if name := pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName; name != "data" {
+		t.Fatalf("expected recreated pod to use claim data but received %s", name)
+	}
+}
+
+func Test_replacePVCKeepingNameRetainsOriginalPV(t *testing.T) {
+	original := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "test"},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-old"},
+	}
+	transient := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data-abcde", Namespace: "test"},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-new"},
+	}
+	oldPV := newTestPV("pv-old", v1.PersistentVolumeReclaimDelete)
+	oldPV.Spec.ClaimRef = &v1.ObjectReference{Namespace: "test", Name: "data", UID: "original-uid"}
+	client := fake.NewSimpleClientset(original, transient, oldPV, newTestPV("pv-new", v1.PersistentVolumeReclaimDelete))
+	client.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pvc := action.(k8stesting.CreateAction).GetObject().(*v1.PersistentVolumeClaim)
+		pvc.Status.Phase = v1.ClaimBound
+		return false, nil, nil
+	})
+	// the fake clientset does not replay the deletion of a claim to a watch started after it
+	client.PrependWatchReactor("persistentvolumeclaims", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFakeWithChanSize(1, false)
+		w.Delete(&v1.PersistentVolumeClaim{})
+		return true, w, nil
+	})
+	ds, err := NewDiskScaler(nil, client, newFakeDynamicClient(), "test", stubRecommender{}, false)
+	if err != nil {
+		t.Fatalf("unable to create disk scaler: %s", err)
+	}
+	ds.rollbackWindow = time.Hour
+
+	err = ds.replacePVCKeepingName(context.Background(), "test", "data", "data-abcde")
+	if err != nil {
+		t.Fatalf("received unexpected err: %s", err)
+	}
+
+	rebound, err := client.CoreV1().PersistentVolumeClaims("test").Get(context.Background(), "data", metav1.GetOptions{})
+	if err != nil || rebound.Spec.VolumeName != "pv-new" {
+		t.Fatalf("expected pvc data to be recreated bound to pv-new")
+	}
+	retained, err := client.CoreV1().PersistentVolumes().Get(context.Background(), "pv-old", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the original pv to survive the rebind: %s", err)
+	}
+	if retained.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+		t.Fatalf("expected the original pv to be retained but received reclaim policy %s", retained.Spec.PersistentVolumeReclaimPolicy)
+	}
+	if retained.GetLabels()[PVCLabelRetained] != "true" || retained.GetAnnotations()[PVCAnnotationReplacedBy] != "pv-new" {
+		t.Fatalf("expected the original pv to be tracked as replaced by pv-new but received %+v", retained.ObjectMeta)
+	}
+	if retained.Spec.ClaimRef == nil || retained.Spec.ClaimRef.UID != "" {
+		t.Fatalf("expected the claimRef of the original pv to be cleared of the deleted claim but received %+v", retained.Spec.ClaimRef)
+	}
+	pv, _ := client.CoreV1().PersistentVolumes().Get(context.Background(), "pv-new", metav1.GetOptions{})
+	if pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete {
+		t.Fatalf("expected the reclaim policy of pv-new to be restored but received %s", pv.Spec.PersistentVolumeReclaimPolicy)
+	}
+
+	err = ds.pruneRetainedPVCs(context.Background(), time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("received unexpected err: %s", err)
+	}
+	if _, err := client.CoreV1().PersistentVolumes().Get(context.Background(), "pv-old", metav1.GetOptions{}); err == nil {
+		t.Fatalf("expected the original pv to be deleted once its rollback window expired")
+	}
+}
+
+func Test_rebindCopiedClaimFailureKeepsOperation(t *testing.T) {
+	original := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "test"},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-old"},
+		Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+	}
+	transient := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data-abcde", Namespace: "test"},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-new"},
+		Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+	}
+	client := fake.NewSimpleClientset(original, transient, newTestPV("pv-old", v1.PersistentVolumeReclaimDelete), newTestPV("pv-new", v1.PersistentVolumeReclaimDelete))
+	client.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("admission webhook denied the request")
+	})
+	// the fake clientset does not replay the deletion of a claim to a watch started after it
+	client.PrependWatchReactor("persistentvolumeclaims", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFakeWithChanSize(1, false)
+		w.Delete(&v1.PersistentVolumeClaim{})
+		return true, w, nil
+	})
+	ds, err := NewDiskScaler(nil, client, newFakeDynamicClient(), "test", stubRecommender{}, false)
+	if err != nil {
+		t.Fatalf("unable to create disk scaler: %s", err)
+	}
+
+	op := newOperation("test", workloadKindPod, "app")
+	op.Phase = operationQuiesced
+	op.OriginalScale = 1
+	op.Pod = newTestPod("data")
+	op.Claims["data"] = &claimOperation{Phase: claimCreated, NewClaim: "data-abcde", KeepsName: true}
+
+	err = ds.rebindCopiedClaim(context.Background(), "test", "data", "data-abcde", op)
+	var incomplete *RebindIncompleteError
+	if !errors.As(err, &incomplete) {
+		t.Fatalf("expected a rebind incomplete err but received %v", err)
+	}
+	if !strings.Contains(err.Error(), "pv-new") || !strings.Contains(err.Error(), "pv-old") {
+		t.Fatalf("expected the err to name both volumes but received %s", err)
+	}
+	if incompleteRebind(map[string]*pvcDetails{"data": {err: err}}) == nil {
+		t.Fatalf("expected the workload not to be restored while pvc data is missing")
+	}
+	if !op.rebinding() {
+		t.Fatalf("expected pvc data to be left rebinding but received %s", op.Claims["data"].Phase)
+	}
+	ops, err := ds.journal.operations(context.Background())
+	if err != nil || len(ops) != 1 || ops[0].Claims["data"].Phase != claimRebinding {
+		t.Fatalf("expected the operation to be journaled with pvc data rebinding but received %v, %v", ops, err)
+	}
+	for _, pvName := range []string{"pv-old", "pv-new"} {
+		pv, err := client.CoreV1().PersistentVolumes().Get(context.Background(), pvName, metav1.GetOptions{})
+		if err != nil || pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+			t.Fatalf("expected %s to be retained while pvc data is missing but received %v, %v", pvName, pv, err)
+		}
+	}
+
+	err = ds.recoverOperation(context.Background(), ops[0])
+	if !errors.As(err, &incomplete) {
+		t.Fatalf("expected the recovery to fail while pvc data is missing but received %v", err)
+	}
+	if _, err := client.CoreV1().Pods("test").Get(context.Background(), "app", metav1.GetOptions{}); err == nil {
+		t.Fatalf("expected the pod not to be recreated while pvc data is missing")
+	}
+}
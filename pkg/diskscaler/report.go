@@ -0,0 +1,177 @@
+package diskscaler
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubecost/disk-autoscaler/pkg/metrics"
+	v1 "k8s.io/api/core/v1"
+)
+
+// directionNone is the direction of a claim already at its recommended size
+const directionNone = "none"
+
+// reportColumns is the header of the report in CSV
+var reportColumns = []string{"namespace", "kind", "workload", "pvc", "pv", "storageClass", "currentSize", "recommendedSize", "direction", "monthlySavings", "blockers"}
+
+// ReportEntry is the recommendation for a claim of a workload found by an audit run.
+type ReportEntry struct {
+	Namespace       string  `json:"namespace"`
+	Kind            string  `json:"kind"`
+	Workload        string  `json:"workload"`
+	PVC             string  `json:"pvc"`
+	PV              string  `json:"pv"`
+	StorageClass    string  `json:"storageClass"`
+	CurrentSize     string  `json:"currentSize"`
+	RecommendedSize string  `json:"recommendedSize,omitempty"`
+	Direction       string  `json:"direction"`
+	MonthlySavings  float64 `json:"monthlySavings"`
+	// Blockers are the reasons the claim would not be resized once audit mode is turned off
+	Blockers []string `json:"blockers"`
+}
+
+// Report holds the recommendations found by the last audit run.
+type Report struct {
+	GeneratedAt *time.Time    `json:"generatedAt,omitempty"`
+	Entries     []ReportEntry `json:"entries"`
+}
+
+// auditReport keeps the report of the last audit run completed while the next one is built.
+type auditReport struct {
+	mu      sync.Mutex
+	latest  Report
+	pending map[string][]ReportEntry
+}
+
+func newAuditReport() *auditReport {
+	return &auditReport{latest: Report{Entries: []ReportEntry{}}}
+}
+
+// begin starts building the report of a new audit run.
+func (r *auditReport) begin() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = map[string][]ReportEntry{}
+}
+
+// add adds the entries of the claims of a workload to the report being built.
+func (r *auditReport) add(key string, entries []ReportEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == nil {
+		return
+	}
+	r.pending[key] = entries
+}
+
+// publish replaces the latest report by the report built since begin, sorted by workload and claim.
+func (r *auditReport) publish(generatedAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]string, 0, len(r.pending))
+	for key := range r.pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := []ReportEntry{}
+	for _, key := range keys {
+		entries = append(entries, r.pending[key]...)
+	}
+	r.latest = Report{GeneratedAt: &generatedAt, Entries: entries}
+	r.pending = nil
+}
+
+// replace replaces the latest report by a report built elsewhere.
+func (r *auditReport) replace(report Report) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latest = report
+}
+
+// get returns the latest report.
+func (r *auditReport) get() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.latest
+}
+
+// recordAudit adds the recommendation for every claim of the workload to the report of the audit or dry run,
+// along with the reasons the claim would not be resized.
+func (ds *DiskScaler) recordAudit(ctx context.Context, workload *v1.ObjectReference, volMap map[string]*pvcDetails, now time.Time) {
+	report, ok := ctx.Value(reportContextKey).(*auditReport)
+	if !ok {
+		return
+	}
+	entries := []ReportEntry{}
+	for pvcName, pvcDetails := range volMap {
+		entry := ReportEntry{
+			Namespace:      workload.Namespace,
+			Kind:           workload.Kind,
+			Workload:       workload.Name,
+			PVC:            pvcName,
+			PV:             pvcDetails.pvName,
+			StorageClass:   pvcDetails.storageClass,
+			CurrentSize:    pvcDetails.currentSize.String(),
+			Direction:      directionNone,
+			MonthlySavings: pvcDetails.savings,
+			Blockers:       []string{},
+		}
+		// A claim blocked before a recommendation was received has no size to be resized to
+		if !pvcDetails.resizeTo.IsZero() {
+			entry.RecommendedSize = pvcDetails.resizeTo.String()
+		}
+		switch {
+		case pvcDetails.resizeTo.IsZero(), isEqualQuantity(pvcDetails.currentSize, pvcDetails.resizeTo):
+		case isGreaterQuantity(pvcDetails.currentSize, pvcDetails.resizeTo):
+			entry.Direction = metrics.DirectionExpand
+		default:
+			entry.Direction = metrics.DirectionShrink
+		}
+		if pvcDetails.blocker != nil {
+			entry.Blockers = append(entry.Blockers, pvcDetails.blocker.Error())
+		} else if pvcDetails.canExpand() {
+			if remaining := pvcDetails.driver.CooldownRemaining(pvcDetails.lastResized, now); remaining > 0 {
+				entry.Blockers = append(entry.Blockers, fmt.Sprintf("pvc was resized at %s and provisioner %s allows another resize in %s", pvcDetails.lastResized.Format(timeFormat), pvcDetails.provisioner, remaining.Round(time.Minute)))
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].PVC < entries[j].PVC })
+	report.add(workloadKey(workload.Namespace, workload.Kind, workload.Name), entries)
+}
+
+// writeCSV writes the entries of the report as CSV with a header, blockers being separated by semicolons.
+func (r Report) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(reportColumns)
+	if err != nil {
+		return err
+	}
+	for _, entry := range r.Entries {
+		err = writer.Write([]string{
+			entry.Namespace,
+			entry.Kind,
+			entry.Workload,
+			entry.PVC,
+			entry.PV,
+			entry.StorageClass,
+			entry.CurrentSize,
+			entry.RecommendedSize,
+			entry.Direction,
+			strconv.FormatFloat(entry.MonthlySavings, 'f', 2, 64),
+			strings.Join(entry.Blockers, "; "),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,149 @@
+package diskscaler
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_recordAudit(t *testing.T) {
+	type testCase struct {
+		name              string
+		provisioner       string
+		currentSize       string
+		recommendedSize   string
+		lastResized       time.Time
+		expectedDirection string
+		expectedSize      string
+		expectedBlocker   string
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []testCase{
+		{
+			name:              "when a volume would be shrunk",
+			provisioner:       "ebs.csi.aws.com",
+			currentSize:       "100Gi",
+			recommendedSize:   "10Gi",
+			expectedDirection: "shrink",
+			expectedSize:      "10Gi",
+		},
+		{
+			name:              "when a volume would be expanded within the cooldown of the provisioner",
+			provisioner:       "ebs.csi.aws.com",
+			currentSize:       "10Gi",
+			recommendedSize:   "20Gi",
+			lastResized:       now.Add(-time.Hour),
+			expectedDirection: "expand",
+			expectedSize:      "20Gi",
+			expectedBlocker:   "allows another resize in 5h0m0s",
+		},
+		{
+			name:              "when the provisioner is not supported",
+			provisioner:       "example.com/nfs",
+			currentSize:       "100Gi",
+			recommendedSize:   "10Gi",
+			expectedDirection: "shrink",
+			expectedSize:      "10Gi",
+			expectedBlocker:   "unsupported provisioner example.com/nfs",
+		},
+		{
+			name:              "when the recommender has no data for the volume",
+			provisioner:       "ebs.csi.aws.com",
+			currentSize:       "100Gi",
+			expectedDirection: "none",
+			expectedBlocker:   "unable to get recommendation",
+		},
+	}
+
+	for _, tc := range testCases {
+		storageClassName := "test-sc"
+		bindingMode := storagev1.VolumeBindingWaitForFirstConsumer
+		allowExpansion := true
+		pvc := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "test"},
+			Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &storageClassName, VolumeName: "pv-1"},
+			Status:     v1.PersistentVolumeClaimStatus{Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse(tc.currentSize)}},
+		}
+		if !tc.lastResized.IsZero() {
+			pvc.Annotations = map[string]string{PVCAnnotationLastResized: tc.lastResized.Format(timeFormat)}
+		}
+		pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}}
+		sc := &storagev1.StorageClass{
+			ObjectMeta:           metav1.ObjectMeta{Name: storageClassName},
+			Provisioner:          tc.provisioner,
+			VolumeBindingMode:    &bindingMode,
+			AllowVolumeExpansion: &allowExpansion,
+		}
+		recommender := stubRecommender{}
+		if tc.recommendedSize != "" {
+			recommender["pv-1"] = tc.recommendedSize
+		}
+		ds, err := NewDiskScaler(nil, fake.NewSimpleClientset(pvc, pv, sc), newFakeDynamicClient(), "test", recommender, true)
+		if err != nil {
+			t.Fatalf("test '%s': unable to create disk scaler: %s", tc.name, err)
+		}
+		ds.recorder = record.NewFakeRecorder(10)
+
+		owner := &v1.ObjectReference{APIVersion: "apps/v1", Kind: workloadKindDeployment, Namespace: "test", Name: "app"}
+		details, err := ds.getPVCDetails(context.Background(), "test", owner, "data", scalingPolicy{targetUtilization: 70, interval: "7h", direction: PolicyDirectionBoth})
+		if err != nil {
+			t.Fatalf("test '%s': expected a blocked claim to be reported in audit mode but received err: %s", tc.name, err)
+		}
+
+		auditReport := newAuditReport()
+		auditReport.begin()
+		ds.recordAudit(context.WithValue(context.Background(), reportContextKey, auditReport), owner, map[string]*pvcDetails{"data": details}, now)
+		auditReport.publish(now)
+		report := auditReport.get()
+		if len(report.Entries) != 1 {
+			t.Fatalf("test '%s': expected 1 entry in the report but received %d", tc.name, len(report.Entries))
+		}
+		entry := report.Entries[0]
+		if entry.Direction != tc.expectedDirection || entry.RecommendedSize != tc.expectedSize {
+			t.Fatalf("test '%s': expected %s to %s but received %s to %s", tc.name, tc.expectedDirection, tc.expectedSize, entry.Direction, entry.RecommendedSize)
+		}
+		if tc.expectedBlocker == "" && len(entry.Blockers) != 0 {
+			t.Fatalf("test '%s': expected no blocker but received %v", tc.name, entry.Blockers)
+		}
+		if tc.expectedBlocker != "" && (len(entry.Blockers) != 1 || !strings.Contains(entry.Blockers[0], tc.expectedBlocker)) {
+			t.Fatalf("test '%s': expected blocker %q but received %v", tc.name, tc.expectedBlocker, entry.Blockers)
+		}
+	}
+}
+
+func Test_reportWriteCSV(t *testing.T) {
+	report := Report{Entries: []ReportEntry{
+		{
+			Namespace:       "test",
+			Kind:            workloadKindDeployment,
+			Workload:        "app",
+			PVC:             "data",
+			PV:              "pv-1",
+			StorageClass:    "gp3",
+			CurrentSize:     "100Gi",
+			RecommendedSize: "10Gi",
+			Direction:       "shrink",
+			MonthlySavings:  7.2,
+			Blockers:        []string{"unsupported provisioner", "pvc, was resized"},
+		},
+	}}
+	var out bytes.Buffer
+	if err := report.writeCSV(&out); err != nil {
+		t.Fatalf("unable to write report: %s", err)
+	}
+	expected := "namespace,kind,workload,pvc,pv,storageClass,currentSize,recommendedSize,direction,monthlySavings,blockers\n" +
+		"test,Deployment,app,data,pv-1,gp3,100Gi,10Gi,shrink,7.20,\"unsupported provisioner; pvc, was resized\"\n"
+	if out.String() != expected {
+		t.Fatalf("expected report\n%s\nbut received\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,372 @@
+package diskscaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Retaining replaced claims for a day gives teams time to notice an app misbehaving after a resize
+const defaultRollbackWindow = 24 * time.Hour
+
+// retainReplacedPVC keeps a claim which was replaced by replacedBy in its workload for the rollback
+// window instead of deleting it. The reclaim policy of its volume is switched to Retain so that the
+// data survives the claim being deleted by mistake. The claim is deleted right away when no rollback
+// window is configured.
+func (ds *DiskScaler) retainReplacedPVC(ctx context.Context, namespace string, pvcName string, replacedBy string) error {
+	if ds.rollbackWindow <= 0 {
+		return ds.deletePVC(ctx, namespace, pvcName)
+	}
+	pvc, err := ds.basicK8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		log.Debug().Msgf("ctx: %s, no pv claim: %s found to retain", ctx.Value(diskScalerRunContextKey), pvcName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get persistent volume claim: %s err: %w", pvcName, err)
+	}
+
+	reclaimPolicy := ""
+	if pvc.Spec.VolumeName != "" {
+		pv, err := ds.getPVInfo(ctx, pvc.Spec.VolumeName)
+		if err != nil {
+			return err
+		}
+		reclaimPolicy = string(pv.Spec.PersistentVolumeReclaimPolicy)
+		// A claim retained twice keeps the reclaim policy recorded the first time
+		if recorded, ok := pvc.GetAnnotations()[PVCAnnotationReclaimPolicy]; ok {
+			reclaimPolicy = recorded
+		}
+		err = ds.patchPVReclaimPolicy(ctx, pv.Name, v1.PersistentVolumeReclaimRetain)
+		if err != nil {
+			return err
+		}
+	}
+
+	retainedUntil := time.Now().Add(ds.rollbackWindow)
+	err = ds.patchPVCMetadata(ctx, namespace, pvcName,
+		map[string]interface{}{PVCLabelRetained: "true"},
+		map[string]interface{}{
+			PVCAnnotationReplacedBy:    replacedBy,
+			PVCAnnotationRetainedUntil: retainedUntil.Format(timeFormat),
+			PVCAnnotationReclaimPolicy: reclaimPolicy,
+		})
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("ctx: %s, pvc %s replaced by pvc %s is retained for rollback until %s", ctx.Value(diskScalerRunContextKey), pvcName, replacedBy, retainedUntil.Format(timeFormat))
+	return nil
+}
+
+// retainReplacedPV keeps the volume of a claim which was recreated bound to the volume replacedBy
+// for the rollback window, so that its data can still be recovered by hand. The rollback workflow
+// only swaps claims and never rebinds one, so a resize which kept the claim name cannot be undone
+// by it. The claimRef of the volume is cleared down to the claim name, which the recreated claim no
+// longer matches, so that no other claim binds the volume while it is retained. The volume is
+// released with its reclaim policy right away when no rollback window is configured.
+func (ds *DiskScaler) retainReplacedPV(ctx context.Context, pvName string, replacedBy string, reclaimPolicy v1.PersistentVolumeReclaimPolicy) error {
+	if ds.rollbackWindow <= 0 {
+		return ds.patchPVReclaimPolicy(ctx, pvName, reclaimPolicy)
+	}
+	retainedUntil := time.Now().Add(ds.rollbackWindow)
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{PVCLabelRetained: "true"},
+			"annotations": map[string]interface{}{
+				PVCAnnotationReplacedBy:    replacedBy,
+				PVCAnnotationRetainedUntil: retainedUntil.Format(timeFormat),
+				PVCAnnotationReclaimPolicy: string(reclaimPolicy),
+			},
+		},
+		"spec": map[string]interface{}{
+			"claimRef": map[string]interface{}{"uid": nil, "resourceVersion": nil},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to marshal patch of pv %s: %w", pvName, err)
+	}
+	_, err = ds.basicK8sClient.CoreV1().PersistentVolumes().Patch(ctx, pvName, types.MergePatchType, data, metav1.PatchOptions{})
+	if k8serrors.IsNotFound(err) {
+		log.Debug().Msgf("ctx: %s, no pv: %s found to retain", ctx.Value(diskScalerRunContextKey), pvName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retain pv %s: %w", pvName, err)
+	}
+	log.Info().Msgf("ctx: %s, pv %s replaced by pv %s is retained for manual recovery until %s", ctx.Value(diskScalerRunContextKey), pvName, replacedBy, retainedUntil.Format(timeFormat))
+	return nil
+}
+
+// releaseRetainedPVC ends the retention of a claim which is in use again, restoring the reclaim policy of its volume.
+func (ds *DiskScaler) releaseRetainedPVC(ctx context.Context, pvc *v1.PersistentVolumeClaim) error {
+	if reclaimPolicy := pvc.GetAnnotations()[PVCAnnotationReclaimPolicy]; reclaimPolicy != "" && pvc.Spec.VolumeName != "" {
+		err := ds.patchPVReclaimPolicy(ctx, pvc.Spec.VolumeName, v1.PersistentVolumeReclaimPolicy(reclaimPolicy))
+		if err != nil {
+			return err
+		}
+	}
+	return ds.patchPVCMetadata(ctx, pvc.Namespace, pvc.Name,
+		map[string]interface{}{PVCLabelRetained: nil},
+		map[string]interface{}{
+			PVCAnnotationReplacedBy:    nil,
+			PVCAnnotationRetainedUntil: nil,
+			PVCAnnotationReclaimPolicy: nil,
+		})
+}
+
+// patchPVCMetadata merges the given labels and annotations into the claim, a nil value removes the key.
+func (ds *DiskScaler) patchPVCMetadata(ctx context.Context, namespace string, pvcName string, pvcLabels map[string]interface{}, annotations map[string]interface{}) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      pvcLabels,
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to marshal patch of pvc %s: %w", pvcName, err)
+	}
+	_, err = ds.basicK8sClient.CoreV1().PersistentVolumeClaims(namespace).Patch(ctx, pvcName, types.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to patch pvc %s: %w", pvcName, err)
+	}
+	return nil
+}
+
+// retainedPVCs returns the retained claims of the namespace, or of every namespace when it is empty.
+func (ds *DiskScaler) retainedPVCs(ctx context.Context, namespace string) ([]v1.PersistentVolumeClaim, error) {
+	list, err := ds.basicK8sClient.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{PVCLabelRetained: "true"}).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list retained pvcs: %w", err)
+	}
+	return list.Items, nil
+}
+
+// runRollbackWorkflow points the workload back at the claims its current claims replaced in the
+// last resize. The workload is stopped while its claims are swapped. The claims rolled back from
+// are retained in turn, so that a rollback can itself be undone within the rollback window.
+// The outcome is recorded as events on the workload and on the claims rolled back to.
+func (ds *DiskScaler) runRollbackWorkflow(ctx context.Context, namespace, kind, name string) error {
+	wl, err := ds.workloadFor(kind)
+	if err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+	if _, ok := wl.(*statefulSetWorkload); ok {
+		return fmt.Errorf("rollback failed: statefulset claims keep their name when resized and cannot be rolled back")
+	}
+	release, err := ds.startScaling(namespace, wl.Kind(), name)
+	if err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+	defer release()
+
+	meta, template, err := wl.Get(ctx, namespace, name)
+	if err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+	ref := workloadReference(wl.Kind(), meta)
+	retained, err := ds.retainedPVCs(ctx, namespace)
+	if err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+	// Current claim of the workload to the retained claim it replaced
+	rollbacks := map[string]*v1.PersistentVolumeClaim{}
+	for i := range retained {
+		replacedBy := retained[i].GetAnnotations()[PVCAnnotationReplacedBy]
+		if usesClaim(template, replacedBy) {
+			rollbacks[replacedBy] = &retained[i]
+		}
+	}
+	if len(rollbacks) == 0 {
+		return fmt.Errorf("rollback failed: %s %s in namespace %s has no retained pvc to roll back to, claims which kept their name when resized cannot be rolled back", strings.ToLower(wl.Kind()), name, namespace)
+	}
+
+	// Only the quiesce is journaled so that the workload is restored after a restart, a claim
+	// swap journaled as a resize would be rolled back by deleting the retained claim
+	op := newOperation(namespace, wl.Kind(), name)
+	err = ds.journal.record(ctx, op)
+	if err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+	var originalScale int32
+	err = withRetries(ctx, fmt.Sprintf("quiesce %s", strings.ToLower(wl.Kind())), func() error {
+		var quiesceErr error
+		originalScale, quiesceErr = wl.Quiesce(ctx, namespace, name)
+		return quiesceErr
+	})
+	if err != nil {
+		ds.completeOperation(ctx, op)
+		ds.recordEvent(ctx, ref, nil, v1.EventTypeWarning, EventReasonRollbackFailed, "unable to scale down to roll back its volumes: %v", err)
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+	op.Phase = operationQuiesced
+	op.OriginalScale = originalScale
+	ds.checkpoint(ctx, op)
+
+	var rollbackErr error
+	rolledBack := map[string]string{}
+	for current, original := range rollbacks {
+		err := wl.SwapClaim(ctx, namespace, name, current, original.Name)
+		if err != nil {
+			rollbackErr = fmt.Errorf("unable to point %s %s back at pvc %s: %w", strings.ToLower(wl.Kind()), name, original.Name, err)
+			ds.recordEvent(ctx, ref, claimReference(original), v1.EventTypeWarning, EventReasonRollbackFailed, "unable to roll back from pvc %s to pvc %s: %v", current, original.Name, err)
+			continue
+		}
+		log.Info().Msgf("ctx: %s, rolled back %s %s from pvc %s to pvc %s", ctx.Value(diskScalerRunContextKey), strings.ToLower(wl.Kind()), name, current, original.Name)
+		ds.recordEvent(ctx, ref, claimReference(original), v1.EventTypeNormal, EventReasonRolledBack, "rolled back from pvc %s to pvc %s", current, original.Name)
+		rolledBack[current] = original.Name
+		err = ds.releaseRetainedPVC(ctx, original)
+		if err != nil {
+			log.Error().Msgf("ctx: %s, unable to end the retention of pvc %s: %v", ctx.Value(diskScalerRunContextKey), original.Name, err)
+		}
+	}
+
+	err = withRetries(ctx, fmt.Sprintf("restore %s", strings.ToLower(wl.Kind())), func() error {
+		return wl.Restore(ctx, namespace, name, originalScale)
+	})
+	if err != nil {
+		ds.recordEvent(ctx, ref, nil, v1.EventTypeWarning, EventReasonRollbackFailed, "unable to scale back up to %d replicas after rolling back its volumes: %v", originalScale, err)
+		return fmt.Errorf("rollback failed to restore %s %s to %d replicas: %w", strings.ToLower(wl.Kind()), name, originalScale, err)
+	}
+	ds.completeOperation(ctx, op)
+
+	for current, original := range rolledBack {
+		err := ds.retainReplacedPVC(ctx, namespace, current, original)
+		if err != nil {
+			log.Error().Msgf("ctx: %s, unable to retain pvc %s rolled back from: %v", ctx.Value(diskScalerRunContextKey), current, err)
+		}
+	}
+	if rollbackErr != nil {
+		return fmt.Errorf("rollback partially failed: %w", rollbackErr)
+	}
+	return nil
+}
+
+// pruneRetainedPVCs deletes the retained claims whose rollback window has expired, along with
+// their volumes unless the volumes were retained before the resize. A claim mounted by a pod is
+// in use again and is released instead. The volumes retained by a rebind are pruned alike.
+func (ds *DiskScaler) pruneRetainedPVCs(ctx context.Context, now time.Time) error {
+	retained, err := ds.retainedPVCs(ctx, "")
+	if err != nil {
+		return err
+	}
+	for i := range retained {
+		pvc := &retained[i]
+		retainedUntil, err := time.Parse(timeFormat, pvc.GetAnnotations()[PVCAnnotationRetainedUntil])
+		if err != nil {
+			log.Warn().Msgf("retained pvc %s in namespace %s has no valid %s annotation and is kept", pvc.Name, pvc.Namespace, PVCAnnotationRetainedUntil)
+			continue
+		}
+		if now.Before(retainedUntil) {
+			continue
+		}
+		inUse, err := ds.claimInUse(ctx, pvc.Namespace, pvc.Name)
+		if err != nil {
+			log.Error().Msgf("unable to check whether retained pvc %s in namespace %s is in use: %v", pvc.Name, pvc.Namespace, err)
+			continue
+		}
+		if inUse {
+			log.Warn().Msgf("retained pvc %s in namespace %s is mounted by a pod and is no longer retained", pvc.Name, pvc.Namespace)
+			if err := ds.releaseRetainedPVC(ctx, pvc); err != nil {
+				log.Error().Msgf("unable to end the retention of pvc %s in namespace %s: %v", pvc.Name, pvc.Namespace, err)
+			}
+			continue
+		}
+		// The volume is released with its original reclaim policy once the claim is deleted
+		if reclaimPolicy := pvc.GetAnnotations()[PVCAnnotationReclaimPolicy]; reclaimPolicy != "" && pvc.Spec.VolumeName != "" {
+			err := ds.patchPVReclaimPolicy(ctx, pvc.Spec.VolumeName, v1.PersistentVolumeReclaimPolicy(reclaimPolicy))
+			if err != nil {
+				log.Error().Msgf("unable to restore the reclaim policy of pv %s of retained pvc %s: %v", pvc.Spec.VolumeName, pvc.Name, err)
+				continue
+			}
+		}
+		err = ds.basicK8sClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			log.Error().Msgf("unable to delete retained pvc %s in namespace %s: %v", pvc.Name, pvc.Namespace, err)
+			continue
+		}
+		log.Info().Msgf("deleted pvc %s in namespace %s as its rollback window expired", pvc.Name, pvc.Namespace)
+	}
+	return ds.pruneRetainedPVs(ctx, now)
+}
+
+// pruneRetainedPVs releases the volumes retained by a rebind whose rollback window has expired.
+// A volume is deleted when its original reclaim policy deletes volumes, and is otherwise kept
+// with its original reclaim policy.
+func (ds *DiskScaler) pruneRetainedPVs(ctx context.Context, now time.Time) error {
+	list, err := ds.basicK8sClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{PVCLabelRetained: "true"}).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list retained pvs: %w", err)
+	}
+	for _, pv := range list.Items {
+		retainedUntil, err := time.Parse(timeFormat, pv.GetAnnotations()[PVCAnnotationRetainedUntil])
+		if err != nil {
+			log.Warn().Msgf("retained pv %s has no valid %s annotation and is kept", pv.Name, PVCAnnotationRetainedUntil)
+			continue
+		}
+		if now.Before(retainedUntil) {
+			continue
+		}
+		reclaimPolicy := v1.PersistentVolumeReclaimPolicy(pv.GetAnnotations()[PVCAnnotationReclaimPolicy])
+		if reclaimPolicy == "" {
+			reclaimPolicy = v1.PersistentVolumeReclaimRetain
+		}
+		data, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": map[string]interface{}{PVCLabelRetained: nil},
+				"annotations": map[string]interface{}{
+					PVCAnnotationReplacedBy:    nil,
+					PVCAnnotationRetainedUntil: nil,
+					PVCAnnotationReclaimPolicy: nil,
+				},
+			},
+			"spec": map[string]interface{}{"persistentVolumeReclaimPolicy": reclaimPolicy},
+		})
+		if err != nil {
+			log.Error().Msgf("unable to marshal patch of retained pv %s: %v", pv.Name, err)
+			continue
+		}
+		_, err = ds.basicK8sClient.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, data, metav1.PatchOptions{})
+		if err != nil {
+			log.Error().Msgf("unable to restore the reclaim policy of retained pv %s: %v", pv.Name, err)
+			continue
+		}
+		if reclaimPolicy != v1.PersistentVolumeReclaimDelete {
+			log.Info().Msgf("pv %s is no longer retained as its rollback window expired", pv.Name)
+			continue
+		}
+		err = ds.basicK8sClient.CoreV1().PersistentVolumes().Delete(ctx, pv.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			log.Error().Msgf("unable to delete retained pv %s: %v", pv.Name, err)
+			continue
+		}
+		log.Info().Msgf("deleted pv %s as its rollback window expired", pv.Name)
+	}
+	return nil
+}
+
+// claimInUse returns true when a pod of the namespace mounts the claim.
+func (ds *DiskScaler) claimInUse(ctx context.Context, namespace string, claim string) (bool, error) {
+	pods, err := ds.basicK8sClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("unable to list pods in namespace %s: %w", namespace, err)
+	}
+	for _, pod := range pods.Items {
+		if usesClaim(&v1.PodTemplateSpec{Spec: pod.Spec}, claim) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
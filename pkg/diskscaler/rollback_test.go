@@ -0,0 +1,168 @@
+package diskscaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func newRetainedPVC(name, volumeName, replacedBy string, retainedUntil time.Time) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "test",
+			Labels:    map[string]string{PVCLabelRetained: "true"},
+			Annotations: map[string]string{
+				PVCAnnotationReplacedBy:    replacedBy,
+				PVCAnnotationRetainedUntil: retainedUntil.Format(timeFormat),
+				PVCAnnotationReclaimPolicy: string(v1.PersistentVolumeReclaimDelete),
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{VolumeName: volumeName},
+	}
+}
+
+func newTestPV(name string, reclaimPolicy v1.PersistentVolumeReclaimPolicy) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: reclaimPolicy},
+	}
+}
+
+func newTestPod(claim string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test"},
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{{
+				Name:         "data",
+				VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+			}},
+		},
+	}
+}
+
+func Test_runRollbackWorkflow(t *testing.T) {
+	type testCase struct {
+		name          string
+		retained      bool
+		expectErr     bool
+		expectedClaim string
+	}
+
+	testCases := []testCase{
+		{
+			name:          "when the workload is pointed back at the retained pvc",
+			retained:      true,
+			expectedClaim: "data",
+		},
+		{
+			name:          "when the workload has no retained pvc to roll back to",
+			expectErr:     true,
+			expectedClaim: "data-abcde",
+		},
+	}
+
+	for _, tc := range testCases {
+		current := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data-abcde", Namespace: "test"},
+			Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-new"},
+		}
+		objects := []runtime.Object{newTestPod("data-abcde"), current, newTestPV("pv-new", v1.PersistentVolumeReclaimDelete)}
+		if tc.retained {
+			objects = append(objects, newRetainedPVC("data", "pv-old", "data-abcde", time.Now().Add(time.Hour)), newTestPV("pv-old", v1.PersistentVolumeReclaimRetain))
+		}
+		client := fake.NewSimpleClientset(objects...)
+		ds, err := NewDiskScaler(nil, client, newFakeDynamicClient(), "test", stubRecommender{}, false)
+		if err != nil {
+			t.Fatalf("test '%s': unable to create disk scaler: %s", tc.name, err)
+		}
+		recorder := record.NewFakeRecorder(10)
+		recorder.IncludeObject = true
+		ds.recorder = recorder
+
+		err = ds.runRollbackWorkflow(context.Background(), "test", workloadKindPod, "app")
+		if tc.expectErr && err == nil {
+			t.Fatalf("test '%s': expected err but received none", tc.name)
+		}
+		if !tc.expectErr && err != nil {
+			t.Fatalf("test '%s': received unexpected err: %s", tc.name, err)
+		}
+
+		pod, err := client.CoreV1().Pods("test").Get(context.Background(), "app", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("test '%s': expected pod to exist: %s", tc.name, err)
+		}
+		if claim := pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName; claim != tc.expectedClaim {
+			t.Fatalf("test '%s': expected pod to use claim %s but received %s", tc.name, tc.expectedClaim, claim)
+		}
+		if !tc.retained {
+			continue
+		}
+		if !receivedEvent(recorder, v1.EventTypeNormal+" "+EventReasonRolledBack, "kind="+workloadKindPod) {
+			t.Fatalf("test '%s': expected a %s event on the pod", tc.name, EventReasonRolledBack)
+		}
+
+		original, _ := client.CoreV1().PersistentVolumeClaims("test").Get(context.Background(), "data", metav1.GetOptions{})
+		if _, ok := original.GetLabels()[PVCLabelRetained]; ok {
+			t.Fatalf("test '%s': expected pvc data to no longer be retained", tc.name)
+		}
+		pvOld, _ := client.CoreV1().PersistentVolumes().Get(context.Background(), "pv-old", metav1.GetOptions{})
+		if pvOld.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete {
+			t.Fatalf("test '%s': expected the reclaim policy of pv-old to be restored but received %s", tc.name, pvOld.Spec.PersistentVolumeReclaimPolicy)
+		}
+		rolledBack, _ := client.CoreV1().PersistentVolumeClaims("test").Get(context.Background(), "data-abcde", metav1.GetOptions{})
+		if rolledBack.GetAnnotations()[PVCAnnotationReplacedBy] != "data" || rolledBack.GetLabels()[PVCLabelRetained] != "true" {
+			t.Fatalf("test '%s': expected pvc data-abcde to be retained after the rollback", tc.name)
+		}
+		pvNew, _ := client.CoreV1().PersistentVolumes().Get(context.Background(), "pv-new", metav1.GetOptions{})
+		if pvNew.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+			t.Fatalf("test '%s': expected pv-new to be retained but received %s", tc.name, pvNew.Spec.PersistentVolumeReclaimPolicy)
+		}
+	}
+}
+
+func Test_pruneRetainedPVCs(t *testing.T) {
+	now := time.Now()
+	client := fake.NewSimpleClientset(
+		newRetainedPVC("expired", "pv-expired", "expired-abcde", now.Add(-time.Hour)),
+		newRetainedPVC("in-window", "pv-in-window", "in-window-abcde", now.Add(time.Hour)),
+		newRetainedPVC("in-use", "pv-in-use", "in-use-abcde", now.Add(-time.Hour)),
+		newTestPV("pv-expired", v1.PersistentVolumeReclaimRetain),
+		newTestPV("pv-in-use", v1.PersistentVolumeReclaimRetain),
+		newTestPod("in-use"),
+	)
+	ds, err := NewDiskScaler(nil, client, newFakeDynamicClient(), "test", stubRecommender{}, false)
+	if err != nil {
+		t.Fatalf("unable to create disk scaler: %s", err)
+	}
+
+	err = ds.pruneRetainedPVCs(context.Background(), now)
+	if err != nil {
+		t.Fatalf("received unexpected err: %s", err)
+	}
+
+	if _, err := client.CoreV1().PersistentVolumeClaims("test").Get(context.Background(), "expired", metav1.GetOptions{}); err == nil {
+		t.Fatalf("expected the expired pvc to be deleted")
+	}
+	pv, _ := client.CoreV1().PersistentVolumes().Get(context.Background(), "pv-expired", metav1.GetOptions{})
+	if pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete {
+		t.Fatalf("expected the reclaim policy of the expired pv to be restored but received %s", pv.Spec.PersistentVolumeReclaimPolicy)
+	}
+	inWindow, err := client.CoreV1().PersistentVolumeClaims("test").Get(context.Background(), "in-window", metav1.GetOptions{})
+	if err != nil || inWindow.GetLabels()[PVCLabelRetained] != "true" {
+		t.Fatalf("expected the pvc in its rollback window to be kept")
+	}
+	inUse, err := client.CoreV1().PersistentVolumeClaims("test").Get(context.Background(), "in-use", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the pvc mounted by a pod to be kept: %s", err)
+	}
+	if _, ok := inUse.GetLabels()[PVCLabelRetained]; ok {
+		t.Fatalf("expected the pvc mounted by a pod to no longer be retained")
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/kubecost/disk-autoscaler/pkg/metrics"
 	"github.com/kubecost/disk-autoscaler/pkg/pvsizingrecommendation"
 	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
@@ -34,23 +37,38 @@ const (
 	AnnotationLastScaled                = "request.autodiskscaling.kubecost.com/lastScaled"
 	AnnotationInterval                  = "request.autodiskscaling.kubecost.com/interval"
 	AnnotationTargetUtilization         = "request.autodiskscaling.kubecost.com/targetUtilization"
+	AnnotationEmergencyUtilization      = "request.autodiskscaling.kubecost.com/emergencyUtilization"
+	AnnotationShrinkStrategy            = "request.autodiskscaling.kubecost.com/shrinkStrategy"
+	AnnotationAllowStop                 = "request.autodiskscaling.kubecost.com/allowStop"
 	PVCAnnotationExtendBy               = "request.autodiskscaling.kubecost.com/volumeExtendedBy"
 	PVCAnnotationCreatedBy              = "request.autodiskscaling.kubecost.com/volumeCreatedBy"
+	PVCAnnotationLastResized            = "request.autodiskscaling.kubecost.com/lastResized"
+	PVCAnnotationLastSnapshot           = "request.autodiskscaling.kubecost.com/lastSnapshot"
+	PVCAnnotationCopyProgress           = "request.autodiskscaling.kubecost.com/copyProgress"
+	SnapshotAnnotationSourcePVC         = "request.autodiskscaling.kubecost.com/sourcePVC"
+	SnapshotAnnotationExpiresAt         = "request.autodiskscaling.kubecost.com/expiresAt"
+	PVCAnnotationReplacedBy             = "request.autodiskscaling.kubecost.com/replacedBy"
+	PVCAnnotationRetainedUntil          = "request.autodiskscaling.kubecost.com/retainedUntil"
+	PVCAnnotationReclaimPolicy          = "request.autodiskscaling.kubecost.com/reclaimPolicy"
+	PVCLabelRetained                    = "request.autodiskscaling.kubecost.com/retained"
 	DiskAutoScaler                      = "kubecost_disk_auto_scaler"
 	timeFormat                          = time.RFC3339
-	diskScalingDefaultInterval          = "7h"
 )
 
 type RunStatus struct {
-	NumEnabled  int
-	NumEligible int
-	SuccessRun  int
-	FailedRun   int
+	NumEnabled     int             `json:"numEnabled"`
+	NumEligible    int             `json:"numEligible"`
+	SuccessRun     int             `json:"successRun"`
+	FailedRun      int             `json:"failedRun"`
+	WorkloadErrors []WorkloadError `json:"workloadErrors,omitempty"`
+	// Recommendations are the recommendations found by a dry run
+	Recommendations []ReportEntry `json:"recommendations,omitempty"`
 }
 
-type DiskScalerDeploymentWorkload struct {
-	Namespace  string `json:"namespace"`
-	Deployment string `json:"deployment"`
+type DiskScalerWorkload struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
 }
 
 type DiskScalerService struct {
@@ -59,18 +77,23 @@ type DiskScalerService struct {
 	resizeAll              bool
 	excludedNamespaceRegex *regexp.Regexp
 	auditMode              bool
+	// runs holds the last runs of the disk scaling loop reported by the runs endpoint
+	runs *runHistory
+	// emergencyWarnings holds the invalid emergency utilization last warned about for each workload,
+	// so that a misconfigured workload is warned about once rather than on every poll
+	emergencyWarnings sync.Map
 }
 
 func NewDiskScalerService(clientConfig *rest.Config,
 	k8sClient kubernetes.Interface,
-	dynamicK8sClient *dynamic.DynamicClient,
+	dynamicK8sClient dynamic.Interface,
 	resizeAll bool,
 	auditMode bool,
-	kubecostSvc *pvsizingrecommendation.KubecostService,
+	recommender pvsizingrecommendation.Recommender,
 	excludedNamespaces []string) (*DiskScalerService, error) {
 	// To-DO :fill it via kubecost API
 	clusterID := "localCluster"
-	ds, err := NewDiskScaler(clientConfig, k8sClient, dynamicK8sClient, clusterID, kubecostSvc, auditMode)
+	ds, err := NewDiskScaler(clientConfig, k8sClient, dynamicK8sClient, clusterID, recommender, auditMode)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create NewDiskScaler: %w", err)
 	}
@@ -81,84 +104,148 @@ func NewDiskScalerService(clientConfig *rest.Config,
 	if err != nil {
 		return nil, fmt.Errorf("unable to create NewDiskScaler: %w", err)
 	}
+	runHistorySize := defaultRunHistorySize
+	if viper.IsSet("run-history-size") {
+		runHistorySize = viper.GetInt("run-history-size")
+	}
+	if runHistorySize < 0 {
+		return nil, fmt.Errorf("run history size must not be negative, got %d", runHistorySize)
+	}
 	dss := &DiskScalerService{
 		basicK8sClient:         k8sClient,
 		ds:                     ds,
 		resizeAll:              resizeAll,
 		excludedNamespaceRegex: regex,
 		auditMode:              auditMode,
+		runs:                   newRunHistory(runHistorySize),
 	}
 	return dss, nil
 }
 
-func (dss *DiskScalerService) getDiskScalerDeploymentWorkload(ctx context.Context, currentRun string) (RunStatus, []DiskScalerDeploymentWorkload, error) {
+func (dss *DiskScalerService) getDiskScalerWorkloads(ctx context.Context, currentRun string, opts runOptions) (RunStatus, []DiskScalerWorkload, error) {
 	status := RunStatus{}
-	deploymentWorkload := []DiskScalerDeploymentWorkload{}
-	deployments, err := dss.basicK8sClient.
-		AppsV1().
-		Deployments(""). // Empty string lists all Deployments in all Namespaces
-		List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return status, deploymentWorkload, fmt.Errorf("listing all Deployments: %s", err)
-	}
+	workloads := []DiskScalerWorkload{}
 
 	enabled := 0
 	eligible := 0
 
-	for _, deployment := range deployments.Items {
-		if deployment.Status.UnavailableReplicas > 0 {
-			continue
-		}
-		if !dss.workloadIsEnabled(deployment.ObjectMeta) {
-			continue
+	err := dss.ds.refreshPolicies(ctx)
+	if err != nil {
+		return status, workloads, err
+	}
+	// The settings resolved for every workload are reported in the status of the policy they come from
+	resolved := map[string][]ResolvedWorkloadPolicy{}
+	now := time.Now()
+	defer func() {
+		dss.ds.reportPolicyStatus(ctx, resolved, now)
+	}()
+
+	for _, wl := range dss.ds.workloads {
+		objects, err := wl.List(ctx)
+		if err != nil {
+			return status, workloads, err
 		}
-		enabled += 1
-		if !dss.workloadIsEligible(deployment.ObjectMeta, currentRun) {
-			continue
+		for _, obj := range objects {
+			policy := dss.ds.policyFor(obj.meta)
+			if policy.source != nil {
+				key := policy.source.key()
+				resolved[key] = append(resolved[key], policy.status(wl.Kind(), obj.meta, now))
+			}
+			if !opts.selects(wl.Kind(), obj.meta) {
+				continue
+			}
+			if !obj.available {
+				continue
+			}
+			if !dss.workloadIsEnabled(obj.meta, policy) {
+				continue
+			}
+			enabled += 1
+			if !opts.force && !dss.workloadIsEligible(obj.meta, policy, currentRun) {
+				continue
+			}
+			eligible += 1
+			workloads = append(workloads, DiskScalerWorkload{
+				Namespace: obj.meta.Namespace,
+				Kind:      wl.Kind(),
+				Name:      obj.meta.Name,
+			})
 		}
-		eligible += 1
-		deploymentWorkload = append(deploymentWorkload, DiskScalerDeploymentWorkload{
-			Namespace:  deployment.Namespace,
-			Deployment: deployment.Name,
-		})
 	}
 	status.NumEnabled = enabled
 	status.NumEligible = eligible
-	return status, deploymentWorkload, nil
+	return status, workloads, nil
 }
 
-func (dss *DiskScalerService) run(diskAutoScalerRun string) (RunStatus, error) {
-	var status RunStatus
-	serviceCtx := context.Background()
-	getDeploymentContext, cancel := context.WithTimeout(context.WithValue(serviceCtx, diskScalerServiceContextKey, "getDeployment"), 60*time.Second)
+// run runs the disk scaling workflow of every workload selected by opts which is due for disk scaling.
+func (dss *DiskScalerService) run(diskAutoScalerRun string, opts runOptions) (status RunStatus, err error) {
+	serviceCtx := context.WithValue(context.Background(), dryRunContextKey, opts.dryRun)
+	getWorkloadContext, cancel := context.WithTimeout(context.WithValue(serviceCtx, diskScalerServiceContextKey, "getWorkload"), 60*time.Second)
 	defer cancel()
 
-	status, deploymentWorkload, err := dss.getDiskScalerDeploymentWorkload(getDeploymentContext, diskAutoScalerRun)
+	status, workloads, err := dss.getDiskScalerWorkloads(getWorkloadContext, diskAutoScalerRun, opts)
 	if err != nil {
-		return RunStatus{}, fmt.Errorf("failed to get deployment workload: %s", err)
+		return RunStatus{}, fmt.Errorf("failed to get workloads: %s", err)
+	}
+
+	// The recommendations of an audit or dry run are reported once every workload is evaluated,
+	// an audit run of every workload replaces the report and a dry run reports them along with the run
+	if dss.ds.auditing(serviceCtx) {
+		report := newAuditReport()
+		report.begin()
+		serviceCtx = context.WithValue(serviceCtx, reportContextKey, report)
+		defer func() {
+			report.publish(time.Now())
+			if dss.auditMode && opts.selectsAll() {
+				dss.ds.report.replace(report.get())
+			}
+			if opts.dryRun {
+				status.Recommendations = report.get().Entries
+			}
+		}()
+	}
+
+	if opts.scheduled && !dss.ds.auditing(serviceCtx) {
+		err = dss.ds.snapshotter.prune(serviceCtx, time.Now())
+		if err != nil {
+			log.Error().Msgf("unable to delete expired volume snapshots at %s: %v", diskAutoScalerRun, err)
+		}
+		err = dss.ds.pruneRetainedPVCs(serviceCtx, time.Now())
+		if err != nil {
+			log.Error().Msgf("unable to delete retained pvcs at %s: %v", diskAutoScalerRun, err)
+		}
 	}
 
-	status.NumEligible = len(deploymentWorkload)
-	if len(deploymentWorkload) == 0 {
+	status.NumEligible = len(workloads)
+	// The gauges reflect the hourly runs of every workload
+	if opts.selectsAll() {
+		metrics.EnabledWorkloads.Set(float64(status.NumEnabled))
+		metrics.EligibleWorkloads.Set(float64(status.NumEligible))
+	}
+	if len(workloads) == 0 {
 		return status, nil
 	}
 
-	log.Debug().Msgf("length of valid candidates for run at: %s are: %d", diskAutoScalerRun, len(deploymentWorkload))
-	log.Debug().Msgf("deployment workload: %+v", deploymentWorkload)
+	log.Debug().Msgf("length of valid candidates for run at: %s are: %d", diskAutoScalerRun, len(workloads))
+	log.Debug().Msgf("workloads: %+v", workloads)
 	var result error
 
 	var wg sync.WaitGroup
-	for _, workload := range deploymentWorkload {
+	var mu sync.Mutex
+	for _, workload := range workloads {
 		wg.Add(1)
-		ctx := context.Background()
-		ctx = context.WithValue(ctx, diskScalerRunContextKey, fmt.Sprintf("%s:%s", workload.Namespace, workload.Deployment))
+		ctx := context.WithValue(serviceCtx, diskScalerRunContextKey, fmt.Sprintf("%s:%s", workload.Namespace, workload.Name))
 
-		go func(workload DiskScalerDeploymentWorkload) {
+		go func(workload DiskScalerWorkload) {
 			defer wg.Done()
-			err := dss.ds.runDiskScalingWorkflow(ctx, workload.Namespace, workload.Deployment)
+			err := dss.ds.runWorkloadDiskScalingWorkflow(ctx, workload.Namespace, workload.Kind, workload.Name)
+			metrics.WorkloadRuns.WithLabelValues(metrics.Outcome(err)).Inc()
+			mu.Lock()
+			defer mu.Unlock()
 			if err != nil {
 				result = multierror.Append(result, err)
 				status.FailedRun += 1
+				status.WorkloadErrors = append(status.WorkloadErrors, WorkloadError{DiskScalerWorkload: workload, Error: err.Error()})
 				return
 			}
 			status.SuccessRun += 1
@@ -166,8 +253,12 @@ func (dss *DiskScalerService) run(diskAutoScalerRun string) (RunStatus, error) {
 
 	}
 	wg.Wait()
+	sort.Slice(status.WorkloadErrors, func(i, j int) bool {
+		a, b := status.WorkloadErrors[i], status.WorkloadErrors[j]
+		return workloadKey(a.Namespace, a.Kind, a.Name) < workloadKey(b.Namespace, b.Kind, b.Name)
+	})
 
-	if dss.auditMode {
+	if dss.ds.auditing(serviceCtx) {
 		log.Info().Msgf("disk autoscaling audit run at : %s", diskAutoScalerRun)
 		return status, nil
 	}
@@ -186,7 +277,11 @@ func (dss *DiskScalerService) startAutomatedScaling() error {
 	go func() {
 		for t := time.Now(); ; t = <-ticker.C {
 			diskAutoScalerRun := t.Format(timeFormat)
-			status, err := dss.run(diskAutoScalerRun)
+			start := time.Now()
+			status, err := dss.run(diskAutoScalerRun, runOptions{scheduled: true})
+			metrics.RunDuration.Observe(time.Since(start).Seconds())
+			metrics.Runs.WithLabelValues(metrics.Outcome(err)).Inc()
+			dss.recordRun(RunRecord{ID: newRunID(start), Trigger: runTriggerSchedule, StartedAt: start}, time.Now(), status, err)
 			if err != nil {
 				if lastRunFailed {
 					log.Error().
@@ -201,9 +296,6 @@ func (dss *DiskScalerService) startAutomatedScaling() error {
 				continue
 			}
 			lastRunFailed = false
-			if dss.auditMode {
-				return
-			}
 			log.Debug().Msgf("status at %s :%+v, triggered the disk scaling", diskAutoScalerRun, status)
 			if status.NumEnabled == 0 {
 				log.Debug().Msgf("No workloads have autoscaling enabled at %s", diskAutoScalerRun)
@@ -218,7 +310,7 @@ func (dss *DiskScalerService) startAutomatedScaling() error {
 
 // Using objectMeta keeps this generic regardless of the underlying workload
 // type we're considering.
-func (dss *DiskScalerService) workloadIsEnabled(meta metav1.ObjectMeta) bool {
+func (dss *DiskScalerService) workloadIsEnabled(meta metav1.ObjectMeta, policy scalingPolicy) bool {
 	// For safety while this feature is early, avoid resizing kube-system
 	// automatically.
 	if meta.Namespace == "kube-system" {
@@ -234,31 +326,25 @@ func (dss *DiskScalerService) workloadIsEnabled(meta metav1.ObjectMeta) bool {
 		return true
 	}
 
-	if dss.resizeAll {
-		if val := meta.Annotations[AnnotationExcluded]; val != "true" {
-			return true
-		}
-	}
-
-	// if annotation excluded is set to true, it is excluded from disk autoscaling!
-	if val := meta.Annotations[AnnotationExcluded]; val == "true" {
+	// if annotation excluded is set to true, it is excluded from disk autoscaling whatever the policy!
+	if policy.excluded {
 		return false
 	}
 
-	// if annotation enabled is set to true and is true
-	// it is included in the disk scaling process!
-	if val := meta.Annotations[AnnotationEnabled]; val == "true" {
+	if dss.resizeAll {
 		return true
 	}
 
-	return false
+	// enabled either by the annotation enabled set to true or by a policy selecting the workload,
+	// it is included in the disk scaling process!
+	return policy.enabled
 }
 
 // workloadIsEligible classifies the workload as either eligible or
 // non eligible based on the timestamp request.autodiskscaling.kubecost.com/lastScaling
 // being over 7 hrs ago. Reason for choosing 7 hours is that volume
 // expansion in AWS is not allowed for a span of 6 hours!
-func (dss *DiskScalerService) workloadIsEligible(meta metav1.ObjectMeta, currentRun string) bool {
+func (dss *DiskScalerService) workloadIsEligible(meta metav1.ObjectMeta, policy scalingPolicy, currentRun string) bool {
 	// For safety while this feature is early, avoid resizing kube-system
 	// automatically.
 	if meta.Namespace == "kube-system" {
@@ -275,6 +361,11 @@ func (dss *DiskScalerService) workloadIsEligible(meta metav1.ObjectMeta, current
 		return false
 	}
 
+	if !policy.inMaintenanceWindow(currentTime) {
+		log.Debug().Msgf("%s %s is outside of the maintenance windows of %s", meta.Namespace, meta.Name, policy.sourceName())
+		return false
+	}
+
 	// seen for the first time
 	if val := meta.Annotations[AnnotationLastScaled]; val == "" {
 		return true
@@ -286,11 +377,7 @@ func (dss *DiskScalerService) workloadIsEligible(meta metav1.ObjectMeta, current
 		if err != nil {
 			return false
 		}
-		interval := meta.Annotations[AnnotationInterval]
-		if interval == "" {
-			interval = diskScalingDefaultInterval
-		}
-		intervalDuration, err := time.ParseDuration(interval)
+		intervalDuration, err := pvsizingrecommendation.ParseInterval(policy.interval)
 		if err != nil {
 			return false
 		}
@@ -302,40 +389,22 @@ func (dss *DiskScalerService) workloadIsEligible(meta metav1.ObjectMeta, current
 	return false
 }
 
-func (dss *DiskScalerService) enableDeployment(ctx context.Context, namespace string, deployment string, interval string, targetUtilization string) error {
-	// For safety while this feature is early, avoid resizing kube-system
-
-	if namespace == "kube-system" {
-		return fmt.Errorf("namespace %s is not eligible for disk auto scaling", "kube-system")
-	}
-
-	if dss.excludedNamespaceRegex != nil && dss.excludedNamespaceRegex.MatchString(namespace) {
-		return fmt.Errorf("namespace %s is not eligible for disk auto scaling", namespace)
-	}
-
-	k8sDep, err := dss.basicK8sClient.
-		AppsV1().
-		Deployments(namespace).
-		Get(ctx, deployment, metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("namespace %s, deployment: %s failed to get deployment object: %v", namespace, deployment, err)
-	}
-
-	currAnnotation := k8sDep.GetAnnotations()
-	currAnnotation[AnnotationEnabled] = "true"
-	currAnnotation[AnnotationInterval] = interval
-	currAnnotation[AnnotationTargetUtilization] = targetUtilization
-	k8sDep.SetAnnotations(currAnnotation)
-	_, err = dss.basicK8sClient.AppsV1().Deployments(namespace).Update(ctx, k8sDep, metav1.UpdateOptions{})
-	if err != nil {
-		return fmt.Errorf("annotating deployment with disk auto scaler annotation failed with err: %w", err)
-	}
+func (dss *DiskScalerService) enableWorkload(ctx context.Context, namespace string, kind string, name string, interval string, targetUtilization string) error {
+	return dss.annotateWorkload(ctx, namespace, kind, name, map[string]string{
+		AnnotationEnabled:           "true",
+		AnnotationInterval:          interval,
+		AnnotationTargetUtilization: targetUtilization,
+	})
+}
 
-	log.Info().Msgf("successfully annotated deployment %s", deployment)
-	return nil
+func (dss *DiskScalerService) excludeWorkload(ctx context.Context, namespace string, kind string, name string) error {
+	return dss.annotateWorkload(ctx, namespace, kind, name, map[string]string{
+		AnnotationExcluded: "true",
+	})
 }
 
-func (dss *DiskScalerService) excludeDeployment(ctx context.Context, namespace string, deployment string) error {
+// annotateWorkload writes the disk auto scaler annotations to a workload of any supported kind.
+func (dss *DiskScalerService) annotateWorkload(ctx context.Context, namespace string, kind string, name string, annotations map[string]string) error {
 	// For safety while this feature is early, avoid resizing kube-system
 
 	if namespace == "kube-system" {
@@ -346,22 +415,16 @@ func (dss *DiskScalerService) excludeDeployment(ctx context.Context, namespace s
 		return fmt.Errorf("namespace %s is not eligible for disk auto scaling", namespace)
 	}
 
-	k8sDep, err := dss.basicK8sClient.
-		AppsV1().
-		Deployments(namespace).
-		Get(ctx, deployment, metav1.GetOptions{})
+	wl, err := dss.ds.workloadFor(kind)
 	if err != nil {
-		return fmt.Errorf("namespace %s, deployment: %s failed to get deployment object: %v", namespace, deployment, err)
+		return err
 	}
 
-	currAnnotation := k8sDep.GetAnnotations()
-	currAnnotation[AnnotationExcluded] = "true"
-	k8sDep.SetAnnotations(currAnnotation)
-	_, err = dss.basicK8sClient.AppsV1().Deployments(namespace).Update(ctx, k8sDep, metav1.UpdateOptions{})
+	err = wl.Annotate(ctx, namespace, name, annotations)
 	if err != nil {
-		return fmt.Errorf("annotating deployment with disk auto scaler annotation failed with err: %w", err)
+		return fmt.Errorf("annotating %s with disk auto scaler annotation failed with err: %w", strings.ToLower(wl.Kind()), err)
 	}
 
-	log.Info().Msgf("successfully annotated deployment %s", deployment)
+	log.Info().Msgf("successfully annotated %s %s", strings.ToLower(wl.Kind()), name)
 	return nil
 }
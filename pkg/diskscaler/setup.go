@@ -1,11 +1,13 @@
 package diskscaler
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"slices"
 	"strings"
 
+	"github.com/kubecost/disk-autoscaler/pkg/metrics"
 	"github.com/kubecost/disk-autoscaler/pkg/pvsizingrecommendation"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
@@ -16,14 +18,13 @@ import (
 
 const (
 	KubecostNamespace = "kubecost"
+
+	recommenderKubecost   = "kubecost"
+	recommenderPrometheus = "prometheus"
+	recommenderKubelet    = "kubelet"
 )
 
 func Setup(mux *http.ServeMux, clientConfig *rest.Config, k8sClient kubernetes.Interface, dynamicK8sClient *dynamic.DynamicClient) error {
-	costModelPath, err := getDiskScalerCostModelPath()
-	if len(costModelPath) == 0 {
-		return fmt.Errorf("setup of Disk Auto Scaler failed: %w", err)
-	}
-
 	auditMode := viper.GetBool("audit-mode")
 
 	resizeAll := viper.GetBool("resize-all")
@@ -46,22 +47,124 @@ func Setup(mux *http.ServeMux, clientConfig *rest.Config, k8sClient kubernetes.I
 		excludedNamespaces = append(excludedNamespaces, KubecostNamespace)
 	}
 
-	recommendationSvc := pvsizingrecommendation.NewKubecostService(costModelPath)
+	recommendationSvc, err := newRecommender(viper.GetString("recommender"), k8sClient)
+	if err != nil {
+		return fmt.Errorf("setup of Disk Auto Scaler failed: %w", err)
+	}
 	dss, err := NewDiskScalerService(clientConfig, k8sClient, dynamicK8sClient, resizeAll, auditMode, recommendationSvc, excludedNamespaces)
 	if err != nil {
 		return fmt.Errorf("failed to create disk scaler service: %w", err)
 	}
 
+	// Operations interrupted by a previous restart are finished before any new scaling starts
+	if !auditMode {
+		err = dss.ds.recoverOperations(context.Background())
+		if err != nil {
+			return fmt.Errorf("unable to recover interrupted disk scaling operations: %w", err)
+		}
+	}
+
 	err = dss.startAutomatedScaling()
 	if err != nil {
 		return fmt.Errorf("unable to start disk scaler service loop: %w", err)
 	}
 
+	// The emergency loop expands volumes and is never started in audit mode
+	if !auditMode {
+		usageSource, err := newUsageSource(viper.GetString("emergency-usage-source"), k8sClient)
+		if err != nil {
+			return fmt.Errorf("setup of Disk Auto Scaler failed: %w", err)
+		}
+		dss.startEmergencyScaling(usageSource, viper.GetDuration("emergency-poll-interval"))
+	}
+
 	mux.HandleFunc("/diskAutoScaler/enable", dss.enableDiskAutoScaling)
 	mux.HandleFunc("/diskAutoScaler/exclude", dss.excludeDiskAutoScaling)
+	mux.HandleFunc("/diskAutoScaler/rollback", dss.rollbackDiskAutoScaling)
+	mux.HandleFunc("GET /diskAutoScaler/workloads", dss.listWorkloads)
+	mux.HandleFunc("GET /diskAutoScaler/workloads/{namespace}/{name}", dss.getWorkload)
+	mux.HandleFunc("GET /diskAutoScaler/workloads/{namespace}/{name}/plan", dss.getPlan)
+	mux.HandleFunc("GET /diskAutoScaler/runs", dss.listRuns)
+	mux.HandleFunc("GET /diskAutoScaler/report", dss.getReport)
+	mux.HandleFunc("POST /diskAutoScaler/run", dss.runNow)
+	mux.HandleFunc("POST /diskAutoScaler/workloads/{namespace}/{name}/scale", dss.scaleNow)
+	mux.Handle("/metrics", metrics.Handler())
 	return nil
 }
 
+// newRecommender creates the recommender backends named in the comma separated list.
+// More than one backend are chained so that the next backend is asked when one has no recommendation.
+func newRecommender(names string, k8sClient kubernetes.Interface) (pvsizingrecommendation.Recommender, error) {
+	if names == "" {
+		names = recommenderKubecost
+	}
+	recommenders := []pvsizingrecommendation.Recommender{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case recommenderKubecost:
+			costModelPath, err := getDiskScalerCostModelPath()
+			if err != nil {
+				return nil, err
+			}
+			recommenders = append(recommenders, pvsizingrecommendation.NewKubecostService(costModelPath))
+		case recommenderPrometheus:
+			prometheusAddress := viper.GetString("prometheus-address")
+			if len(prometheusAddress) == 0 {
+				return nil, fmt.Errorf(`a Prometheus HTTP base path is required by the prometheus recommender. Set with DAS_PROMETHEUS_ADDRESS Example: DAS_PROMETHEUS_ADDRESS=http://prometheus-server.monitoring:80`)
+			}
+			recommenders = append(recommenders, pvsizingrecommendation.NewPrometheusService(prometheusAddress, viper.GetFloat64("storage-price-per-gib")))
+		case recommenderKubelet:
+			recommenders = append(recommenders, pvsizingrecommendation.NewKubeletService(k8sClient, pvsizingrecommendation.KubeletServiceOptions{
+				ScrapeInterval:   viper.GetDuration("kubelet-scrape-interval"),
+				Retention:        viper.GetDuration("kubelet-history-retention"),
+				Percentile:       viper.GetFloat64("kubelet-usage-percentile"),
+				PricePerGiBMonth: viper.GetFloat64("storage-price-per-gib"),
+				HistoryNamespace: diskScalerNamespace(),
+				HistoryConfigMap: viper.GetString("kubelet-history-configmap"),
+			}))
+		default:
+			return nil, fmt.Errorf("unsupported recommender %q, supported recommenders are %s, %s and %s", name, recommenderKubecost, recommenderPrometheus, recommenderKubelet)
+		}
+		log.Info().Msgf("disk auto scaler is using the %s recommender", name)
+	}
+	var recommender pvsizingrecommendation.Recommender = pvsizingrecommendation.NewChainRecommender(recommenders...)
+	if len(recommenders) == 1 {
+		recommender = recommenders[0]
+	}
+
+	forecast := viper.GetString("forecast")
+	if len(forecast) == 0 {
+		return recommender, nil
+	}
+	// The usage history for the forecast comes from the first recommender which keeps one
+	for _, r := range recommenders {
+		history, ok := r.(pvsizingrecommendation.UsageHistoryProvider)
+		if !ok {
+			continue
+		}
+		log.Info().Msgf("disk auto scaler is forecasting usage growth with the %s method", forecast)
+		return pvsizingrecommendation.NewForecastRecommender(recommender, history, strings.ToLower(forecast), viper.GetDuration("forecast-lookback"), viper.GetFloat64("storage-price-per-gib"))
+	}
+	return nil, fmt.Errorf("forecast %s requires the usage history of the %s or %s recommender", forecast, recommenderPrometheus, recommenderKubelet)
+}
+
+// newUsageSource creates the source of the current usage of volumes polled by the emergency loop.
+func newUsageSource(name string, k8sClient kubernetes.Interface) (pvsizingrecommendation.UsageSource, error) {
+	switch strings.ToLower(name) {
+	case "", recommenderKubelet:
+		return pvsizingrecommendation.NewKubeletUsageSource(k8sClient), nil
+	case recommenderPrometheus:
+		prometheusAddress := viper.GetString("prometheus-address")
+		if len(prometheusAddress) == 0 {
+			return nil, fmt.Errorf(`a Prometheus HTTP base path is required by the prometheus usage source. Set with DAS_PROMETHEUS_ADDRESS Example: DAS_PROMETHEUS_ADDRESS=http://prometheus-server.monitoring:80`)
+		}
+		return pvsizingrecommendation.NewPrometheusService(prometheusAddress, 0), nil
+	default:
+		return nil, fmt.Errorf("unsupported emergency usage source %q, supported sources are %s and %s", name, recommenderKubelet, recommenderPrometheus)
+	}
+}
+
 func getDiskScalerCostModelPath() (string, error) {
 	costModelPath := viper.GetString("cost-model-path")
 	if len(costModelPath) == 0 {
@@ -69,3 +172,12 @@ func getDiskScalerCostModelPath() (string, error) {
 	}
 	return costModelPath, nil
 }
+
+// diskScalerNamespace returns the namespace the disk auto scaler is installed in.
+func diskScalerNamespace() string {
+	namespace := viper.GetString("namespace")
+	if len(namespace) == 0 {
+		return KubecostNamespace
+	}
+	return namespace
+}
@@ -0,0 +1,156 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	snapshotPollInterval = 5 * time.Second
+	// Snapshots of large volumes may take a while to be ready to use
+	defaultSnapshotReadyTimeout = 30 * time.Minute
+	defaultSnapshotRetention    = 7 * 24 * time.Hour
+	snapshotNameTimeFormat      = "20060102150405"
+)
+
+var volumeSnapshotGVR = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+// snapshotter takes a CSI VolumeSnapshot of a claim before its data is copied to a new volume,
+// which is the rollback path when a copy corrupts data. Snapshots are deleted once their
+// retention has expired.
+type snapshotter struct {
+	client       dynamic.Interface
+	disabled     bool
+	className    string
+	retention    time.Duration
+	readyTimeout time.Duration
+}
+
+type snapshotterOptions struct {
+	Disabled bool
+	// ClassName is the VolumeSnapshotClass of the snapshots, the default class of the driver when empty
+	ClassName    string
+	Retention    time.Duration
+	ReadyTimeout time.Duration
+}
+
+func newSnapshotter(client dynamic.Interface, options snapshotterOptions) *snapshotter {
+	if options.Retention <= 0 {
+		options.Retention = defaultSnapshotRetention
+	}
+	if options.ReadyTimeout <= 0 {
+		options.ReadyTimeout = defaultSnapshotReadyTimeout
+	}
+	return &snapshotter{
+		client:       client,
+		disabled:     options.Disabled,
+		className:    options.ClassName,
+		retention:    options.Retention,
+		readyTimeout: options.ReadyTimeout,
+	}
+}
+
+// snapshot takes a VolumeSnapshot of the claim and waits for it to be ready to use. It returns
+// the name of the snapshot, or an empty name when snapshots are disabled.
+func (s *snapshotter) snapshot(ctx context.Context, namespace string, pvcName string, now time.Time) (string, error) {
+	if s.disabled {
+		return "", nil
+	}
+	name := fmt.Sprintf("%s-%s", pvcName, now.UTC().Format(snapshotNameTimeFormat))
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": pvcName,
+		},
+	}
+	if s.className != "" {
+		spec["volumeSnapshotClassName"] = s.className
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": volumeSnapshotGVR.GroupVersion().String(),
+		"kind":       "VolumeSnapshot",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+			"labels": map[string]interface{}{
+				PVCAnnotationCreatedBy: DiskAutoScaler,
+			},
+			"annotations": map[string]interface{}{
+				SnapshotAnnotationSourcePVC: pvcName,
+				SnapshotAnnotationExpiresAt: now.Add(s.retention).Format(timeFormat),
+			},
+		},
+		"spec": spec,
+	}}
+
+	snapshots := s.client.Resource(volumeSnapshotGVR).Namespace(namespace)
+	_, err := snapshots.Create(ctx, obj, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to create volume snapshot of pvc %s: %w", pvcName, err)
+	}
+	log.Info().Msgf("ctx: %s, waiting for volume snapshot %s of pvc %s to be ready to use", ctx.Value(diskScalerRunContextKey), name, pvcName)
+
+	var snapshotErr string
+	err = wait.PollUntilContextTimeout(ctx, snapshotPollInterval, s.readyTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := snapshots.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		snapshotErr, _, _ = unstructured.NestedString(current.Object, "status", "error", "message")
+		readyToUse, _, _ := unstructured.NestedBool(current.Object, "status", "readyToUse")
+		return readyToUse, nil
+	})
+	if err != nil {
+		// A snapshot which never became ready is no rollback path, so it is not kept
+		if deleteErr := snapshots.Delete(ctx, name, metav1.DeleteOptions{}); deleteErr != nil && !k8serrors.IsNotFound(deleteErr) {
+			log.Error().Msgf("ctx: %s, unable to delete volume snapshot %s which is not ready to use: %v", ctx.Value(diskScalerRunContextKey), name, deleteErr)
+		}
+		if snapshotErr != "" {
+			return "", fmt.Errorf("volume snapshot %s of pvc %s failed: %s", name, pvcName, snapshotErr)
+		}
+		return "", fmt.Errorf("volume snapshot %s of pvc %s was not ready to use within %s: %w", name, pvcName, s.readyTimeout, err)
+	}
+	log.Info().Msgf("ctx: %s, volume snapshot %s of pvc %s is ready to use and kept until %s", ctx.Value(diskScalerRunContextKey), name, pvcName, now.Add(s.retention).Format(timeFormat))
+	return name, nil
+}
+
+// prune deletes the snapshots taken by the disk auto scaler whose retention has expired.
+// Clusters without the VolumeSnapshot custom resource definition have no snapshots to prune.
+func (s *snapshotter) prune(ctx context.Context, now time.Time) error {
+	list, err := s.client.Resource(volumeSnapshotGVR).Namespace("").List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{PVCAnnotationCreatedBy: DiskAutoScaler}).String(),
+	})
+	if err != nil {
+		if k8serrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to list volume snapshots: %w", err)
+	}
+	for _, item := range list.Items {
+		expiresAt, err := time.Parse(timeFormat, item.GetAnnotations()[SnapshotAnnotationExpiresAt])
+		if err != nil || now.Before(expiresAt) {
+			continue
+		}
+		err = s.client.Resource(volumeSnapshotGVR).Namespace(item.GetNamespace()).Delete(ctx, item.GetName(), metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			log.Error().Msgf("unable to delete expired volume snapshot %s in namespace %s: %v", item.GetName(), item.GetNamespace(), err)
+			continue
+		}
+		log.Info().Msgf("deleted volume snapshot %s of pvc %s in namespace %s as its retention expired at %s", item.GetName(), item.GetAnnotations()[SnapshotAnnotationSourcePVC], item.GetNamespace(), expiresAt.Format(timeFormat))
+	}
+	return nil
+}
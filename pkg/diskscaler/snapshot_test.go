@@ -0,0 +1,130 @@
+package diskscaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newSnapshotObject(name string, labels map[string]interface{}, expiresAt time.Time) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "snapshot.storage.k8s.io/v1",
+		"kind":       "VolumeSnapshot",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "test",
+			"labels":    labels,
+			"annotations": map[string]interface{}{
+				SnapshotAnnotationExpiresAt: expiresAt.Format(timeFormat),
+			},
+		},
+	}}
+}
+
+func Test_snapshotterSnapshot(t *testing.T) {
+	type testCase struct {
+		name             string
+		disabled         bool
+		status           map[string]interface{}
+		expectedName     string
+		expectErr        bool
+		expectedSnapshot bool
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []testCase{
+		{
+			name:             "when the snapshot becomes ready to use",
+			status:           map[string]interface{}{"readyToUse": true},
+			expectedName:     "data-20240101120000",
+			expectedSnapshot: true,
+		},
+		{
+			name:      "when the snapshot fails it is deleted",
+			status:    map[string]interface{}{"readyToUse": false, "error": map[string]interface{}{"message": "quota exceeded"}},
+			expectErr: true,
+		},
+		{
+			name:     "when snapshots are disabled no snapshot is taken",
+			disabled: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		client := newFakeDynamicClient()
+		// The snapshot controller is stood in for by setting the status on creation
+		client.PrependReactor("create", "volumesnapshots", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			obj := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+			obj.Object["status"] = tc.status
+			return false, nil, nil
+		})
+		s := newSnapshotter(client, snapshotterOptions{Disabled: tc.disabled, ClassName: "csi-snapclass", ReadyTimeout: 10 * time.Millisecond})
+
+		name, err := s.snapshot(context.Background(), "test", "data", now)
+		if tc.expectErr && err == nil {
+			t.Fatalf("test '%s': expected err but received none", tc.name)
+		}
+		if !tc.expectErr && err != nil {
+			t.Fatalf("test '%s': received unexpected err: %s", tc.name, err)
+		}
+		if name != tc.expectedName {
+			t.Fatalf("test '%s': expected snapshot %q but received %q", tc.name, tc.expectedName, name)
+		}
+
+		list, err := client.Resource(volumeSnapshotGVR).Namespace("test").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("test '%s': unable to list snapshots: %s", tc.name, err)
+		}
+		if !tc.expectedSnapshot {
+			if len(list.Items) != 0 {
+				t.Fatalf("test '%s': expected no snapshot to be kept but found %d", tc.name, len(list.Items))
+			}
+			continue
+		}
+		if len(list.Items) != 1 {
+			t.Fatalf("test '%s': expected 1 snapshot but found %d", tc.name, len(list.Items))
+		}
+		snapshot := list.Items[0]
+		source, _, _ := unstructured.NestedString(snapshot.Object, "spec", "source", "persistentVolumeClaimName")
+		class, _, _ := unstructured.NestedString(snapshot.Object, "spec", "volumeSnapshotClassName")
+		if source != "data" || class != "csi-snapclass" {
+			t.Fatalf("test '%s': expected a snapshot of pvc data with class csi-snapclass but received %+v", tc.name, snapshot.Object["spec"])
+		}
+		if expiresAt := snapshot.GetAnnotations()[SnapshotAnnotationExpiresAt]; expiresAt != now.Add(defaultSnapshotRetention).Format(timeFormat) {
+			t.Fatalf("test '%s': expected snapshot to expire after the default retention but received %s", tc.name, expiresAt)
+		}
+	}
+}
+
+func Test_snapshotterPrune(t *testing.T) {
+	now := time.Now()
+	ours := map[string]interface{}{PVCAnnotationCreatedBy: DiskAutoScaler}
+	client := newFakeDynamicClient(
+		newSnapshotObject("expired", ours, now.Add(-time.Hour)),
+		newSnapshotObject("retained", ours, now.Add(time.Hour)),
+		newSnapshotObject("not-ours", map[string]interface{}{}, now.Add(-time.Hour)),
+	)
+	s := newSnapshotter(client, snapshotterOptions{})
+
+	err := s.prune(context.Background(), now)
+	if err != nil {
+		t.Fatalf("received unexpected err: %s", err)
+	}
+
+	list, err := client.Resource(volumeSnapshotGVR).Namespace("test").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unable to list snapshots: %s", err)
+	}
+	remaining := map[string]bool{}
+	for _, item := range list.Items {
+		remaining[item.GetName()] = true
+	}
+	if remaining["expired"] || !remaining["retained"] || !remaining["not-ours"] {
+		t.Fatalf("expected only the expired snapshot taken by disk auto scaler to be deleted but %v remain", remaining)
+	}
+}
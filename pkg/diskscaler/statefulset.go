@@ -0,0 +1,380 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	statefulSetPollInterval = 5 * time.Second
+	// Replicas of a StatefulSet are usually databases which may take a while to become ready again
+	statefulSetReadyTimeout = 10 * time.Minute
+)
+
+// runStatefulSetDiskScalingWorkflow initiates a disk scaling workflow for a StatefulSet in the given namespace.
+// Every PVC created from a volumeClaimTemplate is resized on its own. Expansion is done online while
+// the StatefulSet keeps running when the provisioner supports it. Shrinking and offline expansion
+// release one ordinal at a time, starting with the highest ordinal, and wait for the StatefulSet
+// to be ready again before moving on. A StatefulSet can only be scaled in from the top, so releasing
+// ordinal k scales it to k-start replicas and stops every ordinal from k up, and releasing the lowest
+// ordinal stops the whole set. The lowest ordinal is only released when the StatefulSet allows it
+// with the allowStop annotation, otherwise its claims are skipped.
+func (ds *DiskScaler) runStatefulSetDiskScalingWorkflow(ctx context.Context, sts *statefulSetWorkload, namespace, statefulSet string) error {
+	ref, volMap, err := ds.getStatefulSetPVCMap(ctx, namespace, statefulSet)
+	if err != nil {
+		return fmt.Errorf("disk scaling failed : %w", err)
+	}
+
+	// No further action is needed if audit mode is enabled
+	if ds.auditing(ctx) {
+		ds.recordAudit(ctx, ref, volMap, time.Now())
+		return nil
+	}
+
+	ordinalsToCopy := map[int][]string{}
+	for name, pvcDetails := range volMap {
+		if isEqualQuantity(pvcDetails.currentSize, pvcDetails.resizeTo) {
+			log.Info().Msgf("ctx: %s, PVC has %s optimal storage at this time, so no action taken from disk auto scaler", ctx.Value(diskScalerRunContextKey), name)
+			continue
+		}
+		if pvcDetails.canExpand() {
+			if remaining := pvcDetails.driver.CooldownRemaining(pvcDetails.lastResized, time.Now()); remaining > 0 {
+				log.Info().Msgf("ctx: %s, PVC %s was resized at %s and provisioner %s allows another resize in %s, so no action taken from disk auto scaler", ctx.Value(diskScalerRunContextKey), name, pvcDetails.lastResized.Format(timeFormat), pvcDetails.provisioner, remaining.Round(time.Minute))
+				ds.recordEvent(ctx, nil, pvcDetails.claimRef, v1.EventTypeNormal, EventReasonResizeSkipped, "pvc was resized at %s and provisioner %s allows another resize in %s", pvcDetails.lastResized.Format(timeFormat), pvcDetails.provisioner, remaining.Round(time.Minute))
+				pvcDetails.resizeTo = pvcDetails.currentSize
+				continue
+			}
+		}
+		// Provisioners which cannot expand a volume while it is attached are expanded with the ordinal released
+		if pvcDetails.canExpand() && pvcDetails.driver.OnlineExpansion {
+			log.Info().Msgf("ctx: %s, disk auto scaler is performing action to increase the volume size for pvc %s from %s to %s", ctx.Value(diskScalerRunContextKey), name, pvcDetails.currentSize.String(), pvcDetails.resizeTo.String())
+			pvcDetails.err = ds.patchPVCWithResize(ctx, namespace, name, pvcDetails.resizeTo)
+			ds.recordExpansion(ctx, ref, name, pvcDetails)
+			continue
+		}
+		if pvcDetails.ordinal == pvcDetails.ordinalStart && !pvcDetails.allowStop {
+			log.Warn().Msgf("ctx: %s, resizing PVC %s would scale statefulset %s to 0 replicas and it is not annotated with %s, so no action taken from disk auto scaler", ctx.Value(diskScalerRunContextKey), name, statefulSet, AnnotationAllowStop)
+			ds.recordEvent(ctx, ref, pvcDetails.claimRef, v1.EventTypeWarning, EventReasonResizeSkipped, "resizing pvc %s releases the lowest ordinal %d which scales the statefulset to 0 replicas, annotate it with %s=true to allow it", name, pvcDetails.ordinal, AnnotationAllowStop)
+			pvcDetails.resizeTo = pvcDetails.currentSize
+			continue
+		}
+		ordinalsToCopy[pvcDetails.ordinal] = append(ordinalsToCopy[pvcDetails.ordinal], name)
+	}
+
+	// Highest ordinal first, a StatefulSet can only be scaled in from the top so every
+	// lower ordinal keeps serving while this one and those above it are stopped.
+	ordinals := make([]int, 0, len(ordinalsToCopy))
+	for ordinal := range ordinalsToCopy {
+		ordinals = append(ordinals, ordinal)
+	}
+	slices.Sort(ordinals)
+	slices.Reverse(ordinals)
+
+	var abortErr error
+	for i, ordinal := range ordinals {
+		claims := ordinalsToCopy[ordinal]
+		if abortErr != nil {
+			for _, name := range claims {
+				volMap[name].err = abortErr
+			}
+			continue
+		}
+
+		// Releasing an ordinal is journaled so that the StatefulSet is restored after a restart
+		op := newOperation(namespace, workloadKindStatefulSet, statefulSet)
+		op.OriginalScale, err = sts.Replicas(ctx, namespace, statefulSet)
+		if err == nil {
+			err = ds.journal.record(ctx, op)
+		}
+		if err != nil {
+			abortErr = fmt.Errorf("unable to journal resize of ordinal %d of statefulset %s: %w", ordinal, statefulSet, err)
+			for _, name := range claims {
+				volMap[name].err = abortErr
+			}
+			continue
+		}
+
+		var originalScale int32
+		err = withRetries(ctx, "scale statefulset", func() error {
+			var scaleErr error
+			originalScale, scaleErr = sts.scale(ctx, namespace, statefulSet, int32(ordinal-volMap[claims[0]].ordinalStart))
+			return scaleErr
+		})
+		if err != nil {
+			ds.completeOperation(ctx, op)
+			ds.recordEvent(ctx, ref, nil, v1.EventTypeWarning, EventReasonResizeFailed, "unable to scale down to release ordinal %d to resize its volumes: %v", ordinal, err)
+			abortErr = fmt.Errorf("unable to scale statefulset %s to release ordinal %d: %w", statefulSet, ordinal, err)
+			for _, name := range claims {
+				volMap[name].err = abortErr
+			}
+			continue
+		}
+		ds.recordEvent(ctx, ref, nil, v1.EventTypeNormal, EventReasonScaledDown, "scaled down from %d to %d replicas to release ordinal %d to resize its volumes", originalScale, ordinal-volMap[claims[0]].ordinalStart, ordinal)
+		op.Phase = operationQuiesced
+		op.OriginalScale = originalScale
+		ds.checkpoint(ctx, op)
+
+		for _, name := range claims {
+			pvcDetails := volMap[name]
+			if pvcDetails.canExpand() {
+				log.Info().Msgf("ctx: %s, disk auto scaler is performing action to increase the volume size for pvc %s from %s to %s while it is detached", ctx.Value(diskScalerRunContextKey), name, pvcDetails.currentSize.String(), pvcDetails.resizeTo.String())
+				pvcDetails.err = ds.patchPVCWithResize(ctx, namespace, name, pvcDetails.resizeTo)
+				ds.recordExpansion(ctx, ref, name, pvcDetails)
+				continue
+			}
+			log.Info().Msgf("ctx: %s, disk auto scaler is performing action to resize the volume for pvc %s from %s to %s", ctx.Value(diskScalerRunContextKey), name, pvcDetails.currentSize.String(), pvcDetails.resizeTo.String())
+			pvcDetails.err = ds.copyResizeKeepingName(ctx, namespace, name, pvcDetails, op)
+			ds.recordRebind(ctx, ref, name, pvcDetails)
+		}
+
+		// The ordinal must not start without a claim whose rebind is incomplete, the controller would
+		// recreate it empty from the volumeClaimTemplate, so the operation stays in the journal
+		if err := incompleteRebind(volMap); err != nil {
+			ds.recordEvent(ctx, ref, nil, v1.EventTypeWarning, EventReasonResizeFailed, "not scaled back up to %d replicas because a volume of ordinal %d is not bound after its rebind failed: %v", originalScale, ordinal, err)
+			rebindErr := fmt.Errorf("disk scaling failed, statefulset %s is left with ordinal %d released: %w", statefulSet, ordinal, err)
+			for _, lower := range ordinals[i+1:] {
+				for _, name := range ordinalsToCopy[lower] {
+					volMap[name].err = rebindErr
+				}
+			}
+			recordResizes(ctx, namespace, volMap)
+			ds.recordHistory(ref, volMap, time.Now())
+			return rebindErr
+		}
+
+		err = withRetries(ctx, "scale statefulset", func() error {
+			return sts.Restore(ctx, namespace, statefulSet, originalScale)
+		})
+		if err != nil {
+			ds.recordEvent(ctx, ref, nil, v1.EventTypeWarning, EventReasonResizeFailed, "unable to scale back up to %d replicas after resizing the volumes of ordinal %d: %v", originalScale, ordinal, err)
+			restoreErr := fmt.Errorf("ctx: %s, disk scaling failed to restore statefulset %s to %d replicas: %w", ctx.Value(diskScalerRunContextKey), statefulSet, originalScale, err)
+			// The volumes of the lower ordinals are not resized while the set is not restored
+			for _, lower := range ordinals[i+1:] {
+				for _, name := range ordinalsToCopy[lower] {
+					volMap[name].err = restoreErr
+				}
+			}
+			recordResizes(ctx, namespace, volMap)
+			ds.recordHistory(ref, volMap, time.Now())
+			return restoreErr
+		}
+		ds.recordEvent(ctx, ref, nil, v1.EventTypeNormal, EventReasonScaledUp, "scaled back up to %d replicas after resizing the volumes of ordinal %d", originalScale, ordinal)
+		if op.rebinding() {
+			op.Phase = operationRestored
+			ds.checkpoint(ctx, op)
+		} else {
+			ds.completeOperation(ctx, op)
+		}
+
+		// Do not take the next ordinal down until the set is back at full strength
+		err = sts.waitReady(ctx, namespace, statefulSet, originalScale)
+		if err != nil {
+			abortErr = fmt.Errorf("statefulset %s did not become ready after resizing ordinal %d: %w", statefulSet, ordinal, err)
+			ds.recordEvent(ctx, ref, nil, v1.EventTypeWarning, EventReasonResizeFailed, "not ready after resizing the volumes of ordinal %d, the volumes of lower ordinals are not resized: %v", ordinal, err)
+		}
+	}
+
+	err = ds.updateStatefulSetClaimTemplates(ctx, namespace, statefulSet, claimTemplateSizes(volMap))
+	if err != nil {
+		log.Error().Msgf("ctx: %s, unable to update volumeClaimTemplates of statefulset %s: %v", ctx.Value(diskScalerRunContextKey), statefulSet, err)
+	}
+
+	err = withRetries(ctx, "annotate statefulset with disk auto scaler annotations", func() error {
+		return sts.Annotate(ctx, namespace, statefulSet, map[string]string{AnnotationLastScaled: time.Now().Format(timeFormat)})
+	})
+	if err != nil {
+		return fmt.Errorf("ctx: %s, disk scaling annotating statefulset failed: %w", ctx.Value(diskScalerRunContextKey), err)
+	}
+
+	recordResizes(ctx, namespace, volMap)
+	ds.recordHistory(ref, volMap, time.Now())
+	failedPVCS := make([]string, 0)
+	for pvcName, pvcDetails := range volMap {
+		if pvcDetails.err != nil {
+			failedPVCS = append(failedPVCS, pvcName)
+			log.Error().Msgf("ctx: %s, disk scaling of pvc with name: %s failed with err: %v", ctx.Value(diskScalerRunContextKey), pvcName, pvcDetails.err)
+		}
+	}
+
+	if len(failedPVCS) == 0 {
+		return nil
+	}
+
+	if len(failedPVCS) == len(volMap) {
+		return &DiskScalingAllFailedError{namespace: namespace, kind: workloadKindStatefulSet, workload: statefulSet}
+	}
+	return &DiskScalingPartialFailedError{namespace: namespace, kind: workloadKindStatefulSet, workload: statefulSet, pvc: failedPVCS}
+}
+
+// getStatefulSetPVCMap maps every PVC created from the volumeClaimTemplates of the StatefulSet
+// for each of its ordinals to the details needed to resize it, along with the reference of the StatefulSet.
+func (ds *DiskScaler) getStatefulSetPVCMap(ctx context.Context, namespace string, statefulSetName string) (*v1.ObjectReference, map[string]*pvcDetails, error) {
+	volumeMap := map[string]*pvcDetails{}
+	sts, err := ds.basicK8sClient.AppsV1().StatefulSets(namespace).Get(ctx, statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		log.Error().Msgf("ctx: %s, unable to get statefulset for the name %s err: %v", ctx.Value(diskScalerRunContextKey), statefulSetName, err)
+		return nil, volumeMap, fmt.Errorf("unable to get statefulset for the name %s err: %w", statefulSetName, err)
+	}
+
+	policy := ds.policyFor(sts.ObjectMeta)
+	ref := workloadReference(workloadKindStatefulSet, sts.ObjectMeta)
+
+	// Claims referenced directly in the pod template are shared by every replica
+	// and cannot be resized one ordinal at a time.
+	for _, vol := range sts.Spec.Template.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil {
+			log.Warn().Msgf("ctx: %s, statefulset %s mounts shared pvc %s which is not resized by disk auto scaler", ctx.Value(diskScalerRunContextKey), statefulSetName, vol.PersistentVolumeClaim.ClaimName)
+		}
+	}
+
+	start := ordinalStart(sts)
+	replicas := statefulSetReplicas(sts)
+	allowStop := sts.Annotations[AnnotationAllowStop] == "true"
+	for _, template := range sts.Spec.VolumeClaimTemplates {
+		for ordinal := start; ordinal < start+int(replicas); ordinal++ {
+			pvcName := fmt.Sprintf("%s-%s-%d", template.Name, statefulSetName, ordinal)
+			details, err := ds.getPVCDetails(ctx, namespace, ref, pvcName, policy)
+			if err != nil {
+				return ref, map[string]*pvcDetails{}, err
+			}
+			if details == nil {
+				continue
+			}
+			details.claimTemplate = template.Name
+			details.ordinal = ordinal
+			details.ordinalStart = start
+			details.allowStop = allowStop
+			details.workloadSpec = &sts.Spec.Template.Spec
+			volumeMap[pvcName] = details
+		}
+	}
+	return ref, volumeMap, nil
+}
+
+// claimTemplateSizes returns the size each volumeClaimTemplate should request after the
+// resize, which is the largest size among the claims created from it.
+func claimTemplateSizes(volMap map[string]*pvcDetails) map[string]resource.Quantity {
+	sizes := map[string]resource.Quantity{}
+	for _, pvcDetails := range volMap {
+		size := pvcDetails.resizeTo
+		if pvcDetails.err != nil {
+			size = pvcDetails.currentSize
+		}
+		if current, ok := sizes[pvcDetails.claimTemplate]; ok && !isGreaterQuantity(current, size) {
+			continue
+		}
+		sizes[pvcDetails.claimTemplate] = size
+	}
+	return sizes
+}
+
+// updateStatefulSetClaimTemplates sets the storage request of the volumeClaimTemplates to the given sizes.
+// volumeClaimTemplates cannot be changed in place, so the StatefulSet is deleted while orphaning its
+// pods and claims and recreated with the new templates. The recreated StatefulSet adopts the running
+// pods and the unchanged pod template means they are not restarted.
+func (ds *DiskScaler) updateStatefulSetClaimTemplates(ctx context.Context, namespace string, statefulSetName string, sizes map[string]resource.Quantity) error {
+	statefulSets := ds.basicK8sClient.AppsV1().StatefulSets(namespace)
+	sts, err := statefulSets.Get(ctx, statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get statefulset %s: %w", statefulSetName, err)
+	}
+
+	changed := false
+	for i := range sts.Spec.VolumeClaimTemplates {
+		template := &sts.Spec.VolumeClaimTemplates[i]
+		size, ok := sizes[template.Name]
+		if !ok || isEqualQuantity(template.Spec.Resources.Requests[v1.ResourceStorage], size) {
+			continue
+		}
+		if template.Spec.Resources.Requests == nil {
+			template.Spec.Resources.Requests = v1.ResourceList{}
+		}
+		template.Spec.Resources.Requests[v1.ResourceStorage] = size
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	recreated := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        sts.Name,
+			Namespace:   sts.Namespace,
+			Labels:      sts.Labels,
+			Annotations: sts.Annotations,
+		},
+		Spec: sts.Spec,
+	}
+	// The StatefulSet only exists in the journal while it is deleted, so that it is recreated after a restart
+	op := newOperation(namespace, workloadKindStatefulSet, statefulSetName)
+	op.Phase = operationRecreating
+	op.StatefulSet = recreated
+	err = ds.journal.record(ctx, op)
+	if err != nil {
+		return fmt.Errorf("unable to journal statefulset %s before recreating it: %w", statefulSetName, err)
+	}
+
+	orphan := metav1.DeletePropagationOrphan
+	err = statefulSets.Delete(ctx, statefulSetName, metav1.DeleteOptions{PropagationPolicy: &orphan})
+	if err != nil {
+		ds.completeOperation(ctx, op)
+		return fmt.Errorf("unable to delete statefulset %s to update its volumeClaimTemplates: %w", statefulSetName, err)
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, statefulSetPollInterval, diskScalingOperationTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := statefulSets.Get(ctx, statefulSetName, metav1.GetOptions{})
+		return k8serrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		// The StatefulSet is recreated from the journal once its deletion completes and disk auto scaler restarts
+		ds.recordEvent(ctx, workloadReference(workloadKindStatefulSet, sts.ObjectMeta), nil, v1.EventTypeWarning, EventReasonResizeFailed, "not deleted in time to update its volumeClaimTemplates: %v", err)
+		return fmt.Errorf("timeout waiting for statefulset %s to be deleted: %w", statefulSetName, err)
+	}
+
+	err = ds.recreateStatefulSet(ctx, recreated)
+	if err != nil {
+		ds.recordEvent(ctx, workloadReference(workloadKindStatefulSet, sts.ObjectMeta), nil, v1.EventTypeWarning, EventReasonResizeFailed, "unable to recreate it to update its volumeClaimTemplates, its pods are orphaned until it is recreated: %v", err)
+		return fmt.Errorf("unable to recreate statefulset %s, its pods are orphaned and it is recreated from the journal on the next start: %w", statefulSetName, err)
+	}
+	ds.completeOperation(ctx, op)
+
+	log.Info().Msgf("ctx: %s, successfully updated volumeClaimTemplates of statefulset %s", ctx.Value(diskScalerRunContextKey), statefulSetName)
+	return nil
+}
+
+// recreateStatefulSet creates the StatefulSet deleted to update its volumeClaimTemplates, unless it already exists.
+func (ds *DiskScaler) recreateStatefulSet(ctx context.Context, sts *appsv1.StatefulSet) error {
+	return withRetries(ctx, fmt.Sprintf("recreate statefulset %s", sts.Name), func() error {
+		_, err := ds.basicK8sClient.AppsV1().StatefulSets(sts.Namespace).Create(ctx, sts, metav1.CreateOptions{})
+		if k8serrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// statefulSetReplicas returns the desired replicas of the StatefulSet, which defaults to 1.
+func statefulSetReplicas(sts *appsv1.StatefulSet) int32 {
+	if sts.Spec.Replicas == nil {
+		return 1
+	}
+	return *sts.Spec.Replicas
+}
+
+// ordinalStart returns the first ordinal assigned to the replicas of the StatefulSet.
+func ordinalStart(sts *appsv1.StatefulSet) int {
+	if sts.Spec.Ordinals == nil {
+		return 0
+	}
+	return int(sts.Spec.Ordinals.Start)
+}
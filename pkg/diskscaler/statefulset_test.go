@@ -0,0 +1,291 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// newTestStatefulSet returns a StatefulSet with a data volumeClaimTemplate of 100Gi and the given replicas, all of them ready.
+func newTestStatefulSet(replicas int32, annotations map[string]string) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "test", Annotations: annotations},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			VolumeClaimTemplates: []v1.PersistentVolumeClaim{{
+				ObjectMeta: metav1.ObjectMeta{Name: "data"},
+				Spec: v1.PersistentVolumeClaimSpec{Resources: v1.VolumeResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("100Gi")},
+				}},
+			}},
+		},
+		Status: appsv1.StatefulSetStatus{ReadyReplicas: replicas},
+	}
+}
+
+func Test_runStatefulSetDiskScalingWorkflow(t *testing.T) {
+	type testCase struct {
+		name                string
+		annotations         map[string]string
+		readyReplicas       int32
+		failRestore         bool
+		expectedScales      []int32
+		expectedExpanded    []string
+		expectErr           bool
+		expectTemplatesSize string
+	}
+
+	testCases := []testCase{
+		{
+			name:                "when every ordinal is expanded offline highest first",
+			annotations:         map[string]string{AnnotationAllowStop: "true"},
+			readyReplicas:       3,
+			expectedScales:      []int32{2, 3, 1, 3, 0, 3},
+			expectedExpanded:    []string{"data-db-2", "data-db-1", "data-db-0"},
+			expectTemplatesSize: "200Gi",
+		},
+		{
+			name:                "when the statefulset does not allow the lowest ordinal to stop",
+			readyReplicas:       3,
+			expectedScales:      []int32{2, 3, 1, 3},
+			expectedExpanded:    []string{"data-db-2", "data-db-1"},
+			expectTemplatesSize: "200Gi",
+		},
+		{
+			name:                "when the statefulset is not ready after the highest ordinal",
+			annotations:         map[string]string{AnnotationAllowStop: "true"},
+			readyReplicas:       1,
+			expectedScales:      []int32{2, 3},
+			expectedExpanded:    []string{"data-db-2"},
+			expectErr:           true,
+			expectTemplatesSize: "200Gi",
+		},
+		{
+			name:                "when the statefulset cannot be restored after the highest ordinal",
+			annotations:         map[string]string{AnnotationAllowStop: "true"},
+			readyReplicas:       3,
+			failRestore:         true,
+			expectedScales:      []int32{2, 3, 3, 3},
+			expectedExpanded:    []string{"data-db-2"},
+			expectErr:           true,
+			expectTemplatesSize: "100Gi",
+		},
+	}
+
+	for _, tc := range testCases {
+		storageClassName := "longhorn"
+		bindingMode := storagev1.VolumeBindingImmediate
+		allowExpansion := true
+		sts := newTestStatefulSet(3, tc.annotations)
+		sts.Status.ReadyReplicas = tc.readyReplicas
+		objects := []runtime.Object{sts, &storagev1.StorageClass{
+			ObjectMeta:           metav1.ObjectMeta{Name: storageClassName},
+			Provisioner:          "driver.longhorn.io",
+			VolumeBindingMode:    &bindingMode,
+			AllowVolumeExpansion: &allowExpansion,
+		}}
+		recommender := stubRecommender{}
+		for ordinal := 0; ordinal < 3; ordinal++ {
+			pvName := fmt.Sprintf("pv-%d", ordinal)
+			objects = append(objects,
+				&v1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("data-db-%d", ordinal), Namespace: "test"},
+					Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &storageClassName, VolumeName: pvName},
+					Status:     v1.PersistentVolumeClaimStatus{Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("100Gi")}},
+				},
+				&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: pvName}},
+			)
+			recommender[pvName] = "200Gi"
+		}
+		client := fake.NewSimpleClientset(objects...)
+
+		// the fake clientset does not serve the scale subresource, so it is backed by the replicas scaled to.
+		replicas := int32(3)
+		scales := []int32{}
+		client.PrependReactor("get", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "scale" {
+				return false, nil, nil
+			}
+			return true, &autoscalingv1.Scale{
+				ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "test"},
+				Spec:       autoscalingv1.ScaleSpec{Replicas: replicas},
+			}, nil
+		})
+		client.PrependReactor("update", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "scale" {
+				return false, nil, nil
+			}
+			scale := action.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale)
+			scales = append(scales, scale.Spec.Replicas)
+			if tc.failRestore && scale.Spec.Replicas == 3 {
+				return true, nil, fmt.Errorf("scale update rejected")
+			}
+			replicas = scale.Spec.Replicas
+			return true, scale, nil
+		})
+
+		ds, err := NewDiskScaler(nil, client, newFakeDynamicClient(), "test", recommender, false)
+		if err != nil {
+			t.Fatalf("test '%s': unable to create disk scaler: %s", tc.name, err)
+		}
+		ds.recorder = record.NewFakeRecorder(100)
+		wl := &statefulSetWorkload{client: client, readyTimeout: 10 * time.Millisecond}
+
+		err = ds.runStatefulSetDiskScalingWorkflow(context.Background(), wl, "test", "db")
+		if tc.expectErr && err == nil {
+			t.Fatalf("test '%s': expected err but received none", tc.name)
+		}
+		if !tc.expectErr && err != nil {
+			t.Fatalf("test '%s': received unexpected err: %s", tc.name, err)
+		}
+
+		if fmt.Sprint(scales) != fmt.Sprint(tc.expectedScales) {
+			t.Fatalf("test '%s': expected the statefulset to be scaled to %v replicas but received %v", tc.name, tc.expectedScales, scales)
+		}
+		expanded := []string{}
+		for _, action := range client.Fake.Actions() {
+			if action.GetVerb() == "patch" && action.GetResource().Resource == "persistentvolumeclaims" {
+				expanded = append(expanded, action.(k8stesting.PatchAction).GetName())
+			}
+		}
+		if strings.Join(expanded, ",") != strings.Join(tc.expectedExpanded, ",") {
+			t.Fatalf("test '%s': expected pvcs %v to be expanded in order but received %v", tc.name, tc.expectedExpanded, expanded)
+		}
+
+		recreated, err := client.AppsV1().StatefulSets("test").Get(context.Background(), "db", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("test '%s': expected the statefulset to exist after the resize: %s", tc.name, err)
+		}
+		size := recreated.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[v1.ResourceStorage]
+		if !isEqualQuantity(size, resource.MustParse(tc.expectTemplatesSize)) {
+			t.Fatalf("test '%s': expected the volumeClaimTemplate to request %s but received %s", tc.name, tc.expectTemplatesSize, size.String())
+		}
+	}
+}
+
+func Test_updateStatefulSetClaimTemplates(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestStatefulSet(3, nil))
+	ds, err := NewDiskScaler(nil, client, newFakeDynamicClient(), "test", stubRecommender{}, false)
+	if err != nil {
+		t.Fatalf("unable to create disk scaler: %s", err)
+	}
+
+	err = ds.updateStatefulSetClaimTemplates(context.Background(), "test", "db", map[string]resource.Quantity{"data": resource.MustParse("50Gi")})
+	if err != nil {
+		t.Fatalf("received unexpected err: %s", err)
+	}
+
+	deleted, created := false, false
+	for _, action := range client.Fake.Actions() {
+		if action.GetResource().Resource != "statefulsets" {
+			continue
+		}
+		switch action.GetVerb() {
+		case "delete":
+			opts := action.(k8stesting.DeleteAction).GetDeleteOptions()
+			if opts.PropagationPolicy == nil || *opts.PropagationPolicy != metav1.DeletePropagationOrphan {
+				t.Fatalf("expected the statefulset to be deleted orphaning its pods but received %+v", opts)
+			}
+			deleted = true
+		case "create":
+			if !deleted {
+				t.Fatalf("expected the statefulset to be recreated after it was deleted")
+			}
+			created = true
+		}
+	}
+	if !deleted || !created {
+		t.Fatalf("expected the statefulset to be deleted and recreated but received %v", client.Fake.Actions())
+	}
+	sts, err := client.AppsV1().StatefulSets("test").Get(context.Background(), "db", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the statefulset to be recreated: %s", err)
+	}
+	size := sts.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[v1.ResourceStorage]
+	if !isEqualQuantity(size, resource.MustParse("50Gi")) {
+		t.Fatalf("expected the volumeClaimTemplate to request 50Gi but received %s", size.String())
+	}
+	ops, err := ds.journal.operations(context.Background())
+	if err != nil || len(ops) != 0 {
+		t.Fatalf("expected the recreated statefulset to be removed from the journal but received %v, %v", ops, err)
+	}
+}
+
+func Test_planStatefulSet(t *testing.T) {
+	type testCase struct {
+		name                  string
+		allowStop             bool
+		expectedReleases      []string
+		expectedPreconditions []string
+	}
+
+	testCases := []testCase{
+		{
+			name:             "when the lowest ordinal is allowed to stop the whole set",
+			allowStop:        true,
+			expectedReleases: []string{"3->2", "3->1", "3->0"},
+		},
+		{
+			name:                  "when the lowest ordinal is not allowed to stop the whole set",
+			expectedReleases:      []string{"3->2", "3->1"},
+			expectedPreconditions: []string{preconditionStop},
+		},
+	}
+
+	for _, tc := range testCases {
+		ds, err := NewDiskScaler(nil, fake.NewSimpleClientset(), newFakeDynamicClient(), "test", stubRecommender{}, false)
+		if err != nil {
+			t.Fatalf("test '%s': unable to create disk scaler: %s", tc.name, err)
+		}
+		volMap := map[string]*pvcDetails{}
+		claims := []string{}
+		for ordinal := 0; ordinal < 3; ordinal++ {
+			pvcName := fmt.Sprintf("data-db-%d", ordinal)
+			volMap[pvcName] = &pvcDetails{
+				currentSize:    resource.MustParse("100Gi"),
+				resizeTo:       resource.MustParse("10Gi"),
+				resizedPVCName: pvcName + "-abcde",
+				claimTemplate:  "data",
+				ordinal:        ordinal,
+				allowStop:      tc.allowStop,
+			}
+			claims = append(claims, pvcName)
+		}
+		plan := &Plan{Name: "db", Kind: workloadKindStatefulSet, Replicas: 3, Executable: true}
+
+		ds.planStatefulSet(plan, claims, volMap, time.Now())
+
+		releases := []string{}
+		for _, step := range plan.Steps {
+			if step.Action == planActionScale && *step.ToReplicas < *step.FromReplicas {
+				releases = append(releases, fmt.Sprintf("%d->%d", *step.FromReplicas, *step.ToReplicas))
+				if !strings.Contains(step.Description, fmt.Sprintf("stopping %d replicas", *step.FromReplicas-*step.ToReplicas)) {
+					t.Fatalf("test '%s': expected the step to report the replicas stopped but received %s", tc.name, step.Description)
+				}
+			}
+		}
+		if strings.Join(releases, ",") != strings.Join(tc.expectedReleases, ",") {
+			t.Fatalf("test '%s': expected the ordinals to be released by scaling %v but received %v", tc.name, tc.expectedReleases, releases)
+		}
+		checks := []string{}
+		for _, precondition := range plan.Preconditions {
+			checks = append(checks, precondition.Check)
+		}
+		if strings.Join(checks, ",") != strings.Join(tc.expectedPreconditions, ",") {
+			t.Fatalf("test '%s': expected failed preconditions %v but received %+v", tc.name, tc.expectedPreconditions, plan.Preconditions)
+		}
+	}
+}
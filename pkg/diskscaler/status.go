@@ -0,0 +1,328 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubecost/disk-autoscaler/pkg/metrics"
+	"github.com/kubecost/disk-autoscaler/pkg/pvsizingrecommendation"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultRunHistorySize = 24
+	// resizeHistorySize is how many resizes are kept for every workload
+	resizeHistorySize = 10
+)
+
+// WorkloadError is the error of the disk scaling workflow of a workload during a run.
+type WorkloadError struct {
+	DiskScalerWorkload
+	Error string `json:"error"`
+}
+
+// RunRecord is a run of the disk scaling loop as reported by the runs endpoint.
+type RunRecord struct {
+	ID string `json:"id"`
+	// Trigger is either schedule for the hourly runs or manual for the runs triggered through the API
+	Trigger    string    `json:"trigger"`
+	DryRun     bool      `json:"dryRun,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	RunStatus
+	// Error is set when the run failed before scaling any workload
+	Error string `json:"error,omitempty"`
+}
+
+// WorkloadStatus is a workload with disk auto scaling enabled as reported by the workloads endpoint.
+type WorkloadStatus struct {
+	ResolvedWorkloadPolicy
+	Eligible   bool       `json:"eligible"`
+	LastScaled *time.Time `json:"lastScaled,omitempty"`
+	// NextEligibleAt is when the interval since the last scaling is over, unset when never scaled
+	NextEligibleAt *time.Time `json:"nextEligibleAt,omitempty"`
+}
+
+// ClaimStatus is a claim of a workload along with its recommendation as reported by the workload endpoint.
+type ClaimStatus struct {
+	Name                    string     `json:"name"`
+	PV                      string     `json:"pv"`
+	StorageClass            string     `json:"storageClass"`
+	CurrentSize             string     `json:"currentSize"`
+	RequestedSize           string     `json:"requestedSize"`
+	RecommendedSize         string     `json:"recommendedSize,omitempty"`
+	ProjectedMonthlySavings float64    `json:"projectedMonthlySavings,omitempty"`
+	ProjectedFullAt         *time.Time `json:"projectedFullAt,omitempty"`
+	RecommendationError     string     `json:"recommendationError,omitempty"`
+	LastResized             *time.Time `json:"lastResized,omitempty"`
+	LastSnapshot            string     `json:"lastSnapshot,omitempty"`
+}
+
+// ResizeRecord is a resize of a claim attempted by the disk auto scaler.
+type ResizeRecord struct {
+	Time      time.Time `json:"time"`
+	Claim     string    `json:"claim"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Direction string    `json:"direction"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// WorkloadDetails is a workload along with its claims and its last resizes as reported by the workload endpoint.
+type WorkloadDetails struct {
+	WorkloadStatus
+	Claims []ClaimStatus `json:"claims"`
+	// Resizes are the last resizes of the claims of the workload since the disk auto scaler started, latest first
+	Resizes []ResizeRecord `json:"resizes"`
+	// OperationInProgress is the journaled operation of the workload, if it is being scaled
+	OperationInProgress *operation `json:"operationInProgress,omitempty"`
+}
+
+// runHistory keeps the last runs of the disk scaling loop.
+type runHistory struct {
+	mu   sync.Mutex
+	size int
+	runs []RunRecord
+}
+
+func newRunHistory(size int) *runHistory {
+	return &runHistory{size: size}
+}
+
+func (h *runHistory) add(run RunRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runs = append(h.runs, run)
+	if len(h.runs) > h.size {
+		h.runs = h.runs[len(h.runs)-h.size:]
+	}
+}
+
+// last returns at most limit runs, latest first, or every run kept when limit is not positive.
+func (h *runHistory) last(limit int) []RunRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if limit <= 0 || limit > len(h.runs) {
+		limit = len(h.runs)
+	}
+	runs := make([]RunRecord, 0, limit)
+	for i := len(h.runs) - 1; i >= len(h.runs)-limit; i-- {
+		runs = append(runs, h.runs[i])
+	}
+	return runs
+}
+
+// recordRun keeps the run of the disk scaling loop which finished at the given time.
+func (dss *DiskScalerService) recordRun(run RunRecord, finishedAt time.Time, status RunStatus, err error) {
+	run.FinishedAt = finishedAt
+	run.RunStatus = status
+	if err != nil {
+		run.Error = err.Error()
+	}
+	dss.runs.add(run)
+}
+
+// resizeHistory keeps the last resizes of the claims of every workload.
+type resizeHistory struct {
+	mu      sync.Mutex
+	resizes map[string][]ResizeRecord
+}
+
+func newResizeHistory() *resizeHistory {
+	return &resizeHistory{resizes: map[string][]ResizeRecord{}}
+}
+
+func (h *resizeHistory) add(key string, resizes ...ResizeRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	kept := append(h.resizes[key], resizes...)
+	if len(kept) > resizeHistorySize {
+		kept = kept[len(kept)-resizeHistorySize:]
+	}
+	h.resizes[key] = kept
+}
+
+// get returns the resizes kept for the workload, latest first.
+func (h *resizeHistory) get(key string) []ResizeRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	kept := h.resizes[key]
+	resizes := make([]ResizeRecord, 0, len(kept))
+	for i := len(kept) - 1; i >= 0; i-- {
+		resizes = append(resizes, kept[i])
+	}
+	return resizes
+}
+
+// workloadKey identifies a workload across kinds.
+func workloadKey(namespace, kind, name string) string {
+	return fmt.Sprintf("%s/%s/%s", namespace, strings.ToLower(kind), name)
+}
+
+// recordHistory keeps the resize of every claim of the workload which was resized, or failed to be.
+func (ds *DiskScaler) recordHistory(workload *v1.ObjectReference, volMap map[string]*pvcDetails, now time.Time) {
+	resizes := []ResizeRecord{}
+	for pvcName, pvcDetails := range volMap {
+		if isEqualQuantity(pvcDetails.currentSize, pvcDetails.resizeTo) {
+			continue
+		}
+		resize := ResizeRecord{
+			Time:      now,
+			Claim:     pvcName,
+			From:      pvcDetails.currentSize.String(),
+			To:        pvcDetails.resizeTo.String(),
+			Direction: metrics.DirectionShrink,
+			Outcome:   metrics.Outcome(pvcDetails.err),
+		}
+		if isGreaterQuantity(pvcDetails.currentSize, pvcDetails.resizeTo) {
+			resize.Direction = metrics.DirectionExpand
+		}
+		if pvcDetails.err != nil {
+			resize.Error = pvcDetails.err.Error()
+		}
+		resizes = append(resizes, resize)
+	}
+	sort.Slice(resizes, func(i, j int) bool { return resizes[i].Claim < resizes[j].Claim })
+	ds.history.add(workloadKey(workload.Namespace, workload.Kind, workload.Name), resizes...)
+}
+
+// workloadStatuses returns the status of every workload with disk auto scaling enabled, in the
+// given namespace or in every namespace when empty, sorted by namespace, kind and name.
+func (dss *DiskScalerService) workloadStatuses(ctx context.Context, namespace string, now time.Time) ([]WorkloadStatus, error) {
+	statuses := []WorkloadStatus{}
+	for _, wl := range dss.ds.workloads {
+		objects, err := wl.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objects {
+			if namespace != "" && obj.meta.Namespace != namespace {
+				continue
+			}
+			policy := dss.ds.policyFor(obj.meta)
+			if !dss.workloadIsEnabled(obj.meta, policy) {
+				continue
+			}
+			statuses = append(statuses, dss.workloadStatus(wl.Kind(), obj, policy, now))
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return workloadKey(statuses[i].Namespace, statuses[i].Kind, statuses[i].Name) < workloadKey(statuses[j].Namespace, statuses[j].Kind, statuses[j].Name)
+	})
+	return statuses, nil
+}
+
+// workloadStatus returns the resolved settings of the workload along with when it is eligible for disk scaling.
+func (dss *DiskScalerService) workloadStatus(kind string, obj workloadObject, policy scalingPolicy, now time.Time) WorkloadStatus {
+	status := WorkloadStatus{
+		ResolvedWorkloadPolicy: policy.status(kind, obj.meta, now),
+		Eligible:               obj.available && dss.workloadIsEligible(obj.meta, policy, now.Format(timeFormat)),
+	}
+	lastScaled, err := time.Parse(timeFormat, obj.meta.Annotations[AnnotationLastScaled])
+	if err != nil {
+		return status
+	}
+	status.LastScaled = &lastScaled
+	if interval, err := pvsizingrecommendation.ParseInterval(policy.interval); err == nil {
+		nextEligibleAt := lastScaled.Add(interval)
+		status.NextEligibleAt = &nextEligibleAt
+	}
+	return status
+}
+
+// workloadDetails returns the status of the workload along with its claims and their
+// recommendations, its last resizes and the operation in progress, if any.
+func (dss *DiskScalerService) workloadDetails(ctx context.Context, namespace, kind, name string, now time.Time) (*WorkloadDetails, error) {
+	wl, err := dss.ds.workloadFor(kind)
+	if err != nil {
+		return nil, err
+	}
+	meta, _, err := wl.Get(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	policy := dss.ds.policyFor(meta)
+	// Availability only matters to the scaling loop, the workload is reported as it would be found
+	objects, err := wl.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	obj := workloadObject{meta: meta}
+	for _, listed := range objects {
+		if listed.meta.Namespace == namespace && listed.meta.Name == name {
+			obj = listed
+		}
+	}
+	details := &WorkloadDetails{
+		WorkloadStatus: dss.workloadStatus(wl.Kind(), obj, policy, now),
+		Claims:         []ClaimStatus{},
+		Resizes:        dss.ds.history.get(workloadKey(namespace, wl.Kind(), name)),
+	}
+
+	claims, err := dss.ds.workloadClaims(ctx, wl, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, claim := range claims {
+		status, err := dss.ds.claimStatus(ctx, namespace, claim, policy)
+		if err != nil {
+			return nil, err
+		}
+		details.Claims = append(details.Claims, status)
+	}
+
+	ops, err := dss.ds.journal.operations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		if op.Namespace == namespace && strings.EqualFold(op.Kind, wl.Kind()) && op.Workload == name {
+			details.OperationInProgress = op
+		}
+	}
+	return details, nil
+}
+
+// claimStatus returns the sizes of the claim and its recommendation for the policy. A claim
+// without a recommendation is reported with the reason there is none.
+func (ds *DiskScaler) claimStatus(ctx context.Context, namespace string, pvcName string, policy scalingPolicy) (ClaimStatus, error) {
+	pvc, err := ds.getPVCInfo(ctx, namespace, pvcName)
+	if err != nil {
+		return ClaimStatus{}, err
+	}
+	currentSize := pvc.Status.Capacity[v1.ResourceStorage]
+	requestedSize := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	status := ClaimStatus{
+		Name:          pvcName,
+		PV:            pvc.Spec.VolumeName,
+		CurrentSize:   currentSize.String(),
+		RequestedSize: requestedSize.String(),
+		LastSnapshot:  pvc.GetAnnotations()[PVCAnnotationLastSnapshot],
+	}
+	if pvc.Spec.StorageClassName != nil {
+		status.StorageClass = *pvc.Spec.StorageClassName
+	}
+	if lastResized := lastResizedTime(pvc); !lastResized.IsZero() {
+		status.LastResized = &lastResized
+	}
+	if status.PV == "" {
+		status.RecommendationError = "pvc is not bound"
+		return status, nil
+	}
+	recommendation, err := ds.getRecommendationForPV(ctx, status.PV, policy.targetUtilization, policy.interval)
+	if err != nil {
+		status.RecommendationError = err.Error()
+		return status, nil
+	}
+	status.RecommendedSize = recommendation.RecommendedResourceSize.String()
+	status.ProjectedMonthlySavings = recommendation.Savings
+	if !recommendation.ProjectedFullAt.IsZero() {
+		status.ProjectedFullAt = &recommendation.ProjectedFullAt
+	}
+	return status, nil
+}
@@ -0,0 +1,174 @@
+package diskscaler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_runHistory(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := map[string]struct {
+		size     int
+		added    int
+		limit    int
+		expected []int
+	}{
+		"when every run is asked for": {
+			size:     3,
+			added:    2,
+			limit:    0,
+			expected: []int{1, 0},
+		},
+		"when more runs were added than are kept": {
+			size:     3,
+			added:    5,
+			limit:    0,
+			expected: []int{4, 3, 2},
+		},
+		"when fewer runs are asked for than are kept": {
+			size:     3,
+			added:    5,
+			limit:    2,
+			expected: []int{4, 3},
+		},
+		"when more runs are asked for than are kept": {
+			size:     3,
+			added:    1,
+			limit:    10,
+			expected: []int{0},
+		},
+		"when no run is kept": {
+			size:     0,
+			added:    2,
+			limit:    0,
+			expected: []int{},
+		},
+	}
+	for name, tc := range testCases {
+		history := newRunHistory(tc.size)
+		for i := 0; i < tc.added; i++ {
+			history.add(RunRecord{StartedAt: start.Add(time.Duration(i) * time.Hour)})
+		}
+		runs := history.last(tc.limit)
+		if len(runs) != len(tc.expected) {
+			t.Fatalf("for test case: `%s`, expected %d runs but received %d", name, len(tc.expected), len(runs))
+		}
+		for i, run := range runs {
+			if expected := start.Add(time.Duration(tc.expected[i]) * time.Hour); !run.StartedAt.Equal(expected) {
+				t.Fatalf("for test case: `%s`, expected run %d to start at %s but received %s", name, i, expected, run.StartedAt)
+			}
+		}
+	}
+}
+
+func Test_statusEndpoints(t *testing.T) {
+	lastScaled := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "db",
+			Namespace: "test",
+			Annotations: map[string]string{
+				AnnotationEnabled:    "true",
+				AnnotationInterval:   "7h",
+				AnnotationLastScaled: lastScaled.Format(timeFormat),
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Volumes: []v1.Volume{{
+				Name:         "data",
+				VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+			}}}},
+		},
+		Status: appsv1.DeploymentStatus{AvailableReplicas: 1},
+	}
+	disabled := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "test"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{AvailableReplicas: 1},
+	}
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "test"},
+		Spec: v1.PersistentVolumeClaimSpec{
+			VolumeName: "pv-data",
+			Resources:  v1.VolumeResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")}},
+		},
+		Status: v1.PersistentVolumeClaimStatus{Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")}},
+	}
+	dss, err := NewDiskScalerService(nil, fake.NewSimpleClientset(deployment, disabled, pvc), newFakeDynamicClient(), false, false, stubRecommender{"pv-data": "4Gi"}, []string{KubecostNamespace})
+	if err != nil {
+		t.Fatalf("unable to create disk scaler service: %s", err)
+	}
+	dss.ds.history.add(workloadKey("test", workloadKindDeployment, "db"), ResizeRecord{Claim: "data", From: "20Gi", To: "10Gi"})
+	dss.recordRun(RunRecord{ID: newRunID(lastScaled), Trigger: runTriggerSchedule, StartedAt: lastScaled}, lastScaled.Add(time.Minute), RunStatus{
+		NumEnabled:     1,
+		NumEligible:    1,
+		FailedRun:      1,
+		WorkloadErrors: []WorkloadError{{DiskScalerWorkload: DiskScalerWorkload{Namespace: "test", Kind: workloadKindDeployment, Name: "db"}, Error: "copy failed"}},
+	}, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /diskAutoScaler/workloads", dss.listWorkloads)
+	mux.HandleFunc("GET /diskAutoScaler/workloads/{namespace}/{name}", dss.getWorkload)
+	mux.HandleFunc("GET /diskAutoScaler/runs", dss.listRuns)
+
+	get := func(path string, v interface{}) int {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), v); err != nil {
+				t.Fatalf("unable to decode response of %s: %s", path, err)
+			}
+		}
+		return recorder.Code
+	}
+
+	statuses := []WorkloadStatus{}
+	if code := get("/diskAutoScaler/workloads?namespace=test", &statuses); code != http.StatusOK {
+		t.Fatalf("expected workloads to be listed but received status %d", code)
+	}
+	if len(statuses) != 1 || statuses[0].Name != "db" || statuses[0].Eligible {
+		t.Fatalf("expected only the enabled deployment, not eligible yet, but received %+v", statuses)
+	}
+	if statuses[0].NextEligibleAt == nil || !statuses[0].NextEligibleAt.Equal(lastScaled.Add(7*time.Hour)) {
+		t.Fatalf("expected the deployment to be next eligible 7h after it was last scaled but received %v", statuses[0].NextEligibleAt)
+	}
+
+	details := WorkloadDetails{}
+	if code := get("/diskAutoScaler/workloads/test/db", &details); code != http.StatusOK {
+		t.Fatalf("expected the deployment to be reported but received status %d", code)
+	}
+	if len(details.Claims) != 1 || details.Claims[0].CurrentSize != "10Gi" || details.Claims[0].RecommendedSize != "4Gi" {
+		t.Fatalf("expected the claim of the deployment with its recommendation but received %+v", details.Claims)
+	}
+	if len(details.Resizes) != 1 || details.Resizes[0].To != "10Gi" {
+		t.Fatalf("expected the last resize of the deployment but received %+v", details.Resizes)
+	}
+	if code := get("/diskAutoScaler/workloads/test/missing", &details); code != http.StatusNotFound {
+		t.Fatalf("expected a missing deployment not to be found but received status %d", code)
+	}
+	if code := get("/diskAutoScaler/workloads/test/db?kind=CronJob", &details); code != http.StatusBadRequest {
+		t.Fatalf("expected an unsupported kind to be rejected but received status %d", code)
+	}
+
+	runs := []RunRecord{}
+	if code := get("/diskAutoScaler/runs?limit=5", &runs); code != http.StatusOK {
+		t.Fatalf("expected runs to be listed but received status %d", code)
+	}
+	if len(runs) != 1 || len(runs[0].WorkloadErrors) != 1 || runs[0].WorkloadErrors[0].Error != "copy failed" {
+		t.Fatalf("expected the run with the error of the deployment but received %+v", runs)
+	}
+	if code := get("/diskAutoScaler/runs?limit=none", &runs); code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid limit to be rejected but received status %d", code)
+	}
+}
@@ -0,0 +1,108 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// selectedNodeAnnotation is set on a claim by the scheduler when its storage class binds volumes
+// on first consumer, and the provisioner then creates the volume in the topology of the node.
+// The disk auto scaler sets it itself on claims of storage classes binding volumes immediately.
+const selectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+
+var nodeSelectorOperators = map[v1.NodeSelectorOperator]selection.Operator{
+	v1.NodeSelectorOpIn:           selection.In,
+	v1.NodeSelectorOpNotIn:        selection.NotIn,
+	v1.NodeSelectorOpExists:       selection.Exists,
+	v1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	v1.NodeSelectorOpGt:           selection.GreaterThan,
+	v1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// nodeForPV returns a ready and schedulable node matching the node affinity of the volume. A new
+// volume provisioned for that node lands in the same zone, or on the same node for node local
+// volumes, so that the copier can mount both volumes.
+func (ds *DiskScaler) nodeForPV(ctx context.Context, pv *v1.PersistentVolume) (string, error) {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil || len(pv.Spec.NodeAffinity.Required.NodeSelectorTerms) == 0 {
+		return "", fmt.Errorf("pv %s has no node affinity to provision the new volume in the same topology", pv.GetName())
+	}
+	nodes, err := ds.basicK8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to list nodes: %w", err)
+	}
+	// Nodes are sorted so that the same node is selected for every volume of the same topology
+	sort.Slice(nodes.Items, func(i, j int) bool { return nodes.Items[i].GetName() < nodes.Items[j].GetName() })
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if node.Spec.Unschedulable || !isNodeReady(node) {
+			continue
+		}
+		if matchesNodeSelector(node, pv.Spec.NodeAffinity.Required) {
+			return node.GetName(), nil
+		}
+	}
+	return "", fmt.Errorf("no ready and schedulable node matches the node affinity of pv %s", pv.GetName())
+}
+
+func isNodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// matchesNodeSelector returns true when the node matches any of the terms of the selector.
+func matchesNodeSelector(node *v1.Node, selector *v1.NodeSelector) bool {
+	for _, term := range selector.NodeSelectorTerms {
+		if matchesNodeSelectorTerm(node, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesNodeSelectorTerm returns true when the node matches every requirement of the term,
+// an empty term matches no node.
+func matchesNodeSelectorTerm(node *v1.Node, term v1.NodeSelectorTerm) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+	selector := labels.NewSelector()
+	for _, expression := range term.MatchExpressions {
+		operator, ok := nodeSelectorOperators[expression.Operator]
+		if !ok {
+			return false
+		}
+		requirement, err := labels.NewRequirement(expression.Key, operator, expression.Values)
+		if err != nil {
+			return false
+		}
+		selector = selector.Add(*requirement)
+	}
+	if !selector.Matches(labels.Set(node.GetLabels())) {
+		return false
+	}
+	// metadata.name is the only field a node selector term may match
+	for _, field := range term.MatchFields {
+		if field.Key != "metadata.name" {
+			return false
+		}
+		matches := slices.Contains(field.Values, node.GetName())
+		if (field.Operator == v1.NodeSelectorOpIn && !matches) || (field.Operator == v1.NodeSelectorOpNotIn && matches) {
+			return false
+		}
+		if field.Operator != v1.NodeSelectorOpIn && field.Operator != v1.NodeSelectorOpNotIn {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package diskscaler
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newNode(name string, zone string, ready bool, unschedulable bool) *v1.Node {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{v1.LabelTopologyZone: zone, v1.LabelHostname: name},
+		},
+		Spec: v1.NodeSpec{Unschedulable: unschedulable},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}},
+		},
+	}
+}
+
+func Test_nodeForPV(t *testing.T) {
+	zoneAffinity := &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+		MatchExpressions: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"us-east-1a"}}},
+	}}}}
+
+	testCases := map[string]struct {
+		affinity     *v1.VolumeNodeAffinity
+		nodes        []runtime.Object
+		expectedNode string
+		expectErr    bool
+	}{
+		"when the first node of the zone is ready": {
+			affinity:     zoneAffinity,
+			nodes:        []runtime.Object{newNode("node-c", "us-east-1a", true, false), newNode("node-b", "us-east-1b", true, false), newNode("node-a", "us-east-1a", true, false)},
+			expectedNode: "node-a",
+		},
+		"when nodes of the zone are not ready or cordoned": {
+			affinity:     zoneAffinity,
+			nodes:        []runtime.Object{newNode("node-a", "us-east-1a", false, false), newNode("node-b", "us-east-1a", true, true), newNode("node-c", "us-east-1a", true, false)},
+			expectedNode: "node-c",
+		},
+		"when the volume is local to a node": {
+			affinity: &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+				MatchFields: []v1.NodeSelectorRequirement{{Key: "metadata.name", Operator: v1.NodeSelectorOpIn, Values: []string{"node-b"}}},
+			}}}},
+			nodes:        []runtime.Object{newNode("node-a", "us-east-1a", true, false), newNode("node-b", "us-east-1a", true, false)},
+			expectedNode: "node-b",
+		},
+		"when no node is in the zone": {
+			affinity:  zoneAffinity,
+			nodes:     []runtime.Object{newNode("node-b", "us-east-1b", true, false)},
+			expectErr: true,
+		},
+		"when the volume has no node affinity": {
+			nodes:     []runtime.Object{newNode("node-a", "us-east-1a", true, false)},
+			expectErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		ds := &DiskScaler{basicK8sClient: fake.NewSimpleClientset(tc.nodes...)}
+		pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}, Spec: v1.PersistentVolumeSpec{NodeAffinity: tc.affinity}}
+		node, err := ds.nodeForPV(context.Background(), pv)
+		if tc.expectErr {
+			if err == nil {
+				t.Fatalf("for test case: `%s`, expected err but received none", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("for test case: `%s`, received unexpected err: %s", name, err)
+		}
+		if node != tc.expectedNode {
+			t.Fatalf("for test case: `%s`, expected node %s but received %s", name, tc.expectedNode, node)
+		}
+	}
+}
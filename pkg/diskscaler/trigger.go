@@ -0,0 +1,97 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubecost/disk-autoscaler/pkg/metrics"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	dryRunContextKey = contextKey("ds_dry_run")
+	reportContextKey = contextKey("ds_report")
+	// noRecordContextKey is set when evaluating workloads must not record events or change metrics, as when planning
+	noRecordContextKey = contextKey("ds_no_record")
+
+	runTriggerSchedule = "schedule"
+	runTriggerManual   = "manual"
+)
+
+// runOptions narrow down a run of the disk scaling loop, the zero value runs every workload due for disk scaling.
+type runOptions struct {
+	// namespace, selector, kind and name select the workloads of the run, every workload when unset
+	namespace string
+	selector  labels.Selector
+	kind      string
+	name      string
+	// force scales the selected workloads regardless of their interval and maintenance windows
+	force bool
+	// dryRun evaluates the selected workloads and reports their recommendations without resizing any volume
+	dryRun bool
+	// scheduled is set for the runs of the scheduled loop, which alone prune expired snapshots and retained claims
+	scheduled bool
+}
+
+// selectsAll returns true when every workload is selected by the run.
+func (o runOptions) selectsAll() bool {
+	return o.namespace == "" && o.selector == nil && o.name == ""
+}
+
+// selects returns true when the workload is selected by the run.
+func (o runOptions) selects(kind string, meta metav1.ObjectMeta) bool {
+	if o.namespace != "" && meta.Namespace != o.namespace {
+		return false
+	}
+	if o.selector != nil && !o.selector.Matches(labels.Set(meta.Labels)) {
+		return false
+	}
+	if o.name != "" && (meta.Name != o.name || !strings.EqualFold(kind, o.kind)) {
+		return false
+	}
+	return true
+}
+
+// auditing returns true when volumes are only evaluated and never resized, either in audit mode or during a dry run.
+func (ds *DiskScaler) auditing(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunContextKey).(bool)
+	return ds.auditMode || dryRun
+}
+
+// recording returns false when events and metrics must not be recorded in ctx.
+func recording(ctx context.Context) bool {
+	noRecord, _ := ctx.Value(noRecordContextKey).(bool)
+	return !noRecord
+}
+
+// newRunID returns the ID of a run of the disk scaling loop started at the given time.
+func newRunID(startedAt time.Time) string {
+	return fmt.Sprintf("%s-%s", startedAt.UTC().Format("20060102-150405"), randStringRunes(5))
+}
+
+// trigger starts a run of the disk scaling loop on demand and returns its ID right away.
+// The run is reported by the runs endpoint once it finishes.
+func (dss *DiskScalerService) trigger(opts runOptions) string {
+	start := time.Now()
+	record := RunRecord{
+		ID:        newRunID(start),
+		Trigger:   runTriggerManual,
+		DryRun:    opts.dryRun,
+		StartedAt: start,
+	}
+	go func() {
+		diskAutoScalerRun := start.Format(timeFormat)
+		status, err := dss.run(diskAutoScalerRun, opts)
+		metrics.RunDuration.Observe(time.Since(start).Seconds())
+		metrics.Runs.WithLabelValues(metrics.Outcome(err)).Inc()
+		if err != nil {
+			log.Error().Err(err).Msgf("Run %s triggered at time %s failed", record.ID, diskAutoScalerRun)
+		}
+		dss.recordRun(record, time.Now(), status, err)
+	}()
+	return record.ID
+}
@@ -0,0 +1,163 @@
+package diskscaler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_runOptionsSelects(t *testing.T) {
+	meta := metav1.ObjectMeta{Name: "db", Namespace: "test", Labels: map[string]string{"app": "db"}}
+	testCases := map[string]struct {
+		opts     runOptions
+		expected bool
+	}{
+		"when every workload is selected": {
+			opts:     runOptions{},
+			expected: true,
+		},
+		"when the namespace of the workload is selected": {
+			opts:     runOptions{namespace: "test"},
+			expected: true,
+		},
+		"when another namespace is selected": {
+			opts:     runOptions{namespace: "other"},
+			expected: false,
+		},
+		"when the labels of the workload match the selector": {
+			opts:     runOptions{selector: labels.SelectorFromSet(labels.Set{"app": "db"})},
+			expected: true,
+		},
+		"when the labels of the workload do not match the selector": {
+			opts:     runOptions{selector: labels.SelectorFromSet(labels.Set{"app": "web"})},
+			expected: false,
+		},
+		"when the workload is selected by kind and name": {
+			opts:     runOptions{namespace: "test", kind: "deployment", name: "db"},
+			expected: true,
+		},
+		"when a workload of another kind with the same name is selected": {
+			opts:     runOptions{namespace: "test", kind: workloadKindStatefulSet, name: "db"},
+			expected: false,
+		},
+	}
+	for name, tc := range testCases {
+		if actual := tc.opts.selects(workloadKindDeployment, meta); actual != tc.expected {
+			t.Fatalf("for test case: `%s`, expected %t but received %t", name, tc.expected, actual)
+		}
+	}
+}
+
+func Test_scaleNow(t *testing.T) {
+	storageClassName := "gp3"
+	bindingMode := storagev1.VolumeBindingWaitForFirstConsumer
+	replicas := int32(1)
+	// The deployment was scaled a minute ago, so it is only scaled when forced
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "db",
+			Namespace: "test",
+			Annotations: map[string]string{
+				AnnotationEnabled:    "true",
+				AnnotationLastScaled: time.Now().Add(-time.Minute).Format(timeFormat),
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Volumes: []v1.Volume{{
+				Name:         "data",
+				VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+			}}}},
+		},
+		Status: appsv1.DeploymentStatus{AvailableReplicas: 1},
+	}
+	disabled := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "test"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	unavailable := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "test", Annotations: map[string]string{AnnotationEnabled: "true"}},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{UnavailableReplicas: 1},
+	}
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "test"},
+		Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &storageClassName, VolumeName: "pv-data"},
+		Status:     v1.PersistentVolumeClaimStatus{Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("100Gi")}},
+	}
+	pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-data"}}
+	sc := &storagev1.StorageClass{
+		ObjectMeta:        metav1.ObjectMeta{Name: storageClassName},
+		Provisioner:       "ebs.csi.aws.com",
+		VolumeBindingMode: &bindingMode,
+	}
+	client := fake.NewSimpleClientset(deployment, disabled, unavailable, pvc, pv, sc)
+	dss, err := NewDiskScalerService(nil, client, newFakeDynamicClient(), false, false, stubRecommender{"pv-data": "10Gi"}, []string{KubecostNamespace})
+	if err != nil {
+		t.Fatalf("unable to create disk scaler service: %s", err)
+	}
+	dss.ds.recorder = record.NewFakeRecorder(10)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /diskAutoScaler/workloads/{namespace}/{name}/scale", dss.scaleNow)
+	post := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, nil))
+		return recorder
+	}
+
+	testCases := map[string]struct {
+		path         string
+		expectedCode int
+	}{
+		"when the workload does not exist":       {path: "/diskAutoScaler/workloads/test/missing/scale", expectedCode: http.StatusNotFound},
+		"when the workload is not enabled":       {path: "/diskAutoScaler/workloads/test/web/scale", expectedCode: http.StatusConflict},
+		"when the workload is not available":     {path: "/diskAutoScaler/workloads/test/cache/scale", expectedCode: http.StatusConflict},
+		"when the kind is not supported":         {path: "/diskAutoScaler/workloads/test/db/scale?kind=CronJob", expectedCode: http.StatusBadRequest},
+		"when the dry run flag is not a boolean": {path: "/diskAutoScaler/workloads/test/db/scale?dryRun=maybe", expectedCode: http.StatusBadRequest},
+	}
+	for name, tc := range testCases {
+		if actual := post(tc.path).Code; actual != tc.expectedCode {
+			t.Fatalf("for test case: `%s`, expected status %d but received %d", name, tc.expectedCode, actual)
+		}
+	}
+
+	response := post("/diskAutoScaler/workloads/test/db/scale?dryRun=true")
+	triggered := map[string]string{}
+	if err := json.Unmarshal(response.Body.Bytes(), &triggered); err != nil || triggered["runId"] == "" {
+		t.Fatalf("expected the ID of the run but received %q", response.Body.String())
+	}
+	var run *RunRecord
+	for deadline := time.Now().Add(10 * time.Second); run == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, r := range dss.runs.last(0) {
+			if r.ID == triggered["runId"] {
+				run = &r
+			}
+		}
+	}
+	if run == nil {
+		t.Fatalf("expected run %s to finish", triggered["runId"])
+	}
+	if !run.DryRun || run.Trigger != runTriggerManual || run.NumEligible != 1 || run.SuccessRun != 1 {
+		t.Fatalf("expected a successful manual dry run of the deployment but received %+v", run)
+	}
+	if len(run.Recommendations) != 1 || run.Recommendations[0].Direction != "shrink" || run.Recommendations[0].RecommendedSize != "10Gi" {
+		t.Fatalf("expected the deployment to be recommended to shrink to 10Gi but received %+v", run.Recommendations)
+	}
+	for _, action := range client.Fake.Actions() {
+		if action.GetVerb() != "get" && action.GetVerb() != "list" {
+			t.Fatalf("expected a dry run not to change anything but received %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
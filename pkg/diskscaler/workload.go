@@ -0,0 +1,469 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Kinds of workloads whose volumes are scaled by the disk auto scaler
+const (
+	workloadKindDeployment  = "Deployment"
+	workloadKindStatefulSet = "StatefulSet"
+	workloadKindReplicaSet  = "ReplicaSet"
+	workloadKindPod         = "Pod"
+	workloadKindRollout     = "Rollout"
+)
+
+// workload is implemented for every kind of controller whose pods mount the
+// PersistentVolumeClaims resized by the disk auto scaler, so that the disk
+// scaling workflow is not tied to a single kind.
+type workload interface {
+	// Kind returns the Kubernetes kind handled by the workload.
+	Kind() string
+	// List returns every workload of this kind in all namespaces.
+	List(ctx context.Context) ([]workloadObject, error)
+	// Get returns the object metadata and the pod template of the workload.
+	Get(ctx context.Context, namespace, name string) (metav1.ObjectMeta, *v1.PodTemplateSpec, error)
+	// Replicas returns the replicas the workload is meant to run, without changing it.
+	Replicas(ctx context.Context, namespace, name string) (int32, error)
+	// Quiesce stops all the pods of the workload and returns the replicas it was running.
+	Quiesce(ctx context.Context, namespace, name string) (int32, error)
+	// Restore brings the workload back to the given number of replicas.
+	Restore(ctx context.Context, namespace, name string, replicas int32) error
+	// SwapClaim points the pod template volume using oldClaim to newClaim.
+	SwapClaim(ctx context.Context, namespace, name, oldClaim, newClaim string) error
+	// Annotate adds the given annotations to the workload.
+	Annotate(ctx context.Context, namespace, name string, annotations map[string]string) error
+}
+
+// workloadObject is a workload returned when listing, along with whether all of its replicas are available.
+type workloadObject struct {
+	meta      metav1.ObjectMeta
+	available bool
+}
+
+// newWorkloads returns the adapters for every supported workload kind.
+func newWorkloads(ds *DiskScaler) []workload {
+	return []workload{
+		&deploymentWorkload{client: ds.basicK8sClient},
+		&statefulSetWorkload{client: ds.basicK8sClient, readyTimeout: statefulSetReadyTimeout},
+		&replicaSetWorkload{client: ds.basicK8sClient},
+		newPodWorkload(ds.basicK8sClient),
+		&rolloutWorkload{client: ds.dynamicK8sClient},
+	}
+}
+
+// workloadFor returns the adapter handling the given kind, matched case insensitively.
+func (ds *DiskScaler) workloadFor(kind string) (workload, error) {
+	for _, wl := range ds.workloads {
+		if strings.EqualFold(wl.Kind(), kind) {
+			return wl, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported workload kind %s", kind)
+}
+
+// withRetries calls fn up to maxRetries times in case of any infrastructure delay
+func withRetries(ctx context.Context, description string, fn func() error) error {
+	var retryErr error
+	for i := 0; i < maxRetries; i++ {
+		retryErr = fn()
+		if retryErr == nil {
+			break
+		}
+		log.Debug().Msgf("ctx: %s, failed to %s in %d attempt(s) with err %v", ctx.Value(diskScalerRunContextKey), description, i, retryErr)
+		if i < maxRetries-1 {
+			time.Sleep(inactivityDuringDelay)
+		}
+	}
+	return retryErr
+}
+
+// swapClaimInPodSpec replaces the claim name of the volume using oldClaim with newClaim.
+func swapClaimInPodSpec(spec *v1.PodSpec, oldClaim string, newClaim string) error {
+	for i := range spec.Volumes {
+		pvc := spec.Volumes[i].PersistentVolumeClaim
+		if pvc != nil && pvc.ClaimName == oldClaim {
+			pvc.ClaimName = newClaim
+			return nil
+		}
+	}
+	return fmt.Errorf("no volume uses pvc %s", oldClaim)
+}
+
+// mergeAnnotations adds annotations to the object, overwriting existing keys.
+func mergeAnnotations(obj metav1.Object, annotations map[string]string) {
+	currAnnotation := obj.GetAnnotations()
+	if currAnnotation == nil {
+		currAnnotation = map[string]string{}
+	}
+	for key, val := range annotations {
+		currAnnotation[key] = val
+	}
+	obj.SetAnnotations(currAnnotation)
+}
+
+// deploymentWorkload adapts apps/v1 Deployments.
+type deploymentWorkload struct {
+	client kubernetes.Interface
+}
+
+func (d *deploymentWorkload) Kind() string {
+	return workloadKindDeployment
+}
+
+func (d *deploymentWorkload) List(ctx context.Context) ([]workloadObject, error) {
+	deployments, err := d.client.
+		AppsV1().
+		Deployments(""). // Empty string lists all Deployments in all Namespaces
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing all Deployments: %s", err)
+	}
+	objects := make([]workloadObject, 0, len(deployments.Items))
+	for _, deployment := range deployments.Items {
+		objects = append(objects, workloadObject{
+			meta:      deployment.ObjectMeta,
+			available: deployment.Status.UnavailableReplicas == 0,
+		})
+	}
+	return objects, nil
+}
+
+func (d *deploymentWorkload) Get(ctx context.Context, namespace, name string) (metav1.ObjectMeta, *v1.PodTemplateSpec, error) {
+	dep, err := d.client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return metav1.ObjectMeta{}, nil, fmt.Errorf("unable to get deployment for the name %s err: %w", name, err)
+	}
+	return dep.ObjectMeta, &dep.Spec.Template, nil
+}
+
+func (d *deploymentWorkload) Replicas(ctx context.Context, namespace, name string) (int32, error) {
+	dep, err := d.client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get deployment for the name %s err: %w", name, err)
+	}
+	return deploymentReplicas(dep), nil
+}
+
+func (d *deploymentWorkload) Quiesce(ctx context.Context, namespace, name string) (int32, error) {
+	return d.scale(ctx, namespace, name, 0)
+}
+
+func (d *deploymentWorkload) Restore(ctx context.Context, namespace, name string, replicas int32) error {
+	_, err := d.scale(ctx, namespace, name, replicas)
+	return err
+}
+
+// scale scales the specified Deployment to the number of replicas specified in scaleTo.
+func (d *deploymentWorkload) scale(ctx context.Context, namespace, name string, scaleTo int32) (int32, error) {
+	deployment := d.client.AppsV1().
+		Deployments(namespace)
+
+	s, err := deployment.
+		GetScale(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get deployment to scale with err: %w", err)
+	}
+
+	sc := *s
+	originalScale := sc.Spec.Replicas
+	sc.Spec.Replicas = scaleTo
+
+	if originalScale == scaleTo {
+		log.Info().Msgf("scaling the deployment skipped as the existing deployment scale is the same: %d", originalScale)
+	}
+
+	_, err = deployment.
+		UpdateScale(ctx,
+			name, &sc, metav1.UpdateOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to scale the deployment: %w", err)
+	}
+
+	log.Info().Msgf("ctx: %s, successfully scaled deployment: %s from %d to %d", ctx.Value(diskScalerRunContextKey), name, originalScale, scaleTo)
+	return originalScale, nil
+}
+
+func (d *deploymentWorkload) SwapClaim(ctx context.Context, namespace, name, oldClaim, newClaim string) error {
+	deployment := d.client.AppsV1().Deployments(namespace)
+	// RetryOnConflict uses exponential backoff to avoid exhausting the apiserver
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, err := deployment.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get deployment: %s err: %w", name, err)
+		}
+		if err := swapClaimInPodSpec(&result.Spec.Template.Spec, oldClaim, newClaim); err != nil {
+			return err
+		}
+		_, err = deployment.Update(ctx, result, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (d *deploymentWorkload) Annotate(ctx context.Context, namespace, name string, annotations map[string]string) error {
+	deployment := d.client.AppsV1().Deployments(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, err := deployment.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get deployment with err: %w", err)
+		}
+		mergeAnnotations(result, annotations)
+		_, err = deployment.Update(ctx, result, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// statefulSetWorkload adapts apps/v1 StatefulSets. Besides quiescing the whole set it can scale
+// to a given ordinal, which the StatefulSet disk scaling workflow uses to resize one ordinal at a time.
+type statefulSetWorkload struct {
+	client kubernetes.Interface
+	// readyTimeout is how long the StatefulSet may take to be ready again after an ordinal was released
+	readyTimeout time.Duration
+}
+
+func (s *statefulSetWorkload) Kind() string {
+	return workloadKindStatefulSet
+}
+
+func (s *statefulSetWorkload) List(ctx context.Context) ([]workloadObject, error) {
+	statefulSets, err := s.client.
+		AppsV1().
+		StatefulSets(""). // Empty string lists all StatefulSets in all Namespaces
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing all StatefulSets: %s", err)
+	}
+	objects := make([]workloadObject, 0, len(statefulSets.Items))
+	for _, sts := range statefulSets.Items {
+		objects = append(objects, workloadObject{
+			meta:      sts.ObjectMeta,
+			available: sts.Status.ReadyReplicas >= statefulSetReplicas(&sts),
+		})
+	}
+	return objects, nil
+}
+
+func (s *statefulSetWorkload) Get(ctx context.Context, namespace, name string) (metav1.ObjectMeta, *v1.PodTemplateSpec, error) {
+	sts, err := s.client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return metav1.ObjectMeta{}, nil, fmt.Errorf("unable to get statefulset for the name %s err: %w", name, err)
+	}
+	return sts.ObjectMeta, &sts.Spec.Template, nil
+}
+
+func (s *statefulSetWorkload) Replicas(ctx context.Context, namespace, name string) (int32, error) {
+	sts, err := s.client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get statefulset for the name %s err: %w", name, err)
+	}
+	return statefulSetReplicas(sts), nil
+}
+
+func (s *statefulSetWorkload) Quiesce(ctx context.Context, namespace, name string) (int32, error) {
+	return s.scale(ctx, namespace, name, 0)
+}
+
+func (s *statefulSetWorkload) Restore(ctx context.Context, namespace, name string, replicas int32) error {
+	_, err := s.scale(ctx, namespace, name, replicas)
+	return err
+}
+
+// scale scales the specified StatefulSet to the number of replicas specified in scaleTo.
+func (s *statefulSetWorkload) scale(ctx context.Context, namespace, name string, scaleTo int32) (int32, error) {
+	statefulSets := s.client.AppsV1().
+		StatefulSets(namespace)
+
+	sc, err := statefulSets.
+		GetScale(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get statefulset to scale with err: %w", err)
+	}
+
+	originalScale := sc.Spec.Replicas
+	sc.Spec.Replicas = scaleTo
+
+	_, err = statefulSets.
+		UpdateScale(ctx,
+			name, sc, metav1.UpdateOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to scale the statefulset: %w", err)
+	}
+
+	log.Info().Msgf("ctx: %s, successfully scaled statefulset: %s from %d to %d", ctx.Value(diskScalerRunContextKey), name, originalScale, scaleTo)
+	return originalScale, nil
+}
+
+// waitReady waits until the StatefulSet reports the given number of ready replicas.
+func (s *statefulSetWorkload) waitReady(ctx context.Context, namespace, name string, replicas int32) error {
+	return wait.PollUntilContextTimeout(ctx, statefulSetPollInterval, s.readyTimeout, true, func(ctx context.Context) (bool, error) {
+		sts, err := s.client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return sts.Status.ObservedGeneration >= sts.Generation && sts.Status.ReadyReplicas >= replicas, nil
+	})
+}
+
+func (s *statefulSetWorkload) SwapClaim(ctx context.Context, namespace, name, oldClaim, newClaim string) error {
+	statefulSets := s.client.AppsV1().StatefulSets(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, err := statefulSets.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get statefulset: %s err: %w", name, err)
+		}
+		if err := swapClaimInPodSpec(&result.Spec.Template.Spec, oldClaim, newClaim); err != nil {
+			return err
+		}
+		_, err = statefulSets.Update(ctx, result, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (s *statefulSetWorkload) Annotate(ctx context.Context, namespace, name string, annotations map[string]string) error {
+	statefulSets := s.client.AppsV1().StatefulSets(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, err := statefulSets.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get statefulset with err: %w", err)
+		}
+		mergeAnnotations(result, annotations)
+		_, err = statefulSets.Update(ctx, result, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// replicaSetWorkload adapts apps/v1 ReplicaSets which are not managed by another controller.
+// ReplicaSets owned by a Deployment or a Rollout are scaled through their owner.
+type replicaSetWorkload struct {
+	client kubernetes.Interface
+}
+
+func (r *replicaSetWorkload) Kind() string {
+	return workloadKindReplicaSet
+}
+
+func (r *replicaSetWorkload) List(ctx context.Context) ([]workloadObject, error) {
+	replicaSets, err := r.client.
+		AppsV1().
+		ReplicaSets(""). // Empty string lists all ReplicaSets in all Namespaces
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing all ReplicaSets: %s", err)
+	}
+	objects := make([]workloadObject, 0)
+	for _, rs := range replicaSets.Items {
+		if metav1.GetControllerOf(&rs) != nil {
+			continue
+		}
+		objects = append(objects, workloadObject{
+			meta:      rs.ObjectMeta,
+			available: rs.Status.ReadyReplicas >= replicaSetReplicas(&rs),
+		})
+	}
+	return objects, nil
+}
+
+func (r *replicaSetWorkload) Get(ctx context.Context, namespace, name string) (metav1.ObjectMeta, *v1.PodTemplateSpec, error) {
+	rs, err := r.client.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return metav1.ObjectMeta{}, nil, fmt.Errorf("unable to get replicaset for the name %s err: %w", name, err)
+	}
+	return rs.ObjectMeta, &rs.Spec.Template, nil
+}
+
+func (r *replicaSetWorkload) Replicas(ctx context.Context, namespace, name string) (int32, error) {
+	rs, err := r.client.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get replicaset for the name %s err: %w", name, err)
+	}
+	return replicaSetReplicas(rs), nil
+}
+
+func (r *replicaSetWorkload) Quiesce(ctx context.Context, namespace, name string) (int32, error) {
+	return r.scale(ctx, namespace, name, 0)
+}
+
+func (r *replicaSetWorkload) Restore(ctx context.Context, namespace, name string, replicas int32) error {
+	_, err := r.scale(ctx, namespace, name, replicas)
+	return err
+}
+
+// scale scales the specified ReplicaSet to the number of replicas specified in scaleTo.
+func (r *replicaSetWorkload) scale(ctx context.Context, namespace, name string, scaleTo int32) (int32, error) {
+	replicaSets := r.client.AppsV1().
+		ReplicaSets(namespace)
+
+	sc, err := replicaSets.
+		GetScale(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get replicaset to scale with err: %w", err)
+	}
+
+	originalScale := sc.Spec.Replicas
+	sc.Spec.Replicas = scaleTo
+
+	_, err = replicaSets.
+		UpdateScale(ctx,
+			name, sc, metav1.UpdateOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to scale the replicaset: %w", err)
+	}
+
+	log.Info().Msgf("ctx: %s, successfully scaled replicaset: %s from %d to %d", ctx.Value(diskScalerRunContextKey), name, originalScale, scaleTo)
+	return originalScale, nil
+}
+
+func (r *replicaSetWorkload) SwapClaim(ctx context.Context, namespace, name, oldClaim, newClaim string) error {
+	replicaSets := r.client.AppsV1().ReplicaSets(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, err := replicaSets.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get replicaset: %s err: %w", name, err)
+		}
+		if err := swapClaimInPodSpec(&result.Spec.Template.Spec, oldClaim, newClaim); err != nil {
+			return err
+		}
+		_, err = replicaSets.Update(ctx, result, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (r *replicaSetWorkload) Annotate(ctx context.Context, namespace, name string, annotations map[string]string) error {
+	replicaSets := r.client.AppsV1().ReplicaSets(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, err := replicaSets.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get replicaset with err: %w", err)
+		}
+		mergeAnnotations(result, annotations)
+		_, err = replicaSets.Update(ctx, result, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// replicaSetReplicas returns the desired replicas of the ReplicaSet, which defaults to 1.
+func replicaSetReplicas(rs *appsv1.ReplicaSet) int32 {
+	if rs.Spec.Replicas == nil {
+		return 1
+	}
+	return *rs.Spec.Replicas
+}
+
+// deploymentReplicas returns the desired replicas of the Deployment, which defaults to 1.
+func deploymentReplicas(dep *appsv1.Deployment) int32 {
+	if dep.Spec.Replicas == nil {
+		return 1
+	}
+	return *dep.Spec.Replicas
+}
@@ -0,0 +1,222 @@
+package diskscaler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// podWorkload adapts bare Pods which are not managed by any controller. A Pod cannot be scaled and
+// its volumes are immutable, so quiescing deletes the Pod and keeps its definition in memory.
+// Claims are swapped and annotations are written on that definition and the Pod is created from
+// it again when restored.
+type podWorkload struct {
+	client   kubernetes.Interface
+	mu       sync.Mutex
+	quiesced map[string]*v1.Pod
+}
+
+func newPodWorkload(client kubernetes.Interface) *podWorkload {
+	return &podWorkload{
+		client:   client,
+		quiesced: map[string]*v1.Pod{},
+	}
+}
+
+func (p *podWorkload) Kind() string {
+	return workloadKindPod
+}
+
+func (p *podWorkload) List(ctx context.Context) ([]workloadObject, error) {
+	pods, err := p.client.
+		CoreV1().
+		Pods(""). // Empty string lists all Pods in all Namespaces
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing all Pods: %s", err)
+	}
+	objects := make([]workloadObject, 0)
+	for _, pod := range pods.Items {
+		if metav1.GetControllerOf(&pod) != nil {
+			continue
+		}
+		objects = append(objects, workloadObject{
+			meta:      pod.ObjectMeta,
+			available: isPodReady(&pod),
+		})
+	}
+	return objects, nil
+}
+
+func (p *podWorkload) Get(ctx context.Context, namespace, name string) (metav1.ObjectMeta, *v1.PodTemplateSpec, error) {
+	pod, err := p.getPod(ctx, namespace, name)
+	if err != nil {
+		return metav1.ObjectMeta{}, nil, err
+	}
+	return pod.ObjectMeta, &v1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}, nil
+}
+
+// getPod returns the quiesced definition of the Pod when it has been deleted, otherwise the live Pod.
+func (p *podWorkload) getPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
+	p.mu.Lock()
+	pod, ok := p.quiesced[namespace+"/"+name]
+	p.mu.Unlock()
+	if ok {
+		return pod, nil
+	}
+	pod, err := p.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get pod for the name %s err: %w", name, err)
+	}
+	return pod, nil
+}
+
+// Replicas returns 1 for a Pod which exists or is quiesced, a Pod being its only replica.
+func (p *podWorkload) Replicas(ctx context.Context, namespace, name string) (int32, error) {
+	_, err := p.getPod(ctx, namespace, name)
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (p *podWorkload) Quiesce(ctx context.Context, namespace, name string) (int32, error) {
+	key := namespace + "/" + name
+	p.mu.Lock()
+	_, ok := p.quiesced[key]
+	p.mu.Unlock()
+	if ok {
+		return 1, nil
+	}
+
+	pod, err := p.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get pod for the name %s err: %w", name, err)
+	}
+
+	err = p.client.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete pod %s: %w", name, err)
+	}
+
+	p.mu.Lock()
+	p.quiesced[key] = podForRecreation(pod)
+	p.mu.Unlock()
+
+	err = wait.PollUntilContextTimeout(ctx, pvcBoundPollInterval, diskScalingOperationTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := p.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		return k8serrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("timeout waiting for pod %s to be deleted: %w", name, err)
+	}
+
+	log.Info().Msgf("ctx: %s, successfully deleted pod: %s to release its volumes", ctx.Value(diskScalerRunContextKey), name)
+	return 1, nil
+}
+
+func (p *podWorkload) Restore(ctx context.Context, namespace, name string, replicas int32) error {
+	key := namespace + "/" + name
+	p.mu.Lock()
+	pod, ok := p.quiesced[key]
+	p.mu.Unlock()
+	if !ok || replicas == 0 {
+		return nil
+	}
+
+	_, err := p.client.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("unable to recreate pod %s: %w", name, err)
+	}
+
+	p.mu.Lock()
+	delete(p.quiesced, key)
+	p.mu.Unlock()
+
+	log.Info().Msgf("ctx: %s, successfully recreated pod: %s", ctx.Value(diskScalerRunContextKey), name)
+	return nil
+}
+
+// quiescedPod returns a copy of the definition of the quiesced Pod, or nil when it is not quiesced.
+func (p *podWorkload) quiescedPod(namespace, name string) *v1.Pod {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pod, ok := p.quiesced[namespace+"/"+name]
+	if !ok {
+		return nil
+	}
+	return pod.DeepCopy()
+}
+
+// setQuiescedPod restores the definition of a Pod quiesced by a previous run so that it can be recreated.
+func (p *podWorkload) setQuiescedPod(namespace, name string, pod *v1.Pod) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.quiesced[namespace+"/"+name] = pod
+}
+
+func (p *podWorkload) SwapClaim(ctx context.Context, namespace, name, oldClaim, newClaim string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pod, ok := p.quiesced[namespace+"/"+name]
+	if !ok {
+		return fmt.Errorf("volumes of pod %s are immutable, it must be quiesced before swapping pvc %s", name, oldClaim)
+	}
+	return swapClaimInPodSpec(&pod.Spec, oldClaim, newClaim)
+}
+
+func (p *podWorkload) Annotate(ctx context.Context, namespace, name string, annotations map[string]string) error {
+	p.mu.Lock()
+	pod, ok := p.quiesced[namespace+"/"+name]
+	if ok {
+		mergeAnnotations(pod, annotations)
+	}
+	p.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	data := fmt.Sprintf(`{"metadata":{"annotations":%s}}`, mustMarshalJSON(annotations))
+	_, err := p.client.CoreV1().Pods(namespace).Patch(ctx, name, types.MergePatchType, []byte(data), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate the pod with err: %w", err)
+	}
+	return nil
+}
+
+// podForRecreation strips the fields set by the API server and the scheduler so that the
+// Pod can be created again and scheduled wherever its new volumes are.
+func podForRecreation(pod *v1.Pod) *v1.Pod {
+	recreated := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pod.Name,
+			Namespace:   pod.Namespace,
+			Labels:      pod.Labels,
+			Annotations: pod.Annotations,
+		},
+		Spec: *pod.Spec.DeepCopy(),
+	}
+	recreated.Spec.NodeName = ""
+	return recreated
+}
+
+// isPodReady returns true when the Pod is running and reports the Ready condition.
+func isPodReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
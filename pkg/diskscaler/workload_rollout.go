@@ -0,0 +1,181 @@
+package diskscaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+var rolloutGVR = schema.GroupVersionResource{
+	Group:    "argoproj.io",
+	Version:  "v1alpha1",
+	Resource: "rollouts",
+}
+
+// rolloutWorkload adapts Argo Rollouts through the dynamic client. Clusters without the
+// Rollout custom resource definition simply have no Rollouts to list.
+type rolloutWorkload struct {
+	client dynamic.Interface
+}
+
+func (r *rolloutWorkload) Kind() string {
+	return workloadKindRollout
+}
+
+func (r *rolloutWorkload) List(ctx context.Context) ([]workloadObject, error) {
+	rollouts, err := r.client.Resource(rolloutGVR).Namespace("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			log.Trace().Msgf("argo rollouts are not installed, skipping rollouts")
+			return nil, nil
+		}
+		return nil, fmt.Errorf("listing all Rollouts: %s", err)
+	}
+	objects := make([]workloadObject, 0, len(rollouts.Items))
+	for _, rollout := range rollouts.Items {
+		available, _, _ := unstructured.NestedInt64(rollout.Object, "status", "availableReplicas")
+		objects = append(objects, workloadObject{
+			meta:      objectMetaFromUnstructured(&rollout),
+			available: available >= rolloutReplicas(&rollout),
+		})
+	}
+	return objects, nil
+}
+
+func (r *rolloutWorkload) Get(ctx context.Context, namespace, name string) (metav1.ObjectMeta, *v1.PodTemplateSpec, error) {
+	rollout, err := r.client.Resource(rolloutGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return metav1.ObjectMeta{}, nil, fmt.Errorf("unable to get rollout for the name %s err: %w", name, err)
+	}
+	templateObj, found, err := unstructured.NestedMap(rollout.Object, "spec", "template")
+	if err != nil || !found {
+		return metav1.ObjectMeta{}, nil, fmt.Errorf("rollout %s has no pod template, rollouts using workloadRef are not supported", name)
+	}
+	template := &v1.PodTemplateSpec{}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(templateObj, template)
+	if err != nil {
+		return metav1.ObjectMeta{}, nil, fmt.Errorf("unable to parse pod template of rollout %s: %w", name, err)
+	}
+	return objectMetaFromUnstructured(rollout), template, nil
+}
+
+func (r *rolloutWorkload) Replicas(ctx context.Context, namespace, name string) (int32, error) {
+	rollout, err := r.client.Resource(rolloutGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get rollout for the name %s err: %w", name, err)
+	}
+	return int32(rolloutReplicas(rollout)), nil
+}
+
+func (r *rolloutWorkload) Quiesce(ctx context.Context, namespace, name string) (int32, error) {
+	return r.scale(ctx, namespace, name, 0)
+}
+
+func (r *rolloutWorkload) Restore(ctx context.Context, namespace, name string, replicas int32) error {
+	_, err := r.scale(ctx, namespace, name, replicas)
+	return err
+}
+
+// scale scales the specified Rollout to the number of replicas specified in scaleTo.
+func (r *rolloutWorkload) scale(ctx context.Context, namespace, name string, scaleTo int32) (int32, error) {
+	rollouts := r.client.Resource(rolloutGVR).Namespace(namespace)
+	rollout, err := rollouts.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rollout to scale with err: %w", err)
+	}
+	originalScale := int32(rolloutReplicas(rollout))
+
+	data := fmt.Sprintf(`{"spec":{"replicas":%d}}`, scaleTo)
+	_, err = rollouts.Patch(ctx, name, types.MergePatchType, []byte(data), metav1.PatchOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to scale the rollout: %w", err)
+	}
+
+	log.Info().Msgf("ctx: %s, successfully scaled rollout: %s from %d to %d", ctx.Value(diskScalerRunContextKey), name, originalScale, scaleTo)
+	return originalScale, nil
+}
+
+func (r *rolloutWorkload) SwapClaim(ctx context.Context, namespace, name, oldClaim, newClaim string) error {
+	rollouts := r.client.Resource(rolloutGVR).Namespace(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		rollout, err := rollouts.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get rollout: %s err: %w", name, err)
+		}
+		volumes, _, err := unstructured.NestedSlice(rollout.Object, "spec", "template", "spec", "volumes")
+		if err != nil {
+			return fmt.Errorf("unable to read volumes of rollout %s: %w", name, err)
+		}
+		swapped := false
+		for _, vol := range volumes {
+			volume, ok := vol.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			claimName, found, _ := unstructured.NestedString(volume, "persistentVolumeClaim", "claimName")
+			if !found || claimName != oldClaim {
+				continue
+			}
+			if err := unstructured.SetNestedField(volume, newClaim, "persistentVolumeClaim", "claimName"); err != nil {
+				return err
+			}
+			swapped = true
+			break
+		}
+		if !swapped {
+			return fmt.Errorf("no volume uses pvc %s", oldClaim)
+		}
+		if err := unstructured.SetNestedSlice(rollout.Object, volumes, "spec", "template", "spec", "volumes"); err != nil {
+			return err
+		}
+		_, err = rollouts.Update(ctx, rollout, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (r *rolloutWorkload) Annotate(ctx context.Context, namespace, name string, annotations map[string]string) error {
+	data := fmt.Sprintf(`{"metadata":{"annotations":%s}}`, mustMarshalJSON(annotations))
+	_, err := r.client.Resource(rolloutGVR).Namespace(namespace).Patch(ctx, name, types.MergePatchType, []byte(data), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate the rollout with err: %w", err)
+	}
+	return nil
+}
+
+// rolloutReplicas returns the desired replicas of the Rollout, which defaults to 1.
+func rolloutReplicas(rollout *unstructured.Unstructured) int64 {
+	replicas, found, err := unstructured.NestedInt64(rollout.Object, "spec", "replicas")
+	if err != nil || !found {
+		return 1
+	}
+	return replicas
+}
+
+// objectMetaFromUnstructured returns the metadata of an unstructured object needed to evaluate it.
+func objectMetaFromUnstructured(obj *unstructured.Unstructured) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        obj.GetName(),
+		Namespace:   obj.GetNamespace(),
+		UID:         obj.GetUID(),
+		Labels:      obj.GetLabels(),
+		Annotations: obj.GetAnnotations(),
+	}
+}
+
+// mustMarshalJSON marshals a string map, which cannot fail.
+func mustMarshalJSON(m map[string]string) string {
+	b, _ := json.Marshal(m)
+	return string(b)
+}
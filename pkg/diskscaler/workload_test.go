@@ -0,0 +1,111 @@
+package diskscaler
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_swapClaimInPodSpec(t *testing.T) {
+	cases := map[string]struct {
+		oldClaim    string
+		expectErr   bool
+		expectedPVC []string
+	}{
+		"when the claim is mounted it is replaced": {
+			oldClaim:    "data",
+			expectedPVC: []string{"data-abcde", "logs"},
+		},
+		"when the claim is not mounted an error is returned": {
+			oldClaim:    "cache",
+			expectErr:   true,
+			expectedPVC: []string{"data", "logs"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			spec := &v1.PodSpec{
+				Volumes: []v1.Volume{
+					{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+					{Name: "config", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+					{Name: "logs", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "logs"}}},
+				},
+			}
+			err := swapClaimInPodSpec(spec, tc.oldClaim, "data-abcde")
+			if tc.expectErr != (err != nil) {
+				t.Fatalf("for test case: `%s`, expected error %t but received %v", name, tc.expectErr, err)
+			}
+			claims := []string{}
+			for _, vol := range spec.Volumes {
+				if vol.PersistentVolumeClaim != nil {
+					claims = append(claims, vol.PersistentVolumeClaim.ClaimName)
+				}
+			}
+			for i, claim := range tc.expectedPVC {
+				if claims[i] != claim {
+					t.Fatalf("for test case: `%s`, expected claim %s but received %s", name, claim, claims[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_podWorkload(t *testing.T) {
+	ctx := context.Background()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "team"},
+		Spec: v1.PodSpec{
+			NodeName: "node-a",
+			Volumes: []v1.Volume{
+				{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(pod)
+	wl := newPodWorkload(client)
+
+	err := wl.SwapClaim(ctx, "team", "db", "data", "data-abcde")
+	if err == nil {
+		t.Fatalf("expected swapping the claim of a running pod to fail")
+	}
+
+	replicas, err := wl.Quiesce(ctx, "team", "db")
+	if err != nil {
+		t.Fatalf("unexpected err quiescing pod: %v", err)
+	}
+	if replicas != 1 {
+		t.Fatalf("expected 1 replica but received %d", replicas)
+	}
+
+	err = wl.SwapClaim(ctx, "team", "db", "data", "data-abcde")
+	if err != nil {
+		t.Fatalf("unexpected err swapping claim: %v", err)
+	}
+	err = wl.Annotate(ctx, "team", "db", map[string]string{AnnotationLastScaled: "2024-05-16T22:44:21Z"})
+	if err != nil {
+		t.Fatalf("unexpected err annotating pod: %v", err)
+	}
+
+	err = wl.Restore(ctx, "team", "db", replicas)
+	if err != nil {
+		t.Fatalf("unexpected err restoring pod: %v", err)
+	}
+
+	recreated, err := client.CoreV1().Pods("team").Get(ctx, "db", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected pod to be recreated: %v", err)
+	}
+	if claim := recreated.Spec.Volumes[0].PersistentVolumeClaim.ClaimName; claim != "data-abcde" {
+		t.Fatalf("expected recreated pod to use claim data-abcde but received %s", claim)
+	}
+	if recreated.Spec.NodeName != "" {
+		t.Fatalf("expected recreated pod to be scheduled again but it is bound to %s", recreated.Spec.NodeName)
+	}
+	if recreated.Annotations[AnnotationLastScaled] == "" {
+		t.Fatalf("expected recreated pod to have annotation %s", AnnotationLastScaled)
+	}
+}
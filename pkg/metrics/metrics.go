@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "disk_autoscaler"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+
+	DirectionExpand = "expand"
+	DirectionShrink = "shrink"
+)
+
+// volumeLabels identify the series of the volume of a claim
+var volumeLabels = []string{"namespace", "pvc", "pv"}
+
+var (
+	// Runs counts the runs of the disk scaling loop by outcome.
+	Runs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Runs of the disk scaling loop by outcome.",
+	}, []string{"outcome"})
+
+	// RunDuration observes how long the runs of the disk scaling loop take.
+	RunDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of the runs of the disk scaling loop.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	})
+
+	// EnabledWorkloads is the number of workloads with disk auto scaling enabled at the last run.
+	EnabledWorkloads = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "enabled_workloads",
+		Help:      "Workloads with disk auto scaling enabled at the last run.",
+	})
+
+	// EligibleWorkloads is the number of workloads due for disk scaling at the last run.
+	EligibleWorkloads = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "eligible_workloads",
+		Help:      "Workloads due for disk scaling at the last run.",
+	})
+
+	// WorkloadRuns counts the disk scaling workflows run for a workload by outcome.
+	WorkloadRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workload_runs_total",
+		Help:      "Disk scaling workflows run for a workload by outcome.",
+	}, []string{"outcome"})
+
+	// Resizes counts the resizes of claims by direction, outcome and whether they were emergency expansions.
+	Resizes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resize_operations_total",
+		Help:      "Resizes of persistent volume claims by direction, outcome and whether they were emergency expansions.",
+	}, []string{"direction", "outcome", "emergency"})
+
+	// CopyDuration observes how long the data mover takes to copy a volume by outcome.
+	CopyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "copy_duration_seconds",
+		Help:      "Duration of the copies of volumes by the data mover by outcome.",
+		Buckets:   prometheus.ExponentialBuckets(10, 2, 12),
+	}, []string{"outcome"})
+
+	// CopyBytes observes the size of the data copied by the data mover.
+	CopyBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "copy_bytes",
+		Help:      "Bytes of data on the volumes copied by the data mover.",
+		Buckets:   prometheus.ExponentialBuckets(1<<20, 4, 12),
+	})
+
+	// KubecostRequestDuration observes the latency of the requests to the Kubecost API.
+	KubecostRequestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kubecost_request_duration_seconds",
+		Help:      "Latency of the requests for recommendations to the Kubecost API.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// KubecostRequestErrors counts the requests to the Kubecost API which failed or got a non-OK response.
+	KubecostRequestErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kubecost_request_errors_total",
+		Help:      "Requests for recommendations to the Kubecost API which failed or got a non-OK response.",
+	})
+
+	// VolumeCurrentSize is the current size of the volume of a claim at the last evaluation.
+	VolumeCurrentSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "volume_current_size_bytes",
+		Help:      "Current size of the persistent volume of a claim at its last evaluation.",
+	}, volumeLabels)
+
+	// VolumeRecommendedSize is the recommended size of the volume of a claim at the last evaluation.
+	VolumeRecommendedSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "volume_recommended_size_bytes",
+		Help:      "Recommended size of the persistent volume of a claim at its last evaluation.",
+	}, volumeLabels)
+
+	// VolumeProjectedSavings is the projected monthly savings of resizing the volume of a claim to its recommended size.
+	VolumeProjectedSavings = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "volume_projected_monthly_savings",
+		Help:      "Projected monthly savings, in the currency of the recommender, of resizing the persistent volume of a claim to its recommended size.",
+	}, volumeLabels)
+)
+
+func init() {
+	prometheus.MustRegister(
+		Runs,
+		RunDuration,
+		EnabledWorkloads,
+		EligibleWorkloads,
+		WorkloadRuns,
+		Resizes,
+		CopyDuration,
+		CopyBytes,
+		KubecostRequestDuration,
+		KubecostRequestErrors,
+		VolumeCurrentSize,
+		VolumeRecommendedSize,
+		VolumeProjectedSavings,
+	)
+}
+
+// Handler serves the metrics of the disk auto scaler along with the Go runtime and process metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Outcome returns the outcome label of an operation which returned err.
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// ForgetVolume deletes the series of a claim, whose volume was replaced or which no longer exists.
+func ForgetVolume(namespace string, pvc string) {
+	labels := prometheus.Labels{"namespace": namespace, "pvc": pvc}
+	VolumeCurrentSize.DeletePartialMatch(labels)
+	VolumeRecommendedSize.DeletePartialMatch(labels)
+	VolumeProjectedSavings.DeletePartialMatch(labels)
+}
@@ -0,0 +1,66 @@
+package provisioner
+
+import (
+	"time"
+
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	waitForFirstConsumerOnly = []storagev1.VolumeBindingMode{storagev1.VolumeBindingWaitForFirstConsumer}
+	allBindingModes          = []storagev1.VolumeBindingMode{storagev1.VolumeBindingWaitForFirstConsumer, storagev1.VolumeBindingImmediate}
+)
+
+// The drivers shipped with the disk auto scaler. Zonal block storage only supports
+// WaitForFirstConsumer so that a new volume is provisioned next to the original one,
+// network storage reachable from every node supports both binding modes.
+func init() {
+	// AWS EBS does not allow modifying a volume more than once every 6 hours
+	Register(Driver{
+		Name:            "ebs.csi.aws.com",
+		MinSize:         resource.MustParse("1Gi"),
+		Granularity:     resource.MustParse("1Gi"),
+		ResizeCooldown:  6 * time.Hour,
+		OnlineExpansion: true,
+		BindingModes:    waitForFirstConsumerOnly,
+	})
+	// Standard persistent disks cannot be smaller than 10GB
+	Register(Driver{
+		Name:            "pd.csi.storage.gke.io",
+		MinSize:         resource.MustParse("10Gi"),
+		Granularity:     resource.MustParse("1Gi"),
+		OnlineExpansion: true,
+		BindingModes:    waitForFirstConsumerOnly,
+	})
+	Register(Driver{
+		Name:            "disk.csi.azure.com",
+		MinSize:         resource.MustParse("1Gi"),
+		Granularity:     resource.MustParse("1Gi"),
+		OnlineExpansion: true,
+		BindingModes:    waitForFirstConsumerOnly,
+	})
+	Register(Driver{
+		Name:            "rbd.csi.ceph.com",
+		MinSize:         resource.MustParse("1Gi"),
+		Granularity:     resource.MustParse("1Mi"),
+		OnlineExpansion: true,
+		BindingModes:    allBindingModes,
+	})
+	// Longhorn volumes must be detached to be expanded on most releases
+	Register(Driver{
+		Name:            "driver.longhorn.io",
+		MinSize:         resource.MustParse("1Gi"),
+		Granularity:     resource.MustParse("2Mi"),
+		OnlineExpansion: false,
+		BindingModes:    allBindingModes,
+	})
+	// TopoLVM allocates logical volumes in units of 1GiB on the node the pod is scheduled to
+	Register(Driver{
+		Name:            "topolvm.io",
+		MinSize:         resource.MustParse("1Gi"),
+		Granularity:     resource.MustParse("1Gi"),
+		OnlineExpansion: true,
+		BindingModes:    waitForFirstConsumerOnly,
+	})
+}
@@ -0,0 +1,90 @@
+// Package provisioner describes the CSI provisioners supported by the disk auto scaler
+// along with the constraints each of them puts on resizing a volume.
+package provisioner
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Driver describes the resize capabilities of a CSI provisioner.
+type Driver struct {
+	// Name is the provisioner set on the StorageClass, e.g. ebs.csi.aws.com
+	Name string
+	// MinSize is the smallest volume the provisioner creates
+	MinSize resource.Quantity
+	// Granularity is the unit volume sizes are rounded up to
+	Granularity resource.Quantity
+	// ResizeCooldown is the time to wait after a volume was modified before it can be modified again
+	ResizeCooldown time.Duration
+	// OnlineExpansion is true when a volume can be expanded while it is attached to a running pod
+	OnlineExpansion bool
+	// BindingModes are the StorageClass volume binding modes under which a volume can be shrunk
+	BindingModes []storagev1.VolumeBindingMode
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Driver{}
+)
+
+// Register adds a driver to the registry, replacing any driver registered with the same name.
+func Register(d Driver) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[d.Name] = d
+}
+
+// Lookup returns the driver registered for the provisioner name.
+func Lookup(name string) (Driver, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d, ok := registry[name]
+	return d, ok
+}
+
+// Names returns the names of every registered driver in sorted order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// SupportsBindingMode returns true if volumes of a StorageClass with the binding mode can be shrunk.
+func (d Driver) SupportsBindingMode(mode storagev1.VolumeBindingMode) bool {
+	return slices.Contains(d.BindingModes, mode)
+}
+
+// Normalize rounds size up to the granularity of the driver and raises it to the minimum size.
+func (d Driver) Normalize(size resource.Quantity) resource.Quantity {
+	bytes := size.Value()
+	if granularity := d.Granularity.Value(); granularity > 0 && bytes%granularity != 0 {
+		bytes = (bytes/granularity + 1) * granularity
+	}
+	if minBytes := d.MinSize.Value(); bytes < minBytes {
+		bytes = minBytes
+	}
+	return *resource.NewQuantity(bytes, resource.BinarySI)
+}
+
+// CooldownRemaining returns how long to wait before a volume last resized at lastResized
+// can be resized again. A zero lastResized means the volume was never resized.
+func (d Driver) CooldownRemaining(lastResized time.Time, now time.Time) time.Duration {
+	if lastResized.IsZero() || d.ResizeCooldown == 0 {
+		return 0
+	}
+	remaining := lastResized.Add(d.ResizeCooldown).Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
@@ -0,0 +1,140 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func Test_Normalize(t *testing.T) {
+	type testCase struct {
+		name         string
+		driver       string
+		size         resource.Quantity
+		expectedSize resource.Quantity
+	}
+
+	testCases := []testCase{
+		{
+			name:         "when size is a multiple of the granularity",
+			driver:       "ebs.csi.aws.com",
+			size:         resource.MustParse("4Gi"),
+			expectedSize: resource.MustParse("4Gi"),
+		},
+		{
+			name:         "when size is rounded up to the granularity",
+			driver:       "ebs.csi.aws.com",
+			size:         resource.MustParse("3500Mi"),
+			expectedSize: resource.MustParse("4Gi"),
+		},
+		{
+			name:         "when size is below the minimum size",
+			driver:       "pd.csi.storage.gke.io",
+			size:         resource.MustParse("2Gi"),
+			expectedSize: resource.MustParse("10Gi"),
+		},
+		{
+			name:         "when size in decimal units is rounded up to a fine granularity",
+			driver:       "driver.longhorn.io",
+			size:         resource.MustParse("2G"),
+			expectedSize: resource.MustParse("1908Mi"),
+		},
+	}
+
+	for _, tc := range testCases {
+		d, ok := Lookup(tc.driver)
+		if !ok {
+			t.Fatalf("test '%s': driver %s is not registered", tc.name, tc.driver)
+		}
+		size := d.Normalize(tc.size)
+		if size.Cmp(tc.expectedSize) != 0 {
+			t.Fatalf("test '%s': failed expected size is %s but received %s", tc.name, tc.expectedSize.String(), size.String())
+		}
+	}
+}
+
+func Test_CooldownRemaining(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	type testCase struct {
+		name              string
+		driver            string
+		lastResized       time.Time
+		expectedRemaining time.Duration
+	}
+
+	testCases := []testCase{
+		{
+			name:              "when the volume was never resized",
+			driver:            "ebs.csi.aws.com",
+			lastResized:       time.Time{},
+			expectedRemaining: 0,
+		},
+		{
+			name:              "when the volume was resized within the cooldown",
+			driver:            "ebs.csi.aws.com",
+			lastResized:       now.Add(-2 * time.Hour),
+			expectedRemaining: 4 * time.Hour,
+		},
+		{
+			name:              "when the cooldown has passed",
+			driver:            "ebs.csi.aws.com",
+			lastResized:       now.Add(-7 * time.Hour),
+			expectedRemaining: 0,
+		},
+		{
+			name:              "when the driver has no cooldown",
+			driver:            "rbd.csi.ceph.com",
+			lastResized:       now.Add(-time.Minute),
+			expectedRemaining: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		d, _ := Lookup(tc.driver)
+		remaining := d.CooldownRemaining(tc.lastResized, now)
+		if remaining != tc.expectedRemaining {
+			t.Fatalf("test '%s': failed expected remaining cooldown is %s but received %s", tc.name, tc.expectedRemaining, remaining)
+		}
+	}
+}
+
+func Test_SupportsBindingMode(t *testing.T) {
+	type testCase struct {
+		name            string
+		driver          string
+		mode            storagev1.VolumeBindingMode
+		expectedSupport bool
+	}
+
+	testCases := []testCase{
+		{
+			name:            "when zonal block storage uses WaitForFirstConsumer",
+			driver:          "disk.csi.azure.com",
+			mode:            storagev1.VolumeBindingWaitForFirstConsumer,
+			expectedSupport: true,
+		},
+		{
+			name:            "when zonal block storage uses Immediate",
+			driver:          "topolvm.io",
+			mode:            storagev1.VolumeBindingImmediate,
+			expectedSupport: false,
+		},
+		{
+			name:            "when network storage uses Immediate",
+			driver:          "rbd.csi.ceph.com",
+			mode:            storagev1.VolumeBindingImmediate,
+			expectedSupport: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		d, _ := Lookup(tc.driver)
+		supported := d.SupportsBindingMode(tc.mode)
+		if supported != tc.expectedSupport {
+			t.Fatalf("test '%s': failed expected support is %t but received %t", tc.name, tc.expectedSupport, supported)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package pvsizingrecommendation
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ForecastLinear = "linear"
+	ForecastHolt   = "holt"
+
+	defaultForecastLookback = 7 * 24 * time.Hour
+	minForecastSamples      = 3
+	// Smoothing factors of the level and the trend for Holt's linear method
+	holtAlpha = 0.5
+	holtBeta  = 0.3
+)
+
+// UsageHistoryProvider returns the usage samples of a volume collected since the given time, oldest first.
+type UsageHistoryProvider interface {
+	UsageHistory(ctx context.Context, pvName string, since time.Time) ([]VolumeUsage, error)
+}
+
+// ForecastRecommender sizes volumes for the usage projected through the next time they
+// can be scaled, i.e. the interval, so that a growing volume is not shrunk just before
+// it fills up. The recommendation of the base recommender is never reduced.
+type ForecastRecommender struct {
+	base    Recommender
+	history UsageHistoryProvider
+	method  string
+	// lookback is how much usage history the trend is fitted on
+	lookback time.Duration
+	// pricePerGiBMonth is used to adjust the savings of the base recommendation
+	pricePerGiBMonth float64
+	now              func() time.Time
+}
+
+func NewForecastRecommender(base Recommender, history UsageHistoryProvider, method string, lookback time.Duration, pricePerGiBMonth float64) (*ForecastRecommender, error) {
+	if method != ForecastLinear && method != ForecastHolt {
+		return nil, fmt.Errorf("unsupported forecast method %q, supported methods are %s and %s", method, ForecastLinear, ForecastHolt)
+	}
+	if lookback <= 0 {
+		lookback = defaultForecastLookback
+	}
+	return &ForecastRecommender{
+		base:             base,
+		history:          history,
+		method:           method,
+		lookback:         lookback,
+		pricePerGiBMonth: pricePerGiBMonth,
+		now:              time.Now,
+	}, nil
+}
+
+func (fr *ForecastRecommender) GetRecommendation(ctx context.Context, pvName string, targetUtilization int, interval string) (RecommendationSizeWithSavings, error) {
+	recommendation, err := fr.base.GetRecommendation(ctx, pvName, targetUtilization, interval)
+	if err != nil {
+		return recommendation, err
+	}
+	if targetUtilization <= 0 || targetUtilization > 100 {
+		targetUtilization = overwriteTargetUtilization
+	}
+	horizon, err := ParseInterval(interval)
+	if err != nil {
+		return recommendation, fmt.Errorf("invalid window %s: %w", interval, err)
+	}
+
+	now := fr.now()
+	samples, err := fr.history.UsageHistory(ctx, pvName, now.Add(-fr.lookback))
+	if err != nil {
+		log.Debug().Msgf("unable to get usage history of pv %s, using the recommendation without forecast: %v", pvName, err)
+		return recommendation, nil
+	}
+	if len(samples) < minForecastSamples {
+		log.Debug().Msgf("only %d usage samples of pv %s, using the recommendation without forecast", len(samples), pvName)
+		return recommendation, nil
+	}
+
+	var fc forecast
+	switch fr.method {
+	case ForecastHolt:
+		fc = holtForecast(samples)
+	default:
+		fc = linearForecast(samples)
+	}
+
+	projectedBytes := math.Max(fc.at(now.Add(horizon)), samples[len(samples)-1].UsedBytes)
+	recommendation.ProjectedFullAt = fc.reaches(samples[len(samples)-1].CapacityBytes, now)
+
+	forecastSize, err := storageRecommendationFromBytes(projectedBytes / (float64(targetUtilization) / 100))
+	if err != nil {
+		return recommendation, fmt.Errorf("failed to convert forecast bytes recommendation to storage request: %w", err)
+	}
+	if forecastSize.Cmp(recommendation.RecommendedResourceSize) > 0 {
+		extraGiB := float64(forecastSize.Value()-recommendation.RecommendedResourceSize.Value()) / oneGiBytes
+		recommendation.Savings -= extraGiB * fr.pricePerGiBMonth
+		recommendation.RecommendedResourceSize = forecastSize
+	}
+
+	log.Debug().Msgf("%s forecast of pv %s is %.0f bytes in %s growing %.0f bytes per hour, recommended size is %s", fr.method, pvName, projectedBytes, horizon, fc.slope*3600, recommendation.RecommendedResourceSize.String())
+	return recommendation, nil
+}
+
+// forecast is a linear trend of usage in bytes, anchored at a point in time.
+type forecast struct {
+	origin time.Time
+	level  float64
+	// slope is the growth in bytes per second
+	slope float64
+}
+
+// at returns the usage projected at t.
+func (f forecast) at(t time.Time) float64 {
+	return f.level + f.slope*t.Sub(f.origin).Seconds()
+}
+
+// reaches returns when the projected usage reaches capacityBytes, which is zero when it never does.
+func (f forecast) reaches(capacityBytes float64, now time.Time) time.Time {
+	if capacityBytes <= 0 {
+		return time.Time{}
+	}
+	if f.at(now) >= capacityBytes {
+		return now
+	}
+	if f.slope <= 0 {
+		return time.Time{}
+	}
+	seconds := (capacityBytes - f.level) / f.slope
+	return f.origin.Add(time.Duration(seconds * float64(time.Second)))
+}
+
+// linearForecast fits the usage with a least squares line.
+func linearForecast(samples []VolumeUsage) forecast {
+	origin := samples[0].Time
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range samples {
+		x := sample.Time.Sub(origin).Seconds()
+		sumX += x
+		sumY += sample.UsedBytes
+		sumXY += x * sample.UsedBytes
+		sumXX += x * x
+	}
+	n := float64(len(samples))
+	denominator := n*sumXX - sumX*sumX
+	if almostEqual(denominator, 0.0) {
+		return forecast{origin: origin, level: sumY / n}
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	return forecast{
+		origin: origin,
+		level:  (sumY - slope*sumX) / n,
+		slope:  slope,
+	}
+}
+
+// holtForecast smooths the level and the trend of the usage with Holt's linear method,
+// which follows recent changes of the growth rate more closely than a least squares line.
+func holtForecast(samples []VolumeUsage) forecast {
+	level := samples[0].UsedBytes
+	// trend is the growth in bytes per second
+	trend := 0.0
+	for i := 1; i < len(samples); i++ {
+		elapsed := samples[i].Time.Sub(samples[i-1].Time).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		previousLevel := level
+		level = holtAlpha*samples[i].UsedBytes + (1-holtAlpha)*(level+trend*elapsed)
+		trend = holtBeta*(level-previousLevel)/elapsed + (1-holtBeta)*trend
+	}
+	return forecast{
+		origin: samples[len(samples)-1].Time,
+		level:  level,
+		slope:  trend,
+	}
+}
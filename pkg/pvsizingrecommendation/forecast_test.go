@@ -0,0 +1,110 @@
+package pvsizingrecommendation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// stubHistory returns the samples it holds for every PV.
+type stubHistory []VolumeUsage
+
+func (sh stubHistory) UsageHistory(ctx context.Context, pvName string, since time.Time) ([]VolumeUsage, error) {
+	return sh, nil
+}
+
+// growingUsage returns hourly samples of a 100Gi volume over the last day growing by gibPerDay.
+func growingUsage(now time.Time, startGiB, gibPerDay float64) stubHistory {
+	samples := stubHistory{}
+	for h := 24; h >= 0; h-- {
+		elapsedDays := float64(24-h) / 24
+		samples = append(samples, VolumeUsage{
+			Time:          now.Add(-time.Duration(h) * time.Hour),
+			UsedBytes:     (startGiB + gibPerDay*elapsedDays) * oneGiBytes,
+			CapacityBytes: 100 * oneGiBytes,
+		})
+	}
+	return samples
+}
+
+func Test_ForecastRecommender(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	type testCase struct {
+		name                    string
+		method                  string
+		history                 stubHistory
+		baseSize                string
+		interval                string
+		expectedQuantity        resource.Quantity
+		expectedProjectedFullAt time.Time
+	}
+
+	testCases := []testCase{
+		{
+			name:   "when a volume grows 5Gi a day it is sized for the usage in 2 days",
+			method: ForecastLinear,
+			// 20Gi now and 30Gi in 2 days, which needs 43Gi at 70% target utilization
+			history:                 growingUsage(now, 15, 5),
+			baseSize:                "29Gi",
+			interval:                "2d",
+			expectedQuantity:        resource.MustParse("43Gi"),
+			expectedProjectedFullAt: now.Add(16 * 24 * time.Hour),
+		},
+		{
+			name:                    "when a volume grows 5Gi a day and holt's method follows the trend",
+			method:                  ForecastHolt,
+			history:                 growingUsage(now, 15, 5),
+			baseSize:                "29Gi",
+			interval:                "2d",
+			expectedQuantity:        resource.MustParse("43Gi"),
+			expectedProjectedFullAt: now.Add(16 * 24 * time.Hour),
+		},
+		{
+			name:             "when a volume does not grow the base recommendation is kept",
+			method:           ForecastLinear,
+			history:          growingUsage(now, 20, 0),
+			baseSize:         "29Gi",
+			interval:         "2d",
+			expectedQuantity: resource.MustParse("29Gi"),
+		},
+		{
+			name:             "when a volume shrinks the base recommendation is kept",
+			method:           ForecastHolt,
+			history:          growingUsage(now, 20, -5),
+			baseSize:         "29Gi",
+			interval:         "2d",
+			expectedQuantity: resource.MustParse("29Gi"),
+		},
+		{
+			name:             "when there is not enough history the base recommendation is kept",
+			method:           ForecastLinear,
+			history:          growingUsage(now, 15, 5)[:2],
+			baseSize:         "29Gi",
+			interval:         "2d",
+			expectedQuantity: resource.MustParse("29Gi"),
+		},
+	}
+
+	for _, tc := range testCases {
+		base := &stubRecommender{sizes: map[string]string{"pv-1": tc.baseSize}}
+		fr, err := NewForecastRecommender(base, tc.history, tc.method, 0, 0)
+		if err != nil {
+			t.Fatalf("test case %s: received unexpected err: %s", tc.name, err)
+		}
+		fr.now = func() time.Time { return now }
+
+		recommendation, err := fr.GetRecommendation(context.Background(), "pv-1", 70, tc.interval)
+		if err != nil {
+			t.Fatalf("test case %s: received unexpected err: %s", tc.name, err)
+		}
+		if recommendation.RecommendedResourceSize.Cmp(tc.expectedQuantity) != 0 {
+			t.Fatalf("test case %s: failed expected quantity %s but received %s", tc.name, tc.expectedQuantity.String(), recommendation.RecommendedResourceSize.String())
+		}
+		if diff := recommendation.ProjectedFullAt.Sub(tc.expectedProjectedFullAt).Abs(); diff > time.Hour {
+			t.Fatalf("test case %s: failed expected projected full at %s but received %s", tc.name, tc.expectedProjectedFullAt, recommendation.ProjectedFullAt)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package pvsizingrecommendation
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultKubeletScrapeInterval   = 5 * time.Minute
+	defaultKubeletHistoryRetention = 7 * 24 * time.Hour
+	kubeletHistoryConfigMapKey     = "history.json.gz"
+	// ConfigMaps are limited to 1MiB, leave room for the rest of the object
+	maxKubeletHistoryConfigMapBytes = 900 * 1024
+)
+
+// VolumeUsage is a sample of the usage of a volume.
+type VolumeUsage struct {
+	Time          time.Time `json:"t"`
+	UsedBytes     float64   `json:"u"`
+	CapacityBytes float64   `json:"c"`
+	InodesUsed    float64   `json:"i"`
+}
+
+// KubeletServiceOptions configures the kubelet recommender.
+type KubeletServiceOptions struct {
+	// ScrapeInterval is how often every node is asked for the usage of its volumes
+	ScrapeInterval time.Duration
+	// Retention is how long the usage history of a volume is kept
+	Retention time.Duration
+	// Percentile of the usage in the window the volume is sized for, 0 sizes for the peak usage
+	Percentile float64
+	// PricePerGiBMonth is used to estimate the monthly savings of a recommendation
+	PricePerGiBMonth float64
+	// HistoryNamespace and HistoryConfigMap name the ConfigMap the history is persisted to,
+	// the history is only kept in memory when HistoryConfigMap is empty
+	HistoryNamespace string
+	HistoryConfigMap string
+}
+
+// KubeletService recommends PV sizes from the volume stats each kubelet reports in its
+// stats/summary API, read through the API server node proxy. The usage of every volume
+// is sampled on an interval into a rolling history, so the Kubernetes API is the only
+// dependency.
+type KubeletService struct {
+	client  kubernetes.Interface
+	options KubeletServiceOptions
+	// fetchSummary returns the stats/summary of a node
+	fetchSummary func(ctx context.Context, nodeName string) ([]byte, error)
+	mu           sync.RWMutex
+	history      map[string][]VolumeUsage
+}
+
+func NewKubeletService(client kubernetes.Interface, options KubeletServiceOptions) *KubeletService {
+	svc := newKubeletService(client, options)
+	svc.loadHistory(context.Background())
+
+	ticker := time.NewTicker(svc.options.ScrapeInterval)
+	go func() {
+		for t := time.Now(); ; t = <-ticker.C {
+			log.Trace().Msgf("collecting volume usage from kubelets at %s", t.String())
+			ctx, cancel := context.WithTimeout(context.Background(), svc.options.ScrapeInterval)
+			err := svc.collect(ctx, t)
+			cancel()
+			if err != nil {
+				log.Error().Msgf("failed to collect volume usage from kubelets: %v", err)
+			}
+		}
+	}()
+	return svc
+}
+
+func newKubeletService(client kubernetes.Interface, options KubeletServiceOptions) *KubeletService {
+	if options.ScrapeInterval <= 0 {
+		options.ScrapeInterval = defaultKubeletScrapeInterval
+	}
+	if options.Retention <= 0 {
+		options.Retention = defaultKubeletHistoryRetention
+	}
+	return &KubeletService{
+		client:  client,
+		options: options,
+		fetchSummary: func(ctx context.Context, nodeName string) ([]byte, error) {
+			return client.CoreV1().RESTClient().Get().
+				Resource("nodes").
+				Name(nodeName).
+				SubResource("proxy").
+				Suffix("stats", "summary").
+				DoRaw(ctx)
+		},
+		history: map[string][]VolumeUsage{},
+	}
+}
+
+// NewKubeletUsageSource returns a source of the current usage of volumes read from the
+// kubelets on demand, without collecting any usage history.
+func NewKubeletUsageSource(client kubernetes.Interface) UsageSource {
+	return newKubeletService(client, KubeletServiceOptions{})
+}
+
+type statsSummary struct {
+	Pods []podStats `json:"pods"`
+}
+
+type podStats struct {
+	Volumes []volumeStats `json:"volume"`
+}
+
+type volumeStats struct {
+	Name          string  `json:"name"`
+	UsedBytes     *uint64 `json:"usedBytes"`
+	CapacityBytes *uint64 `json:"capacityBytes"`
+	InodesUsed    *uint64 `json:"inodesUsed"`
+	PVCRef        *struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"pvcRef"`
+}
+
+// collect samples the usage of every volume bound to a claim from the kubelet of every node.
+func (ks *KubeletService) collect(ctx context.Context, now time.Time) error {
+	samples, err := ks.snapshot(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	for pvName, sample := range samples {
+		ks.history[pvName] = append(ks.history[pvName], sample)
+	}
+	ks.pruneLocked(now)
+	ks.mu.Unlock()
+
+	ks.saveHistory(ctx)
+	return nil
+}
+
+// CurrentUsage returns the usage of every volume bound to a claim, keyed by PV name.
+func (ks *KubeletService) CurrentUsage(ctx context.Context) (map[string]VolumeUsage, error) {
+	return ks.snapshot(ctx, time.Now())
+}
+
+// snapshot reads the usage of every volume bound to a claim from the kubelet of every node.
+func (ks *KubeletService) snapshot(ctx context.Context, now time.Time) (map[string]VolumeUsage, error) {
+	pvcs, err := ks.client.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing all PersistentVolumeClaims: %w", err)
+	}
+	pvNames := make(map[string]string, len(pvcs.Items))
+	for _, pvc := range pvcs.Items {
+		if pvc.Spec.VolumeName != "" {
+			pvNames[pvc.Namespace+"/"+pvc.Name] = pvc.Spec.VolumeName
+		}
+	}
+
+	nodes, err := ks.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing all Nodes: %w", err)
+	}
+
+	// A volume mounted by several pods is reported once per pod
+	samples := map[string]VolumeUsage{}
+	for _, node := range nodes.Items {
+		body, err := ks.fetchSummary(ctx, node.Name)
+		if err != nil {
+			log.Debug().Msgf("unable to get stats summary of node %s: %v", node.Name, err)
+			continue
+		}
+		var summary statsSummary
+		if err := json.Unmarshal(body, &summary); err != nil {
+			log.Debug().Msgf("unable to parse stats summary of node %s: %v", node.Name, err)
+			continue
+		}
+		for _, pod := range summary.Pods {
+			for _, vol := range pod.Volumes {
+				if vol.PVCRef == nil || vol.UsedBytes == nil || vol.CapacityBytes == nil {
+					continue
+				}
+				pvName, ok := pvNames[vol.PVCRef.Namespace+"/"+vol.PVCRef.Name]
+				if !ok {
+					continue
+				}
+				sample := VolumeUsage{
+					Time:          now,
+					UsedBytes:     float64(*vol.UsedBytes),
+					CapacityBytes: float64(*vol.CapacityBytes),
+				}
+				if vol.InodesUsed != nil {
+					sample.InodesUsed = float64(*vol.InodesUsed)
+				}
+				if current, ok := samples[pvName]; ok && current.UsedBytes >= sample.UsedBytes {
+					continue
+				}
+				samples[pvName] = sample
+			}
+		}
+	}
+
+	log.Debug().Msgf("collected usage of %d volumes from %d kubelets", len(samples), len(nodes.Items))
+	return samples, nil
+}
+
+// pruneLocked drops the samples older than the retention and the volumes left without samples.
+func (ks *KubeletService) pruneLocked(now time.Time) {
+	oldest := now.Add(-ks.options.Retention)
+	for pvName, samples := range ks.history {
+		i := 0
+		for i < len(samples) && samples[i].Time.Before(oldest) {
+			i++
+		}
+		if i == len(samples) {
+			delete(ks.history, pvName)
+			continue
+		}
+		ks.history[pvName] = samples[i:]
+	}
+}
+
+func (ks *KubeletService) GetRecommendation(ctx context.Context, pvName string, targetUtilization int, interval string) (RecommendationSizeWithSavings, error) {
+	recommendation := RecommendationSizeWithSavings{}
+	if targetUtilization <= 0 || targetUtilization > 100 {
+		targetUtilization = overwriteTargetUtilization
+	}
+	window, err := ParseInterval(interval)
+	if err != nil {
+		return recommendation, fmt.Errorf("invalid window %s: %w", interval, err)
+	}
+
+	now := time.Now()
+	samples := ks.samplesSince(pvName, now.Add(-window))
+	if len(samples) == 0 {
+		return recommendation, fmt.Errorf("no usage of pv %s was collected from kubelets: %w", pvName, ErrNoRecommendation)
+	}
+	// A volume must not be sized from a few minutes of usage right after the disk auto scaler started
+	if covered := now.Sub(samples[0].Time); covered < window/2 {
+		return recommendation, fmt.Errorf("usage of pv %s was collected for %s of the %s window: %w", pvName, covered.Round(time.Minute), window, ErrNoRecommendation)
+	}
+
+	usedBytes := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		usedBytes = append(usedBytes, sample.UsedBytes)
+	}
+	usage := usagePercentile(usedBytes, ks.options.Percentile)
+	if almostEqual(usage, 0.0) {
+		return recommendation, fmt.Errorf("unable to find accurate utilization from kubelets at this time: %w", ErrNoRecommendation)
+	}
+
+	recommendedSize, err := storageRecommendationFromBytes(usage / (float64(targetUtilization) / 100))
+	if err != nil {
+		return recommendation, fmt.Errorf("failed to convert kubelet bytes recommendation to storage request: %w", err)
+	}
+	recommendation.RecommendedResourceSize = recommendedSize
+	capacityBytes := samples[len(samples)-1].CapacityBytes
+	recommendation.Savings = (capacityBytes - float64(recommendedSize.Value())) / oneGiBytes * ks.options.PricePerGiBMonth
+
+	log.Debug().Msgf("kubelet usage of pv %s over %s from %d samples is %.0f bytes, recommended size is %s", pvName, interval, len(samples), usage, recommendedSize.String())
+	return recommendation, nil
+}
+
+// UsageHistory returns the usage samples of the volume collected since the given time.
+func (ks *KubeletService) UsageHistory(ctx context.Context, pvName string, since time.Time) ([]VolumeUsage, error) {
+	return ks.samplesSince(pvName, since), nil
+}
+
+// samplesSince returns a copy of the usage samples of the volume collected after since.
+func (ks *KubeletService) samplesSince(pvName string, since time.Time) []VolumeUsage {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	history := ks.history[pvName]
+	i, _ := slices.BinarySearchFunc(history, since, func(sample VolumeUsage, t time.Time) int {
+		return sample.Time.Compare(t)
+	})
+	return slices.Clone(history[i:])
+}
+
+// loadHistory restores the history persisted to the ConfigMap, if configured.
+func (ks *KubeletService) loadHistory(ctx context.Context) {
+	if ks.options.HistoryConfigMap == "" {
+		return
+	}
+	cm, err := ks.client.CoreV1().ConfigMaps(ks.options.HistoryNamespace).Get(ctx, ks.options.HistoryConfigMap, metav1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			log.Warn().Msgf("unable to load volume usage history from configmap %s: %v", ks.options.HistoryConfigMap, err)
+		}
+		return
+	}
+	history, err := decodeHistory(cm.BinaryData[kubeletHistoryConfigMapKey])
+	if err != nil {
+		log.Warn().Msgf("unable to decode volume usage history from configmap %s: %v", ks.options.HistoryConfigMap, err)
+		return
+	}
+	ks.mu.Lock()
+	ks.history = history
+	ks.pruneLocked(time.Now())
+	ks.mu.Unlock()
+	log.Info().Msgf("loaded usage history of %d volumes from configmap %s", len(history), ks.options.HistoryConfigMap)
+}
+
+// saveHistory persists the history to the ConfigMap, if configured.
+func (ks *KubeletService) saveHistory(ctx context.Context) {
+	if ks.options.HistoryConfigMap == "" {
+		return
+	}
+	ks.mu.RLock()
+	data, err := encodeHistory(ks.history)
+	ks.mu.RUnlock()
+	if err != nil {
+		log.Warn().Msgf("unable to encode volume usage history: %v", err)
+		return
+	}
+	if len(data) > maxKubeletHistoryConfigMapBytes {
+		log.Warn().Msgf("volume usage history of %d bytes does not fit in configmap %s, reduce the retention or increase the scrape interval", len(data), ks.options.HistoryConfigMap)
+		return
+	}
+
+	configMaps := ks.client.CoreV1().ConfigMaps(ks.options.HistoryNamespace)
+	cm, err := configMaps.Get(ctx, ks.options.HistoryConfigMap, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ks.options.HistoryConfigMap,
+				Namespace: ks.options.HistoryNamespace,
+			},
+			BinaryData: map[string][]byte{kubeletHistoryConfigMapKey: data},
+		}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	} else if err == nil {
+		cm.BinaryData = map[string][]byte{kubeletHistoryConfigMapKey: data}
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		log.Warn().Msgf("unable to persist volume usage history to configmap %s: %v", ks.options.HistoryConfigMap, err)
+	}
+}
+
+func encodeHistory(history map[string][]VolumeUsage) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(history); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeHistory(data []byte) (map[string][]VolumeUsage, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	history := map[string][]VolumeUsage{}
+	if err := json.Unmarshal(raw, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// usagePercentile returns the nearest rank percentile of the values, or the maximum
+// when percentile is 0 or out of range.
+func usagePercentile(values []float64, percentile float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	if percentile <= 0 || percentile >= 100 {
+		return sorted[len(sorted)-1]
+	}
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// ParseInterval parses an interval, which like a Go duration may also be given in days, e.g. 2d.
+// It is shared by the recommenders and the scaling intervals so that both accept the same intervals.
+func ParseInterval(window string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(window, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(window)
+}
@@ -0,0 +1,142 @@
+package pvsizingrecommendation
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestKubeletService(options KubeletServiceOptions, usedBytes *float64) *KubeletService {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "test"},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-1"},
+	}
+	svc := newKubeletService(fake.NewSimpleClientset(node, pvc), options)
+	svc.fetchSummary = func(ctx context.Context, nodeName string) ([]byte, error) {
+		if nodeName != "node-1" {
+			return nil, fmt.Errorf("unknown node %s", nodeName)
+		}
+		// The claim is mounted by two pods, only the largest usage is sampled
+		return []byte(fmt.Sprintf(`{"pods":[
+			{"volume":[{"name":"data","usedBytes":%.0f,"capacityBytes":%.0f,"inodesUsed":10,"pvcRef":{"name":"data","namespace":"test"}}]},
+			{"volume":[{"name":"data","usedBytes":1,"capacityBytes":%.0f,"pvcRef":{"name":"data","namespace":"test"}},{"name":"tmp","usedBytes":5}]}
+		]}`, *usedBytes, 100*oneGiBytes, 100*oneGiBytes)), nil
+	}
+	return svc
+}
+
+func Test_KubeletServiceGetRecommendation(t *testing.T) {
+	type testCase struct {
+		name             string
+		percentile       float64
+		usedGiB          []float64
+		sampleEvery      time.Duration
+		interval         string
+		expectedQuantity resource.Quantity
+		expectedErr      bool
+	}
+
+	testCases := []testCase{
+		{
+			name:             "when the volume is sized for the peak usage",
+			usedGiB:          []float64{2, 7, 3, 4},
+			sampleEvery:      time.Hour,
+			interval:         "4h",
+			expectedQuantity: resource.MustParse("10Gi"),
+		},
+		{
+			name:             "when the volume is sized for the 50th percentile of the usage",
+			percentile:       50,
+			usedGiB:          []float64{2, 7, 3.5, 4},
+			sampleEvery:      time.Hour,
+			interval:         "4h",
+			expectedQuantity: resource.MustParse("5Gi"),
+		},
+		{
+			name:             "when only the samples in the window are considered",
+			usedGiB:          []float64{50, 7, 3, 4},
+			sampleEvery:      24 * time.Hour,
+			interval:         "3d",
+			expectedQuantity: resource.MustParse("10Gi"),
+		},
+		{
+			name:        "when the usage history does not cover half of the window",
+			usedGiB:     []float64{2, 7},
+			sampleEvery: time.Hour,
+			interval:    "7h",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		var usedBytes float64
+		svc := newTestKubeletService(KubeletServiceOptions{Percentile: tc.percentile, Retention: 30 * 24 * time.Hour}, &usedBytes)
+		start := time.Now().Add(-time.Duration(len(tc.usedGiB)-1) * tc.sampleEvery)
+		for i, used := range tc.usedGiB {
+			usedBytes = used * oneGiBytes
+			if err := svc.collect(context.Background(), start.Add(time.Duration(i)*tc.sampleEvery)); err != nil {
+				t.Fatalf("test case %s: unable to collect usage: %s", tc.name, err)
+			}
+		}
+
+		recommendation, err := svc.GetRecommendation(context.Background(), "pv-1", 70, tc.interval)
+		if tc.expectedErr {
+			if err == nil {
+				t.Fatalf("test case %s: expected an error but received none", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test case %s: received unexpected err: %s", tc.name, err)
+		}
+		if recommendation.RecommendedResourceSize.Cmp(tc.expectedQuantity) != 0 {
+			t.Fatalf("test case %s: failed expected quantity %s but received %s", tc.name, tc.expectedQuantity.String(), recommendation.RecommendedResourceSize.String())
+		}
+	}
+}
+
+func Test_KubeletServiceHistoryPersistence(t *testing.T) {
+	usedBytes := 7 * oneGiBytes
+	options := KubeletServiceOptions{HistoryNamespace: "kubecost", HistoryConfigMap: "disk-autoscaler-usage-history"}
+	svc := newTestKubeletService(options, &usedBytes)
+	now := time.Now()
+	for _, at := range []time.Time{now.Add(-8 * 24 * time.Hour), now.Add(-2 * time.Hour), now} {
+		if err := svc.collect(context.Background(), at); err != nil {
+			t.Fatalf("unable to collect usage: %s", err)
+		}
+	}
+
+	restarted := newKubeletService(svc.client, options)
+	restarted.loadHistory(context.Background())
+	samples := restarted.samplesSince("pv-1", now.Add(-30*24*time.Hour))
+	// The sample older than the default retention of 7 days is pruned
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples to be restored but received %d", len(samples))
+	}
+	if samples[0].UsedBytes != usedBytes || samples[0].InodesUsed != 10 {
+		t.Fatalf("expected the restored sample to be the collected sample but received %+v", samples[0])
+	}
+}
+
+func Test_usagePercentile(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3, 6, 7, 8, 9, 10}
+	testCases := map[float64]float64{
+		0:   10,
+		50:  5,
+		90:  9,
+		95:  10,
+		100: 10,
+	}
+	for percentile, expected := range testCases {
+		if actual := usagePercentile(values, percentile); actual != expected {
+			t.Fatalf("expected percentile %.0f to be %.0f but received %.0f", percentile, expected, actual)
+		}
+	}
+}
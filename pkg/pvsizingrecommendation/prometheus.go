@@ -0,0 +1,280 @@
+package pvsizingrecommendation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	prometheusQueryTimeout = 30 * time.Second
+
+	// kubelet volume stats are labelled with the claim, kube-state-metrics maps the claim to its volume
+	prometheusPeakUsedBytesQuery = `max(max_over_time(kubelet_volume_stats_used_bytes[%s]) * on(namespace, persistentvolumeclaim) group_left() max by (namespace, persistentvolumeclaim) (kube_persistentvolumeclaim_info{volumename="%s"}))`
+	prometheusCapacityBytesQuery = `max(kubelet_volume_stats_capacity_bytes * on(namespace, persistentvolumeclaim) group_left() max by (namespace, persistentvolumeclaim) (kube_persistentvolumeclaim_info{volumename="%s"}))`
+	prometheusUsedBytesQuery     = `max(kubelet_volume_stats_used_bytes * on(namespace, persistentvolumeclaim) group_left() max by (namespace, persistentvolumeclaim) (kube_persistentvolumeclaim_info{volumename="%s"}))`
+	// The claim of every volume is mapped to its volume name by kube-state-metrics
+	prometheusAllUsedBytesQuery     = `max by (volumename) (kubelet_volume_stats_used_bytes * on(namespace, persistentvolumeclaim) group_left(volumename) kube_persistentvolumeclaim_info)`
+	prometheusAllCapacityBytesQuery = `max by (volumename) (kubelet_volume_stats_capacity_bytes * on(namespace, persistentvolumeclaim) group_left(volumename) kube_persistentvolumeclaim_info)`
+	// Range queries are limited to this many points so that long windows stay cheap
+	maxPrometheusRangePoints = 250
+)
+
+// PrometheusService recommends PV sizes from the kubelet_volume_stats metrics scraped
+// by a Prometheus compatible server. The recommended capacity holds the peak usage of
+// the window at the target utilization.
+type PrometheusService struct {
+	queryApiPath      string
+	queryRangeApiPath string
+	// pricePerGiBMonth is used to estimate the monthly savings of a recommendation
+	pricePerGiBMonth float64
+	client           *http.Client
+}
+
+func NewPrometheusService(address string, pricePerGiBMonth float64) *PrometheusService {
+	address = strings.TrimSuffix(address, "/")
+	return &PrometheusService{
+		queryApiPath:      fmt.Sprintf("%s/api/v1/query", address),
+		queryRangeApiPath: fmt.Sprintf("%s/api/v1/query_range", address),
+		pricePerGiBMonth:  pricePerGiBMonth,
+		client:            &http.Client{Timeout: prometheusQueryTimeout},
+	}
+}
+
+// CheckAvailable returns nil if the service is available to handle requests.
+func (ps *PrometheusService) CheckAvailable(ctx context.Context) error {
+	_, _, err := ps.query(ctx, "vector(1)")
+	if err != nil {
+		return fmt.Errorf("unavailable: %w", err)
+	}
+	return nil
+}
+
+type promResponse struct {
+	Status    string   `json:"status"`
+	ErrorType string   `json:"errorType"`
+	Error     string   `json:"error"`
+	Data      promData `json:"data"`
+}
+
+type promData struct {
+	ResultType string         `json:"resultType"`
+	Result     []promInstance `json:"result"`
+}
+
+type promInstance struct {
+	Metric map[string]string `json:"metric"`
+	// Value is a pair of the sample timestamp and the sample value as a string
+	Value []interface{} `json:"value"`
+	// Values are the samples of a range query
+	Values [][]interface{} `json:"values"`
+}
+
+func (ps *PrometheusService) GetRecommendation(ctx context.Context, pvName string, targetUtilization int, interval string) (RecommendationSizeWithSavings, error) {
+	recommendation := RecommendationSizeWithSavings{}
+	if targetUtilization <= 0 || targetUtilization > 100 {
+		targetUtilization = overwriteTargetUtilization
+	}
+
+	peakUsedBytes, found, err := ps.query(ctx, fmt.Sprintf(prometheusPeakUsedBytesQuery, interval, pvName))
+	if err != nil {
+		return recommendation, fmt.Errorf("failed to fetch pv usage from prometheus: %w", err)
+	}
+	// Happens when the storage provisioned but prometheus hasnt scraped it yet
+	if !found || almostEqual(peakUsedBytes, 0.0) {
+		return recommendation, fmt.Errorf("unable to find accurate utilization from prometheus at this time: %w", ErrNoRecommendation)
+	}
+
+	recommendedBytes := peakUsedBytes / (float64(targetUtilization) / 100)
+	recommendedSize, err := storageRecommendationFromBytes(recommendedBytes)
+	if err != nil {
+		return recommendation, fmt.Errorf("failed to convert prometheus bytes recommendation to storage request: %w", err)
+	}
+	recommendation.RecommendedResourceSize = recommendedSize
+
+	if ps.pricePerGiBMonth > 0 {
+		capacityBytes, found, err := ps.query(ctx, fmt.Sprintf(prometheusCapacityBytesQuery, pvName))
+		if err != nil {
+			log.Debug().Msgf("unable to fetch capacity of pv %s from prometheus, savings are not estimated: %v", pvName, err)
+		} else if found {
+			recommendation.Savings = (capacityBytes - float64(recommendedSize.Value())) / oneGiBytes * ps.pricePerGiBMonth
+		}
+	}
+
+	log.Debug().Msgf("prometheus peak usage of pv %s over %s is %.0f bytes, recommended size is %s", pvName, interval, peakUsedBytes, recommendedSize.String())
+	return recommendation, nil
+}
+
+// query runs an instant query expected to return at most one sample and returns its value.
+// found is false when the query returned no sample.
+func (ps *PrometheusService) query(ctx context.Context, query string) (value float64, found bool, err error) {
+	data, err := ps.get(ctx, ps.queryApiPath, url.Values{"query": []string{query}})
+	if err != nil {
+		return 0, false, err
+	}
+	if len(data.Result) == 0 {
+		return 0, false, nil
+	}
+	_, value, err = parseSample(data.Result[0].Value)
+	if err != nil {
+		return 0, false, err
+	}
+	return value, true, nil
+}
+
+// CurrentUsage returns the latest usage of every volume scraped by Prometheus, keyed by PV name.
+func (ps *PrometheusService) CurrentUsage(ctx context.Context) (map[string]VolumeUsage, error) {
+	used, err := ps.get(ctx, ps.queryApiPath, url.Values{"query": []string{prometheusAllUsedBytesQuery}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch usage of volumes from prometheus: %w", err)
+	}
+	capacity, err := ps.get(ctx, ps.queryApiPath, url.Values{"query": []string{prometheusAllCapacityBytesQuery}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch capacity of volumes from prometheus: %w", err)
+	}
+
+	usage := make(map[string]VolumeUsage, len(used.Result))
+	for _, instance := range used.Result {
+		t, value, err := parseSample(instance.Value)
+		if err != nil {
+			return nil, err
+		}
+		usage[instance.Metric["volumename"]] = VolumeUsage{Time: t, UsedBytes: value}
+	}
+	for _, instance := range capacity.Result {
+		sample, ok := usage[instance.Metric["volumename"]]
+		if !ok {
+			continue
+		}
+		_, value, err := parseSample(instance.Value)
+		if err != nil {
+			return nil, err
+		}
+		sample.CapacityBytes = value
+		usage[instance.Metric["volumename"]] = sample
+	}
+	return usage, nil
+}
+
+// UsageHistory returns the usage of the volume since the given time, at a resolution of at
+// most maxPrometheusRangePoints samples.
+func (ps *PrometheusService) UsageHistory(ctx context.Context, pvName string, since time.Time) ([]VolumeUsage, error) {
+	end := time.Now()
+	step := max(end.Sub(since)/maxPrometheusRangePoints, time.Minute)
+	used, err := ps.queryRange(ctx, fmt.Sprintf(prometheusUsedBytesQuery, pvName), since, end, step)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pv usage history from prometheus: %w", err)
+	}
+	capacity, err := ps.queryRange(ctx, fmt.Sprintf(prometheusCapacityBytesQuery, pvName), since, end, step)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pv capacity history from prometheus: %w", err)
+	}
+
+	// Both queries are evaluated at the same steps
+	capacityAt := make(map[int64]float64, len(capacity))
+	for _, sample := range capacity {
+		capacityAt[sample.Time.Unix()] = sample.UsedBytes
+	}
+	for i := range used {
+		used[i].CapacityBytes = capacityAt[used[i].Time.Unix()]
+	}
+	return used, nil
+}
+
+// queryRange runs a range query expected to return at most one series and returns its samples
+// as UsedBytes.
+func (ps *PrometheusService) queryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]VolumeUsage, error) {
+	data, err := ps.get(ctx, ps.queryRangeApiPath, url.Values{
+		"query": []string{query},
+		"start": []string{strconv.FormatInt(start.Unix(), 10)},
+		"end":   []string{strconv.FormatInt(end.Unix(), 10)},
+		"step":  []string{strconv.FormatFloat(step.Seconds(), 'f', 0, 64)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(data.Result) == 0 {
+		return nil, nil
+	}
+	samples := make([]VolumeUsage, 0, len(data.Result[0].Values))
+	for _, pair := range data.Result[0].Values {
+		t, value, err := parseSample(pair)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, VolumeUsage{Time: t, UsedBytes: value})
+	}
+	return samples, nil
+}
+
+// get calls the Prometheus HTTP API and returns the data of a successful response.
+func (ps *PrometheusService) get(ctx context.Context, apiPath string, params url.Values) (promData, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", apiPath, nil)
+	if err != nil {
+		return promData{}, fmt.Errorf("making request: %s", err)
+	}
+	req.URL.RawQuery = params.Encode()
+	log.Trace().
+		Str("url", req.URL.String()).
+		Msgf("Request prometheus query")
+
+	resp, err := ps.client.Do(req)
+	if err != nil {
+		return promData{}, fmt.Errorf("executing query: %w", err)
+	}
+
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("error closing response body for query(): %v", err)
+		}
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return promData{}, fmt.Errorf("reading response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return promData{}, fmt.Errorf("non-OK response status (%d), body: %s", resp.StatusCode, string(respBody))
+	}
+
+	var promResp promResponse
+	err = json.Unmarshal(respBody, &promResp)
+	if err != nil {
+		return promData{}, fmt.Errorf("unable to parse the response from prometheus: %w", err)
+	}
+	if promResp.Status != "success" {
+		return promData{}, fmt.Errorf("query failed with %s: %s", promResp.ErrorType, promResp.Error)
+	}
+	return promResp.Data, nil
+}
+
+// parseSample parses a pair of a timestamp and a value as a string returned by Prometheus.
+func parseSample(sample []interface{}) (time.Time, float64, error) {
+	if len(sample) != 2 {
+		return time.Time{}, 0, fmt.Errorf("unexpected sample %v in the response from prometheus", sample)
+	}
+	timestamp, ok := sample[0].(float64)
+	if !ok {
+		return time.Time{}, 0, fmt.Errorf("unexpected sample timestamp %v in the response from prometheus", sample[0])
+	}
+	valueStr, ok := sample[1].(string)
+	if !ok {
+		return time.Time{}, 0, fmt.Errorf("unexpected sample value %v in the response from prometheus", sample[1])
+	}
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("unable to parse sample value %s: %w", valueStr, err)
+	}
+	sec, frac := math.Modf(timestamp)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), value, nil
+}
@@ -0,0 +1,138 @@
+package pvsizingrecommendation
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// newFakePrometheus serves instant queries for the kubelet volume stats of a single PV.
+// A negative value means the metric has no sample.
+func newFakePrometheus(t *testing.T, pvName string, usedBytes, capacityBytes float64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query := r.URL.Query().Get("query")
+		value := -1.0
+		switch {
+		case query == "vector(1)":
+			value = 1
+		case !strings.Contains(query, fmt.Sprintf(`volumename="%s"`, pvName)):
+		case strings.Contains(query, "kubelet_volume_stats_used_bytes"):
+			value = usedBytes
+		case strings.Contains(query, "kubelet_volume_stats_capacity_bytes"):
+			value = capacityBytes
+		}
+		result := "[]"
+		if value >= 0 {
+			result = fmt.Sprintf(`[{"metric":{},"value":[1700000000,"%f"]}]`, value)
+		}
+		_, err := fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, result)
+		if err != nil {
+			t.Errorf("failed to write fake prometheus response: %s", err)
+		}
+	}))
+}
+
+func Test_PrometheusServiceGetRecommendation(t *testing.T) {
+	type testCase struct {
+		name              string
+		pvName            string
+		usedBytes         float64
+		capacityBytes     float64
+		targetUtilization int
+		pricePerGiBMonth  float64
+		expectedQuantity  resource.Quantity
+		expectedSavings   float64
+		expectedErr       bool
+	}
+
+	testCases := []testCase{
+		{
+			name:              "when peak usage of 7Gi is sized for 70% target utilization",
+			pvName:            "pv-1",
+			usedBytes:         7 * oneGiBytes,
+			capacityBytes:     100 * oneGiBytes,
+			targetUtilization: 70,
+			pricePerGiBMonth:  0.08,
+			expectedQuantity:  resource.MustParse("10Gi"),
+			expectedSavings:   90 * 0.08,
+		},
+		{
+			name:              "when recommended size is rounded up to the next Gi",
+			pvName:            "pv-1",
+			usedBytes:         4.5 * oneGiBytes,
+			capacityBytes:     10 * oneGiBytes,
+			targetUtilization: 50,
+			expectedQuantity:  resource.MustParse("9Gi"),
+		},
+		{
+			name:              "when target utilization is 0 it defaults to 70%",
+			pvName:            "pv-1",
+			usedBytes:         14 * oneGiBytes,
+			capacityBytes:     10 * oneGiBytes,
+			targetUtilization: 0,
+			pricePerGiBMonth:  0.1,
+			expectedQuantity:  resource.MustParse("20Gi"),
+			expectedSavings:   -1,
+		},
+		{
+			name:              "when small usage is raised to 1Gi",
+			pvName:            "pv-1",
+			usedBytes:         1024 * 1024,
+			capacityBytes:     10 * oneGiBytes,
+			targetUtilization: 70,
+			expectedQuantity:  resource.MustParse("1Gi"),
+		},
+		{
+			name:              "when prometheus has no usage of the pv",
+			pvName:            "pv-unknown",
+			usedBytes:         7 * oneGiBytes,
+			capacityBytes:     10 * oneGiBytes,
+			targetUtilization: 70,
+			expectedErr:       true,
+		},
+	}
+
+	for _, tc := range testCases {
+		server := newFakePrometheus(t, "pv-1", tc.usedBytes, tc.capacityBytes)
+		svc := NewPrometheusService(server.URL+"/", tc.pricePerGiBMonth)
+		recommendation, err := svc.GetRecommendation(context.Background(), tc.pvName, tc.targetUtilization, "7d")
+		server.Close()
+		if tc.expectedErr {
+			if err == nil {
+				t.Fatalf("test case %s: expected an error but received none", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test case %s: received unexpected err: %s", tc.name, err)
+		}
+		if recommendation.RecommendedResourceSize.Cmp(tc.expectedQuantity) != 0 {
+			t.Fatalf("test case %s: failed expected quantity %s but received %s", tc.name, tc.expectedQuantity.String(), recommendation.RecommendedResourceSize.String())
+		}
+		if math.Abs(recommendation.Savings-tc.expectedSavings) > 0.001 {
+			t.Fatalf("test case %s: failed expected savings %f but received %f", tc.name, tc.expectedSavings, recommendation.Savings)
+		}
+	}
+}
+
+func Test_PrometheusServiceCheckAvailable(t *testing.T) {
+	server := newFakePrometheus(t, "pv-1", 0, 0)
+	defer server.Close()
+
+	if err := NewPrometheusService(server.URL, 0).CheckAvailable(context.Background()); err != nil {
+		t.Fatalf("expected prometheus to be available but received err: %s", err)
+	}
+	if err := NewPrometheusService(server.URL+"/missing", 0).CheckAvailable(context.Background()); err == nil {
+		t.Fatalf("expected prometheus at a wrong path to be unavailable")
+	}
+}
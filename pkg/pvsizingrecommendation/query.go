@@ -13,6 +13,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kubecost/disk-autoscaler/pkg/metrics"
 	"github.com/rs/zerolog/log"
 	"k8s.io/apimachinery/pkg/api/resource"
 )
@@ -105,6 +106,8 @@ type PVRecommendation struct {
 type RecommendationSizeWithSavings struct {
 	RecommendedResourceSize resource.Quantity
 	Savings                 float64
+	// ProjectedFullAt is when the volume is forecast to be full, zero when unknown or never
+	ProjectedFullAt time.Time
 }
 
 func (krs *KubecostService) GetRecommendation(ctx context.Context, pvName string, targetUtilization int, interval string) (RecommendationSizeWithSavings, error) {
@@ -132,19 +135,10 @@ func (krs *KubecostService) GetRecommendation(ctx context.Context, pvName string
 
 	// Happens when the storage provisioned but kubecost hasnt recieved data
 	if almostEqual(recommendedBytes, 0.0) {
-		return recommendation, fmt.Errorf("unable to find accurate utilization from kubecost at this time")
+		return recommendation, fmt.Errorf("unable to find accurate utilization from kubecost at this time: %w", ErrNoRecommendation)
 	}
 
-	// 1 Gi is the smallest storage size in AWS EBS
-	// ref : https://kubernetes.io/docs/tasks/administer-cluster/limit-storage-consumption/#limitrange-to-limit-requests-for-storage
-	if recommendedBytes < oneGiBytes {
-		log.Debug().Msgf("recommendedBytes from kubecost %f[in Bytes] is less than 1 Gi, defaulting to 1 Gi, since minimal storage provision is 1Gi", recommendedBytes)
-		recommendation.RecommendedResourceSize = resource.MustParse("1Gi")
-		recommendation.Savings = savingsMonthly
-		return recommendation, nil
-	}
-
-	recommendedSize, err := convertKubecostBytesToStorageRecommendation(recommendedBytes / oneGiBytes)
+	recommendedSize, err := storageRecommendationFromBytes(recommendedBytes)
 	if err != nil {
 		return recommendation, fmt.Errorf("failed to convert kubecost bytes recommendation to storage request: %w", err)
 	}
@@ -183,8 +177,11 @@ func (krs *KubecostService) getFromCacheOrFetch(window string, overheadPercent s
 		Msgf("Request recommendation")
 
 	client := &http.Client{}
+	start := time.Now()
 	resp, err := client.Do(req)
+	metrics.KubecostRequestDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
+		metrics.KubecostRequestErrors.Inc()
 		return []byte{}, fmt.Errorf("executing query: %w", err)
 	}
 
@@ -200,6 +197,7 @@ func (krs *KubecostService) getFromCacheOrFetch(window string, overheadPercent s
 	}
 
 	if resp.StatusCode != http.StatusOK {
+		metrics.KubecostRequestErrors.Inc()
 		return []byte{}, fmt.Errorf("non-OK response status (%d), body: %s", resp.StatusCode, string(respBody))
 	}
 
@@ -207,6 +205,18 @@ func (krs *KubecostService) getFromCacheOrFetch(window string, overheadPercent s
 	return respBody, nil
 }
 
+// storageRecommendationFromBytes converts a recommended capacity in bytes to a storage request
+// of whole GiB which is never smaller than 1Gi.
+func storageRecommendationFromBytes(recommendedBytes float64) (resource.Quantity, error) {
+	// 1 Gi is the smallest storage size in AWS EBS
+	// ref : https://kubernetes.io/docs/tasks/administer-cluster/limit-storage-consumption/#limitrange-to-limit-requests-for-storage
+	if recommendedBytes < oneGiBytes {
+		log.Debug().Msgf("recommendedBytes %f[in Bytes] is less than 1 Gi, defaulting to 1 Gi, since minimal storage provision is 1Gi", recommendedBytes)
+		return resource.MustParse("1Gi"), nil
+	}
+	return convertKubecostBytesToStorageRecommendation(recommendedBytes / oneGiBytes)
+}
+
 // convertKubecostBytesToStorageRecommendation converts the byte recommendation
 // from Kubecost to a storage resource.Quantity. The function rounds to the
 // nearest whole number greater than the storage recommendation when there
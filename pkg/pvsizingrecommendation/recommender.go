@@ -0,0 +1,52 @@
+package pvsizingrecommendation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrNoRecommendation is returned by a Recommender which has no usage data for a volume yet.
+var ErrNoRecommendation = errors.New("no recommendation available")
+
+// Recommender returns the recommended size of a PV for the target utilization,
+// based on its usage over the window given by interval.
+type Recommender interface {
+	GetRecommendation(ctx context.Context, pvName string, targetUtilization int, interval string) (RecommendationSizeWithSavings, error)
+}
+
+// UsageSource returns the current usage of every volume it knows of, keyed by PV name.
+type UsageSource interface {
+	CurrentUsage(ctx context.Context) (map[string]VolumeUsage, error)
+}
+
+// ChainRecommender asks each of its recommenders in order and returns the first
+// recommendation found, falling back to the next recommender when one fails.
+type ChainRecommender struct {
+	recommenders []Recommender
+}
+
+func NewChainRecommender(recommenders ...Recommender) *ChainRecommender {
+	return &ChainRecommender{
+		recommenders: recommenders,
+	}
+}
+
+func (cr *ChainRecommender) GetRecommendation(ctx context.Context, pvName string, targetUtilization int, interval string) (RecommendationSizeWithSavings, error) {
+	var result *multierror.Error
+	for i, recommender := range cr.recommenders {
+		recommendation, err := recommender.GetRecommendation(ctx, pvName, targetUtilization, interval)
+		if err == nil {
+			return recommendation, nil
+		}
+		log.Debug().Msgf("recommender %d of %d has no recommendation for pv %s, err: %v", i+1, len(cr.recommenders), pvName, err)
+		result = multierror.Append(result, err)
+	}
+	if result == nil {
+		return RecommendationSizeWithSavings{}, fmt.Errorf("no recommender is configured: %w", ErrNoRecommendation)
+	}
+	return RecommendationSizeWithSavings{}, result.ErrorOrNil()
+}
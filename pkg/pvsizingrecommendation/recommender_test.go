@@ -0,0 +1,96 @@
+package pvsizingrecommendation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// stubRecommender recommends the sizes it holds per PV and has no recommendation for any other PV.
+type stubRecommender struct {
+	sizes map[string]string
+	err   error
+}
+
+func (sr *stubRecommender) GetRecommendation(ctx context.Context, pvName string, targetUtilization int, interval string) (RecommendationSizeWithSavings, error) {
+	if sr.err != nil {
+		return RecommendationSizeWithSavings{}, sr.err
+	}
+	size, ok := sr.sizes[pvName]
+	if !ok {
+		return RecommendationSizeWithSavings{}, fmt.Errorf("stub: %w", ErrNoRecommendation)
+	}
+	return RecommendationSizeWithSavings{RecommendedResourceSize: resource.MustParse(size)}, nil
+}
+
+func Test_ChainRecommender(t *testing.T) {
+	type testCase struct {
+		name                string
+		recommenders        []Recommender
+		pvName              string
+		expectedQuantity    resource.Quantity
+		expectedErr         bool
+		expectedNoRecommend bool
+	}
+
+	first := &stubRecommender{sizes: map[string]string{"pv-1": "10Gi"}}
+	second := &stubRecommender{sizes: map[string]string{"pv-1": "20Gi", "pv-2": "5Gi"}}
+	unavailable := &stubRecommender{err: fmt.Errorf("connection refused")}
+
+	testCases := []testCase{
+		{
+			name:             "when the first recommender has a recommendation",
+			recommenders:     []Recommender{first, second},
+			pvName:           "pv-1",
+			expectedQuantity: resource.MustParse("10Gi"),
+		},
+		{
+			name:             "when the first recommender has no data for the pv",
+			recommenders:     []Recommender{first, second},
+			pvName:           "pv-2",
+			expectedQuantity: resource.MustParse("5Gi"),
+		},
+		{
+			name:             "when the first recommender is unavailable",
+			recommenders:     []Recommender{unavailable, second},
+			pvName:           "pv-2",
+			expectedQuantity: resource.MustParse("5Gi"),
+		},
+		{
+			name:                "when no recommender has data for the pv",
+			recommenders:        []Recommender{first, second},
+			pvName:              "pv-3",
+			expectedErr:         true,
+			expectedNoRecommend: true,
+		},
+		{
+			name:                "when no recommender is configured",
+			recommenders:        []Recommender{},
+			pvName:              "pv-1",
+			expectedErr:         true,
+			expectedNoRecommend: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		recommendation, err := NewChainRecommender(tc.recommenders...).GetRecommendation(context.Background(), tc.pvName, 70, "7h")
+		if tc.expectedErr {
+			if err == nil {
+				t.Fatalf("test case %s: expected an error but received none", tc.name)
+			}
+			if errors.Is(err, ErrNoRecommendation) != tc.expectedNoRecommend {
+				t.Fatalf("test case %s: expected err to be ErrNoRecommendation %t but received %s", tc.name, tc.expectedNoRecommend, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test case %s: received unexpected err: %s", tc.name, err)
+		}
+		if recommendation.RecommendedResourceSize.Cmp(tc.expectedQuantity) != 0 {
+			t.Fatalf("test case %s: failed expected quantity %s but received %s", tc.name, tc.expectedQuantity.String(), recommendation.RecommendedResourceSize.String())
+		}
+	}
+}